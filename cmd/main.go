@@ -9,12 +9,16 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gogazub/myapp/internal/api"
 	"github.com/gogazub/myapp/internal/consumer"
+	"github.com/gogazub/myapp/internal/listener"
+	"github.com/gogazub/myapp/internal/outbox"
 	repo "github.com/gogazub/myapp/internal/repository"
 	svc "github.com/gogazub/myapp/internal/service"
 	"github.com/joho/godotenv"
@@ -32,17 +36,84 @@ func main() {
 		log.Printf("warning: no .env loaded: %v", err)
 	}
 
-	service, err := createService()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "retention" {
+		os.Exit(runRetention(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		os.Exit(runRotateKeys(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "customer" {
+		os.Exit(runCustomer(os.Args[2:]))
+	}
+
+	app, err := createApp()
 	if err != nil {
 		log.Printf("starting app error: %v", err)
 		os.Exit(1)
 	}
+	service := app.service
 
 	errCh := make(chan error, 1)
 	rootCtx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	wg := sync.WaitGroup{}
+
+	// Прогрев кеша идет в фоне: HTTP сервер стартует сразу, а /ready показывает прогресс
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		warmupCache(rootCtx, app)
+	}()
+
+	if app.memCache != nil && app.snapshotPath != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.memCache.RunSnapshots(rootCtx, app.snapshotPath, app.snapshotInterval, app.psqlRepo)
+		}()
+	}
+
+	if app.replicas != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.replicas.RunHealthChecks(rootCtx)
+		}()
+	}
+
+	for _, store := range app.stores {
+		if app.partitionsAhead > 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				store.RunPartitionMaintenance(rootCtx, app.partitionsAhead, 24*time.Hour)
+			}()
+		}
+		if app.outbox != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				relay := outbox.NewRelay(store, app.outbox, app.outboxCfg)
+				if err := relay.Run(rootCtx); err != nil && !errors.Is(err, context.Canceled) {
+					log.Printf("outbox relay error: %v", err)
+				}
+			}()
+		}
+	}
+
+	if app.redisCache != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := app.redisCache.RunInvalidation(rootCtx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("redis invalidation error: %v", err)
+			}
+		}()
+	}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -59,6 +130,18 @@ func main() {
 		}
 	}()
 
+	if envBool("CACHE_LISTEN_NOTIFY", true) {
+		for _, dsn := range app.notifyDSNs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := startListener(rootCtx, service, dsn); err != nil && !errors.Is(err, context.Canceled) {
+					log.Printf("order listener error: %v", err)
+				}
+			}()
+		}
+	}
+
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
@@ -85,6 +168,11 @@ func main() {
 		log.Printf("timeout (%s) waiting for components to stop, exiting", timeout)
 	}
 
+	if app.outbox != nil {
+		if err := app.outbox.Close(); err != nil {
+			log.Printf("outbox writer close error: %v", err)
+		}
+	}
 	log.Println("shutdown complete")
 
 }
@@ -92,12 +180,19 @@ func main() {
 // startConsumer запускает Kafka consumer и передает данные в сервис
 func startConsumer(ctx context.Context, service svc.IService) error {
 	broker := os.Getenv("KAFKA_BROKER")
+	if broker == "" {
+		// Локальный запуск без Kafka: заказы приходят только через уже сохраненные данные
+		log.Println("KAFKA_BROKER is empty - consumer disabled")
+		return nil
+	}
 	config := consumer.Config{
 		Brokers:  []string{broker},
 		Topic:    "orders",
 		GroupID:  "order-group",
 		MinBytes: 10e3,
 		MaxBytes: 10e6,
+		// Строгая сверка amount с позициями: без нее заказы, которые принимались раньше, не теряются
+		StrictAmounts: envBool("CONSUMER_STRICT_AMOUNTS", false),
 	}
 	kafkaCfg := kafka.ReaderConfig{
 		Brokers:  config.Brokers,
@@ -108,14 +203,25 @@ func startConsumer(ctx context.Context, service svc.IService) error {
 		MaxWait:  1 * time.Second,
 	}
 	reader := kafka.NewReader(kafkaCfg)
-	kafkaConsumer := consumer.NewConsumer(service, reader)
+	kafkaConsumer := consumer.NewConsumerWithConfig(service, reader, config)
 	// Можно добавить контекст для цепочки ошибок
 	return kafkaConsumer.Start(ctx)
 }
 
+// startListener слушает NOTIFY order_changed базы dsn и инвалидирует кеш при изменениях заказов в ней
+func startListener(ctx context.Context, service svc.IService, dsn string) error {
+	l := listener.NewListener(service, listener.NewPQNotifier(dsn))
+	defer func() {
+		if err := l.Close(); err != nil {
+			log.Printf("order listener close error: %v", err)
+		}
+	}()
+	return l.Start(ctx)
+}
+
 // startServer запускает HTTP сервер, который обслуживает запросы по order_id
 func startServer(ctx context.Context, service svc.IService) error {
-	srv := api.NewServer(service)
+	srv := api.NewServerWithConfig(service, api.Config{AdminToken: os.Getenv("ADMIN_TOKEN")})
 
 	address := ":" + os.Getenv("SERVER_PORT")
 	if address == "" {
@@ -131,9 +237,8 @@ func startServer(ctx context.Context, service svc.IService) error {
 	return nil
 }
 
-// Создает подключение к БД
-func connectToDB() (*sql.DB, error) {
-
+// dbConnString строка подключения к Postgres из переменных окружения
+func dbConnString() string {
 	dbHost := os.Getenv("DB_HOST")
 	dbPort := os.Getenv("DB_PORT")
 	dbUser := os.Getenv("DB_USER")
@@ -141,10 +246,18 @@ func connectToDB() (*sql.DB, error) {
 	dbName := os.Getenv("DB_NAME")
 	dbSSLMode := os.Getenv("DB_SSLMODE")
 
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
 		dbUser, dbPassword, dbHost, dbPort, dbName, dbSSLMode)
+}
+
+// Создает подключение к БД
+func connectToDB() (*sql.DB, error) {
+	return connectDSN(dbConnString())
+}
 
-	db, err := sql.Open("postgres", connStr)
+// connectDSN подключение к Postgres по строке dsn с проверкой соединения
+func connectDSN(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("connect to db error: %w", err)
 	}
@@ -156,21 +269,266 @@ func connectToDB() (*sql.DB, error) {
 	return db, nil
 }
 
-// createService инициализирует репозитории и сервис для обработки заказов
-func createService() (*svc.Service, error) {
+// app собранные зависимости сервиса, нужные main для запуска фоновых задач
+type app struct {
+	service  *svc.Service
+	psqlRepo repo.IDBRepository
+	// memCache in-memory кеш (CACHE_BACKEND=memory), nil для redis
+	memCache *repo.CacheRepository
+	// redisCache распределенный кеш (CACHE_BACKEND=redis), nil для memory
+	redisCache *repo.RedisCacheRepository
+
+	// stores базы с заказами: основная или шарды. Для каждой работают обслуживание партиций и outbox relay
+	stores []*repo.DBRepository
+	// partitionsAhead на сколько месяцев вперед создавать партиции, 0 - выключено
+	partitionsAhead int
+	// outbox writer топика событий (OUTBOX_TOPIC), nil - relay выключен
+	outbox    *kafka.Writer
+	outboxCfg outbox.Config
+	// notifyDSNs базы, чьи NOTIFY order_changed инвалидируют кеш
+	notifyDSNs []string
+	// replicas реплики для чтения (DB_REPLICAS), nil - чтение с primary
+	replicas *repo.ReplicaSet
+
+	// snapshotPath путь к снапшоту кеша. Пустой - снапшоты выключены
+	snapshotPath     string
+	snapshotInterval time.Duration
+}
+
+// createApp инициализирует репозитории и сервис для обработки заказов
+func createApp() (*app, error) {
+	a := &app{}
+	switch backend := envString("DB_BACKEND", "postgres"); backend {
+	case "postgres":
+		if err := openPostgres(a); err != nil {
+			return nil, fmt.Errorf("create service error:%w", err)
+		}
+	case "memory":
+		// Локальная разработка без Postgres: нет истории, outbox, партиций и NOTIFY
+		path := os.Getenv("MEMORY_DB_PATH")
+		mem, err := repo.NewMemoryRepository(repo.MemoryConfig{Path: path})
+		if err != nil {
+			return nil, fmt.Errorf("create service error:%w", err)
+		}
+		a.psqlRepo = mem
+		log.Printf("in-memory storage, persisted to %q", path)
+	case "sqlite":
+		// Встроенная БД для edge-инсталляций и интеграционных тестов без Docker.
+		// Как и с memory: нет истории, outbox, партиций и NOTIFY
+		path := envString("SQLITE_PATH", "orders.db")
+		db, err := repo.OpenSQLite(path)
+		if err != nil {
+			return nil, fmt.Errorf("create service error:%w", err)
+		}
+		migrateCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if err := autoMigrateSQLite(migrateCtx, db); err != nil {
+			return nil, fmt.Errorf("create service error: sqlite migrate:%w", err)
+		}
+		a.psqlRepo = repo.NewSQLiteRepository(db)
+		log.Printf("sqlite storage %s", path)
+	default:
+		return nil, fmt.Errorf("create service error: unknown DB_BACKEND %q", backend)
+	}
+
+	var cacheRepo repo.ICacheRepository
+	switch backend := os.Getenv("CACHE_BACKEND"); backend {
+	case "", "memory":
+		a.memCache = repo.NewCacheRepositoryWithConfig(repo.CacheConfig{
+			MaxSize:    envInt("CACHE_SIZE", 0),
+			TTL:        envDuration("CACHE_TTL", 0),
+			WarmupSize: envInt("CACHE_WARMUP_SIZE", 0),
+		})
+		a.snapshotPath = os.Getenv("CACHE_SNAPSHOT_PATH")
+		a.snapshotInterval = envDuration("CACHE_SNAPSHOT_INTERVAL", 5*time.Minute)
+		cacheRepo = a.memCache
+	case "redis":
+		a.redisCache = repo.NewRedisCacheRepository(repo.RedisCacheConfig{
+			Addr:       os.Getenv("REDIS_ADDR"),
+			Password:   os.Getenv("REDIS_PASSWORD"),
+			DB:         envInt("REDIS_DB", 0),
+			TTL:        envDuration("CACHE_TTL", 0),
+			WarmupSize: envInt("CACHE_WARMUP_SIZE", 0),
+			L1Size:     envInt("CACHE_L1_SIZE", 0),
+			L1TTL:      envDuration("CACHE_L1_TTL", 0),
+		})
+		pingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := a.redisCache.Ping(pingCtx); err != nil {
+			return nil, fmt.Errorf("create service error:%w", err)
+		}
+		cacheRepo = a.redisCache
+	default:
+		return nil, fmt.Errorf("create service error: unknown CACHE_BACKEND %q", backend)
+	}
+
+	a.service = svc.NewService(a.psqlRepo, cacheRepo)
+	return a, nil
+}
+
+// openPostgres подключает Postgres (DB_BACKEND=postgres): основную БД или шарды, реплики и outbox
+func openPostgres(a *app) error {
 	db, err := connectToDB()
 	if err != nil {
-		return nil, fmt.Errorf("create service error:%w", err)
+		return err
 	}
 
-	psqlRepo := repo.NewOrderRepository(db)
-	cacheRepo := repo.NewCacheRepository()
+	var migrateDB func(*sql.DB) error
+	if envBool("DB_AUTO_MIGRATE", false) {
+		migrateCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		migrateDB = func(db *sql.DB) error { return autoMigrate(migrateCtx, db) }
+		if err := migrateDB(db); err != nil {
+			return fmt.Errorf("auto migrate:%w", err)
+		}
+	}
 
-	err = cacheRepo.LoadFromDB(psqlRepo)
+	targets, err := shardTargets()
+	if err != nil {
+		return err
+	}
+	a.notifyDSNs = []string{dbConnString()}
+	dbCfg, err := dbConfig()
 	if err != nil {
-		return nil, fmt.Errorf("create service error:%w", err)
+		return err
+	}
+	if replicas := envList("DB_REPLICAS"); len(replicas) > 0 {
+		if len(targets) > 0 {
+			return fmt.Errorf("DB_REPLICAS is not supported with DB_SHARDS")
+		}
+		a.replicas, err = openReplicas(db, replicas)
+		if err != nil {
+			return err
+		}
+		dbCfg.Replicas = a.replicas
+	}
+	psqlRepo := repo.NewOrderRepositoryWithConfig(db, dbCfg)
+	a.psqlRepo = psqlRepo
+	a.stores = []*repo.DBRepository{psqlRepo}
+
+	if len(targets) > 0 {
+		// Заказы лежат на шардах, основная БД хранит только каталог order_shards
+		set, err := openShards(targets, db, dbCfg, migrateDB)
+		if err != nil {
+			return err
+		}
+		a.psqlRepo, a.stores, a.notifyDSNs = set.repo, set.shards, nil
+		for _, t := range targets {
+			a.notifyDSNs = append(a.notifyDSNs, t.dsn)
+		}
+		log.Printf("sharded storage: %d shards", len(targets))
+	}
+	a.partitionsAhead = max(envInt("DB_PARTITIONS_AHEAD", repo.DefaultPartitionsAhead), 0)
+	// Без Kafka relay по умолчанию выключен: события копятся в order_outbox до ее появления
+	broker := os.Getenv("KAFKA_BROKER")
+	if topic := envString("OUTBOX_TOPIC", "order-events"); envBool("OUTBOX_RELAY", broker != "") {
+		if broker == "" {
+			return fmt.Errorf("OUTBOX_RELAY=true requires KAFKA_BROKER")
+		}
+		a.outbox = &kafka.Writer{
+			Addr:         kafka.TCP(broker),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		}
+		a.outboxCfg = outbox.Config{
+			BatchSize:    envInt("OUTBOX_BATCH_SIZE", 0),
+			Lease:        envDuration("OUTBOX_LEASE", 0),
+			PollInterval: envDuration("OUTBOX_POLL_INTERVAL", 0),
+			Retention:    envDuration("OUTBOX_RETENTION", 0),
+		}
+	}
+	return nil
+}
+
+// warmupCache восстанавливает кеш из снапшота, если он есть, иначе грузит свежие заказы из БД
+func warmupCache(ctx context.Context, a *app) {
+	if a.memCache != nil && a.snapshotPath != "" {
+		n, err := a.memCache.LoadSnapshotFile(ctx, a.snapshotPath, a.psqlRepo)
+		if err == nil {
+			log.Printf("cache restored from snapshot %s: %d orders", a.snapshotPath, n)
+			return
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("cache snapshot load error: %v - falling back to db warmup", err)
+		}
 	}
 
-	service := svc.NewService(psqlRepo, cacheRepo)
-	return service, nil
+	if err := a.service.WarmupCache(ctx); err != nil {
+		log.Printf("cache warmup error: %v", err)
+		return
+	}
+	log.Printf("cache warmup done: %d orders", a.service.WarmupStatus().Loaded)
+}
+
+// openReplicas открывает подключения к репликам. Соединение не проверяется: недоступная реплика
+// не мешает старту, а проверка здоровья не пустит на нее запросы
+func openReplicas(primary *sql.DB, dsns []string) (*repo.ReplicaSet, error) {
+	dbs := make([]*sql.DB, 0, len(dsns))
+	names := make([]string, 0, len(dsns))
+	for i, dsn := range dsns {
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, fmt.Errorf("open replica %d: %w", i, err)
+		}
+		dbs = append(dbs, db)
+		names = append(names, "replica-"+strconv.Itoa(i))
+	}
+	return repo.NewReplicaSet(primary, dbs, names, repo.ReplicaConfig{
+		MaxLag:        envDuration("DB_REPLICA_MAX_LAG", 0),
+		CheckInterval: envDuration("DB_REPLICA_CHECK_INTERVAL", 0),
+	}), nil
+}
+
+// envList читает список через запятую из переменной окружения. Пустые элементы пропускаются
+func envList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// envInt читает целое из переменной окружения. При отсутствии или ошибке возвращает def
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("warning: bad %s=%q: %v", key, v, err)
+		return def
+	}
+	return n
+}
+
+// envDuration читает time.Duration (например "5m") из переменной окружения
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("warning: bad %s=%q: %v", key, v, err)
+		return def
+	}
+	return d
+}
+
+// envBool читает bool ("true", "1", "false", ...) из переменной окружения
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("warning: bad %s=%q: %v", key, v, err)
+		return def
+	}
+	return b
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/gogazub/myapp/internal/migrate"
+	"github.com/gogazub/myapp/migrations"
+	sqlitemigrations "github.com/gogazub/myapp/migrations/sqlite"
+)
+
+const migrateUsage = `usage: app migrate <command>
+  up [N]        применить N (по умолчанию все) ожидающих миграций
+  down [N]      откатить N (по умолчанию одну) последних миграций
+  status        текущая версия и ожидающие миграции
+  force VERSION записать версию без выполнения SQL и снять dirty`
+
+// runMigrate подкоманда migrate. Возвращает код выхода процесса
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// Схема одинакова в основной БД и на всех шардах DB_SHARDS; базы обрабатываются по очереди,
+	// первая ошибка останавливает команду
+	targets, err := dbTargets()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, t := range targets {
+		if len(targets) > 1 {
+			fmt.Printf("db %s:\n", t.name)
+		}
+		if err := migrateTarget(ctx, t, args[0], args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "migrate %s error: %v\n", args[0], err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// migrateTarget выполняет команду migrate на одной базе
+func migrateTarget(ctx context.Context, t dbTarget, cmd string, args []string) error {
+	db, err := connectDSN(t.dsn)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+	return migrateCommand(ctx, m, cmd, args)
+}
+
+func migrateCommand(ctx context.Context, m *migrate.Migrator, cmd string, args []string) error {
+	switch cmd {
+	case "up":
+		n, err := optionalCount(args)
+		if err != nil {
+			return err
+		}
+		applied, err := m.Up(ctx, n)
+		fmt.Printf("applied %d migration(s)\n", applied)
+		return err
+	case "down":
+		n, err := optionalCount(args)
+		if err != nil {
+			return err
+		}
+		reverted, err := m.Down(ctx, n)
+		fmt.Printf("reverted %d migration(s)\n", reverted)
+		return err
+	case "status":
+		st, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("version: %d dirty: %t\n", st.Version, st.Dirty)
+		for _, mig := range st.Pending {
+			fmt.Printf("pending: %06d_%s\n", mig.Version, mig.Name)
+		}
+		return nil
+	case "force":
+		if len(args) != 1 {
+			return fmt.Errorf("force requires VERSION")
+		}
+		v, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("bad version %q: %w", args[0], err)
+		}
+		return m.Force(ctx, uint(v))
+	default:
+		return fmt.Errorf("unknown command %q\n%s", cmd, migrateUsage)
+	}
+}
+
+func optionalCount(args []string) (int, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad count %q", args[0])
+	}
+	return n, nil
+}
+
+// autoMigrate применяет ожидающие миграции при старте сервиса (DB_AUTO_MIGRATE=true)
+func autoMigrate(ctx context.Context, db *sql.DB) error {
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+	_, err = m.Up(ctx, 0)
+	return err
+}
+
+// autoMigrateSQLite применяет миграции migrations/sqlite к встроенной БД (DB_BACKEND=sqlite).
+// Выполняется при каждом старте: файл БД принадлежит одному процессу
+func autoMigrateSQLite(ctx context.Context, db *sql.DB) error {
+	m, err := migrate.NewWithDialect(db, sqlitemigrations.FS, migrate.SQLite)
+	if err != nil {
+		return err
+	}
+	_, err = m.Up(ctx, 0)
+	return err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gogazub/myapp/internal/pii"
+	repo "github.com/gogazub/myapp/internal/repository"
+)
+
+// dbConfig настройки репозиториев заказов: таймаут запросов и ключи шифрования PII
+func dbConfig() (repo.DBConfig, error) {
+	cipher, err := piiCipher()
+	if err != nil {
+		return repo.DBConfig{}, err
+	}
+	return repo.DBConfig{
+		StatementTimeout: envDuration("DB_STATEMENT_TIMEOUT", 5*time.Second),
+		PII:              cipher,
+	}, nil
+}
+
+// piiCipher ключи шифрования данных доставки. Связка мастер-ключей - PII_MASTER_KEYS
+// ("k2=<base64>,k1=<base64>", первый активный) или файл PII_MASTER_KEYS_FILE, ключ blind index -
+// PII_BLIND_INDEX_KEY или PII_BLIND_INDEX_KEY_FILE. Без мастер-ключей шифрование выключено: nil
+func piiCipher() (*pii.Cipher, error) {
+	keysValue, err := envSecret("PII_MASTER_KEYS")
+	if err != nil || keysValue == "" {
+		return nil, err
+	}
+	keys, err := pii.ParseKeys(keysValue)
+	if err != nil {
+		return nil, fmt.Errorf("PII_MASTER_KEYS: %w", err)
+	}
+	bidxValue, err := envSecret("PII_BLIND_INDEX_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if bidxValue == "" {
+		return nil, errors.New("PII_BLIND_INDEX_KEY is required with PII_MASTER_KEYS")
+	}
+	bidx, err := pii.ParseSecret(bidxValue)
+	if err != nil {
+		return nil, fmt.Errorf("PII_BLIND_INDEX_KEY: %w", err)
+	}
+	return pii.NewCipher(keys, bidx)
+}
+
+// envSecret значение переменной key или содержимое файла из key_FILE (смонтированный секрет)
+func envSecret(key string) (string, error) {
+	if v := os.Getenv(key); v != "" {
+		return v, nil
+	}
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE: %w", key, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// runRotateKeys подкоманда rotate-keys: перешифровывает активным мастер-ключом данные доставки,
+// события outbox и исходные сообщения, маскирует персональные поля в истории.
+// Строки, еще хранящиеся открытым текстом, шифруются. Возвращает код выхода процесса
+func runRotateKeys(args []string) int {
+	fs := flag.NewFlagSet("rotate-keys", flag.ContinueOnError)
+	batch := fs.Int("batch", 500, "строк за транзакцию")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	dbCfg, err := dbConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if dbCfg.PII == nil {
+		fmt.Fprintln(os.Stderr, "PII_MASTER_KEYS is not set")
+		return 1
+	}
+	db, err := connectToDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer func() { _ = db.Close() }()
+
+	// При DB_SHARDS заказы лежат только на шардах
+	stores := []*repo.DBRepository{repo.NewOrderRepositoryWithConfig(db, dbCfg)}
+	names := []string{"main"}
+	targets, err := shardTargets()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(targets) > 0 {
+		set, err := openShards(targets, db, dbCfg, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer set.Close()
+		stores, names = set.shards, nil
+		for _, t := range targets {
+			names = append(names, t.name)
+		}
+	}
+
+	fmt.Printf("active key: %s\n", dbCfg.PII.ActiveKeyID())
+	for i, r := range stores {
+		for _, table := range repo.PIITables {
+			n, err := rotateTable(ctx, r, table, *batch)
+			verb := "re-encrypted"
+			if table == "order_history" {
+				verb = "masked"
+			}
+			fmt.Printf("db %s %s: %d rows %s\n", names[i], table, n, verb)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "rotate-keys error: %v\n", err)
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+// rotateTable перешифровывает table порциями по batch, пока не останется строк под старыми ключами
+func rotateTable(ctx context.Context, r repo.IPIIRotator, table string, batch int) (int, error) {
+	total, after := 0, 0
+	for {
+		last, n, err := r.RotatePII(ctx, table, after, batch)
+		total += n
+		if err != nil || n == 0 {
+			return total, err
+		}
+		after = last
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gogazub/myapp/internal/model"
+	repo "github.com/gogazub/myapp/internal/repository"
+)
+
+const customerUsage = `usage: app customer <command> CUSTOMER_ID
+  export [-out FILE] выгрузить все заказы покупателя с историей и исходными сообщениями (JSON)
+  erase -yes         анонимизировать доставку и идентификаторы платежей всех заказов покупателя`
+
+// runCustomer подкоманда customer: запросы покупателя к его персональным данным (GDPR).
+// Каждая операция пишется в privacy_audit. Возвращает код выхода процесса
+func runCustomer(args []string) int {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "erase") {
+		fmt.Fprintln(os.Stderr, customerUsage)
+		return 2
+	}
+	cmd := args[0]
+	fs := flag.NewFlagSet("customer "+cmd, flag.ContinueOnError)
+	out := fs.String("out", "", "файл выгрузки, по умолчанию stdout")
+	yes := fs.Bool("yes", false, "подтвердить удаление: его нельзя отменить")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || fs.Arg(0) == "" {
+		fmt.Fprintln(os.Stderr, customerUsage)
+		return 2
+	}
+	customerID := fs.Arg(0)
+	if cmd == "erase" && !*yes {
+		fmt.Fprintln(os.Stderr, "customer erase: pass -yes to confirm, the data cannot be restored")
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	ctx = model.WithChangeSource(ctx, model.ChangeSource{Kind: model.SourceCLI, User: os.Getenv("USER")})
+
+	db, err := connectToDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer func() { _ = db.Close() }()
+
+	dbCfg, err := dbConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var r repo.IPrivacyRepository = repo.NewOrderRepositoryWithConfig(db, dbCfg)
+	targets, err := shardTargets()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(targets) > 0 {
+		set, err := openShards(targets, db, dbCfg, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer set.Close()
+		r = set.repo
+	}
+
+	if cmd == "export" {
+		err = exportCustomer(ctx, r, customerID, *out)
+	} else {
+		err = eraseCustomer(ctx, r, customerID)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "customer %s error: %v\n", cmd, err)
+		return 1
+	}
+	return 0
+}
+
+// exportCustomer пишет выгрузку в path (с правами 0600: в ней персональные данные) или в stdout
+func exportCustomer(ctx context.Context, r repo.IPrivacyRepository, customerID, path string) error {
+	export, err := r.ExportCustomer(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(export); err != nil {
+		return err
+	}
+	if path != "" {
+		fmt.Fprintf(os.Stderr, "exported %d orders to %s\n", len(export.Orders), path)
+	}
+	return nil
+}
+
+// eraseCustomer анонимизирует заказы покупателя. Кеши сервисов сбрасываются по NOTIFY order_changed
+func eraseCustomer(ctx context.Context, r repo.IPrivacyRepository, customerID string) error {
+	res, err := r.EraseCustomer(ctx, customerID)
+	for _, id := range res.Orders {
+		fmt.Printf("erased: %s\n", id)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("customer %s: %d orders erased\n", customerID, len(res.Orders))
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	repo "github.com/gogazub/myapp/internal/repository"
+	"github.com/gogazub/myapp/internal/retention"
+)
+
+// runRetention подкоманда retention. Значения по умолчанию берутся из RETENTION_*.
+// Возвращает код выхода процесса
+func runRetention(args []string) int {
+	fs := flag.NewFlagSet("retention", flag.ContinueOnError)
+	cfg := retention.Config{}
+	mode := fs.String("mode", envString("RETENTION_MODE", string(retention.ModeArchive)), "archive или export")
+	fs.DurationVar(&cfg.MaxAge, "older-than", envDuration("RETENTION_MAX_AGE", 0), "удалять заказы старше, например 8760h")
+	fs.StringVar(&cfg.ExportDir, "dir", os.Getenv("RETENTION_EXPORT_DIR"), "каталог файлов для -mode export")
+	fs.IntVar(&cfg.BatchSize, "batch", envInt("RETENTION_BATCH_SIZE", retention.DefaultBatchSize), "заказов за транзакцию")
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "только показать, что будет удалено")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cfg.Mode = retention.Mode(*mode)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	db, err := connectToDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer func() { _ = db.Close() }()
+
+	dbCfg, err := dbConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var r repo.IRetentionRepository = repo.NewOrderRepositoryWithConfig(db, dbCfg)
+	targets, err := shardTargets()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(targets) > 0 {
+		set, err := openShards(targets, db, dbCfg, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer set.Close()
+		r = set.repo
+	}
+	rep, err := retention.Run(ctx, r, cfg)
+	printRetentionReport(rep)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "retention error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printRetentionReport(rep retention.Report) {
+	st := rep.Expired
+	fmt.Printf("cutoff: %s mode: %s dry-run: %t\n", rep.Cutoff.Format(time.RFC3339), rep.Mode, rep.DryRun)
+	fmt.Printf("expired: %d orders (%d soft-deleted), %d items", st.Orders, st.Deleted, st.Items)
+	if st.Orders > 0 {
+		fmt.Printf(", date_created %s .. %s", st.Oldest.Format(time.RFC3339), st.Newest.Format(time.RFC3339))
+	}
+	fmt.Println()
+	if !rep.DryRun {
+		fmt.Printf("removed: %d orders, %d rejected raw messages\n", rep.Removed, rep.RejectedRaw)
+	}
+	for _, f := range rep.Files {
+		fmt.Printf("exported: %s\n", f)
+	}
+}
+
+// envString читает строку из переменной окружения, пустая - def
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
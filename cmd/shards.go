@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	repo "github.com/gogazub/myapp/internal/repository"
+)
+
+// dbTarget база данных с именем для логов: основная (DB_*) или шард из DB_SHARDS
+type dbTarget struct {
+	name string
+	dsn  string
+}
+
+// shardTargets шарды из DB_SHARDS вида "s0=postgres://...,s1=postgres://...".
+// Пустая переменная - шардирование выключено
+func shardTargets() ([]dbTarget, error) {
+	v := strings.TrimSpace(os.Getenv("DB_SHARDS"))
+	if v == "" {
+		return nil, nil
+	}
+	var out []dbTarget
+	for _, part := range strings.Split(v, ",") {
+		name, dsn, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || name == "" || dsn == "" {
+			return nil, fmt.Errorf("bad DB_SHARDS entry %q, want name=dsn", part)
+		}
+		out = append(out, dbTarget{name: name, dsn: dsn})
+	}
+	return out, nil
+}
+
+// shardKeyMap карта Shardkey -> шард из DB_SHARD_MAP вида "1=s0,2=s0,9=s1"
+func shardKeyMap() (map[string]string, error) {
+	out := map[string]string{}
+	v := strings.TrimSpace(os.Getenv("DB_SHARD_MAP"))
+	if v == "" {
+		return out, nil
+	}
+	for _, part := range strings.Split(v, ",") {
+		key, name, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("bad DB_SHARD_MAP entry %q, want shardkey=shard", part)
+		}
+		out[key] = name
+	}
+	return out, nil
+}
+
+// dbTargets основная БД и шарды: все базы, к которым применяются миграции
+func dbTargets() ([]dbTarget, error) {
+	shards, err := shardTargets()
+	if err != nil {
+		return nil, err
+	}
+	return append([]dbTarget{{name: "main", dsn: dbConnString()}}, shards...), nil
+}
+
+// shardSet подключения к шардам и собранный поверх них репозиторий
+type shardSet struct {
+	repo    *repo.ShardedRepository
+	shards  []*repo.DBRepository
+	targets []dbTarget
+	dbs     []*sql.DB
+}
+
+// Close закрывает подключения к шардам
+func (s *shardSet) Close() {
+	for _, db := range s.dbs {
+		_ = db.Close()
+	}
+}
+
+// openShards подключается к шардам DB_SHARDS. Каталог order_shards берется из основной БД mainDB,
+// если DB_SHARD_DIRECTORY=true; иначе поиск по order_uid опрашивает все шарды.
+// migrateDB вызывается для каждого шарда сразу после подключения, nil - без миграций
+func openShards(targets []dbTarget, mainDB *sql.DB, cfg repo.DBConfig, migrateDB func(*sql.DB) error) (*shardSet, error) {
+	keyMap, err := shardKeyMap()
+	if err != nil {
+		return nil, err
+	}
+	set := &shardSet{targets: targets}
+	shards := make([]repo.Shard, 0, len(targets))
+	for _, t := range targets {
+		db, err := connectDSN(t.dsn)
+		if err != nil {
+			set.Close()
+			return nil, fmt.Errorf("shard %s: %w", t.name, err)
+		}
+		set.dbs = append(set.dbs, db)
+		if migrateDB != nil {
+			if err := migrateDB(db); err != nil {
+				set.Close()
+				return nil, fmt.Errorf("shard %s: migrate:%w", t.name, err)
+			}
+		}
+		r := repo.NewOrderRepositoryWithConfig(db, cfg)
+		set.shards = append(set.shards, r)
+		shards = append(shards, repo.Shard{Name: t.name, Repo: r})
+	}
+
+	shardedCfg := repo.ShardedConfig{Shards: shards, KeyMap: keyMap}
+	if envBool("DB_SHARD_DIRECTORY", true) {
+		shardedCfg.Directory = repo.NewShardDirectory(mainDB)
+	}
+	set.repo, err = repo.NewShardedRepository(shardedCfg)
+	if err != nil {
+		set.Close()
+		return nil, err
+	}
+	return set, nil
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/gogazub/myapp/internal/service"
 	"github.com/gogazub/myapp/tests"
 )
 
@@ -29,6 +31,71 @@ func (m *mockService) GetOrderByID(ctx context.Context, id string) (*model.Order
 	return o, args.Error(1)
 }
 
+func (m *mockService) GetOrderHistory(ctx context.Context, id string, limit int) ([]model.HistoryEntry, error) {
+	args := m.Called(ctx, id, limit)
+	var h []model.HistoryEntry
+	if v := args.Get(0); v != nil {
+		h = v.([]model.HistoryEntry)
+	}
+	return h, args.Error(1)
+}
+
+func (m *mockService) GetOrderRawMessages(ctx context.Context, id string, limit int) ([]model.RawMessage, error) {
+	args := m.Called(ctx, id, limit)
+	var raw []model.RawMessage
+	if v := args.Get(0); v != nil {
+		raw = v.([]model.RawMessage)
+	}
+	return raw, args.Error(1)
+}
+func (m *mockService) SaveRawMessage(ctx context.Context, msg model.RawMessage) error {
+	return m.Called(ctx, msg).Error(0)
+}
+func (m *mockService) SearchOrders(ctx context.Context, q model.SearchQuery) (model.SearchPage, error) {
+	args := m.Called(ctx, q)
+	return args.Get(0).(model.SearchPage), args.Error(1)
+}
+func (m *mockService) DeleteOrder(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+func (m *mockService) ExportCustomerData(ctx context.Context, customerID string) (model.CustomerExport, error) {
+	args := m.Called(ctx, customerID)
+	return args.Get(0).(model.CustomerExport), args.Error(1)
+}
+func (m *mockService) EraseCustomerData(ctx context.Context, customerID string) (model.CustomerErasure, error) {
+	args := m.Called(ctx, customerID)
+	return args.Get(0).(model.CustomerErasure), args.Error(1)
+}
+func (m *mockService) CacheStats() repository.CacheStats {
+	args := m.Called()
+	return args.Get(0).(repository.CacheStats)
+}
+func (m *mockService) InvalidateCache(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+func (m *mockService) RefreshCache(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+func (m *mockService) ClearCache(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+func (m *mockService) WarmupCache(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+func (m *mockService) WarmupStatus() repository.WarmupStatus {
+	args := m.Called()
+	return args.Get(0).(repository.WarmupStatus)
+}
+func (m *mockService) ResyncCache(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
 // ---- handleGetOrderByID ----
 
 func TestHandleGetOrderByID_Success(t *testing.T) {
@@ -89,6 +156,465 @@ func TestHandleGetOrderByID_EmptyID(t *testing.T) {
 	ms.AssertExpectations(t)
 }
 
+// ---- handleOrderHistory ----
+
+func TestHandleOrderHistory(t *testing.T) {
+	entries := []model.HistoryEntry{{
+		ID: 1, OrderUID: "uid-1", Op: model.HistoryCreate,
+		Source:  model.ChangeSource{Kind: model.SourceKafka, Topic: "orders", Offset: 7},
+		Changes: []model.FieldChange{{Field: "track_number", New: "WB1"}},
+	}}
+	cases := []struct {
+		name  string
+		url   string
+		limit int
+		ret   []model.HistoryEntry
+		err   error
+		code  int
+	}{
+		{"ok", "/orders/uid-1/history", 0, entries, nil, http.StatusOK},
+		{"limit", "/orders/uid-1/history?limit=5", 5, entries, nil, http.StatusOK},
+		{"not found", "/orders/uid-1/history", 0, nil, repository.ErrOrderNotFound, http.StatusNotFound},
+		{"not supported", "/orders/uid-1/history", 0, nil, service.ErrNotSupported, http.StatusNotImplemented},
+		{"db error", "/orders/uid-1/history", 0, nil, assertAnError(), http.StatusInternalServerError},
+		{"no header", "/orders/uid-1/history", 0, nil, nil, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := new(mockService)
+			s := NewServerWithConfig(ms, Config{AdminToken: "secret"})
+			auth := tc.code != http.StatusUnauthorized
+			if auth {
+				ms.On("GetOrderHistory", mock.Anything, "uid-1", tc.limit).Return(tc.ret, tc.err).Once()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if auth {
+				req.Header.Set("Authorization", "Bearer secret")
+			}
+			rr := httptest.NewRecorder()
+			s.routes().ServeHTTP(rr, req)
+
+			require.Equal(t, tc.code, rr.Code)
+			if tc.code == http.StatusOK {
+				var got []model.HistoryEntry
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+				require.Equal(t, tc.ret[0].Source, got[0].Source)
+				require.Equal(t, "track_number", got[0].Changes[0].Field)
+			}
+			if !auth {
+				ms.AssertNotCalled(t, "GetOrderHistory", mock.Anything, mock.Anything, mock.Anything)
+			}
+			ms.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleOrderHistory_BadLimit(t *testing.T) {
+	ms := new(mockService)
+	s := NewServerWithConfig(ms, Config{AdminToken: "secret"})
+
+	req := httptest.NewRequest(http.MethodGet, "/orders/uid-1/history?limit=abc", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	s.routes().ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	ms.AssertNotCalled(t, "GetOrderHistory")
+}
+
+// ---- handleOrderRaw ----
+
+func TestHandleOrderRaw(t *testing.T) {
+	raw := []model.RawMessage{{
+		ID: 3, OrderUID: "uid-1", Payload: json.RawMessage(`{"order_uid":"uid-1"}`),
+		Topic: "orders", Offset: 7, Headers: []model.RawHeader{}, SchemaVersion: 1,
+	}}
+	cases := []struct {
+		name   string
+		url    string
+		header string
+		limit  int
+		ret    []model.RawMessage
+		err    error
+		call   bool
+		code   int
+	}{
+		{"ok", "/orders/uid-1/raw", "Bearer secret", 0, raw, nil, true, http.StatusOK},
+		{"limit", "/orders/uid-1/raw?limit=5", "Bearer secret", 5, raw, nil, true, http.StatusOK},
+		{"not found", "/orders/uid-1/raw", "Bearer secret", 0, nil, repository.ErrOrderNotFound, true, http.StatusNotFound},
+		{"not supported", "/orders/uid-1/raw", "Bearer secret", 0, nil, service.ErrNotSupported, true, http.StatusNotImplemented},
+		{"db error", "/orders/uid-1/raw", "Bearer secret", 0, nil, assertAnError(), true, http.StatusInternalServerError},
+		{"bad limit", "/orders/uid-1/raw?limit=abc", "Bearer secret", 0, nil, nil, false, http.StatusBadRequest},
+		{"no header", "/orders/uid-1/raw", "", 0, nil, nil, false, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := new(mockService)
+			s := NewServerWithConfig(ms, Config{AdminToken: "secret"})
+			if tc.call {
+				ms.On("GetOrderRawMessages", mock.Anything, "uid-1", tc.limit).Return(tc.ret, tc.err).Once()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			s.routes().ServeHTTP(rr, req)
+
+			require.Equal(t, tc.code, rr.Code)
+			if tc.code == http.StatusOK {
+				var got []model.RawMessage
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+				require.JSONEq(t, `{"order_uid":"uid-1"}`, string(got[0].Payload))
+				require.Equal(t, int64(7), got[0].Offset)
+			}
+			if !tc.call {
+				ms.AssertNotCalled(t, "GetOrderRawMessages", mock.Anything, mock.Anything, mock.Anything)
+			}
+			ms.AssertExpectations(t)
+		})
+	}
+}
+
+// ---- handleSearchOrders ----
+
+func TestHandleSearchOrders(t *testing.T) {
+	page := model.SearchPage{
+		Hits: []model.SearchHit{{OrderUID: "uid-1", Rank: 1, Matches: []model.SearchMatch{
+			{Field: "delivery.name", Value: "Test Testov", Highlight: "Test <mark>Testov</mark>", Score: 1},
+		}}},
+		NextOffset: 20,
+	}
+	cases := []struct {
+		name   string
+		url    string
+		header string
+		query  model.SearchQuery
+		err    error
+		call   bool
+		code   int
+	}{
+		{"ok", "/orders/search?q=+testov+", "Bearer secret", model.SearchQuery{Text: "testov"}, nil, true, http.StatusOK},
+		{"paging", "/orders/search?q=testov&limit=5&offset=10", "Bearer secret",
+			model.SearchQuery{Text: "testov", Limit: 5, Offset: 10}, nil, true, http.StatusOK},
+		{"not supported", "/orders/search?q=testov", "Bearer secret", model.SearchQuery{Text: "testov"},
+			service.ErrNotSupported, true, http.StatusNotImplemented},
+		{"db error", "/orders/search?q=testov", "Bearer secret", model.SearchQuery{Text: "testov"},
+			assertAnError(), true, http.StatusInternalServerError},
+		{"short query", "/orders/search?q=ab", "Bearer secret", model.SearchQuery{}, nil, false, http.StatusBadRequest},
+		{"no query", "/orders/search", "Bearer secret", model.SearchQuery{}, nil, false, http.StatusBadRequest},
+		{"limit too big", "/orders/search?q=testov&limit=1000", "Bearer secret", model.SearchQuery{}, nil, false, http.StatusBadRequest},
+		{"bad offset", "/orders/search?q=testov&offset=-1", "Bearer secret", model.SearchQuery{}, nil, false, http.StatusBadRequest},
+		{"no header", "/orders/search?q=testov", "", model.SearchQuery{}, nil, false, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := new(mockService)
+			s := NewServerWithConfig(ms, Config{AdminToken: "secret"})
+			if tc.call {
+				ms.On("SearchOrders", mock.Anything, tc.query).Return(page, tc.err).Once()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			s.routes().ServeHTTP(rr, req)
+
+			require.Equal(t, tc.code, rr.Code)
+			if tc.code == http.StatusOK {
+				var got model.SearchPage
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+				require.Equal(t, page, got)
+			}
+			if !tc.call {
+				ms.AssertNotCalled(t, "SearchOrders", mock.Anything, mock.Anything)
+			}
+			ms.AssertExpectations(t)
+		})
+	}
+}
+
+// ---- handleDeleteOrder ----
+
+func TestHandleDeleteOrder(t *testing.T) {
+	cases := []struct {
+		name   string
+		token  string
+		header string
+		err    error
+		call   bool
+		code   int
+	}{
+		{"ok", "secret", "Bearer secret", nil, true, http.StatusNoContent},
+		{"not found", "secret", "Bearer secret", repository.ErrOrderNotFound, true, http.StatusNotFound},
+		{"db error", "secret", "Bearer secret", assertAnError(), true, http.StatusInternalServerError},
+		{"wrong token", "secret", "Bearer other", nil, false, http.StatusUnauthorized},
+		{"no header", "secret", "", nil, false, http.StatusUnauthorized},
+		{"token not configured", "", "Bearer ", nil, false, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := new(mockService)
+			s := NewServerWithConfig(ms, Config{AdminToken: tc.token})
+			if tc.call {
+				ms.On("DeleteOrder", mock.MatchedBy(func(ctx context.Context) bool {
+					return model.ChangeSourceFrom(ctx).Kind == model.SourceAPI
+				}), "uid-1").Return(tc.err).Once()
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/orders/uid-1", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			s.routes().ServeHTTP(rr, req)
+
+			require.Equal(t, tc.code, rr.Code)
+			if !tc.call {
+				ms.AssertNotCalled(t, "DeleteOrder", mock.Anything, mock.Anything)
+			}
+			ms.AssertExpectations(t)
+		})
+	}
+}
+
+// ---- customer data (GDPR) ----
+
+func TestHandleCustomerData(t *testing.T) {
+	export := model.CustomerExport{CustomerID: "cust-1", Orders: []model.ExportedOrder{{Order: &model.Order{OrderUID: "uid-1"}}}}
+	erased := model.CustomerErasure{CustomerID: "cust-1", Orders: []string{"uid-1"}}
+	cases := []struct {
+		name   string
+		method string
+		url    string
+		header string
+		call   string
+		err    error
+		code   int
+		body   string
+	}{
+		{"export", http.MethodGet, "/admin/customers/cust-1/export", "Bearer secret", "ExportCustomerData", nil,
+			http.StatusOK, `"order_uid":"uid-1"`},
+		{"export not supported", http.MethodGet, "/admin/customers/cust-1/export", "Bearer secret", "ExportCustomerData",
+			service.ErrNotSupported, http.StatusNotImplemented, ""},
+		{"export no token", http.MethodGet, "/admin/customers/cust-1/export", "", "", nil, http.StatusUnauthorized, ""},
+		{"erase", http.MethodPost, "/admin/customers/cust-1/erase", "Bearer secret", "EraseCustomerData", nil,
+			http.StatusOK, `"orders":["uid-1"]`},
+		{"erase db error", http.MethodPost, "/admin/customers/cust-1/erase", "Bearer secret", "EraseCustomerData",
+			assertAnError(), http.StatusInternalServerError, ""},
+		{"erase wrong token", http.MethodPost, "/admin/customers/cust-1/erase", "Bearer other", "", nil,
+			http.StatusUnauthorized, ""},
+		// GET не удаляет: запрос уходит в файловый сервер
+		{"erase by GET", http.MethodGet, "/admin/customers/cust-1/erase", "Bearer secret", "", nil,
+			http.StatusNotFound, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := new(mockService)
+			s := NewServerWithConfig(ms, Config{AdminToken: "secret"})
+			fromAPI := mock.MatchedBy(func(ctx context.Context) bool {
+				return model.ChangeSourceFrom(ctx) == model.ChangeSource{Kind: model.SourceAPI, User: "admin"}
+			})
+			switch tc.call {
+			case "ExportCustomerData":
+				ms.On(tc.call, fromAPI, "cust-1").Return(export, tc.err).Once()
+			case "EraseCustomerData":
+				ms.On(tc.call, fromAPI, "cust-1").Return(erased, tc.err).Once()
+			}
+
+			req := httptest.NewRequest(tc.method, tc.url, nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			s.routes().ServeHTTP(rr, req)
+
+			require.Equal(t, tc.code, rr.Code)
+			require.Contains(t, rr.Body.String(), tc.body)
+			if tc.call == "" {
+				ms.AssertNotCalled(t, "ExportCustomerData", mock.Anything, mock.Anything)
+				ms.AssertNotCalled(t, "EraseCustomerData", mock.Anything, mock.Anything)
+			}
+			ms.AssertExpectations(t)
+		})
+	}
+}
+
+// ---- admin cache ----
+
+func TestAdminCacheStats(t *testing.T) {
+	ms := new(mockService)
+	s := NewServerWithConfig(ms, Config{AdminToken: "secret"})
+
+	ms.On("CacheStats").Return(repository.CacheStats{Hits: 3, Misses: 1, Size: 2}).Once()
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/cache/stats", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	s.routes().ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var got repository.CacheStats
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	require.Equal(t, uint64(3), got.Hits)
+	require.Equal(t, 2, got.Size)
+	ms.AssertExpectations(t)
+}
+
+func TestAdminCacheInvalidate(t *testing.T) {
+	ms := new(mockService)
+	s := NewServerWithConfig(ms, Config{AdminToken: "secret"})
+
+	ms.On("InvalidateCache", mock.Anything, "uid-1").Return(nil).Once()
+
+	req := httptest.NewRequest(http.MethodDelete, "/admin/cache/uid-1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	s.routes().ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	ms.AssertExpectations(t)
+}
+
+func TestAdminCacheClear(t *testing.T) {
+	ms := new(mockService)
+	s := NewServerWithConfig(ms, Config{AdminToken: "secret"})
+
+	ms.On("ClearCache", mock.Anything).Return(nil).Once()
+
+	req := httptest.NewRequest(http.MethodDelete, "/admin/cache", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	s.routes().ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	ms.AssertExpectations(t)
+}
+
+func TestAdminCacheWarmup(t *testing.T) {
+	ms := new(mockService)
+	s := NewServerWithConfig(ms, Config{AdminToken: "secret"})
+
+	ms.On("WarmupCache", mock.Anything).Return(nil).Once()
+	ms.On("CacheStats").Return(repository.CacheStats{Size: 10, Loads: 1}).Once()
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/cache/warmup", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	s.routes().ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	ms.AssertExpectations(t)
+}
+
+func TestAdminCacheWarmup_Error(t *testing.T) {
+	ms := new(mockService)
+	s := NewServerWithConfig(ms, Config{AdminToken: "secret"})
+
+	ms.On("WarmupCache", mock.Anything).Return(assertAnError()).Once()
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/cache/warmup", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	s.routes().ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+	ms.AssertNotCalled(t, "CacheStats")
+}
+
+func TestAdminCacheWarmup_InProgress(t *testing.T) {
+	ms := new(mockService)
+	s := NewServerWithConfig(ms, Config{AdminToken: "secret"})
+
+	ms.On("WarmupCache", mock.Anything).Return(repository.ErrWarmupInProgress).Once()
+	ms.On("WarmupStatus").Return(repository.WarmupStatus{State: repository.WarmupRunning}).Once()
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/cache/warmup", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	s.routes().ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusConflict, rr.Code)
+	ms.AssertExpectations(t)
+}
+
+func TestAdminCache_RequiresToken(t *testing.T) {
+	routes := []struct{ method, url string }{
+		{http.MethodGet, "/admin/cache/stats"},
+		{http.MethodDelete, "/admin/cache/uid-1"},
+		{http.MethodDelete, "/admin/cache"},
+		{http.MethodPost, "/admin/cache/warmup"},
+	}
+	auth := []struct {
+		name   string
+		token  string
+		header string
+		code   int
+	}{
+		{"no header", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer other", http.StatusUnauthorized},
+		{"token not configured", "", "Bearer ", http.StatusForbidden},
+	}
+	for _, rt := range routes {
+		for _, a := range auth {
+			t.Run(rt.method+" "+rt.url+" "+a.name, func(t *testing.T) {
+				// Ожиданий нет: любой вызов сервиса уронит тест
+				ms := new(mockService)
+				s := NewServerWithConfig(ms, Config{AdminToken: a.token})
+
+				req := httptest.NewRequest(rt.method, rt.url, nil)
+				if a.header != "" {
+					req.Header.Set("Authorization", a.header)
+				}
+				rr := httptest.NewRecorder()
+				s.routes().ServeHTTP(rr, req)
+
+				require.Equal(t, a.code, rr.Code)
+				ms.AssertExpectations(t)
+			})
+		}
+	}
+}
+
+// ---- readiness ----
+
+func TestReady(t *testing.T) {
+	cases := []struct {
+		name   string
+		status repository.WarmupStatus
+		code   int
+	}{
+		{"pending", repository.WarmupStatus{State: repository.WarmupPending}, http.StatusServiceUnavailable},
+		{"running", repository.WarmupStatus{State: repository.WarmupRunning, Loaded: 10, Target: 100}, http.StatusServiceUnavailable},
+		{"done", repository.WarmupStatus{State: repository.WarmupDone, Loaded: 100, Target: 100}, http.StatusOK},
+		{"failed", repository.WarmupStatus{State: repository.WarmupFailed, Error: "db down"}, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := new(mockService)
+			s := NewServer(ms)
+			ms.On("WarmupStatus").Return(tc.status).Once()
+
+			req := httptest.NewRequest(http.MethodGet, "/ready", nil)
+			rr := httptest.NewRecorder()
+			s.routes().ServeHTTP(rr, req)
+
+			require.Equal(t, tc.code, rr.Code)
+			var body struct {
+				Ready  bool                    `json:"ready"`
+				Warmup repository.WarmupStatus `json:"warmup"`
+			}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+			require.Equal(t, tc.status.Loaded, body.Warmup.Loaded)
+			require.Equal(t, tc.code == http.StatusOK, body.Ready)
+		})
+	}
+}
+
 func assertAnError() error { return errAny }
 
 var errAny = &anyError{}
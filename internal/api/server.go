@@ -3,12 +3,19 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/gogazub/myapp/internal/model"
+	repo "github.com/gogazub/myapp/internal/repository"
 	svc "github.com/gogazub/myapp/internal/service"
 )
 
@@ -17,15 +24,29 @@ type IServer interface {
 	handleGetOrderByID(w http.ResponseWriter, r *http.Request)
 }
 
+// Config настройки сервера
+type Config struct {
+	// AdminToken bearer-токен для изменяющих заказы запросов (DELETE /orders/{id}).
+	// Пустой - такие запросы запрещены
+	AdminToken string
+}
+
 // Server - реализация http-сервера.
 type Server struct {
-	service svc.IService
+	service    svc.IService
+	adminToken string
 }
 
 // NewServer - конструктор.
 func NewServer(service svc.IService) *Server {
+	return NewServerWithConfig(service, Config{})
+}
+
+// NewServerWithConfig конструктор с настройками
+func NewServerWithConfig(service svc.IService, cfg Config) *Server {
 	return &Server{
-		service: service,
+		service:    service,
+		adminToken: cfg.AdminToken,
 	}
 }
 
@@ -38,15 +59,9 @@ func (s *Server) handleError(msg string, err error) {
 
 // Start запускает сервер
 func (s *Server) Start(ctx context.Context, address string) error {
-	// Создаем новый mux, потому что http.Handle... влияет на глобальный mux
-	mux := http.NewServeMux()
-	mux.HandleFunc("/orders/", s.handleGetOrderByID)
-	mux.Handle("/", http.FileServer(http.Dir("./internal/api/web")))
-	mux.HandleFunc("/healt", handleHealth)
-
 	srv := &http.Server{
 		Addr:    address,
-		Handler: mux,
+		Handler: s.routes(),
 	}
 
 	srvErrCh := make(chan error, 1)
@@ -74,6 +89,29 @@ func (s *Server) Start(ctx context.Context, address string) error {
 	}
 }
 
+// routes собирает маршруты сервера
+func (s *Server) routes() http.Handler {
+	// Создаем новый mux, потому что http.Handle... влияет на глобальный mux
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orders/", s.handleGetOrderByID)
+	// История содержит старые и новые данные доставки: без ключей PII они не маскируются
+	mux.HandleFunc("GET /orders/{id}/history", s.requireAdmin(s.handleOrderHistory))
+	mux.HandleFunc("GET /orders/{id}/raw", s.requireAdmin(s.handleOrderRaw))
+	mux.HandleFunc("GET /orders/search", s.requireAdmin(s.handleSearchOrders))
+	mux.HandleFunc("DELETE /orders/{id}", s.requireAdmin(s.handleDeleteOrder))
+	mux.HandleFunc("GET /admin/customers/{id}/export", s.requireAdmin(s.handleCustomerExport))
+	mux.HandleFunc("POST /admin/customers/{id}/erase", s.requireAdmin(s.handleCustomerErase))
+	mux.Handle("/", http.FileServer(http.Dir("./internal/api/web")))
+	mux.HandleFunc("/healt", handleHealth)
+	mux.HandleFunc("GET /ready", s.handleReady)
+
+	mux.HandleFunc("GET /admin/cache/stats", s.requireAdmin(s.handleCacheStats))
+	mux.HandleFunc("DELETE /admin/cache/{id}", s.requireAdmin(s.handleCacheInvalidate))
+	mux.HandleFunc("DELETE /admin/cache", s.requireAdmin(s.handleCacheClear))
+	mux.HandleFunc("POST /admin/cache/warmup", s.requireAdmin(s.handleCacheWarmup))
+	return mux
+}
+
 // Обработчик GET-запросов по order_id
 func (s *Server) handleGetOrderByID(w http.ResponseWriter, r *http.Request) {
 	orderID := r.URL.Path[len("/orders/"):]
@@ -100,6 +138,183 @@ func (s *Server) handleGetOrderByID(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Обработчик GET /orders/{id}/history?limit=N. Журнал изменений заказа от старых к новым
+func (s *Server) handleOrderHistory(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Minute)
+	defer cancel()
+	history, err := s.service.GetOrderHistory(ctx, r.PathValue("id"), limit)
+	switch {
+	case err == nil:
+		s.writeJSON(w, http.StatusOK, history)
+	case errors.Is(err, repo.ErrOrderNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, svc.ErrNotSupported):
+		w.WriteHeader(http.StatusNotImplemented)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		s.handleError("Failed to get order history", err)
+	}
+}
+
+// Обработчик GET /orders/{id}/raw?limit=N. Исходные сообщения Kafka заказа с метаданными, сначала новые.
+// Только для администратора: в сообщениях и заголовках могут быть данные, которых нет в API
+func (s *Server) handleOrderRaw(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Minute)
+	defer cancel()
+	raw, err := s.service.GetOrderRawMessages(ctx, r.PathValue("id"), limit)
+	switch {
+	case err == nil:
+		s.writeJSON(w, http.StatusOK, raw)
+	case errors.Is(err, repo.ErrOrderNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, svc.ErrNotSupported):
+		w.WriteHeader(http.StatusNotImplemented)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		s.handleError("Failed to get order raw messages", err)
+	}
+}
+
+// Обработчик GET /orders/search?q=...&limit=N&offset=M. Поиск заказов по имени, телефону, email, городу,
+// названию и бренду позиций. С ключами PII имя, телефон и email находятся только точным совпадением.
+// Только для администратора: выдача содержит персональные данные
+func (s *Server) handleSearchOrders(w http.ResponseWriter, r *http.Request) {
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	if n := utf8.RuneCountInString(text); n < model.MinSearchLen || n > model.MaxSearchLen {
+		http.Error(w, fmt.Sprintf("q must be %d to %d characters", model.MinSearchLen, model.MaxSearchLen),
+			http.StatusBadRequest)
+		return
+	}
+	limit, ok := parseLimit(w, r)
+	if !ok {
+		return
+	}
+	if limit > model.MaxSearchLimit {
+		http.Error(w, "bad limit", http.StatusBadRequest)
+		return
+	}
+	offset := 0
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "bad offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Minute)
+	defer cancel()
+	page, err := s.service.SearchOrders(ctx, model.SearchQuery{Text: text, Limit: limit, Offset: offset})
+	switch {
+	case err == nil:
+		s.writeJSON(w, http.StatusOK, page)
+	case errors.Is(err, svc.ErrNotSupported):
+		w.WriteHeader(http.StatusNotImplemented)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		s.handleError("Failed to search orders", err)
+	}
+}
+
+// parseLimit необязательный параметр limit > 0. 0 - не задан. При ошибке отвечает 400 и возвращает false
+func parseLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		http.Error(w, "bad limit", http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
+// Обработчик DELETE /orders/{id}. Мягкое удаление заказа, только для администратора
+func (s *Server) handleDeleteOrder(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Minute)
+	defer cancel()
+	ctx = model.WithChangeSource(ctx, model.ChangeSource{Kind: model.SourceAPI, User: "admin"})
+
+	err := s.service.DeleteOrder(ctx, r.PathValue("id"))
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, repo.ErrOrderNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		s.handleError("Failed to delete order", err)
+	}
+}
+
+// Обработчик GET /admin/customers/{id}/export. Все заказы покупателя с историей и исходными сообщениями
+// по запросу на выгрузку данных (GDPR). Только для администратора, выгрузка пишется в журнал аудита
+func (s *Server) handleCustomerExport(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Minute)
+	defer cancel()
+	ctx = model.WithChangeSource(ctx, model.ChangeSource{Kind: model.SourceAPI, User: "admin"})
+
+	export, err := s.service.ExportCustomerData(ctx, r.PathValue("id"))
+	switch {
+	case err == nil:
+		s.writeJSON(w, http.StatusOK, export)
+	case errors.Is(err, svc.ErrNotSupported):
+		w.WriteHeader(http.StatusNotImplemented)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		s.handleError("Failed to export customer data", err)
+	}
+}
+
+// Обработчик POST /admin/customers/{id}/erase. Анонимизирует персональные данные покупателя (GDPR),
+// суммы заказов остаются. Только для администратора, операция пишется в журнал аудита
+func (s *Server) handleCustomerErase(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Minute)
+	defer cancel()
+	ctx = model.WithChangeSource(ctx, model.ChangeSource{Kind: model.SourceAPI, User: "admin"})
+
+	res, err := s.service.EraseCustomerData(ctx, r.PathValue("id"))
+	switch {
+	case err == nil:
+		s.writeJSON(w, http.StatusOK, res)
+	case errors.Is(err, svc.ErrNotSupported):
+		w.WriteHeader(http.StatusNotImplemented)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		s.handleError("Failed to erase customer data", err)
+	}
+}
+
+// requireAdmin пропускает запрос только с заголовком Authorization: Bearer <AdminToken>.
+// Без настроенного токена отвечает 403
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
 func handleHealth(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
@@ -111,3 +326,68 @@ func handleHealth(w http.ResponseWriter, _ *http.Request) {
 		log.Println(err.Error())
 	}
 }
+
+// Обработчик GET /admin/cache/stats
+func (s *Server) handleCacheStats(w http.ResponseWriter, _ *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.service.CacheStats())
+}
+
+// Обработчик DELETE /admin/cache/{id}. Удаляет один заказ из кеша
+func (s *Server) handleCacheInvalidate(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.service.InvalidateCache(r.Context(), id); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		s.handleError("Failed to invalidate cache", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Обработчик DELETE /admin/cache. Полностью очищает кеш
+func (s *Server) handleCacheClear(w http.ResponseWriter, r *http.Request) {
+	if err := s.service.ClearCache(r.Context()); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		s.handleError("Failed to clear cache", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Обработчик POST /admin/cache/warmup. Заново заполняет кеш из БД и возвращает статистику
+func (s *Server) handleCacheWarmup(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Minute)
+	defer cancel()
+	if err := s.service.WarmupCache(ctx); err != nil {
+		if errors.Is(err, repo.ErrWarmupInProgress) {
+			s.writeJSON(w, http.StatusConflict, s.service.WarmupStatus())
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		s.handleError("Failed to warmup cache", err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.service.CacheStats())
+}
+
+// Обработчик GET /ready. Пока идет прогрев кеша отвечает 503 и отдает прогресс.
+// Неудачный прогрев не делает сервис неготовым: чтения все равно идут через БД
+func (s *Server) handleReady(w http.ResponseWriter, _ *http.Request) {
+	status := s.service.WarmupStatus()
+	code := http.StatusOK
+	if !status.Finished() {
+		code = http.StatusServiceUnavailable
+	}
+	s.writeJSON(w, code, map[string]any{
+		"ready":  code == http.StatusOK,
+		"warmup": status,
+	})
+}
+
+// writeJSON пишет v в ответ с заданным статусом
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.handleError("Failed to encode response", err)
+	}
+}
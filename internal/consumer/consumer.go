@@ -2,16 +2,17 @@
 package consumer
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/gogazub/myapp/internal/model"
 	svc "github.com/gogazub/myapp/internal/service"
+	"github.com/gogazub/myapp/ordermodel"
 	"github.com/segmentio/kafka-go"
 )
 
@@ -22,6 +23,9 @@ type Config struct {
 	GroupID  string
 	MinBytes int
 	MaxBytes int
+	// StrictAmounts отклонять заказы, у которых amount не равен сумме позиций, доставки и сбора
+	// (ordermodel.ValidateStrict). Исходный формат этого не требовал, поэтому по умолчанию выключено
+	StrictAmounts bool
 }
 
 // IConsumer никуда ни инъектируется, так что интерфейс не нужен
@@ -35,20 +39,25 @@ type IReader interface {
 	Close() error
 }
 
-// Consumer получает сообщения из reader; валидирует их через validate; передает валидные сообщения в service
+// Consumer получает сообщения из reader; валидирует их по правилам ordermodel; передает валидные сообщения в service
 type Consumer struct {
-	reader   IReader
-	service  svc.IService
-	validate *validator.Validate
+	reader        IReader
+	service       svc.IService
+	strictAmounts bool
 }
 
 // NewConsumer конструктор
 func NewConsumer(service svc.IService, reader IReader) *Consumer {
+	return NewConsumerWithConfig(service, reader, Config{})
+}
 
+// NewConsumerWithConfig конструктор с настройками обработки сообщений. Параметры подключения из cfg
+// не используются: reader уже создан
+func NewConsumerWithConfig(service svc.IService, reader IReader, cfg Config) *Consumer {
 	return &Consumer{
-		reader:   reader,
-		service:  service,
-		validate: validator.New(),
+		reader:        reader,
+		service:       service,
+		strictAmounts: cfg.StrictAmounts,
 	}
 }
 
@@ -72,28 +81,94 @@ func (c *Consumer) Start(ctx context.Context) error {
 
 // Обработка сообщения из кафки
 func (c *Consumer) processMessage(ctx context.Context, msg kafka.Message) error {
-	var order model.Order
-	decoder := json.NewDecoder(bytes.NewReader(msg.Value))
-	decoder.DisallowUnknownFields()
-	if err := decoder.Decode(&order); err != nil {
+	// Исходное сообщение сохраняется до разбора: отклоненное тоже остается в order_raw_messages
+	raw := rawMessage(msg)
+	if err := c.saveRaw(ctx, raw); err != nil {
 		return fmt.Errorf("processing message error: %w", err)
 	}
 
+	// Сообщение версии новее ordermodel.SchemaVersion отклоняется: читать его как текущую нельзя
+	order, err := ordermodel.DecodeVersion(msg.Value, raw.SchemaVersion)
+	if err != nil {
+		return c.reject(ctx, raw, fmt.Errorf("processing message error: %w", err))
+	}
+
 	log.Printf("get message: %s", order.OrderUID)
 
-	// Валидация через validator
-	// Можно добавить валидацию с бизнес логикой. Например, что cost == сумме всех item
-	if err := c.validate.Struct(order); err != nil {
-		return fmt.Errorf("processing message error:%w", err)
+	// Валидация через validator и проверка сумм. Сходимость amount - только в строгом режиме
+	validate := ordermodel.Validate
+	if c.strictAmounts {
+		validate = ordermodel.ValidateStrict
+	}
+	if err := validate(order); err != nil {
+		return c.reject(ctx, raw, fmt.Errorf("processing message error:%w", err))
 	}
 
-	if err := c.service.SaveOrder(ctx, &order); err != nil {
+	// Источник изменения попадет в историю заказа
+	ctx = model.WithChangeSource(ctx, model.ChangeSource{
+		Kind:      model.SourceKafka,
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+	})
+	if err := c.service.SaveOrder(ctx, order); err != nil {
 		return fmt.Errorf("processing message error:%w", err)
 	}
 
 	return nil
 }
 
+// saveRaw сохраняет исходное сообщение. Хранилище без исходных сообщений не ошибка
+func (c *Consumer) saveRaw(ctx context.Context, raw model.RawMessage) error {
+	if err := c.service.SaveRawMessage(ctx, raw); err != nil && !errors.Is(err, svc.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// reject дописывает к сохраненному исходному сообщению причину отклонения и возвращает ее
+func (c *Consumer) reject(ctx context.Context, raw model.RawMessage, reason error) error {
+	raw.Rejection = reason.Error()
+	if err := c.saveRaw(ctx, raw); err != nil {
+		c.handleError("saving rejected message error", err)
+	}
+	return reason
+}
+
+// rawMessage исходное сообщение с метаданными. Версия схемы берется из заголовка ordermodel.SchemaVersionHeader,
+// order_uid и shardkey - из тела, если их удается прочитать
+func rawMessage(msg kafka.Message) model.RawMessage {
+	var ids struct {
+		OrderUID string `json:"order_uid"`
+		Shardkey string `json:"shardkey"`
+	}
+	// Ошибка не важна: тело может быть невалидным, поля заполняются, насколько удалось разобрать
+	_ = json.Unmarshal(msg.Value, &ids)
+	raw := model.RawMessage{
+		OrderUID:      ids.OrderUID,
+		Shardkey:      ids.Shardkey,
+		Payload:       msg.Value,
+		Topic:         msg.Topic,
+		Partition:     msg.Partition,
+		Offset:        msg.Offset,
+		Headers:       make([]model.RawHeader, 0, len(msg.Headers)),
+		Timestamp:     msg.Time,
+		SchemaVersion: model.CurrentSchemaVersion,
+	}
+	for _, h := range msg.Headers {
+		raw.Headers = append(raw.Headers, model.RawHeader{Key: h.Key, Value: string(h.Value)})
+		if h.Key == ordermodel.SchemaVersionHeader {
+			// Нечисловой заголовок - версия 0, DecodeVersion ее отклонит
+			v, err := strconv.Atoi(strings.TrimSpace(string(h.Value)))
+			if err != nil {
+				v = 0
+			}
+			raw.SchemaVersion = v
+		}
+	}
+	return raw
+}
+
 // Close Закрывает подключение с kafka
 func (c *Consumer) Close() error {
 	return c.reader.Close()
@@ -0,0 +1,170 @@
+// Package listener слушает уведомления Postgres (LISTEN/NOTIFY) об изменении заказов и обновляет кеш
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	repo "github.com/gogazub/myapp/internal/repository"
+	svc "github.com/gogazub/myapp/internal/service"
+	"github.com/lib/pq"
+)
+
+// Channel канал NOTIFY, в который триггеры пишут order_uid измененного заказа
+const Channel = "order_changed"
+
+// pingInterval как часто проверять соединение, если уведомлений нет.
+// Без пинга pq может не заметить разрыв на тихом канале
+const pingInterval = 90 * time.Second
+
+// defaultResyncRetry пауза перед повтором пересборки кеша, если прогрев уже идет
+const defaultResyncRetry = 5 * time.Second
+
+// INotifier вынесен в интерфейс ради тестов. *pq.Listener реализует его как есть.
+// После переподключения pq присылает в NotificationChannel nil
+type INotifier interface {
+	Listen(channel string) error
+	NotificationChannel() <-chan *pq.Notification
+	Ping() error
+	Close() error
+}
+
+// Config настройки Listener. Нулевые значения заменяются значениями по умолчанию
+type Config struct {
+	// ResyncRetry пауза перед повтором пересборки кеша, пока идет прогрев (repository.ErrWarmupInProgress)
+	ResyncRetry time.Duration
+}
+
+// Listener получает order_uid из канала order_changed и обновляет эти заказы в кеше.
+// После разрыва соединения кеш инстанса пересобирается в фоне: уведомления за время простоя потеряны
+type Listener struct {
+	notifier    INotifier
+	service     svc.IService
+	resyncRetry time.Duration
+}
+
+// NewListener конструктор
+func NewListener(service svc.IService, notifier INotifier) *Listener {
+	return NewListenerWithConfig(service, notifier, Config{})
+}
+
+// NewListenerWithConfig конструктор с настройками
+func NewListenerWithConfig(service svc.IService, notifier INotifier, cfg Config) *Listener {
+	if cfg.ResyncRetry <= 0 {
+		cfg.ResyncRetry = defaultResyncRetry
+	}
+	return &Listener{
+		notifier:    notifier,
+		service:     service,
+		resyncRetry: cfg.ResyncRetry,
+	}
+}
+
+// NewPQNotifier создает *pq.Listener с переподключением и логированием событий соединения
+func NewPQNotifier(connStr string) *pq.Listener {
+	return pq.NewListener(connStr, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			log.Printf("order listener disconnected:%v", err)
+		case pq.ListenerEventReconnected:
+			log.Printf("order listener reconnected")
+		case pq.ListenerEventConnectionAttemptFailed:
+			log.Printf("order listener connection attempt failed:%v", err)
+		}
+	})
+}
+
+// Start подписывается на канал и обрабатывает уведомления до отмены ctx
+func (l *Listener) Start(ctx context.Context) error {
+	if err := l.notifier.Listen(Channel); err != nil && !errors.Is(err, pq.ErrChannelAlreadyOpen) {
+		return fmt.Errorf("listen %s error:%w", Channel, err)
+	}
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	// Пересборка кеша идет в отдельной горутине, чтобы уведомления не копились, пока грузится кеш.
+	// Переподключения во время пересборки схлопываются в одну следующую
+	resync := make(chan struct{}, 1)
+	resyncDone := make(chan struct{})
+	go func() {
+		defer close(resyncDone)
+		l.runResync(ctx, resync)
+	}()
+	defer func() { <-resyncDone }()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return context.Canceled
+		case n, ok := <-l.notifier.NotificationChannel():
+			if !ok {
+				return fmt.Errorf("notification channel closed")
+			}
+			l.handleNotification(ctx, n, resync)
+		case <-ticker.C:
+			go func() {
+				if err := l.notifier.Ping(); err != nil {
+					l.handleError("listener ping error", err)
+				}
+			}()
+		}
+	}
+}
+
+// handleNotification nil - соединение восстановлено, иначе payload - order_uid
+func (l *Listener) handleNotification(ctx context.Context, n *pq.Notification, resync chan<- struct{}) {
+	if n == nil {
+		log.Printf("order listener reconnected - resyncing cache")
+		select {
+		case resync <- struct{}{}:
+		default: // пересборка уже запрошена
+		}
+		return
+	}
+	if n.Extra == "" {
+		return
+	}
+	if err := l.service.RefreshCache(ctx, n.Extra); err != nil {
+		l.handleError("cache refresh error", err)
+	}
+}
+
+// runResync пересобирает кеш по сигналам из resync до отмены ctx. Если прогрев уже идет
+// (стартовый или от listener другого шарда), повторяет через resyncRetry: прогрев, начатый раньше
+// разрыва, пропущенные уведомления не учитывает
+func (l *Listener) runResync(ctx context.Context, resync <-chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-resync:
+		}
+		for {
+			err := l.service.ResyncCache(ctx)
+			if !errors.Is(err, repo.ErrWarmupInProgress) {
+				if err != nil && ctx.Err() == nil {
+					l.handleError("cache resync error", err)
+				}
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(l.resyncRetry):
+			}
+		}
+	}
+}
+
+// Close закрывает соединение LISTEN
+func (l *Listener) Close() error {
+	return l.notifier.Close()
+}
+
+func (l *Listener) handleError(msg string, err error) {
+	log.Printf("%s:%v", msg, err)
+}
@@ -0,0 +1,340 @@
+// Package migrate применяет версионированные SQL-миграции из fs.FS (обычно migrations.FS).
+// Текущая версия хранится в schema_migrations (формат совместим с golang-migrate),
+// параллельные запуски с нескольких реплик сериализуются advisory lock'ом Postgres.
+// Для встроенной SQLite (NewWithDialect(db, fsys, SQLite)) advisory lock не нужен
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// lockKey ключ pg_advisory_lock, общий для всех экземпляров сервиса
+const lockKey = int64(0x6f726465727321) // "orders!"
+
+// ErrDirty предыдущая миграция упала посередине. Нужно починить схему и выполнить force
+var ErrDirty = errors.New("database is dirty")
+
+// ErrUnknownVersion версии из БД или из аргумента нет среди миграций
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+// Migration одна версия схемы: пара файлов up/down
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status состояние схемы
+type Status struct {
+	// Version последняя примененная версия, 0 - миграций не было
+	Version uint
+	Dirty   bool
+	// Pending миграции, которые применит up
+	Pending []Migration
+}
+
+// Dialect СУБД, к которой применяются миграции
+type Dialect int
+
+const (
+	// Postgres запуски с нескольких реплик сериализуются pg_advisory_lock
+	Postgres Dialect = iota
+	// SQLite БД принадлежит одному процессу, записи сериализует блокировка файла
+	SQLite
+)
+
+// Migrator применяет миграции к db
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+var fileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Load читает миграции из fsys. У каждой версии должны быть оба файла: up и down
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("load migrations error:%w", err)
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		m := fileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("load migrations error: bad file name %q", e.Name())
+		}
+		v, err := strconv.ParseUint(m[1], 10, 32)
+		if err != nil || v == 0 {
+			return nil, fmt.Errorf("load migrations error: bad version in %q", e.Name())
+		}
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("load migrations error:%w", err)
+		}
+
+		mig, ok := byVersion[uint(v)]
+		if !ok {
+			mig = &Migration{Version: uint(v), Name: m[2]}
+			byVersion[uint(v)] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("load migrations error: version %d has names %q and %q", v, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("load migrations error: version %d must have both up and down files", mig.Version)
+		}
+		out = append(out, *mig)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// New конструктор для Postgres. Миграции читаются из fsys сразу, ошибки в файлах видны до подключения к БД
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	return NewWithDialect(db, fsys, Postgres)
+}
+
+// NewWithDialect конструктор для СУБД dialect
+func NewWithDialect(db *sql.DB, fsys fs.FS, dialect Dialect) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Migrations список известных миграций по возрастанию версии
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up применяет до n ожидающих миграций (n <= 0 - все). Возвращает число примененных
+func (m *Migrator) Up(ctx context.Context, n int) (int, error) {
+	applied := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		version, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w: version %d", ErrDirty, version)
+		}
+		start, err := m.indexAfter(version)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations[start:] {
+			if n > 0 && applied >= n {
+				break
+			}
+			log.Printf("migrate up: %06d_%s", mig.Version, mig.Name)
+			if err := m.apply(ctx, conn, mig.Version, mig.Up, mig.Version); err != nil {
+				return fmt.Errorf("migration %06d_%s up error:%w", mig.Version, mig.Name, err)
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down откатывает n последних миграций (n <= 0 - одну). Возвращает число откаченных
+func (m *Migrator) Down(ctx context.Context, n int) (int, error) {
+	if n <= 0 {
+		n = 1
+	}
+	reverted := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		version, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w: version %d", ErrDirty, version)
+		}
+		idx, err := m.indexAfter(version)
+		if err != nil {
+			return err
+		}
+		for i := idx - 1; i >= 0 && reverted < n; i-- {
+			mig := m.migrations[i]
+			var prev uint
+			if i > 0 {
+				prev = m.migrations[i-1].Version
+			}
+			log.Printf("migrate down: %06d_%s", mig.Version, mig.Name)
+			if err := m.apply(ctx, conn, mig.Version, mig.Down, prev); err != nil {
+				return fmt.Errorf("migration %06d_%s down error:%w", mig.Version, mig.Name, err)
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status текущая версия, флаг dirty и ожидающие миграции
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	var st Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		version, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		st.Version, st.Dirty = version, dirty
+		idx, err := m.indexAfter(version)
+		if err != nil {
+			return err
+		}
+		st.Pending = m.migrations[idx:]
+		return nil
+	})
+	return st, err
+}
+
+// Force записывает версию без выполнения SQL и снимает dirty.
+// Нужен после ручной починки упавшей миграции и для БД, схема которых создана не мигратором.
+// version 0 - миграций не было
+func (m *Migrator) Force(ctx context.Context, version uint) error {
+	if version != 0 {
+		if _, err := m.indexAfter(version); err != nil {
+			return err
+		}
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		return setVersion(ctx, conn, version, false)
+	})
+}
+
+// apply помечает версию mark как dirty, затем в одной транзакции выполняет body
+// и записывает итоговую версию to. При ошибке версия остается dirty
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mark uint, body string, to uint) error {
+	if err := setVersion(ctx, conn, mark, true); err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Printf("Rollback error:%s", err.Error())
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	if err := setVersion(ctx, tx, to, false); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// indexAfter индекс первой миграции после version. version должна быть известна (или 0)
+func (m *Migrator) indexAfter(version uint) (int, error) {
+	if version == 0 {
+		return 0, nil
+	}
+	for i, mig := range m.migrations {
+		if mig.Version == version {
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+}
+
+// withLock выполняет fn на выделенном соединении под pg_advisory_lock (для SQLite - без него).
+// Advisory lock сессионный, поэтому lock, миграции и unlock идут через одно соединение
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("migrate lock error:%w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Printf("migrate conn close error:%s", err)
+		}
+	}()
+
+	if m.dialect == Postgres {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+			return fmt.Errorf("migrate lock error:%w", err)
+		}
+		defer func() {
+			// ctx мог быть отменен, а снять lock нужно в любом случае
+			if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey); err != nil {
+				log.Printf("migrate unlock error:%s", err)
+			}
+		}()
+	}
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL PRIMARY KEY,
+		dirty BOOLEAN NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("migrate init error:%w", err)
+	}
+	return fn(conn)
+}
+
+type execQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func readVersion(ctx context.Context, q execQuerier) (uint, bool, error) {
+	var (
+		version int64
+		dirty   bool
+	)
+	err := q.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("read migration version error:%w", err)
+	}
+	return uint(version), dirty, nil
+}
+
+// setVersion в таблице всегда не больше одной строки
+func setVersion(ctx context.Context, q execQuerier, version uint, dirty bool) error {
+	if _, err := q.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return fmt.Errorf("set migration version error:%w", err)
+	}
+	if version == 0 && !dirty {
+		return nil
+	}
+	if _, err := q.ExecContext(ctx,
+		`INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, int64(version), dirty); err != nil {
+		return fmt.Errorf("set migration version error:%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Источники изменения заказа
+const (
+	SourceKafka  = "kafka"
+	SourceAPI    = "api"
+	SourceSystem = "system"
+	SourceCLI    = "cli"
+)
+
+// Операции в истории заказа
+const (
+	HistoryCreate = "create"
+	HistoryUpdate = "update"
+	HistoryDelete = "delete"
+	// HistoryErase персональные данные заказа удалены по запросу покупателя
+	HistoryErase = "erase"
+)
+
+// ChangeSource откуда пришло изменение: сообщение Kafka или запрос к API
+type ChangeSource struct {
+	Kind      string `json:"kind"`
+	Topic     string `json:"topic,omitempty"`
+	Partition int    `json:"partition,omitempty"`
+	Offset    int64  `json:"offset,omitempty"`
+	// User пользователь API, выполнивший изменение
+	User string `json:"user,omitempty"`
+}
+
+// FieldChange изменение одного поля. Field - путь в терминах JSON заказа:
+// "payment.amount", "items[chrt_id=9934930].price". Old/New nil - позиции не было или она удалена
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// HistoryEntry запись журнала изменений заказа
+type HistoryEntry struct {
+	ID        int64         `json:"id"`
+	OrderUID  string        `json:"order_uid"`
+	ChangedAt time.Time     `json:"changed_at"`
+	Op        string        `json:"op"`
+	Source    ChangeSource  `json:"source"`
+	Changes   []FieldChange `json:"changes"`
+}
+
+type changeSourceKey struct{}
+
+// WithChangeSource кладет источник изменения в контекст. Репозиторий пишет его в историю
+func WithChangeSource(ctx context.Context, src ChangeSource) context.Context {
+	return context.WithValue(ctx, changeSourceKey{}, src)
+}
+
+// ChangeSourceFrom источник изменения из контекста. Без него - SourceSystem
+func ChangeSourceFrom(ctx context.Context) ChangeSource {
+	if src, ok := ctx.Value(changeSourceKey{}).(ChangeSource); ok {
+		return src
+	}
+	return ChangeSource{Kind: SourceSystem}
+}
+
+// DiffOrders поля, которые отличаются у old и new. old == nil - заказ создается, сравнение идет с пустым заказом.
+// Items сопоставляются по chrt_id. Служебные поля (id строк, json:"-") не сравниваются
+func DiffOrders(old, new *Order) []FieldChange {
+	if old == nil {
+		old = &Order{}
+	}
+	var changes []FieldChange
+	changes = diffStruct(changes, "", reflect.ValueOf(*old), reflect.ValueOf(*new))
+	changes = diffStruct(changes, "delivery.", reflect.ValueOf(old.Delivery), reflect.ValueOf(new.Delivery))
+	changes = diffStruct(changes, "payment.", reflect.ValueOf(old.Payment), reflect.ValueOf(new.Payment))
+
+	oldItems := make(map[int64]Item, len(old.Items))
+	for _, it := range old.Items {
+		oldItems[it.ChrtID] = it
+	}
+	seen := make(map[int64]bool, len(new.Items))
+	for _, it := range new.Items {
+		seen[it.ChrtID] = true
+		prefix := fmt.Sprintf("items[chrt_id=%d]", it.ChrtID)
+		prev, ok := oldItems[it.ChrtID]
+		if !ok {
+			changes = append(changes, FieldChange{Field: prefix, New: it})
+			continue
+		}
+		changes = diffStruct(changes, prefix+".", reflect.ValueOf(prev), reflect.ValueOf(it))
+	}
+	for _, it := range old.Items {
+		if !seen[it.ChrtID] {
+			changes = append(changes, FieldChange{Field: fmt.Sprintf("items[chrt_id=%d]", it.ChrtID), Old: it})
+		}
+	}
+	return changes
+}
+
+// diffStruct сравнивает скалярные поля структуры с json-тегом. Вложенные структуры и срезы пропускаются
+func diffStruct(changes []FieldChange, prefix string, a, b reflect.Value) []FieldChange {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		switch f.Type.Kind() {
+		case reflect.Struct:
+			if f.Type != reflect.TypeOf(time.Time{}) {
+				continue
+			}
+		case reflect.Slice, reflect.Map:
+			continue
+		}
+		av, bv := a.Field(i).Interface(), b.Field(i).Interface()
+		if equalValues(av, bv) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: prefix + name, Old: av, New: bv})
+	}
+	return changes
+}
+
+func equalValues(a, b any) bool {
+	if at, ok := a.(time.Time); ok {
+		return at.Equal(b.(time.Time))
+	}
+	return a == b
+}
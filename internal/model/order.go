@@ -1,119 +1,46 @@
-// Package model - модели заказа для маршалинга в json; сохранение в бд; задает валидацию.
+// Package model - модели сервиса: история изменений, поиск, исходные сообщения, выгрузки.
+// Модель заказа и Money живут в общем с producer модуле ordermodel, здесь - их псевдонимы
 package model
 
-import (
-	"fmt"
-	"time"
+import "github.com/gogazub/myapp/ordermodel"
+
+// Модель заказа из ordermodel
+type (
+	Order    = ordermodel.Order
+	Delivery = ordermodel.Delivery
+	Payment  = ordermodel.Payment
+	Item     = ordermodel.Item
+	OrderLog = ordermodel.OrderLog
+	Money    = ordermodel.Money
 )
 
-// Order модель заказа
-type Order struct {
-	OrderUID          string    `json:"order_uid" db:"order_uid" validate:"required"`
-	TrackNumber       string    `json:"track_number" db:"track_number" validate:"-"`
-	Entry             string    `json:"entry" db:"entry" validate:"-"`
-	Locale            string    `json:"locale" db:"locale" validate:"-"`
-	InternalSignature string    `json:"internal_signature" db:"internal_signature" validate:"-"`
-	CustomerID        string    `json:"customer_id" db:"customer_id" validate:"required"`
-	DeliveryService   string    `json:"delivery_service" db:"delivery_service" validate:"-"`
-	Shardkey          string    `json:"shardkey" db:"shardkey" validate:"-"`
-	SmID              int       `json:"sm_id" db:"sm_id" validate:"gte=0"`
-	DateCreated       time.Time `json:"date_created" db:"date_created" validate:"required"`
-	OofShard          string    `json:"oof_shard" db:"oof_shard" validate:"required"`
+// Ошибки разбора и валидации из ordermodel
+var (
+	ErrMoneyFormat              = ordermodel.ErrMoneyFormat
+	ErrMoneyPrecision           = ordermodel.ErrMoneyPrecision
+	ErrCurrencyNotSupported     = ordermodel.ErrCurrencyNotSupported
+	ErrAmountMismatch           = ordermodel.ErrAmountMismatch
+	ErrDuplicateItem            = ordermodel.ErrDuplicateItem
+	ErrUnsupportedSchemaVersion = ordermodel.ErrUnsupportedSchemaVersion
+)
 
-	Delivery Delivery `json:"delivery" validate:"required"`
-	Payment  Payment  `json:"payment"  validate:"required"`
-	Items    []Item   `json:"items"    validate:"required,min=1,dive"`
-}
+// GetOrderLog создает облегченный объект OrderLog для логирования
+func GetOrderLog(o *Order) OrderLog { return ordermodel.GetOrderLog(o) }
 
-// Delivery модель поля delivery из модели Order`а
-type Delivery struct {
-	DeliveryID int    `json:"-" db:"delivery_id" validate:"-"`
-	OrderUID   string `json:"-" db:"order_uid"    validate:"-"`
-	Name       string `json:"name" db:"name"       validate:"required"`
-	Phone      string `json:"phone" db:"phone"     validate:"required"`
-	Zip        string `json:"zip" db:"zip"         validate:"required"`
-	City       string `json:"city" db:"city"       validate:"required"`
-	Address    string `json:"address" db:"address" validate:"required"`
-	Region     string `json:"region" db:"region"   validate:"required"`
-	Email      string `json:"email" db:"email"     validate:"required,email"`
-}
+// NewMoney сумма из целых единиц и сотых: NewMoney(149, 90) = 149.90
+func NewMoney(units, cents int64) Money { return ordermodel.NewMoney(units, cents) }
 
-// Payment модель поля payment из модели Order`а
-type Payment struct {
-	PaymentID    int     `json:"-" db:"payment_id" validate:"-"`
-	OrderUID     string  `json:"-" db:"order_uid"   validate:"-"`
-	Transaction  string  `json:"transaction" db:"transaction" validate:"required"`
-	RequestID    string  `json:"request_id" db:"request_id"   validate:"omitempty"`
-	Currency     string  `json:"currency" db:"currency"       validate:"required"`
-	Provider     string  `json:"provider" db:"provider"       validate:"required"`
-	Amount       float64 `json:"amount" db:"amount"           validate:"gte=0"`
-	PaymentDt    int64   `json:"payment_dt" db:"payment_dt"   validate:"gte=0"`
-	Bank         string  `json:"bank" db:"bank"               validate:"required"`
-	DeliveryCost float64 `json:"delivery_cost" db:"delivery_cost" validate:"gte=0"`
-	GoodsTotal   int     `json:"goods_total" db:"goods_total"     validate:"gte=0"`
-	CustomFee    float64 `json:"custom_fee" db:"custom_fee"       validate:"gte=0"`
-}
+// MoneyFromFloat округляет float64 до сотых. Только для старого кода и генераторов
+func MoneyFromFloat(f float64) Money { return ordermodel.MoneyFromFloat(f) }
 
-// Item модель массива item из модели Order`а
-type Item struct {
-	ItemID      int     `json:"-" db:"item_id"         validate:"-"`
-	OrderUID    string  `json:"-" db:"order_uid"       validate:"-"`
-	ChrtID      int64   `json:"chrt_id" db:"chrt_id"   validate:"required,gte=1"`
-	TrackNumber string  `json:"track_number" db:"track_number" validate:"required"`
-	Price       float64 `json:"price" db:"price"       validate:"gte=0"`
-	Rid         string  `json:"rid" db:"rid"           validate:"-"`
-	Name        string  `json:"name" db:"name"         validate:"-"`
-	Sale        int     `json:"sale" db:"sale"         validate:"gte=0,lte=100"`
-	Size        string  `json:"size" db:"size"         validate:"omitempty"`
-	TotalPrice  float64 `json:"total_price" db:"total_price" validate:"gte=0"`
-	NmID        int64   `json:"nm_id" db:"nm_id"       validate:"gte=0"`
-	Brand       string  `json:"brand" db:"brand"       validate:"-"`
-	Status      int     `json:"status" db:"status"     validate:"gte=0"`
-}
+// ParseMoney разбирает десятичную строку без float64
+func ParseMoney(s string) (Money, error) { return ordermodel.ParseMoney(s) }
 
-// OrderLog облегченная модель для логирования
-type OrderLog struct {
-	UID         string    `json:"order_uid"`
-	Track       string    `json:"track_number,omitempty"`
-	DateCreated time.Time `json:"date_created"`
-	ItemsCount  int       `json:"items_count"`
-	ItemsTotal  float64   `json:"items_total"`
-	Tx          string    `json:"tx,omitempty"`
-	Amount      float64   `json:"amount,omitempty"`
-	Currency    string    `json:"currency,omitempty"`
-}
+// MustMoney как ParseMoney, но паникует на ошибке. Для констант и тестов
+func MustMoney(s string) Money { return ordermodel.MustMoney(s) }
 
-// GetOrderLog создает облегченный объект OrderLog для логирования
-func GetOrderLog(o *Order) OrderLog {
-	var sum float64
-	for _, it := range o.Items {
-		sum += it.TotalPrice
-	}
-	return OrderLog{
-		UID:         o.OrderUID,
-		Track:       o.TrackNumber,
-		DateCreated: o.DateCreated,
-		ItemsCount:  len(o.Items),
-		ItemsTotal:  sum,
-		Tx:          o.Payment.Transaction,
-		Amount:      o.Payment.Amount,
-		Currency:    o.Payment.Currency,
-	}
-}
+// SumMoney сумма нескольких значений
+func SumMoney(values ...Money) Money { return ordermodel.SumMoney(values...) }
 
-func (o *OrderLog) String() string {
-	if o == nil {
-		return "<nil>"
-	}
-	return fmt.Sprintf(
-		"order_uid=%s track=%s tx=%s amount=%.2f %s items={count:%d total:%.2f} date=%s",
-		o.UID,
-		o.Track,
-		o.Tx,
-		o.Amount,
-		o.Currency,
-		o.ItemsCount,
-		o.ItemsTotal,
-		o.DateCreated.UTC().Format(time.RFC3339),
-	)
-}
+// CurrencyExponent число знаков минимальной единицы валюты
+func CurrencyExponent(currency string) int { return ordermodel.CurrencyExponent(currency) }
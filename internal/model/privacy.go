@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+// Операции в журнале аудита запросов покупателей к их персональным данным
+const (
+	PrivacyExport = "export"
+	PrivacyErase  = "erase"
+)
+
+// ExportedOrder заказ в выгрузке данных покупателя: формат API плюс время мягкого удаления
+// и признак того, что заказ перенесен в архив retention
+type ExportedOrder struct {
+	*Order
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Archived  bool       `json:"archived,omitempty"`
+}
+
+// CustomerExport все данные, связанные с customer_id: заказы (включая удаленные и архивные),
+// их история изменений и исходные сообщения Kafka
+type CustomerExport struct {
+	CustomerID  string          `json:"customer_id"`
+	ExportedAt  time.Time       `json:"exported_at"`
+	Orders      []ExportedOrder `json:"orders"`
+	History     []HistoryEntry  `json:"history"`
+	RawMessages []RawMessage    `json:"raw_messages"`
+}
+
+// CustomerErasure результат удаления персональных данных покупателя
+type CustomerErasure struct {
+	CustomerID string    `json:"customer_id"`
+	ErasedAt   time.Time `json:"erased_at"`
+	// Orders order_uid анонимизированных заказов, включая архивные
+	Orders []string `json:"orders"`
+}
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gogazub/myapp/ordermodel"
+)
+
+// CurrentSchemaVersion версия схемы сообщения заказа, если продюсер не передал заголовок schema-version
+const CurrentSchemaVersion = ordermodel.SchemaVersion
+
+// RawHeader заголовок сообщения Kafka
+type RawHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// RawMessage исходное сообщение Kafka с метаданными. Сохраняется до разбора, поэтому в том числе
+// отклоненное: тогда Rejection - причина отклонения, а OrderUID может быть пустым
+type RawMessage struct {
+	ID       int64  `json:"id"`
+	OrderUID string `json:"order_uid"`
+	// Payload тело сообщения байт в байт. В JSON API встраивается, если это валидный JSON
+	// (пробелы вне строк убираются), иначе - base64 с payload_encoding "base64"
+	Payload       []byte      `json:"-"`
+	Topic         string      `json:"topic"`
+	Partition     int         `json:"partition"`
+	Offset        int64       `json:"offset"`
+	Headers       []RawHeader `json:"headers"`
+	Timestamp     time.Time   `json:"timestamp"`
+	SchemaVersion int         `json:"schema_version"`
+	ReceivedAt    time.Time   `json:"received_at"`
+	Rejection     string      `json:"rejection,omitempty"`
+	// Shardkey из тела сообщения: по нему шардированное хранилище выбирает шард. Не сохраняется
+	Shardkey string `json:"-"`
+}
+
+// PayloadBase64 значение payload_encoding для тела, которое не является JSON
+const PayloadBase64 = "base64"
+
+type rawMessageJSON struct {
+	Payload         json.RawMessage `json:"payload"`
+	PayloadEncoding string          `json:"payload_encoding,omitempty"`
+}
+
+// MarshalJSON тело-JSON встраивается, остальное кодируется в base64
+func (m RawMessage) MarshalJSON() ([]byte, error) {
+	type plain RawMessage
+	out := struct {
+		plain
+		rawMessageJSON
+	}{plain: plain(m)}
+	if json.Valid(m.Payload) {
+		out.rawMessageJSON.Payload = m.Payload
+	} else {
+		b, err := json.Marshal(m.Payload)
+		if err != nil {
+			return nil, err
+		}
+		out.rawMessageJSON.Payload, out.PayloadEncoding = b, PayloadBase64
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON обратное MarshalJSON
+func (m *RawMessage) UnmarshalJSON(data []byte) error {
+	type plain RawMessage
+	in := struct {
+		*plain
+		rawMessageJSON
+	}{plain: (*plain)(m)}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	m.Payload = in.rawMessageJSON.Payload
+	if in.PayloadEncoding == PayloadBase64 {
+		return json.Unmarshal(in.rawMessageJSON.Payload, &m.Payload)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"html"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Ограничения поиска заказов
+const (
+	// MinSearchLen минимальная длина запроса в символах: короче триграммы не работают
+	MinSearchLen = 3
+	// MaxSearchLen максимальная длина запроса в символах
+	MaxSearchLen = 200
+	// DefaultSearchLimit заказов на странице по умолчанию
+	DefaultSearchLimit = 20
+	// MaxSearchLimit максимум заказов на странице
+	MaxSearchLimit = 100
+	// MaxSearchWindow глубина выдачи: Offset+Limit дальше не отдаются
+	MaxSearchWindow = 1000
+)
+
+// SearchQuery запрос поиска заказов
+type SearchQuery struct {
+	Text   string
+	Limit  int
+	Offset int
+}
+
+// SearchMatch поле заказа, совпавшее с запросом. Highlight - значение, экранированное для HTML,
+// в котором найденные фрагменты обернуты в <mark>
+type SearchMatch struct {
+	Field     string  `json:"field"`
+	Value     string  `json:"value"`
+	Highlight string  `json:"highlight"`
+	Score     float64 `json:"score"`
+}
+
+// SearchHit найденный заказ. Rank - лучшая оценка среди совпавших полей
+type SearchHit struct {
+	OrderUID    string        `json:"order_uid"`
+	DateCreated time.Time     `json:"date_created"`
+	Rank        float64       `json:"rank"`
+	Matches     []SearchMatch `json:"matches"`
+}
+
+// SearchPage страница результатов. NextOffset - offset следующей страницы, 0 - страница последняя
+type SearchPage struct {
+	Hits       []SearchHit `json:"hits"`
+	NextOffset int         `json:"next_offset,omitempty"`
+}
+
+// CompareSearchHits порядок выдачи: rank DESC, date_created DESC, order_uid DESC
+func CompareSearchHits(a, b SearchHit) int {
+	switch {
+	case a.Rank > b.Rank:
+		return -1
+	case a.Rank < b.Rank:
+		return 1
+	}
+	if c := b.DateCreated.Compare(a.DateCreated); c != 0 {
+		return c
+	}
+	return strings.Compare(b.OrderUID, a.OrderUID)
+}
+
+// Highlight экранирует value для HTML и оборачивает в <mark> вхождения слов запроса без учета регистра.
+// Для нечетких совпадений (опечатки) вхождений нет, и value возвращается без разметки
+func Highlight(value, query string) string {
+	text := []rune(value)
+	lower := toLowerRunes(value)
+
+	marked := make([]bool, len(text))
+	for _, term := range strings.Fields(query) {
+		t := toLowerRunes(term)
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if slices.Equal(lower[i:i+len(t)], t) {
+				for j := i; j < i+len(t); j++ {
+					marked[j] = true
+				}
+			}
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		j := i
+		for j < len(text) && marked[j] == marked[i] {
+			j++
+		}
+		part := html.EscapeString(string(text[i:j]))
+		if marked[i] {
+			part = "<mark>" + part + "</mark>"
+		}
+		b.WriteString(part)
+		i = j
+	}
+	return b.String()
+}
+
+// toLowerRunes нижний регистр посимвольно: индексы совпадают с []rune(s)
+func toLowerRunes(s string) []rune {
+	out := []rune(s)
+	for i, r := range out {
+		out[i] = unicode.ToLower(r)
+	}
+	return out
+}
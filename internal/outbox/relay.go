@@ -0,0 +1,128 @@
+// Package outbox публикует события из таблицы order_outbox в Kafka (transactional outbox)
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	repo "github.com/gogazub/myapp/internal/repository"
+	"github.com/segmentio/kafka-go"
+)
+
+// Значения Config по умолчанию
+const (
+	DefaultBatchSize    = 100
+	DefaultPollInterval = time.Second
+	DefaultRetention    = 24 * time.Hour
+	DefaultLease        = 30 * time.Second
+)
+
+// IPublisher отправка сообщений в Kafka. Реализуется *kafka.Writer
+type IPublisher interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Config настройки relay. Нулевые значения заменяются значениями по умолчанию
+type Config struct {
+	// BatchSize событий в одной порции
+	BatchSize int
+	// Lease сколько порция закреплена за relay: дольше публикация не ждет брокер, а после падения
+	// инстанса порцию через Lease возьмет другой
+	Lease time.Duration
+	// PollInterval пауза, когда неопубликованных событий не осталось
+	PollInterval time.Duration
+	// Retention сколько хранить опубликованные события перед удалением
+	Retention time.Duration
+}
+
+// Relay публикует события outbox. Ключ сообщения - order_uid: события одного заказа
+// попадают в одну партицию топика в порядке записи
+type Relay struct {
+	repo repo.IOutboxRepository
+	pub  IPublisher
+	cfg  Config
+}
+
+// NewRelay конструктор
+func NewRelay(r repo.IOutboxRepository, pub IPublisher, cfg Config) *Relay {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultPollInterval
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = DefaultRetention
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = DefaultLease
+	}
+	return &Relay{repo: r, pub: pub, cfg: cfg}
+}
+
+// Run публикует события, пока есть полные порции, затем ждет PollInterval. Раз в Retention/4
+// удаляет опубликованные события старше Retention. Блокирует до отмены ctx, ошибки логируются
+func (r *Relay) Run(ctx context.Context) error {
+	cleanupEvery := r.cfg.Retention / 4
+	lastCleanup := time.Time{}
+	for {
+		n, err := r.PublishPending(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("outbox relay error:%v", err)
+		}
+
+		if time.Since(lastCleanup) >= cleanupEvery {
+			lastCleanup = time.Now()
+			purged, err := r.repo.PurgeOutbox(ctx, lastCleanup.Add(-r.cfg.Retention))
+			switch {
+			case err != nil && ctx.Err() == nil:
+				log.Printf("outbox cleanup error:%v", err)
+			case purged > 0:
+				log.Printf("outbox cleanup: %d events removed", purged)
+			}
+		}
+
+		if err == nil && n == r.cfg.BatchSize {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.cfg.PollInterval):
+		}
+	}
+}
+
+// PublishPending публикует одну порцию событий. Возвращает число опубликованных
+func (r *Relay) PublishPending(ctx context.Context) (int, error) {
+	return r.repo.RelayOutbox(ctx, r.cfg.BatchSize, r.cfg.Lease, func(ctx context.Context, events []repo.OutboxEvent) error {
+		msgs := make([]kafka.Message, 0, len(events))
+		for _, e := range events {
+			msgs = append(msgs, Message(e))
+		}
+		if err := r.pub.WriteMessages(ctx, msgs...); err != nil {
+			return fmt.Errorf("write messages: %w", err)
+		}
+		return nil
+	})
+}
+
+// Message сообщение Kafka для события. event-id в заголовке позволяет потребителю
+// отбросить дубли: при ошибке публикации порция отправляется повторно
+func Message(e repo.OutboxEvent) kafka.Message {
+	return kafka.Message{
+		Key:   []byte(e.OrderUID),
+		Value: e.Payload,
+		Time:  e.CreatedAt,
+		Headers: []kafka.Header{
+			{Key: "event-type", Value: []byte(e.Type)},
+			{Key: "event-id", Value: []byte(strconv.FormatInt(e.ID, 10))},
+		},
+	}
+}
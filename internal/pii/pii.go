@@ -0,0 +1,211 @@
+// Package pii шифрование персональных данных: envelope encryption AES-GCM и blind index
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// KeySize длина мастер-ключа, ключа данных и ключа blind index в байтах (AES-256)
+const KeySize = 32
+
+// ErrUnknownKey мастер-ключа с таким id нет в связке: его убрали из конфигурации раньше,
+// чем rotate-keys перешифровал строки
+var ErrUnknownKey = errors.New("unknown master key")
+
+// Key мастер-ключ с идентификатором. Идентификатор хранится рядом с зашифрованными данными
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Cipher связка мастер-ключей и ключ blind index. Новые данные шифруются активным
+// (первым) мастер-ключом, остальные нужны только для чтения до ротации
+type Cipher struct {
+	active string
+	keys   map[string]cipher.AEAD
+	bidx   []byte
+}
+
+// NewCipher конструктор. keys[0] - активный ключ
+func NewCipher(keys []Key, blindIndexKey []byte) (*Cipher, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("pii: no master keys")
+	}
+	if len(blindIndexKey) != KeySize {
+		return nil, fmt.Errorf("pii: blind index key must be %d bytes, got %d", KeySize, len(blindIndexKey))
+	}
+	c := &Cipher{active: keys[0].ID, keys: make(map[string]cipher.AEAD, len(keys)), bidx: blindIndexKey}
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, errors.New("pii: empty master key id")
+		}
+		if _, ok := c.keys[k.ID]; ok {
+			return nil, fmt.Errorf("pii: duplicate master key id %q", k.ID)
+		}
+		aead, err := newAEAD(k.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("pii: master key %q: %w", k.ID, err)
+		}
+		c.keys[k.ID] = aead
+	}
+	return c, nil
+}
+
+// ParseKeys разбирает связку вида "k2=<base64>,k1=<base64>": первый ключ активный.
+// Разделитель - запятая или перевод строки, чтобы связку можно было хранить в файле
+func ParseKeys(s string) ([]Key, error) {
+	var out []Key
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(part, "=")
+		if !ok || id == "" {
+			return nil, errors.New("bad master key entry, want id=base64")
+		}
+		key, err := ParseSecret(secret)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q: %w", id, err)
+		}
+		out = append(out, Key{ID: id, Secret: key})
+	}
+	return out, nil
+}
+
+// ParseSecret декодирует ключ из base64 (стандартного, с паддингом) и проверяет длину
+func ParseSecret(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("decode key: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// ActiveKeyID идентификатор мастер-ключа, которым шифруются новые данные
+func (c *Cipher) ActiveKeyID() string {
+	return c.active
+}
+
+// NewDataKey создает ключ данных и возвращает его вместе с копией, зашифрованной активным
+// мастер-ключом. aad привязывает зашифрованный ключ к записи: чужой строке он не подойдет
+func (c *Cipher) NewDataKey(aad string) (dek, wrapped []byte, err error) {
+	dek = make([]byte, KeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, nil, fmt.Errorf("pii: generate data key: %w", err)
+	}
+	wrapped, err = seal(c.keys[c.active], dek, aad)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dek, wrapped, nil
+}
+
+// UnwrapDataKey расшифровывает ключ данных мастер-ключом keyID
+func (c *Cipher) UnwrapDataKey(keyID string, wrapped []byte, aad string) ([]byte, error) {
+	aead, ok := c.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("pii: %w %q", ErrUnknownKey, keyID)
+	}
+	dek, err := open(aead, wrapped, aad)
+	if err != nil {
+		return nil, fmt.Errorf("pii: unwrap data key: %w", err)
+	}
+	return dek, nil
+}
+
+// Encrypt шифрует значение ключом данных. Пустое значение не шифруется: nil
+func Encrypt(dek []byte, value, aad string) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, fmt.Errorf("pii: %w", err)
+	}
+	return seal(aead, []byte(value), aad)
+}
+
+// Decrypt обратная к Encrypt операция. Пустой шифротекст - пустое значение
+func Decrypt(dek, ciphertext []byte, aad string) (string, error) {
+	if len(ciphertext) == 0 {
+		return "", nil
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return "", fmt.Errorf("pii: %w", err)
+	}
+	plain, err := open(aead, ciphertext, aad)
+	if err != nil {
+		return "", fmt.Errorf("pii: decrypt: %w", err)
+	}
+	return string(plain), nil
+}
+
+// BlindIndex HMAC-SHA256 нормализованного значения поля. По нему ищется точное совпадение,
+// не раскрывая значение. Пустое значение - nil
+func (c *Cipher) BlindIndex(field, value string) []byte {
+	norm := Normalize(field, value)
+	if norm == "" {
+		return nil
+	}
+	mac := hmac.New(sha256.New, c.bidx)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(norm))
+	return mac.Sum(nil)
+}
+
+// Normalize приводит значение к виду, в котором сравниваются точные совпадения:
+// телефон - только цифры, остальное - нижний регистр и одиночные пробелы
+func Normalize(field, value string) string {
+	if field == "phone" {
+		return strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, value)
+	}
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal nonce || ciphertext || tag. Случайный nonce на каждое шифрование
+func seal(aead cipher.AEAD, plain []byte, aad string) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("pii: generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plain, []byte(aad)), nil
+}
+
+func open(aead cipher.AEAD, data []byte, aad string) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ct := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ct, []byte(aad))
+}
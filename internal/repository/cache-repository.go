@@ -4,26 +4,94 @@ package repository
 import (
 	"container/list"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/gogazub/myapp/internal/model"
 )
 
 const maxCacheSize = 1000
 
+// ErrCacheMiss возвращается, когда заказа нет в кеше (или его TTL истек)
+var ErrCacheMiss = errors.New("order not found")
+
 // ICacheRepository интерфейс кеш репозитория
 type ICacheRepository interface {
-	//LoadFromDB(psqlRepo IDBRepository) error
+	LoadFromDB(ctx context.Context, psqlRepo IDBRepository) error
 	Save(ctx context.Context, order *model.Order) error
 	GetByID(ctx context.Context, id string) (*model.Order, error)
+	// Contains есть ли заказ в кеше. В отличие от GetByID не влияет на LRU и статистику
+	Contains(ctx context.Context, id string) (bool, error)
+	Delete(ctx context.Context, id string) error
+	Clear(ctx context.Context) error
+	Stats() CacheStats
+	WarmupStatus() WarmupStatus
 	//GetAll(ctx context.Context) ([]*model.Order, error)
 }
 
+// ISharedCacheRepository кеш, общий для всех реплик сервиса, с локальной частью у каждой (L1).
+// Clear сбрасывает его целиком у всех, ClearLocal - только локальную часть этой реплики.
+// Evict удаляет заказ из общего кеша и L1 этой реплики, не рассылая инвалидацию остальным:
+// для изменений, о которых каждая реплика узнает сама (NOTIFY)
+type ISharedCacheRepository interface {
+	ICacheRepository
+	ClearLocal(ctx context.Context) error
+	Evict(ctx context.Context, id string) error
+}
+
+// CacheConfig настройки кеша. Нулевые значения заменяются значениями по умолчанию
+type CacheConfig struct {
+	// MaxSize максимальное число заказов в кеше. По умолчанию maxCacheSize
+	MaxSize int
+	// TTL время жизни записи. 0 - записи не протухают, вытесняются только LRU
+	TTL time.Duration
+	// WarmupSize сколько самых свежих заказов грузить при прогреве. По умолчанию MaxSize
+	WarmupSize int
+}
+
+// CacheStats снимок счетчиков кеша. Отдается в /admin/cache/stats
+type CacheStats struct {
+	// Backend реализация кеша: memory или redis
+	Backend       string    `json:"backend"`
+	Hits          uint64    `json:"hits"`
+	Misses        uint64    `json:"misses"`
+	HitRatio      float64   `json:"hit_ratio"`
+	Evictions     uint64    `json:"evictions"`
+	Expired       uint64    `json:"expired"`
+	Invalidations uint64    `json:"invalidations"`
+	Size          int       `json:"size"`
+	Capacity      int       `json:"capacity"`
+	TTLSeconds    float64   `json:"ttl_seconds"`
+	Loads         uint64    `json:"loads"`
+	LastLoadAt    time.Time `json:"last_load_at"`
+	// LastLoadMs длительность последнего LoadFromDB в миллисекундах
+	LastLoadMs     float64 `json:"last_load_ms"`
+	LastLoadOrders int     `json:"last_load_orders"`
+	// L1Hits попадания в локальный L1 перед распределенным кешем (только redis)
+	L1Hits uint64 `json:"l1_hits,omitempty"`
+}
+
 type cacheEntry struct {
-	elem  *list.Element
-	order *model.Order
+	elem      *list.Element
+	order     *model.Order
+	expiresAt time.Time
+}
+
+// cacheCounters счетчики статистики. Изменяются только под r.mu
+type cacheCounters struct {
+	hits          uint64
+	misses        uint64
+	evictions     uint64
+	expired       uint64
+	invalidations uint64
+	loads         uint64
+
+	lastLoadAt       time.Time
+	lastLoadDuration time.Duration
+	lastLoadOrders   int
 }
 
 // CacheRepository реализция интерфейса. TODO: сделать неэспортируемой эту структуру, а также service и dbrepo
@@ -32,72 +100,159 @@ type CacheRepository struct {
 	cache map[string]*cacheEntry
 
 	list *list.List
+
+	maxSize    int
+	warmupSize int
+	ttl        time.Duration
+	stats      cacheCounters
+	warmup     *warmupTracker
+
+	// gen номер последнего изменения через Save/Delete/Clear. touched - номер последнего изменения
+	// ключа, cleared - последнего Clear. Ведутся только во время прогрева (touched != nil):
+	// копия заказа, прочитанная из БД раньше изменения, в кеш уже не кладется
+	gen     uint64
+	touched map[string]uint64
+	cleared uint64
 }
 
 // NewCacheRepository Конструктор. Кэш репозиторий при создании заполняется данными из БД
 func NewCacheRepository() *CacheRepository {
+	return NewCacheRepositoryWithConfig(CacheConfig{})
+}
+
+// NewCacheRepositoryWithConfig конструктор с настройками размера и TTL
+func NewCacheRepositoryWithConfig(cfg CacheConfig) *CacheRepository {
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = maxCacheSize
+	}
+	if cfg.WarmupSize <= 0 || cfg.WarmupSize > cfg.MaxSize {
+		cfg.WarmupSize = cfg.MaxSize
+	}
 	cache := make(map[string]*cacheEntry)
 	return &CacheRepository{
-		cache: cache,
-		list:  list.New(),
+		cache:      cache,
+		list:       list.New(),
+		maxSize:    cfg.MaxSize,
+		warmupSize: cfg.WarmupSize,
+		ttl:        cfg.TTL,
+		warmup:     newWarmupTracker(),
 	}
 }
 
-// LoadFromDB Заполнить мапу значениями из БД
-func (r *CacheRepository) LoadFromDB(psqlRepo IDBRepository) error {
-	orders, err := psqlRepo.GetAll(context.Background())
-
-	if err != nil {
-		return fmt.Errorf("load from db error:%w", err)
+// LoadFromDB Заполнить кеш самыми свежими заказами из БД (по date_created).
+// Заказы читаются потоково страницами (IterateChunks), в памяти одновременно одна страница.
+// Прогресс доступен через WarmupStatus, поэтому метод можно запускать в фоне
+func (r *CacheRepository) LoadFromDB(ctx context.Context, psqlRepo IDBRepository) error {
+	start := time.Now()
+	if err := r.warmup.begin(start); err != nil {
+		return err
 	}
+	r.warmup.setTarget(r.warmupSize)
+	since := r.trackChanges()
+	defer r.untrackChanges()
 
-	for _, order := range orders {
-
-		err = r.Save(context.Background(), order)
+	loaded, seen := 0, 0
+	for chunk, err := range IterateChunks(ctx, psqlRepo, min(DefaultChunkSize, r.warmupSize)) {
 		if err != nil {
-			// Логируем ошибку и OrderLog - облегченная модель заказа
-			r.logOrder(fmt.Sprintf("save order error:%v", err), model.GetOrderLog(order))
+			err = fmt.Errorf("load from db error:%w", err)
+			r.finishWarmup(start, loaded, err)
+			return err
 		}
-		if r.Size() >= maxCacheSize {
+		for _, order := range chunk {
+			if seen >= r.warmupSize {
+				break
+			}
+			seen++
+			// Страницы идут от новых к старым, поэтому каждый следующий заказ кладется в голову LRU
+			if r.saveCold(order, since) {
+				loaded++
+			}
+		}
+		r.warmup.setLoaded(loaded)
+		// Следующая страница читается после возврата из тела цикла
+		since = r.generation()
+		if seen >= r.warmupSize {
 			break
 		}
 	}
 
+	r.finishWarmup(start, loaded, nil)
 	return nil
 }
 
-// Save добавить OrderModel в кэш
+// saveCold кладет заказ в голову LRU (первый кандидат на вытеснение), если есть место.
+// Заказ, уже попавший в кеш через Save во время прогрева, не перезаписывается: он свежее.
+// since - поколение на момент чтения order из БД: если заказ после этого сохранялся или удалялся
+// (или кеш очищался), order устарел и не кладется
+func (r *CacheRepository) saveCold(order *model.Order, since uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.cache[order.OrderUID]; ok || r.list.Len() >= r.maxSize || r.changedSince(order.OrderUID, since) {
+		return false
+	}
+	e := r.list.PushFront(order.OrderUID)
+	r.cache[order.OrderUID] = &cacheEntry{elem: e, order: order.Clone(), expiresAt: r.expiresAt()}
+	return true
+}
+
+// WarmupStatus текущий прогресс прогрева
+func (r *CacheRepository) WarmupStatus() WarmupStatus {
+	return r.warmup.get()
+}
+
+// finishWarmup фиксирует результат прогрева в статусе и статистике
+func (r *CacheRepository) finishWarmup(start time.Time, loaded int, err error) {
+	r.warmup.finish(loaded, err)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.loads++
+	r.stats.lastLoadAt = start
+	r.stats.lastLoadDuration = time.Since(start)
+	r.stats.lastLoadOrders = loaded
+}
+
+// Save добавить OrderModel в кэш. В кеш кладется копия: дальнейшие изменения order вызывающим
+// кодом на закешированное значение не влияют
 func (r *CacheRepository) Save(ctx context.Context, order *model.Order) error {
 	if err := ctx.Err(); err != nil {
 		return fmt.Errorf("save error:%w", err)
 	}
+	order = order.Clone()
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	r.touch(order.OrderUID)
+	expiresAt := r.expiresAt()
 	if ent, ok := r.cache[order.OrderUID]; ok {
 		// обновляем значение и освежаем позицию
 		ent.order = order
+		ent.expiresAt = expiresAt
 		r.list.MoveToBack(ent.elem)
 	} else {
 		// новый
 		e := r.list.PushBack(order.OrderUID)
-		r.cache[order.OrderUID] = &cacheEntry{elem: e, order: order}
+		r.cache[order.OrderUID] = &cacheEntry{elem: e, order: order, expiresAt: expiresAt}
 
 	}
-	for r.list.Len() > maxCacheSize {
+	for r.list.Len() > r.maxSize {
 		front := r.list.Front()
 		if front == nil {
 			break
 		}
-		key := front.Value.(string)
-		r.list.Remove(front)
-		delete(r.cache, key)
+		r.removeElement(front)
+		r.stats.evictions++
 	}
 	return nil
 }
 
-// GetByID Попробовать достать model.Order из кеша. В случае, если элемент есть в кеше, продлевает его жизнь в LRU
+// GetByID Попробовать достать model.Order из кеша. В случае, если элемент есть в кеше, продлевает его жизнь в LRU.
+// Возвращает копию: читатель может менять заказ, не ломая кеш для остальных
 func (r *CacheRepository) GetByID(ctx context.Context, id string) (*model.Order, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, fmt.Errorf("getByID error:%w", err)
@@ -108,14 +263,74 @@ func (r *CacheRepository) GetByID(ctx context.Context, id string) (*model.Order,
 
 	ent, exists := r.cache[id]
 	if !exists {
-		return nil, fmt.Errorf("order not found")
+		r.stats.misses++
+		return nil, ErrCacheMiss
+	}
+	if !ent.expiresAt.IsZero() && time.Now().After(ent.expiresAt) {
+		// Протухшую запись удаляем сразу, чтобы она не занимала место в LRU
+		r.removeElement(ent.elem)
+		r.stats.expired++
+		r.stats.misses++
+		return nil, ErrCacheMiss
 	}
 
+	r.stats.hits++
 	r.list.MoveToBack(ent.elem)
-	return ent.order, nil
+	return ent.order.Clone(), nil
 }
 
-// GetAll возвращает массив всех model.Order, которые хранятся в кеше
+// Contains есть ли в кеше непротухший заказ id
+func (r *CacheRepository) Contains(ctx context.Context, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, fmt.Errorf("contains error:%w", err)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ent, exists := r.cache[id]
+	return exists && (ent.expiresAt.IsZero() || time.Now().Before(ent.expiresAt)), nil
+}
+
+// Delete удаляет заказ из кеша. Отсутствие заказа ошибкой не считается
+func (r *CacheRepository) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("delete error:%w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.touch(id)
+	ent, exists := r.cache[id]
+	if !exists {
+		return nil
+	}
+	r.removeElement(ent.elem)
+	r.stats.invalidations++
+	return nil
+}
+
+// Clear полностью очищает кеш. Счетчики статистики сохраняются
+func (r *CacheRepository) Clear(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("clear error:%w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.touched != nil {
+		r.gen++
+		r.cleared = r.gen
+	}
+	r.stats.invalidations += uint64(r.list.Len())
+	r.cache = make(map[string]*cacheEntry)
+	r.list.Init()
+	return nil
+}
+
+// GetAll возвращает копии всех model.Order, которые хранятся в кеше
 func (r *CacheRepository) GetAll(ctx context.Context) ([]*model.Order, error) {
 	// Быстрый отказ, если контекст уже отменен, чтобы не лочить mutex лишний раз
 	if err := ctx.Err(); err != nil {
@@ -135,7 +350,7 @@ func (r *CacheRepository) GetAll(ctx context.Context) ([]*model.Order, error) {
 				return nil, fmt.Errorf("getAll error:%w", err)
 			}
 		}
-		orders = append(orders, order.order)
+		orders = append(orders, order.order.Clone())
 		i++
 	}
 	return orders, nil
@@ -148,19 +363,82 @@ func (r *CacheRepository) Size() int {
 	return r.list.Len()
 }
 
+// Stats возвращает снимок счетчиков кеша
+func (r *CacheRepository) Stats() CacheStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	st := CacheStats{
+		Backend:        "memory",
+		Hits:           r.stats.hits,
+		Misses:         r.stats.misses,
+		Evictions:      r.stats.evictions,
+		Expired:        r.stats.expired,
+		Invalidations:  r.stats.invalidations,
+		Size:           r.list.Len(),
+		Capacity:       r.maxSize,
+		TTLSeconds:     r.ttl.Seconds(),
+		Loads:          r.stats.loads,
+		LastLoadAt:     r.stats.lastLoadAt,
+		LastLoadMs:     float64(r.stats.lastLoadDuration) / float64(time.Millisecond),
+		LastLoadOrders: r.stats.lastLoadOrders,
+	}
+	if total := st.Hits + st.Misses; total > 0 {
+		st.HitRatio = float64(st.Hits) / float64(total)
+	}
+	return st
+}
+
+// removeElement удаляет запись из списка и мапы. Вызывать под r.mu
+func (r *CacheRepository) removeElement(e *list.Element) {
+	key := e.Value.(string)
+	r.list.Remove(e)
+	delete(r.cache, key)
+}
+
+// trackChanges начинает учет изменений ключей на время прогрева и возвращает текущее поколение
+func (r *CacheRepository) trackChanges() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.touched = map[string]uint64{}
+	return r.gen
+}
+
+// untrackChanges заканчивает учет изменений, начатый trackChanges
+func (r *CacheRepository) untrackChanges() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.touched = nil
+}
+
+// generation текущее поколение: заказы, прочитанные из БД после этого момента, не старее кеша
+func (r *CacheRepository) generation() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.gen
+}
+
+// touch отмечает изменение ключа, если идет учет. Вызывать под r.mu
+func (r *CacheRepository) touch(id string) {
+	if r.touched != nil {
+		r.gen++
+		r.touched[id] = r.gen
+	}
+}
+
+// changedSince true, если ключ менялся или кеш очищался после поколения since. Вызывать под r.mu
+func (r *CacheRepository) changedSince(id string, since uint64) bool {
+	return r.cleared > since || r.touched[id] > since
+}
+
+// expiresAt момент протухания новой записи. Нулевое время - без TTL
+func (r *CacheRepository) expiresAt() time.Time {
+	if r.ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(r.ttl)
+}
+
 func (r *CacheRepository) logOrder(msg string, order model.OrderLog) {
 	log.Printf("%s\norder:%v", msg, order)
 }
-
-// Удаляет n самых старых элементов (с головы списка). Может потребоваться для оптимизации
-// func (r *CacheRepository) evictOldest(n int) {
-// 	for i := 0; i < n; i++ {
-// 		front := r.list.Front()
-// 		if front == nil {
-// 			return
-// 		}
-// 		key := front.Value.(string)
-// 		r.list.Remove(front)
-// 		delete(r.cache, key)
-// 	}
-// }
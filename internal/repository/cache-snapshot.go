@@ -0,0 +1,283 @@
+package repository
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gogazub/myapp/internal/model"
+)
+
+// Формат файла снапшота:
+//
+//	magic (8 байт) | version (uint16, big endian) | gzip(gob(snapshotBody))
+//
+// При несовместимом изменении snapshotBody нужно поднять snapshotVersion:
+// старый снапшот будет отброшен, и кеш прогреется из БД как обычно.
+const (
+	snapshotMagic   = "ORDCACHE"
+	snapshotVersion = uint16(1)
+)
+
+// ErrSnapshotVersion снапшот записан другой (несовместимой) версией формата
+var ErrSnapshotVersion = errors.New("unsupported cache snapshot version")
+
+type snapshotBody struct {
+	// Watermark IDBRepository.Watermark на момент снятия снапшота
+	Watermark time.Time
+	CreatedAt time.Time
+	// Entries в порядке LRU: от самого старого к самому свежему
+	Entries []snapshotEntry
+}
+
+type snapshotEntry struct {
+	Order     *model.Order
+	ExpiresAt time.Time
+}
+
+// WriteSnapshot сериализует содержимое кеша и порядок LRU в w.
+// watermark - отметка orders.updated_at, полученная из БД до вызова
+func (r *CacheRepository) WriteSnapshot(w io.Writer, watermark time.Time) error {
+	body := snapshotBody{Watermark: watermark, CreatedAt: time.Now()}
+
+	r.mu.RLock()
+	body.Entries = make([]snapshotEntry, 0, r.list.Len())
+	for e := r.list.Front(); e != nil; e = e.Next() {
+		ent := r.cache[e.Value.(string)]
+		body.Entries = append(body.Entries, snapshotEntry{Order: ent.order, ExpiresAt: ent.expiresAt})
+	}
+	r.mu.RUnlock()
+
+	if _, err := io.WriteString(w, snapshotMagic); err != nil {
+		return fmt.Errorf("write snapshot error:%w", err)
+	}
+	if err := binary.Write(w, binary.BigEndian, snapshotVersion); err != nil {
+		return fmt.Errorf("write snapshot error:%w", err)
+	}
+
+	zw := gzip.NewWriter(w)
+	if err := gob.NewEncoder(zw).Encode(&body); err != nil {
+		return fmt.Errorf("write snapshot error:%w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("write snapshot error:%w", err)
+	}
+	return nil
+}
+
+// ReadSnapshot загружает записи из снапшота в кеш, сохраняя порядок LRU.
+// Протухшие записи пропускаются. Возвращает watermark снапшота и число загруженных заказов
+func (r *CacheRepository) ReadSnapshot(rd io.Reader) (time.Time, int, error) {
+	header := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(rd, header); err != nil {
+		return time.Time{}, 0, fmt.Errorf("read snapshot error:%w", err)
+	}
+	if string(header) != snapshotMagic {
+		return time.Time{}, 0, fmt.Errorf("read snapshot error: bad magic %q", header)
+	}
+	var version uint16
+	if err := binary.Read(rd, binary.BigEndian, &version); err != nil {
+		return time.Time{}, 0, fmt.Errorf("read snapshot error:%w", err)
+	}
+	if version != snapshotVersion {
+		return time.Time{}, 0, fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+
+	zr, err := gzip.NewReader(rd)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("read snapshot error:%w", err)
+	}
+	defer func() {
+		if err := zr.Close(); err != nil {
+			log.Printf("snapshot gzip close error:%s", err)
+		}
+	}()
+
+	var body snapshotBody
+	if err := gob.NewDecoder(zr).Decode(&body); err != nil {
+		return time.Time{}, 0, fmt.Errorf("read snapshot error:%w", err)
+	}
+
+	now := time.Now()
+	loaded := 0
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ent := range body.Entries {
+		if ent.Order == nil || (!ent.ExpiresAt.IsZero() && now.After(ent.ExpiresAt)) {
+			continue
+		}
+		if old, ok := r.cache[ent.Order.OrderUID]; ok {
+			r.removeElement(old.elem)
+		}
+		e := r.list.PushBack(ent.Order.OrderUID)
+		r.cache[ent.Order.OrderUID] = &cacheEntry{elem: e, order: ent.Order, expiresAt: ent.ExpiresAt}
+		loaded++
+	}
+	// Снапшот мог быть снят с кешем большего размера
+	for r.list.Len() > r.maxSize {
+		r.removeElement(r.list.Front())
+		loaded--
+	}
+	return body.Watermark, loaded, nil
+}
+
+// SaveSnapshotFile атомарно (через временный файл и rename) пишет снапшот в path.
+// Watermark берется из БД до чтения кеша: все, что изменится позже, будет строго новее него
+func (r *CacheRepository) SaveSnapshotFile(ctx context.Context, path string, psqlRepo IDBRepository) error {
+	watermark, err := psqlRepo.Watermark(ctx)
+	if err != nil {
+		return fmt.Errorf("save snapshot error:%w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("save snapshot error:%w", err)
+	}
+	defer func() {
+		// После успешного rename файла уже нет, ошибку игнорируем
+		_ = os.Remove(tmp.Name())
+	}()
+
+	bw := bufio.NewWriter(tmp)
+	if err := r.WriteSnapshot(bw, watermark); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("save snapshot error:%w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("save snapshot error:%w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save snapshot error:%w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("save snapshot error:%w", err)
+	}
+	return nil
+}
+
+// LoadSnapshotFile восстанавливает кеш из снапшота и сверяет его с БД:
+// заказы, измененные после watermark снапшота, перечитываются из БД одним батчем, исчезнувшие - удаляются.
+// Загрузка учитывается как прогрев, поэтому /ready становится готовым без запросов к БД за заказами
+func (r *CacheRepository) LoadSnapshotFile(ctx context.Context, path string, psqlRepo IDBRepository) (int, error) {
+	start := time.Now()
+	if err := r.warmup.begin(start); err != nil {
+		return 0, err
+	}
+
+	loaded, err := r.loadSnapshotFile(ctx, path, psqlRepo)
+	if err != nil {
+		// Кеш мог частично заполниться до ошибки сверки - такие данные могут быть устаревшими.
+		// Статус возвращаем в pending: после неудачи ожидается обычный прогрев из БД
+		r.mu.Lock()
+		r.cache = make(map[string]*cacheEntry)
+		r.list.Init()
+		r.mu.Unlock()
+		r.warmup.reset()
+		return 0, err
+	}
+	r.finishWarmup(start, loaded, nil)
+	return loaded, nil
+}
+
+func (r *CacheRepository) loadSnapshotFile(ctx context.Context, path string, psqlRepo IDBRepository) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("load snapshot error:%w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Printf("snapshot file close error:%s", err)
+		}
+	}()
+
+	watermark, loaded, err := r.ReadSnapshot(bufio.NewReader(f))
+	if err != nil {
+		return 0, err
+	}
+
+	changed, err := psqlRepo.ChangedSince(ctx, watermark)
+	if err != nil {
+		return 0, fmt.Errorf("load snapshot error:%w", err)
+	}
+
+	// Из измененных интересны только те, что попали в снапшот
+	r.mu.RLock()
+	stale := make([]string, 0, len(changed))
+	for _, id := range changed {
+		if _, ok := r.cache[id]; ok {
+			stale = append(stale, id)
+		}
+	}
+	r.mu.RUnlock()
+	if len(stale) == 0 {
+		return loaded, nil
+	}
+
+	// Устаревшие записи перечитываем одним батчем, сохраняя их место в LRU
+	since := r.trackChanges()
+	defer r.untrackChanges()
+	fresh, err := psqlRepo.GetByIDs(ctx, stale)
+	if err != nil {
+		return 0, fmt.Errorf("load snapshot error:%w", err)
+	}
+	byID := make(map[string]*model.Order, len(fresh))
+	for _, o := range fresh {
+		byID[o.OrderUID] = o
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range stale {
+		ent, ok := r.cache[id]
+		if !ok || r.changedSince(id, since) {
+			// Удален или уже перезаписан свежей версией во время чтения
+			continue
+		}
+		if o, found := byID[id]; found {
+			ent.order = o
+			continue
+		}
+		r.removeElement(ent.elem)
+		loaded--
+	}
+	return loaded, nil
+}
+
+// RunSnapshots периодически пишет снапшот в path и делает последний снимок при отмене ctx
+// (graceful shutdown). Блокируется до отмены ctx
+func (r *CacheRepository) RunSnapshots(ctx context.Context, path string, interval time.Duration, psqlRepo IDBRepository) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.SaveSnapshotFile(ctx, path, psqlRepo); err != nil {
+				log.Printf("cache snapshot error:%v", err)
+			}
+		case <-ctx.Done():
+			// Родительский контекст уже отменен, для последнего снимка нужен свой
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := r.SaveSnapshotFile(shutdownCtx, path, psqlRepo); err != nil {
+				log.Printf("cache snapshot on shutdown error:%v", err)
+			}
+			cancel()
+			return
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrWarmupInProgress возвращается при попытке запустить прогрев, пока идет предыдущий
+var ErrWarmupInProgress = errors.New("cache warmup already in progress")
+
+// Состояния прогрева кеша
+const (
+	WarmupPending = "pending"
+	WarmupRunning = "running"
+	WarmupDone    = "done"
+	WarmupFailed  = "failed"
+)
+
+// WarmupStatus прогресс прогрева кеша. Отдается в /ready
+type WarmupStatus struct {
+	State      string    `json:"state"`
+	Loaded     int       `json:"loaded"`
+	Target     int       `json:"target"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Finished true, если прогрев завершился (успешно или с ошибкой)
+func (s WarmupStatus) Finished() bool {
+	return s.State == WarmupDone || s.State == WarmupFailed
+}
+
+// warmupTracker потокобезопасно хранит прогресс прогрева. Общий для всех реализаций кеша
+type warmupTracker struct {
+	mu     sync.Mutex
+	status WarmupStatus
+}
+
+func newWarmupTracker() *warmupTracker {
+	return &warmupTracker{status: WarmupStatus{State: WarmupPending}}
+}
+
+// begin переводит прогрев в состояние running. Параллельный прогрев запрещен
+func (t *warmupTracker) begin(start time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.status.State == WarmupRunning {
+		return ErrWarmupInProgress
+	}
+	t.status = WarmupStatus{State: WarmupRunning, StartedAt: start}
+	return nil
+}
+
+func (t *warmupTracker) setTarget(n int) {
+	t.mu.Lock()
+	t.status.Target = n
+	t.mu.Unlock()
+}
+
+func (t *warmupTracker) setLoaded(n int) {
+	t.mu.Lock()
+	t.status.Loaded = n
+	t.mu.Unlock()
+}
+
+// finish фиксирует результат прогрева
+func (t *warmupTracker) finish(loaded int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.Loaded = loaded
+	t.status.FinishedAt = time.Now()
+	if err != nil {
+		t.status.State = WarmupFailed
+		t.status.Error = err.Error()
+		return
+	}
+	t.status.State = WarmupDone
+}
+
+// reset возвращает прогрев в pending: ожидается новый запуск
+func (t *warmupTracker) reset() {
+	t.mu.Lock()
+	t.status = WarmupStatus{State: WarmupPending}
+	t.mu.Unlock()
+}
+
+func (t *warmupTracker) get() WarmupStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/gogazub/myapp/internal/model"
+)
+
+// maxHistoryLimit предел записей истории за один запрос
+const maxHistoryLimit = 1000
+
+// IHistoryRepository журнал изменений заказов. Реализуется не всеми хранилищами,
+// сервис проверяет его через type assertion
+type IHistoryRepository interface {
+	History(ctx context.Context, id string, limit int) ([]model.HistoryEntry, error)
+}
+
+// History возвращает до limit записей истории заказа от старых к новым.
+// Пустой результат - ErrOrderNotFound: у любого сохраненного заказа есть хотя бы запись create
+func (r *DBRepository) History(ctx context.Context, id string, limit int) ([]model.HistoryEntry, error) {
+	if limit <= 0 || limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	rows, err := r.reader(id).QueryContext(ctx, `
+		SELECT history_id, order_uid, changed_at, op, source, changes
+		FROM order_history WHERE order_uid = $1
+		ORDER BY history_id LIMIT $2
+	`, id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("rows close error:%s", err)
+		}
+	}()
+
+	entries, err := scanHistory(rows)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrOrderNotFound, id)
+	}
+	return entries, nil
+}
+
+// scanHistory читает строки history_id, order_uid, changed_at, op, source, changes
+func scanHistory(rows *sql.Rows) ([]model.HistoryEntry, error) {
+	entries := make([]model.HistoryEntry, 0, 8)
+	for rows.Next() {
+		var (
+			e               model.HistoryEntry
+			source, changes []byte
+		)
+		if err := rows.Scan(&e.ID, &e.OrderUID, &e.ChangedAt, &e.Op, &source, &changes); err != nil {
+			return nil, fmt.Errorf("scanHistory: %w", err)
+		}
+		if err := json.Unmarshal(source, &e.Source); err != nil {
+			return nil, fmt.Errorf("scanHistory: %w", err)
+		}
+		if err := json.Unmarshal(changes, &e.Changes); err != nil {
+			return nil, fmt.Errorf("scanHistory: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// lockPrevious читает текущую версию заказа и блокирует строку orders до конца транзакции,
+// чтобы параллельный Save того же заказа не посчитал diff от той же версии. nil - заказа еще нет
+func (r *DBRepository) lockPrevious(ctx context.Context, tx *sql.Tx, id string) (*model.Order, error) {
+	stmtCtx, cancel := context.WithTimeout(ctx, r.statementTimeout)
+	defer cancel()
+
+	orders, err := r.loadOrders(stmtCtx, tx, `WHERE o.order_uid = $1 FOR UPDATE OF o`, id)
+	if err != nil {
+		return nil, fmt.Errorf("lockPrevious: %w", err)
+	}
+	if len(orders) == 0 {
+		return nil, nil
+	}
+	return orders[0], nil
+}
+
+// appendHistory пишет в order_history diff между prev и order и источник изменения из ctx.
+// Повторное сохранение без изменений (например, повторная доставка сообщения Kafka) запись не создает.
+// Возвращает, изменился ли заказ
+func (r *DBRepository) appendHistory(ctx context.Context, tx *sql.Tx, prev, order *model.Order) (bool, error) {
+	changes := model.DiffOrders(prev, order)
+	op := model.HistoryCreate
+	if prev != nil {
+		if len(changes) == 0 {
+			return false, nil
+		}
+		op = model.HistoryUpdate
+	}
+	if r.pii != nil {
+		changes = maskPII(changes)
+	}
+
+	source, err := json.Marshal(model.ChangeSourceFrom(ctx))
+	if err != nil {
+		return false, fmt.Errorf("appendHistory: %w", err)
+	}
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return false, fmt.Errorf("appendHistory: %w", err)
+	}
+	err = r.exec(ctx, tx, "appendHistory",
+		`INSERT INTO order_history (order_uid, op, source, changes) VALUES ($1, $2, $3, $4)`,
+		order.OrderUID, op, source, changesJSON)
+	return err == nil, err
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"time"
+
+	"github.com/gogazub/myapp/internal/model"
+)
+
+// DefaultChunkSize размер страницы потокового обхода по умолчанию
+const DefaultChunkSize = 500
+
+// Cursor позиция keyset-пагинации: ключ последнего отданного заказа.
+// Нулевой Cursor - начало выборки
+type Cursor struct {
+	DateCreated time.Time
+	OrderUID    string
+}
+
+// IsZero курсор указывает на начало выборки
+func (c Cursor) IsZero() bool {
+	return c.OrderUID == ""
+}
+
+// CursorOf курсор, указывающий сразу за заказом o
+func CursorOf(o *model.Order) Cursor {
+	return Cursor{DateCreated: o.DateCreated, OrderUID: o.OrderUID}
+}
+
+// IterateChunks потоково обходит все заказы страницами по chunkSize (сначала новые).
+// В памяти держится одна страница. ctx проверяется перед каждой страницей:
+// после отмены последним значением отдается ошибка ctx и обход завершается
+func IterateChunks(ctx context.Context, psqlRepo IDBRepository, chunkSize int) iter.Seq2[[]*model.Order, error] {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return func(yield func([]*model.Order, error) bool) {
+		var after Cursor
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(nil, fmt.Errorf("iterate orders error:%w", err))
+				return
+			}
+			chunk, err := psqlRepo.ListPage(ctx, after, chunkSize)
+			if err != nil {
+				yield(nil, fmt.Errorf("iterate orders error:%w", err))
+				return
+			}
+			if len(chunk) == 0 {
+				return
+			}
+			if !yield(chunk, nil) {
+				return
+			}
+			if len(chunk) < chunkSize {
+				return
+			}
+			after = CursorOf(chunk[len(chunk)-1])
+		}
+	}
+}
+
+// IterateOrders то же, что IterateChunks, но по одному заказу
+func IterateOrders(ctx context.Context, psqlRepo IDBRepository, chunkSize int) iter.Seq2[*model.Order, error] {
+	return func(yield func(*model.Order, error) bool) {
+		for chunk, err := range IterateChunks(ctx, psqlRepo, chunkSize) {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, o := range chunk {
+				if !yield(o, nil) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/lib/pq"
+)
+
+// EventOrderSaved тип события outbox: заказ создан или изменен
+const EventOrderSaved = "order.saved"
+
+// EventOrderErased тип события outbox: персональные данные заказа удалены по запросу покупателя.
+// Payload - анонимизированный заказ, получатели должны затереть у себя эти данные
+const EventOrderErased = "order.erased"
+
+// OutboxEvent строка order_outbox. Payload - заказ в формате API
+type OutboxEvent struct {
+	ID        int64
+	OrderUID  string
+	Type      string
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+// IOutboxRepository чтение outbox для relay. Реализуется не всеми хранилищами
+type IOutboxRepository interface {
+	// RelayOutbox передает publish до limit неопубликованных событий в порядке записи и отмечает их
+	// опубликованными, если publish успешен. lease - сколько порция закреплена за этим вызовом:
+	// publish получает ctx с таким таймаутом. Возвращает число опубликованных событий
+	RelayOutbox(ctx context.Context, limit int, lease time.Duration,
+		publish func(ctx context.Context, events []OutboxEvent) error) (int, error)
+	// PurgeOutbox удаляет события, опубликованные раньше before
+	PurgeOutbox(ctx context.Context, before time.Time) (int64, error)
+}
+
+// appendOutbox пишет событие в order_outbox в транзакции записи заказа:
+// событие появляется тогда и только тогда, когда коммитится сам заказ.
+// С ключами PII payload хранит заказ с "***" в данных доставки, полный - зашифрован в payload_enc
+func (r *DBRepository) appendOutbox(ctx context.Context, tx *sql.Tx, eventType string, order *model.Order) error {
+	payload, err := json.Marshal(order)
+	if err != nil {
+		return fmt.Errorf("appendOutbox: %w", err)
+	}
+	stored, enc, key, keyID, err := r.sealOutbox(order.OrderUID, eventType, order, payload)
+	if err != nil {
+		return fmt.Errorf("appendOutbox: %w", err)
+	}
+	return r.exec(ctx, tx, "appendOutbox", `
+		INSERT INTO order_outbox (order_uid, event_type, payload, payload_enc, pii_key, pii_key_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, order.OrderUID, eventType, stored, nullBytes(enc), nullBytes(key), keyID)
+}
+
+// outboxAAD связывает шифротекст события с заказом и типом события
+func outboxAAD(orderUID, eventType string) string {
+	return orderUID + "/outbox." + eventType
+}
+
+// sealOutbox колонки payload, payload_enc, pii_key, pii_key_id события с телом payload заказа order.
+// Без ключей шифрования payload хранится как есть
+func (r *DBRepository) sealOutbox(orderUID, eventType string, order *model.Order,
+	payload []byte) (stored, enc, key []byte, keyID sql.NullString, err error) {
+	if r.pii == nil {
+		return payload, nil, nil, sql.NullString{}, nil
+	}
+	if stored, err = json.Marshal(maskDelivery(order)); err != nil {
+		return nil, nil, nil, sql.NullString{}, err
+	}
+	enc, key, keyID, err = r.sealBlob(payload, outboxAAD(orderUID, eventType))
+	return stored, enc, key, keyID, err
+}
+
+// RelayOutbox публикует порцию событий в три шага, не держа транзакцию и соединение с БД,
+// пока идет публикация:
+//  1. короткая транзакция под pg_try_advisory_xact_lock берет порцию и ставит ей аренду claimed_until.
+//     Если блокировку держит другой инстанс или голова очереди еще в аренде, ничего не делает:
+//     следующую порцию нельзя публиковать раньше предыдущей, поэтому события одного заказа
+//     уходят строго в порядке event_id;
+//  2. publish вне транзакции, не дольше lease;
+//  3. порция отмечается опубликованной отдельным запросом.
+//
+// Ошибка publish снимает аренду: вся порция будет опубликована заново (at-least-once).
+// Если инстанс упал между 2 и 3, порцию после истечения аренды опубликует relay любого инстанса
+func (r *DBRepository) RelayOutbox(ctx context.Context, limit int, lease time.Duration,
+	publish func(ctx context.Context, events []OutboxEvent) error) (int, error) {
+	if limit <= 0 || lease <= 0 {
+		return 0, nil
+	}
+	events, err := r.claimOutbox(ctx, limit, lease)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+	ids := make([]int64, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+
+	pubCtx, cancel := context.WithTimeout(ctx, lease)
+	err = publish(pubCtx, events)
+	cancel()
+	if err != nil {
+		// Без снятия аренды порция ждала бы ее истечения. Неудача тут не страшна: аренда истечет сама
+		if _, relErr := r.db.ExecContext(context.WithoutCancel(ctx),
+			`UPDATE order_outbox SET claimed_until = NULL WHERE event_id = ANY($1) AND published_at IS NULL`,
+			pq.Array(ids)); relErr != nil {
+			log.Printf("relay outbox release error:%v", relErr)
+		}
+		return 0, fmt.Errorf("relay outbox publish: %w", err)
+	}
+
+	if _, err := r.db.ExecContext(ctx,
+		`UPDATE order_outbox SET published_at = now(), claimed_until = NULL WHERE event_id = ANY($1)`,
+		pq.Array(ids)); err != nil {
+		// Порция уже в Kafka: после истечения аренды она уйдет повторно, дубли отбросят по event-id
+		return 0, fmt.Errorf("relay outbox mark: %w", err)
+	}
+	return len(events), nil
+}
+
+// claimOutbox берет до limit неопубликованных событий с головы очереди и ставит им аренду на lease.
+// Пусто, если relay другого инстанса держит блокировку или еще публикует свою порцию
+func (r *DBRepository) claimOutbox(ctx context.Context, limit int, lease time.Duration) ([]OutboxEvent, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Printf("Rollback error:%s", err.Error())
+		}
+	}()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx,
+		`SELECT pg_try_advisory_xact_lock(hashtext('order_outbox_relay'))`).Scan(&locked); err != nil {
+		return nil, fmt.Errorf("relay outbox lock: %w", err)
+	}
+	if !locked {
+		return nil, nil
+	}
+	// Аренда ставится порции целиком с головы очереди, поэтому достаточно проверить первую строку
+	var busy bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT claimed_until > now() FROM order_outbox
+			WHERE published_at IS NULL ORDER BY event_id LIMIT 1), false)
+	`).Scan(&busy); err != nil {
+		return nil, fmt.Errorf("relay outbox claim: %w", err)
+	}
+	if busy {
+		return nil, nil
+	}
+
+	events, err := r.pendingOutbox(ctx, tx, limit)
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE order_outbox SET claimed_until = now() + make_interval(secs => $2) WHERE event_id = ANY($1)`,
+		pq.Array(ids), lease.Seconds()); err != nil {
+		return nil, fmt.Errorf("relay outbox claim: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// PurgeOutbox удаляет опубликованные события старше before
+func (r *DBRepository) PurgeOutbox(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx,
+		`DELETE FROM order_outbox WHERE published_at IS NOT NULL AND published_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("purge outbox: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("purge outbox: %w", err)
+	}
+	return n, nil
+}
+
+// pendingOutbox до limit неопубликованных событий в порядке event_id. Зашифрованный payload расшифровывается
+func (r *DBRepository) pendingOutbox(ctx context.Context, tx *sql.Tx, limit int) ([]OutboxEvent, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT event_id, order_uid, event_type, payload, payload_enc, pii_key, pii_key_id, created_at
+		FROM order_outbox WHERE published_at IS NULL
+		ORDER BY event_id LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("pending outbox: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("rows close error:%s", err.Error())
+		}
+	}()
+
+	events := make([]OutboxEvent, 0, limit)
+	for rows.Next() {
+		var (
+			e        OutboxEvent
+			enc, key []byte
+			keyID    sql.NullString
+		)
+		if err := rows.Scan(&e.ID, &e.OrderUID, &e.Type, &e.Payload, &enc, &key, &keyID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("pending outbox: %w", err)
+		}
+		if len(key) > 0 {
+			if e.Payload, err = r.openBlob(enc, key, keyID, outboxAAD(e.OrderUID, e.Type)); err != nil {
+				return nil, fmt.Errorf("pending outbox: %w", err)
+			}
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("pending outbox: %w", err)
+	}
+	return events, nil
+}
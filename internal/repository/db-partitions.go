@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// DefaultPartitionsAhead на сколько месяцев вперед создаются партиции orders/items
+const DefaultPartitionsAhead = 3
+
+// EnsurePartitions создает месячные партиции orders и items от текущего месяца на ahead месяцев вперед
+// (функция ensure_order_partitions из миграции 000008). Существующие партиции не трогаются.
+// Возвращает число созданных партиций
+func (r *DBRepository) EnsurePartitions(ctx context.Context, ahead int) (int, error) {
+	if ahead < 0 {
+		ahead = 0
+	}
+	var created int
+	err := r.db.QueryRowContext(ctx,
+		`SELECT ensure_order_partitions(now(), now() + make_interval(months => $1))`, ahead).Scan(&created)
+	if err != nil {
+		return 0, fmt.Errorf("ensure partitions: %w", err)
+	}
+	return created, nil
+}
+
+// RunPartitionMaintenance сразу и затем каждые interval создает партиции на ahead месяцев вперед.
+// Блокирует до отмены ctx. Ошибки только логируются: без новой партиции строки попадут
+// в default-партицию и будут перенесены при следующем успешном запуске
+func (r *DBRepository) RunPartitionMaintenance(ctx context.Context, ahead int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := r.EnsurePartitions(ctx, ahead)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Printf("partition maintenance error:%v", err)
+		case n > 0:
+			log.Printf("partition maintenance: %d partitions created", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// moveOrder переносит заказ и его позиции в партицию нового date_created.
+// Postgres выполняет UPDATE ключа партиционирования как перенос строки между партициями
+func (r *DBRepository) moveOrder(ctx context.Context, tx *sql.Tx, id string, from, to time.Time) error {
+	if err := r.exec(ctx, tx, "moveOrder",
+		`UPDATE orders SET date_created = $2 WHERE order_uid = $1 AND date_created = $3`, id, to, from); err != nil {
+		return err
+	}
+	return r.exec(ctx, tx, "moveItems",
+		`UPDATE items SET date_created = $2 WHERE order_uid = $1 AND date_created = $3`, id, to, from)
+}
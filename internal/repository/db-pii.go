@@ -0,0 +1,467 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/pii"
+	"github.com/lib/pq"
+)
+
+// PIITables таблицы с персональными данными: их обходит rotate-keys. Доставки, события outbox
+// и исходные сообщения перешифровываются, в истории значения персональных полей маскируются
+var PIITables = []string{"deliveries", "deliveries_archive", "order_outbox", "order_raw_messages", "order_history"}
+
+// IPIIRotator перешифровка персональных данных активным мастер-ключом
+type IPIIRotator interface {
+	RotatePII(ctx context.Context, table string, after, limit int) (last, rotated int, err error)
+}
+
+// sealedDelivery колонки доставки в БД. При шифровании name, phone, address, email пустые,
+// значения лежат в *Enc под ключом данных key, зашифрованным мастер-ключом keyID
+type sealedDelivery struct {
+	name, phone, address, email             string
+	nameEnc, phoneEnc, addressEnc, emailEnc []byte
+	key                                     []byte
+	keyID                                   sql.NullString
+	nameIdx, phoneIdx, emailIdx             []byte
+}
+
+// args значения колонок name, phone, address, email, *_enc, pii_key, pii_key_id, *_bidx
+func (s *sealedDelivery) args() []any {
+	return []any{s.name, s.phone, s.address, s.email,
+		nullBytes(s.nameEnc), nullBytes(s.phoneEnc), nullBytes(s.addressEnc), nullBytes(s.emailEnc),
+		nullBytes(s.key), s.keyID, nullBytes(s.nameIdx), nullBytes(s.phoneIdx), nullBytes(s.emailIdx)}
+}
+
+// nullBytes nil-срез как NULL: lib/pq передает []byte(nil) пустым bytea
+func nullBytes(b []byte) any {
+	if b == nil {
+		return nil
+	}
+	return b
+}
+
+// piiAAD связывает шифротекст с заказом и полем: подмененный из другой строки не расшифруется
+func piiAAD(orderUID, field string) string {
+	return orderUID + "/delivery." + field
+}
+
+// sealDelivery колонки для записи доставки. Без ключей шифрования - открытый текст
+func (r *DBRepository) sealDelivery(orderUID string, d model.Delivery) (sealedDelivery, error) {
+	if r.pii == nil {
+		return sealedDelivery{name: d.Name, phone: d.Phone, address: d.Address, email: d.Email}, nil
+	}
+	dek, wrapped, err := r.pii.NewDataKey(piiAAD(orderUID, "key"))
+	if err != nil {
+		return sealedDelivery{}, err
+	}
+	s := sealedDelivery{
+		key:      wrapped,
+		keyID:    sql.NullString{String: r.pii.ActiveKeyID(), Valid: true},
+		nameIdx:  r.pii.BlindIndex("name", d.Name),
+		phoneIdx: r.pii.BlindIndex("phone", d.Phone),
+		emailIdx: r.pii.BlindIndex("email", d.Email),
+	}
+	for _, f := range []struct {
+		name  string
+		value string
+		dst   *[]byte
+	}{
+		{"name", d.Name, &s.nameEnc},
+		{"phone", d.Phone, &s.phoneEnc},
+		{"address", d.Address, &s.addressEnc},
+		{"email", d.Email, &s.emailEnc},
+	} {
+		if *f.dst, err = pii.Encrypt(dek, f.value, piiAAD(orderUID, f.name)); err != nil {
+			return sealedDelivery{}, err
+		}
+	}
+	return s, nil
+}
+
+// openDelivery заполняет персональные поля d из колонок. Строка без ключа данных хранится открытым текстом
+func (r *DBRepository) openDelivery(orderUID string, s sealedDelivery, d *model.Delivery) error {
+	d.Name, d.Phone, d.Address, d.Email = s.name, s.phone, s.address, s.email
+	if len(s.key) == 0 {
+		return nil
+	}
+	if r.pii == nil {
+		return fmt.Errorf("delivery of order %s is encrypted, PII keys are not configured", orderUID)
+	}
+	dek, err := r.pii.UnwrapDataKey(s.keyID.String, s.key, piiAAD(orderUID, "key"))
+	if err != nil {
+		return fmt.Errorf("order %s: %w", orderUID, err)
+	}
+	for _, f := range []struct {
+		name string
+		enc  []byte
+		dst  *string
+	}{
+		{"name", s.nameEnc, &d.Name},
+		{"phone", s.phoneEnc, &d.Phone},
+		{"address", s.addressEnc, &d.Address},
+		{"email", s.emailEnc, &d.Email},
+	} {
+		if *f.dst, err = pii.Decrypt(dek, f.enc, piiAAD(orderUID, f.name)); err != nil {
+			return fmt.Errorf("order %s: %w", orderUID, err)
+		}
+	}
+	return nil
+}
+
+// openField расшифровывает одно поле доставки из шифротекста enc и ключа данных key
+func (r *DBRepository) openField(orderUID, field, keyID string, key, enc []byte) (string, error) {
+	if r.pii == nil {
+		return "", fmt.Errorf("delivery of order %s is encrypted, PII keys are not configured", orderUID)
+	}
+	dek, err := r.pii.UnwrapDataKey(keyID, key, piiAAD(orderUID, "key"))
+	if err != nil {
+		return "", fmt.Errorf("order %s: %w", orderUID, err)
+	}
+	value, err := pii.Decrypt(dek, enc, piiAAD(orderUID, field))
+	if err != nil {
+		return "", fmt.Errorf("order %s: %w", orderUID, err)
+	}
+	return value, nil
+}
+
+// sealBlob шифрует тело целиком новым ключом данных. aad привязывает шифротекст к строке.
+// Без ключей шифрования - открытый текст, ключ данных nil
+func (r *DBRepository) sealBlob(plain []byte, aad string) (enc, key []byte, keyID sql.NullString, err error) {
+	if r.pii == nil {
+		return plain, nil, sql.NullString{}, nil
+	}
+	dek, key, err := r.pii.NewDataKey(aad + ".key")
+	if err != nil {
+		return nil, nil, sql.NullString{}, err
+	}
+	if enc, err = pii.Encrypt(dek, string(plain), aad); err != nil {
+		return nil, nil, sql.NullString{}, err
+	}
+	if enc == nil {
+		enc = []byte{}
+	}
+	return enc, key, sql.NullString{String: r.pii.ActiveKeyID(), Valid: true}, nil
+}
+
+// openBlob обратная к sealBlob операция. Тело без ключа данных хранится открытым текстом
+func (r *DBRepository) openBlob(enc, key []byte, keyID sql.NullString, aad string) ([]byte, error) {
+	if len(key) == 0 {
+		return enc, nil
+	}
+	if r.pii == nil {
+		return nil, fmt.Errorf("%s is encrypted, PII keys are not configured", aad)
+	}
+	dek, err := r.pii.UnwrapDataKey(keyID.String, key, aad+".key")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", aad, err)
+	}
+	plain, err := pii.Decrypt(dek, enc, aad)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", aad, err)
+	}
+	return []byte(plain), nil
+}
+
+// maskDelivery копия заказа с "***" вместо непустых персональных полей доставки
+func maskDelivery(order *model.Order) *model.Order {
+	masked := *order
+	for _, f := range []*string{&masked.Delivery.Name, &masked.Delivery.Phone,
+		&masked.Delivery.Address, &masked.Delivery.Email} {
+		if *f != "" {
+			*f = "***"
+		}
+	}
+	return &masked
+}
+
+// piiFields поля истории, значения которых при шифровании не пишутся в order_history
+var piiFields = []string{"delivery.name", "delivery.phone", "delivery.address", "delivery.email"}
+
+// maskPII заменяет значения персональных полей в diff на "***": иначе журнал хранил бы их открытым текстом
+func maskPII(changes []model.FieldChange) []model.FieldChange {
+	for i, c := range changes {
+		if !slices.Contains(piiFields, c.Field) {
+			continue
+		}
+		if c.Old != "" && c.Old != nil {
+			changes[i].Old = "***"
+		}
+		if c.New != "" && c.New != nil {
+			changes[i].New = "***"
+		}
+	}
+	return changes
+}
+
+// RotatePII обрабатывает до limit строк table с первичным ключом > after одной транзакцией.
+// Доставки, события outbox и исходные сообщения, зашифрованные не активным мастер-ключом или еще
+// не зашифрованные, получают новый ключ данных и новые шифротексты (доставки - и blind index).
+// В order_history маскируются значения персональных полей, записанные до включения шифрования.
+// Возвращает последний обработанный ключ (курсор следующего вызова) и число строк
+func (r *DBRepository) RotatePII(ctx context.Context, table string, after, limit int) (int, int, error) {
+	if r.pii == nil {
+		return after, 0, errors.New("rotate pii: PII keys are not configured")
+	}
+	if !slices.Contains(PIITables, table) {
+		return after, 0, fmt.Errorf("rotate pii: unknown table %q", table)
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return after, 0, err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Printf("Rollback error:%s", err.Error())
+		}
+	}()
+
+	var last, n int
+	switch table {
+	case "order_outbox":
+		last, n, err = r.rotateOutbox(ctx, tx, after, limit)
+	case "order_raw_messages":
+		last, n, err = r.rotateRaw(ctx, tx, after, limit)
+	case "order_history":
+		last, n, err = r.maskHistory(ctx, tx, after, limit)
+	default:
+		last, n, err = r.rotateDeliveries(ctx, tx, table, after, limit)
+	}
+	if err != nil {
+		return after, 0, fmt.Errorf("rotate pii: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return after, 0, err
+	}
+	return last, n, nil
+}
+
+// rotateDeliveries перешифровывает доставки table с delivery_id > after
+func (r *DBRepository) rotateDeliveries(ctx context.Context, tx *sql.Tx, table string, after, limit int) (int, int, error) {
+	type row struct {
+		id       int
+		orderUID string
+		sealed   sealedDelivery
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT delivery_id, order_uid, name, phone, address, email,
+		       name_enc, phone_enc, address_enc, email_enc, pii_key, pii_key_id
+		FROM `+table+`
+		WHERE delivery_id > $1 AND pii_key_id IS DISTINCT FROM $2
+		ORDER BY delivery_id LIMIT $3
+		FOR UPDATE
+	`, after, r.pii.ActiveKeyID(), limit)
+	if err != nil {
+		return after, 0, err
+	}
+	var batch []row
+	for rows.Next() {
+		var (
+			x row
+			s = &x.sealed
+		)
+		if err := rows.Scan(&x.id, &x.orderUID, &s.name, &s.phone, &s.address, &s.email,
+			&s.nameEnc, &s.phoneEnc, &s.addressEnc, &s.emailEnc, &s.key, &s.keyID); err != nil {
+			_ = rows.Close()
+			return after, 0, err
+		}
+		batch = append(batch, x)
+	}
+	if err := rows.Close(); err != nil {
+		return after, 0, err
+	}
+	if err := rows.Err(); err != nil {
+		return after, 0, err
+	}
+
+	last := after
+	for _, x := range batch {
+		var d model.Delivery
+		if err := r.openDelivery(x.orderUID, x.sealed, &d); err != nil {
+			return after, 0, err
+		}
+		sealed, err := r.sealDelivery(x.orderUID, d)
+		if err != nil {
+			return after, 0, err
+		}
+		if err := r.exec(ctx, tx, "rotatePII", `
+			UPDATE `+table+` SET
+				name = $2, phone = $3, address = $4, email = $5,
+				name_enc = $6, phone_enc = $7, address_enc = $8, email_enc = $9,
+				pii_key = $10, pii_key_id = $11, name_bidx = $12, phone_bidx = $13, email_bidx = $14
+			WHERE delivery_id = $1
+		`, append([]any{x.id}, sealed.args()...)...); err != nil {
+			return after, 0, err
+		}
+		last = x.id
+	}
+	return last, len(batch), nil
+}
+
+// rotateOutbox перешифровывает события outbox с event_id > after. Событие, записанное открытым
+// текстом, шифруется, а в payload остается заказ с "***" в данных доставки
+func (r *DBRepository) rotateOutbox(ctx context.Context, tx *sql.Tx, after, limit int) (int, int, error) {
+	type row struct {
+		id                  int
+		orderUID, eventType string
+		payload, enc, key   []byte
+		keyID               sql.NullString
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT event_id, order_uid, event_type, payload, payload_enc, pii_key, pii_key_id
+		FROM order_outbox
+		WHERE event_id > $1 AND pii_key_id IS DISTINCT FROM $2
+		ORDER BY event_id LIMIT $3
+		FOR UPDATE
+	`, after, r.pii.ActiveKeyID(), limit)
+	if err != nil {
+		return after, 0, err
+	}
+	var batch []row
+	for rows.Next() {
+		var x row
+		if err := rows.Scan(&x.id, &x.orderUID, &x.eventType, &x.payload, &x.enc, &x.key, &x.keyID); err != nil {
+			_ = rows.Close()
+			return after, 0, err
+		}
+		batch = append(batch, x)
+	}
+	if err := rows.Close(); err != nil {
+		return after, 0, err
+	}
+	if err := rows.Err(); err != nil {
+		return after, 0, err
+	}
+
+	last := after
+	for _, x := range batch {
+		payload := x.payload
+		if len(x.key) > 0 {
+			if payload, err = r.openBlob(x.enc, x.key, x.keyID, outboxAAD(x.orderUID, x.eventType)); err != nil {
+				return after, 0, err
+			}
+		}
+		var order model.Order
+		if err := json.Unmarshal(payload, &order); err != nil {
+			return after, 0, fmt.Errorf("outbox event %d: %w", x.id, err)
+		}
+		stored, enc, key, keyID, err := r.sealOutbox(x.orderUID, x.eventType, &order, payload)
+		if err != nil {
+			return after, 0, err
+		}
+		if err := r.exec(ctx, tx, "rotateOutbox", `
+			UPDATE order_outbox SET payload = $2, payload_enc = $3, pii_key = $4, pii_key_id = $5
+			WHERE event_id = $1
+		`, x.id, stored, enc, key, keyID); err != nil {
+			return after, 0, err
+		}
+		last = x.id
+	}
+	return last, len(batch), nil
+}
+
+// rotateRaw перешифровывает исходные сообщения с raw_id > after. Тело, записанное открытым текстом, шифруется
+func (r *DBRepository) rotateRaw(ctx context.Context, tx *sql.Tx, after, limit int) (int, int, error) {
+	type row struct {
+		id           int
+		topic        string
+		partition    int
+		offset       int64
+		payload, key []byte
+		keyID        sql.NullString
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT raw_id, topic, kafka_partition, kafka_offset, payload, pii_key, pii_key_id
+		FROM order_raw_messages
+		WHERE raw_id > $1 AND pii_key_id IS DISTINCT FROM $2
+		ORDER BY raw_id LIMIT $3
+		FOR UPDATE
+	`, after, r.pii.ActiveKeyID(), limit)
+	if err != nil {
+		return after, 0, err
+	}
+	var batch []row
+	for rows.Next() {
+		var x row
+		if err := rows.Scan(&x.id, &x.topic, &x.partition, &x.offset, &x.payload, &x.key, &x.keyID); err != nil {
+			_ = rows.Close()
+			return after, 0, err
+		}
+		batch = append(batch, x)
+	}
+	if err := rows.Close(); err != nil {
+		return after, 0, err
+	}
+	if err := rows.Err(); err != nil {
+		return after, 0, err
+	}
+
+	last := after
+	for _, x := range batch {
+		aad := rawAAD(x.topic, x.partition, x.offset)
+		plain, err := r.openBlob(x.payload, x.key, x.keyID, aad)
+		if err != nil {
+			return after, 0, err
+		}
+		enc, key, keyID, err := r.sealBlob(plain, aad)
+		if err != nil {
+			return after, 0, err
+		}
+		if err := r.exec(ctx, tx, "rotateRaw",
+			`UPDATE order_raw_messages SET payload = $2, pii_key = $3, pii_key_id = $4 WHERE raw_id = $1`,
+			x.id, enc, key, keyID); err != nil {
+			return after, 0, err
+		}
+		last = x.id
+	}
+	return last, len(batch), nil
+}
+
+// maskHistory маскирует персональные поля в записях истории с history_id > after, сделанных
+// до включения шифрования. Значения не восстанавливаются: после включения шифрования
+// история их тоже не хранит
+func (r *DBRepository) maskHistory(ctx context.Context, tx *sql.Tx, after, limit int) (int, int, error) {
+	// order_history append-only: правка значений разрешена только в режиме обслуживания
+	if err := r.exec(ctx, tx, "historyMaintenance", `SET LOCAL orders.history_maintenance = 'on'`); err != nil {
+		return after, 0, err
+	}
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE order_history SET changes = `+scrubChangesSQL+`
+		WHERE history_id IN (
+			SELECT history_id FROM order_history
+			WHERE history_id > $1 AND jsonb_typeof(changes) = 'array' AND EXISTS (
+				SELECT 1 FROM jsonb_array_elements(changes) AS e(c)
+				WHERE c->>'field' = ANY($2) AND (coalesce(c->>'old', '') NOT IN ('', '***')
+					OR coalesce(c->>'new', '') NOT IN ('', '***')))
+			ORDER BY history_id LIMIT $3
+			FOR UPDATE)
+		RETURNING history_id
+	`, after, pq.Array(piiFields), limit)
+	if err != nil {
+		return after, 0, err
+	}
+	last, n := after, 0
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return after, 0, err
+		}
+		last, n = max(last, id), n+1
+	}
+	if err := rows.Close(); err != nil {
+		return after, 0, err
+	}
+	if err := rows.Err(); err != nil {
+		return after, 0, err
+	}
+	return last, n, nil
+}
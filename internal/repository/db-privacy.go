@@ -0,0 +1,299 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/lib/pq"
+)
+
+// IPrivacyRepository выгрузка и удаление персональных данных покупателя по его запросу (GDPR).
+// Реализуется не всеми хранилищами, сервис проверяет его через type assertion
+type IPrivacyRepository interface {
+	// ExportCustomer все заказы покупателя с историей и исходными сообщениями
+	ExportCustomer(ctx context.Context, customerID string) (model.CustomerExport, error)
+	// EraseCustomer анонимизирует доставку и идентификаторы платежей всех заказов покупателя
+	EraseCustomer(ctx context.Context, customerID string) (model.CustomerErasure, error)
+}
+
+// erasedFields поля заказа, которые затирает EraseCustomer, в терминах истории изменений
+var erasedFields = append(slices.Clone(piiFields), "delivery.zip", "delivery.city", "delivery.region",
+	"payment.transaction", "payment.request_id")
+
+// ExportCustomer выгружает заказы покупателя из основных таблиц и архива, их историю и исходные сообщения
+// одним снимком (REPEATABLE READ) и пишет запись export в privacy_audit. Заказов нет - пустая выгрузка
+func (r *DBRepository) ExportCustomer(ctx context.Context, customerID string) (model.CustomerExport, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return model.CustomerExport{}, err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Printf("Rollback error:%s", err.Error())
+		}
+	}()
+
+	const tail = `WHERE o.customer_id = $1 ORDER BY o.date_created, o.order_uid`
+	live, err := r.loadOrders(ctx, tx, tail, customerID)
+	if err != nil {
+		return model.CustomerExport{}, fmt.Errorf("export customer: %w", err)
+	}
+	archived, err := r.loadArchivedOrders(ctx, tx, tail, customerID)
+	if err != nil {
+		return model.CustomerExport{}, fmt.Errorf("export customer: %w", err)
+	}
+
+	out := model.CustomerExport{
+		CustomerID: customerID,
+		ExportedAt: time.Now().UTC(),
+		Orders:     make([]model.ExportedOrder, 0, len(live)+len(archived)),
+	}
+	for _, o := range live {
+		out.Orders = append(out.Orders, model.ExportedOrder{Order: o, DeletedAt: o.DeletedAt})
+	}
+	for _, o := range archived {
+		out.Orders = append(out.Orders, model.ExportedOrder{Order: o, DeletedAt: o.DeletedAt, Archived: true})
+	}
+	ids := exportedOrderIDs(out.Orders)
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT history_id, order_uid, changed_at, op, source, changes
+		FROM order_history WHERE order_uid = ANY($1)
+		ORDER BY history_id
+	`, pq.Array(ids))
+	if err != nil {
+		return model.CustomerExport{}, fmt.Errorf("export customer history: %w", err)
+	}
+	out.History, err = scanHistory(rows)
+	closeRows(rows)
+	if err != nil {
+		return model.CustomerExport{}, fmt.Errorf("export customer history: %w", err)
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT raw_id, order_uid, payload, topic, kafka_partition, kafka_offset,
+		       headers, message_time, schema_version, received_at, rejection, pii_key, pii_key_id
+		FROM order_raw_messages WHERE order_uid = ANY($1)
+		ORDER BY raw_id
+	`, pq.Array(ids))
+	if err != nil {
+		return model.CustomerExport{}, fmt.Errorf("export customer raw messages: %w", err)
+	}
+	out.RawMessages, err = r.scanRawMessages(rows)
+	closeRows(rows)
+	if err != nil {
+		return model.CustomerExport{}, fmt.Errorf("export customer raw messages: %w", err)
+	}
+
+	if err := r.appendPrivacyAudit(ctx, tx, model.PrivacyExport, customerID, ids); err != nil {
+		return model.CustomerExport{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return model.CustomerExport{}, err
+	}
+	return out, nil
+}
+
+// eraseSQL затирает персональные данные заказов $1 в основных таблицах и архиве. Суммы платежа,
+// позиции и customer_id остаются для учета. Шифротексты удаляются вместе с ключом данных.
+// updated_at заказа сдвигается, чтобы изменение увидели ChangedSince и ресинк кешей
+var eraseSQL = []struct{ name, query string }{
+	{"eraseDeliveries", eraseDeliverySQL("deliveries")},
+	{"eraseArchivedDeliveries", eraseDeliverySQL("deliveries_archive")},
+	{"erasePayments", `UPDATE payments SET transaction = '', request_id = '' WHERE order_uid = ANY($1)`},
+	{"eraseArchivedPayments", `UPDATE payments_archive SET transaction = '', request_id = '' WHERE order_uid = ANY($1)`},
+	{"touchOrders", `UPDATE orders SET updated_at = now() WHERE order_uid = ANY($1)`},
+	{"eraseRawMessages", `DELETE FROM order_raw_messages WHERE order_uid = ANY($1)`},
+	// В событиях заказ целиком, в том числе неопубликованных: вместо них пишется order.erased
+	{"eraseOutbox", `DELETE FROM order_outbox WHERE order_uid = ANY($1)`},
+}
+
+func eraseDeliverySQL(table string) string {
+	return `UPDATE ` + table + ` SET
+			name = '', phone = '', zip = '', city = '', address = '', region = '', email = '',
+			name_enc = NULL, phone_enc = NULL, address_enc = NULL, email_enc = NULL,
+			pii_key = NULL, pii_key_id = NULL, name_bidx = NULL, phone_bidx = NULL, email_bidx = NULL
+		WHERE order_uid = ANY($1)`
+}
+
+// scrubChangesSQL changes с "***" вместо непустых значений полей $2
+const scrubChangesSQL = `(
+		SELECT jsonb_agg(CASE WHEN c->>'field' = ANY($2) THEN c || jsonb_build_object(
+				'old', CASE WHEN coalesce(c->>'old', '') = '' THEN c->'old' ELSE '"***"'::jsonb END,
+				'new', CASE WHEN coalesce(c->>'new', '') = '' THEN c->'new' ELSE '"***"'::jsonb END)
+			ELSE c END ORDER BY n)
+		FROM jsonb_array_elements(changes) WITH ORDINALITY AS e(c, n))`
+
+// scrubHistorySQL заменяет на "***" непустые значения полей $2 в истории заказов $1
+const scrubHistorySQL = `
+	UPDATE order_history SET changes = ` + scrubChangesSQL + `
+	WHERE order_uid = ANY($1) AND jsonb_typeof(changes) = 'array' AND changes <> '[]'::jsonb
+`
+
+// EraseCustomer одной транзакцией анонимизирует заказы покупателя в основных таблицах и архиве:
+// затирает доставку и идентификаторы платежа, удаляет исходные сообщения и события outbox,
+// маскирует эти поля в истории и добавляет в нее запись erase, пишет событие order.erased и запись
+// в privacy_audit. Повторный вызов безопасен. Заказов нет - пустой результат
+func (r *DBRepository) EraseCustomer(ctx context.Context, customerID string) (model.CustomerErasure, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.CustomerErasure{}, err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Printf("Rollback error:%s", err.Error())
+		}
+	}()
+
+	if err := r.exec(ctx, tx, "setStatementTimeout",
+		fmt.Sprintf(`SET LOCAL statement_timeout = %d`, r.statementTimeout.Milliseconds())); err != nil {
+		return model.CustomerErasure{}, err
+	}
+	// order_history append-only: правка значений разрешена только в режиме обслуживания
+	if err := r.exec(ctx, tx, "historyMaintenance", `SET LOCAL orders.history_maintenance = 'on'`); err != nil {
+		return model.CustomerErasure{}, err
+	}
+
+	// FOR UPDATE: параллельный Save заказа дождется анонимизации и запишет уже новое сообщение
+	live, err := r.customerOrderIDs(ctx, tx,
+		`SELECT order_uid FROM orders WHERE customer_id = $1 ORDER BY order_uid FOR UPDATE`, customerID)
+	if err != nil {
+		return model.CustomerErasure{}, err
+	}
+	archived, err := r.customerOrderIDs(ctx, tx,
+		`SELECT order_uid FROM orders_archive WHERE customer_id = $1 ORDER BY order_uid`, customerID)
+	if err != nil {
+		return model.CustomerErasure{}, err
+	}
+	ids := slices.Compact(slices.Sorted(slices.Values(slices.Concat(live, archived))))
+
+	if len(ids) > 0 {
+		if err := r.eraseOrders(ctx, tx, ids, live); err != nil {
+			return model.CustomerErasure{}, err
+		}
+	}
+	if err := r.appendPrivacyAudit(ctx, tx, model.PrivacyErase, customerID, ids); err != nil {
+		return model.CustomerErasure{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return model.CustomerErasure{}, err
+	}
+	for _, id := range live {
+		r.markWritten(id)
+	}
+	if ids == nil {
+		ids = []string{}
+	}
+	return model.CustomerErasure{CustomerID: customerID, ErasedAt: time.Now().UTC(), Orders: ids}, nil
+}
+
+// eraseOrders затирает данные заказов ids и пишет события order.erased для заказов live,
+// которые еще не удалены retention
+func (r *DBRepository) eraseOrders(ctx context.Context, tx *sql.Tx, ids, live []string) error {
+	arg := pq.Array(ids)
+	for _, st := range eraseSQL {
+		if err := r.exec(ctx, tx, st.name, st.query, arg); err != nil {
+			return err
+		}
+	}
+	if err := r.exec(ctx, tx, "scrubHistory", scrubHistorySQL, arg, pq.Array(erasedFields)); err != nil {
+		return err
+	}
+
+	source, err := json.Marshal(model.ChangeSourceFrom(ctx))
+	if err != nil {
+		return fmt.Errorf("eraseOrders: %w", err)
+	}
+	changes := make([]model.FieldChange, 0, len(erasedFields))
+	for _, f := range erasedFields {
+		changes = append(changes, model.FieldChange{Field: f, Old: "***", New: ""})
+	}
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("eraseOrders: %w", err)
+	}
+	if err := r.exec(ctx, tx, "appendHistory", `
+		INSERT INTO order_history (order_uid, op, source, changes)
+		SELECT unnest($1::uuid[]), $2, $3, $4
+	`, arg, model.HistoryErase, source, changesJSON); err != nil {
+		return err
+	}
+
+	if len(live) == 0 {
+		return nil
+	}
+	stmtCtx, cancel := context.WithTimeout(ctx, r.statementTimeout)
+	defer cancel()
+	orders, err := r.loadOrders(stmtCtx, tx, `WHERE o.order_uid = ANY($1)`, pq.Array(live))
+	if err != nil {
+		return fmt.Errorf("eraseOrders: %w", err)
+	}
+	for _, o := range orders {
+		if err := r.appendOutbox(ctx, tx, EventOrderErased, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// customerOrderIDs order_uid из запроса query с параметром customerID
+func (r *DBRepository) customerOrderIDs(ctx context.Context, tx *sql.Tx, query, customerID string) ([]string, error) {
+	stmtCtx, cancel := context.WithTimeout(ctx, r.statementTimeout)
+	defer cancel()
+	rows, err := tx.QueryContext(stmtCtx, query, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("customer orders: %w", err)
+	}
+	defer closeRows(rows)
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("customer orders: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("customer orders: %w", err)
+	}
+	return ids, nil
+}
+
+// appendPrivacyAudit пишет в privacy_audit операцию op над данными покупателя, затронутые заказы
+// и источник запроса из ctx. Таблица append-only, записи не удаляются даже после удаления данных
+func (r *DBRepository) appendPrivacyAudit(ctx context.Context, tx *sql.Tx, op, customerID string, ids []string) error {
+	source, err := json.Marshal(model.ChangeSourceFrom(ctx))
+	if err != nil {
+		return fmt.Errorf("appendPrivacyAudit: %w", err)
+	}
+	if ids == nil {
+		ids = []string{}
+	}
+	return r.exec(ctx, tx, "appendPrivacyAudit",
+		`INSERT INTO privacy_audit (op, customer_id, source, order_uids) VALUES ($1, $2, $3, $4)`,
+		op, customerID, source, pq.Array(ids))
+}
+
+func exportedOrderIDs(orders []model.ExportedOrder) []string {
+	ids := make([]string, 0, len(orders))
+	for _, o := range orders {
+		ids = append(ids, o.OrderUID)
+	}
+	return slices.Compact(slices.Sorted(slices.Values(ids)))
+}
+
+func closeRows(rows *sql.Rows) {
+	if err := rows.Close(); err != nil {
+		log.Printf("rows close error:%s", err)
+	}
+}
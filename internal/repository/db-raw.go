@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/google/uuid"
+)
+
+// maxRawLimit предел исходных сообщений за один запрос
+const maxRawLimit = 100
+
+// IRawMessageRepository исходные сообщения заказов. Реализуется не всеми хранилищами,
+// сервис проверяет его через type assertion
+type IRawMessageRepository interface {
+	// SaveRawMessage сохраняет исходное сообщение отдельным запросом, до разбора заказа
+	SaveRawMessage(ctx context.Context, msg model.RawMessage) error
+	RawMessages(ctx context.Context, id string, limit int) ([]model.RawMessage, error)
+}
+
+// IRawRetentionRepository удаление исходных сообщений, из которых заказ не сохранен.
+// Реализуется не всеми хранилищами, retention проверяет его через type assertion
+type IRawRetentionRepository interface {
+	// PurgeRejectedRaw удаляет отклоненные сообщения и сообщения без order_uid, полученные раньше before
+	PurgeRejectedRaw(ctx context.Context, before time.Time) (int64, error)
+}
+
+// SaveRawMessage сохраняет исходное сообщение байт в байт, с ключами PII - зашифрованным.
+// order_uid пишется, только если это UUID.
+// Повторная доставка того же сообщения (topic, partition, offset) вторую строку не создает,
+// но причину отклонения в существующую строку дописывает
+func (r *DBRepository) SaveRawMessage(ctx context.Context, msg model.RawMessage) error {
+	if msg.Headers == nil {
+		msg.Headers = []model.RawHeader{}
+	}
+	headers, err := json.Marshal(msg.Headers)
+	if err != nil {
+		return fmt.Errorf("saveRawMessage: %w", err)
+	}
+	payload, key, keyID, err := r.sealBlob(msg.Payload, rawAAD(msg.Topic, msg.Partition, msg.Offset))
+	if err != nil {
+		return fmt.Errorf("saveRawMessage: %w", err)
+	}
+	if payload == nil {
+		payload = []byte{}
+	}
+	_, uidErr := uuid.Parse(msg.OrderUID)
+
+	stmtCtx, cancel := context.WithTimeout(ctx, r.statementTimeout)
+	defer cancel()
+	if _, err := r.db.ExecContext(stmtCtx, `
+		INSERT INTO order_raw_messages (order_uid, payload, topic, kafka_partition, kafka_offset,
+			headers, message_time, schema_version, rejection, pii_key, pii_key_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (topic, kafka_partition, kafka_offset) DO UPDATE SET rejection = EXCLUDED.rejection
+		WHERE EXCLUDED.rejection IS NOT NULL
+	`, sql.NullString{String: msg.OrderUID, Valid: uidErr == nil}, payload, msg.Topic, msg.Partition,
+		msg.Offset, headers, sql.NullTime{Time: msg.Timestamp, Valid: !msg.Timestamp.IsZero()},
+		msg.SchemaVersion, sql.NullString{String: msg.Rejection, Valid: msg.Rejection != ""},
+		nullBytes(key), keyID); err != nil {
+		return fmt.Errorf("saveRawMessage: %w", err)
+	}
+	return nil
+}
+
+// rawAAD связывает шифротекст тела с сообщением: topic, partition и offset строки не меняются
+func rawAAD(topic string, partition int, offset int64) string {
+	return fmt.Sprintf("raw/%s/%d/%d", topic, partition, offset)
+}
+
+// RawMessages возвращает до limit последних исходных сообщений заказа, сначала новые.
+// Пустой результат - ErrOrderNotFound
+func (r *DBRepository) RawMessages(ctx context.Context, id string, limit int) ([]model.RawMessage, error) {
+	if limit <= 0 || limit > maxRawLimit {
+		limit = maxRawLimit
+	}
+	rows, err := r.reader(id).QueryContext(ctx, `
+		SELECT raw_id, order_uid, payload, topic, kafka_partition, kafka_offset,
+		       headers, message_time, schema_version, received_at, rejection, pii_key, pii_key_id
+		FROM order_raw_messages WHERE order_uid = $1
+		ORDER BY raw_id DESC LIMIT $2
+	`, id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("raw messages: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("rows close error:%s", err)
+		}
+	}()
+
+	out, err := r.scanRawMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("raw messages: %w", err)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrOrderNotFound, id)
+	}
+	return out, nil
+}
+
+// scanRawMessages читает строки raw_id, order_uid, payload, topic, kafka_partition, kafka_offset,
+// headers, message_time, schema_version, received_at, rejection, pii_key, pii_key_id.
+// Зашифрованное тело расшифровывается
+func (r *DBRepository) scanRawMessages(rows *sql.Rows) ([]model.RawMessage, error) {
+	out := make([]model.RawMessage, 0, 4)
+	for rows.Next() {
+		var (
+			m                          model.RawMessage
+			payload, headers, key      []byte
+			orderUID, rejection, keyID sql.NullString
+			msgTime                    sql.NullTime
+		)
+		if err := rows.Scan(&m.ID, &orderUID, &payload, &m.Topic, &m.Partition, &m.Offset,
+			&headers, &msgTime, &m.SchemaVersion, &m.ReceivedAt, &rejection, &key, &keyID); err != nil {
+			return nil, fmt.Errorf("scanRaw: %w", err)
+		}
+		m.OrderUID, m.Rejection, m.Timestamp = orderUID.String, rejection.String, msgTime.Time
+		var err error
+		if m.Payload, err = r.openBlob(payload, key, keyID, rawAAD(m.Topic, m.Partition, m.Offset)); err != nil {
+			return nil, fmt.Errorf("scanRaw: %w", err)
+		}
+		if err := json.Unmarshal(headers, &m.Headers); err != nil {
+			return nil, fmt.Errorf("scanRaw: %w", err)
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// PurgeRejectedRaw удаляет отклоненные сообщения и сообщения без order_uid, полученные раньше before.
+// Остальные исходные сообщения удаляются вместе со своим заказом
+func (r *DBRepository) PurgeRejectedRaw(ctx context.Context, before time.Time) (int64, error) {
+	stmtCtx, cancel := context.WithTimeout(ctx, r.statementTimeout)
+	defer cancel()
+	res, err := r.db.ExecContext(stmtCtx, `
+		DELETE FROM order_raw_messages
+		WHERE (order_uid IS NULL OR rejection IS NOT NULL) AND received_at < $1
+	`, before)
+	if err != nil {
+		return 0, fmt.Errorf("purge rejected raw: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("purge rejected raw: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Значения ReplicaConfig по умолчанию
+const (
+	defaultReplicaMaxLag        = 5 * time.Second
+	defaultReplicaCheckInterval = 5 * time.Second
+)
+
+// ReplicaConfig настройки чтения с реплик. Нулевые значения заменяются значениями по умолчанию
+type ReplicaConfig struct {
+	// MaxLag максимальное отставание реплики. Реплика с большим отставанием не получает запросов,
+	// а заказ, записанный этим процессом позже чем MaxLag назад, читается с primary
+	MaxLag time.Duration
+	// CheckInterval период проверки доступности и отставания реплик
+	CheckInterval time.Duration
+}
+
+// replicaLagSQL отставание реплики в секундах. Реплика, проигравшая весь полученный WAL,
+// не отстает, даже если на primary давно не было записи
+const replicaLagSQL = `
+	SELECT CASE
+		WHEN NOT pg_is_in_recovery() THEN 0
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END
+`
+
+// ReplicaStatus состояние реплики на момент последней проверки
+type ReplicaStatus struct {
+	Name    string        `json:"name"`
+	Healthy bool          `json:"healthy"`
+	Lag     time.Duration `json:"lag"`
+	Error   string        `json:"error,omitempty"`
+}
+
+type replica struct {
+	name string
+	db   *sql.DB
+
+	mu     sync.Mutex
+	status ReplicaStatus
+	// healthy дублирует status.Healthy для выбора реплики без блокировки
+	healthy atomic.Bool
+}
+
+// IWriteTracker хранилище с чтением с реплик. Сервис отмечает заказ перед тем, как перечитать его
+// по уведомлению об изменении: запись сделал другой процесс, и реплика может ее еще не видеть.
+// Реализуется не всеми хранилищами, сервис проверяет его через type assertion
+type IWriteTracker interface {
+	MarkWritten(ids ...string)
+}
+
+// ReplicaSet primary и реплики для чтения. Чтения распределяются round-robin по здоровым репликам;
+// пока ни одна реплика не прошла проверку, все чтения идут на primary.
+// Реплики считаются нездоровыми до первой проверки
+type ReplicaSet struct {
+	primary  *sql.DB
+	replicas []*replica
+	next     atomic.Uint64
+	cfg      ReplicaConfig
+
+	mu sync.Mutex
+	// written время записи заказов этим процессом за последние MaxLag
+	written map[string]time.Time
+}
+
+// NewReplicaSet конструктор. names - имена реплик для логов и статуса, по одному на replicas
+func NewReplicaSet(primary *sql.DB, replicas []*sql.DB, names []string, cfg ReplicaConfig) *ReplicaSet {
+	if cfg.MaxLag <= 0 {
+		cfg.MaxLag = defaultReplicaMaxLag
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = defaultReplicaCheckInterval
+	}
+	s := &ReplicaSet{primary: primary, cfg: cfg, written: map[string]time.Time{}}
+	for i, db := range replicas {
+		rp := &replica{name: names[i], db: db}
+		rp.status.Name = rp.name
+		s.replicas = append(s.replicas, rp)
+	}
+	return s
+}
+
+// Reader подключение для чтения: следующая здоровая реплика или primary, если здоровых нет
+func (s *ReplicaSet) Reader() *sql.DB {
+	n := len(s.replicas)
+	start := s.next.Add(1)
+	for i := range n {
+		rp := s.replicas[(start+uint64(i))%uint64(n)]
+		if rp.healthy.Load() {
+			return rp.db
+		}
+	}
+	return s.primary
+}
+
+// ReaderFor подключение для чтения заказов ids. Если хотя бы один из них записан этим процессом
+// позже чем MaxLag назад, реплика может его еще не видеть, и чтение идет на primary
+func (s *ReplicaSet) ReaderFor(ids ...string) *sql.DB {
+	now := time.Now()
+	s.mu.Lock()
+	for _, id := range ids {
+		if at, ok := s.written[id]; ok && now.Sub(at) < s.cfg.MaxLag {
+			s.mu.Unlock()
+			return s.primary
+		}
+	}
+	s.mu.Unlock()
+	return s.Reader()
+}
+
+// MarkWritten отмечает запись заказов: следующие MaxLag их чтения пойдут на primary
+func (s *ReplicaSet) MarkWritten(ids ...string) {
+	now := time.Now()
+	s.mu.Lock()
+	for _, id := range ids {
+		s.written[id] = now
+	}
+	s.mu.Unlock()
+}
+
+// CheckHealth проверяет все реплики: реплика здорова, если отвечает и отстает не больше MaxLag.
+// Заодно забываются записи старше MaxLag
+func (s *ReplicaSet) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, rp := range s.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.check(ctx, rp)
+		}()
+	}
+	wg.Wait()
+
+	now := time.Now()
+	s.mu.Lock()
+	for id, at := range s.written {
+		if now.Sub(at) >= s.cfg.MaxLag {
+			delete(s.written, id)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// RunHealthChecks проверяет реплики сразу и затем каждые CheckInterval до отмены ctx
+func (s *ReplicaSet) RunHealthChecks(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.CheckInterval)
+	defer ticker.Stop()
+	for {
+		s.CheckHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status состояние реплик на момент последней проверки
+func (s *ReplicaSet) Status() []ReplicaStatus {
+	out := make([]ReplicaStatus, 0, len(s.replicas))
+	for _, rp := range s.replicas {
+		rp.mu.Lock()
+		out = append(out, rp.status)
+		rp.mu.Unlock()
+	}
+	return out
+}
+
+func (s *ReplicaSet) check(ctx context.Context, rp *replica) {
+	checkCtx, cancel := context.WithTimeout(ctx, s.cfg.CheckInterval)
+	defer cancel()
+
+	st := ReplicaStatus{Name: rp.name}
+	var lag float64
+	if err := rp.db.QueryRowContext(checkCtx, replicaLagSQL).Scan(&lag); err != nil {
+		st.Error = err.Error()
+	} else {
+		st.Lag = time.Duration(lag * float64(time.Second))
+		st.Healthy = st.Lag <= s.cfg.MaxLag
+	}
+
+	rp.mu.Lock()
+	if was := rp.status.Healthy; was != st.Healthy && ctx.Err() == nil {
+		log.Printf("replica %s healthy=%t lag=%s %s", rp.name, st.Healthy, st.Lag, st.Error)
+	}
+	rp.status = st
+	rp.mu.Unlock()
+	rp.healthy.Store(st.Healthy)
+}
@@ -3,32 +3,94 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/pii"
+	"github.com/lib/pq"
 )
 
+// ErrOrderNotFound заказа нет в БД
+var ErrOrderNotFound = errors.New("order not found in db")
+
+// ErrOrderDeleted заказ мягко удален. Повторное сохранение его не восстанавливает
+var ErrOrderDeleted = errors.New("order is deleted")
+
 // IDBRepository интерфейс БД репозитория
 type IDBRepository interface {
 	Save(ctx context.Context, order *model.Order) error
 	GetByID(ctx context.Context, id string) (*model.Order, error)
-	GetAll(ctx context.Context) ([]*model.Order, error)
+	GetByIDs(ctx context.Context, ids []string) ([]*model.Order, error)
+	Delete(ctx context.Context, id string) error
+	ListPage(ctx context.Context, after Cursor, limit int) ([]*model.Order, error)
+	// Watermark отметка для ChangedSince: все изменения, закоммиченные после вызова, будут строго новее нее
+	Watermark(ctx context.Context) (time.Time, error)
+	ChangedSince(ctx context.Context, since time.Time) ([]string, error)
+}
+
+// defaultStatementTimeout таймаут одного запроса записи по умолчанию
+const defaultStatementTimeout = 5 * time.Second
+
+// watermarkMarginStatements во сколько StatementTimeout по умолчанию укладывается транзакция записи:
+// в Save около десятка запросов, каждый ограничен StatementTimeout
+const watermarkMarginStatements = 20
+
+// DBConfig настройки репозитория. Нулевые значения заменяются значениями по умолчанию
+type DBConfig struct {
+	// StatementTimeout предел для одного запроса внутри транзакции записи.
+	// Действует и на клиенте (контекст запроса), и на сервере (SET LOCAL statement_timeout)
+	StatementTimeout time.Duration
+	// Replicas реплики для чтения заказов. nil - все запросы идут в db
+	Replicas *ReplicaSet
+	// PII ключи шифрования персональных данных доставки. nil - данные пишутся открытым текстом
+	PII *pii.Cipher
+	// WatermarkMargin насколько Watermark отстает от часов БД. Должен быть больше самой долгой транзакции
+	// записи, по умолчанию 20 * StatementTimeout, меньше StatementTimeout не бывает
+	WatermarkMargin time.Duration
 }
 
 // DBRepository реализация БД репозитория.
 type DBRepository struct {
-	db *sql.DB
+	db               *sql.DB
+	replicas         *ReplicaSet
+	pii              *pii.Cipher
+	statementTimeout time.Duration
+	watermarkMargin  time.Duration
 }
 
 // NewOrderRepository конструктор. Создает объект репозитория по переданному sql подключению
 func NewOrderRepository(db *sql.DB) *DBRepository {
-	return &DBRepository{db: db}
+	return NewOrderRepositoryWithConfig(db, DBConfig{})
 }
 
-// Save сохраняет заказ вместе с зависимыми сущностями
+// NewOrderRepositoryWithConfig конструктор с настройками таймаутов
+func NewOrderRepositoryWithConfig(db *sql.DB, cfg DBConfig) *DBRepository {
+	if cfg.StatementTimeout <= 0 {
+		cfg.StatementTimeout = defaultStatementTimeout
+	}
+	if cfg.WatermarkMargin <= 0 {
+		cfg.WatermarkMargin = watermarkMarginStatements * cfg.StatementTimeout
+	}
+	cfg.WatermarkMargin = max(cfg.WatermarkMargin, cfg.StatementTimeout)
+	return &DBRepository{
+		db: db, replicas: cfg.Replicas, pii: cfg.PII,
+		statementTimeout: cfg.StatementTimeout, watermarkMargin: cfg.WatermarkMargin,
+	}
+}
+
+// Save сохраняет заказ вместе с зависимыми сущностями. Позиции с повторяющимся chrt_id - model.ErrDuplicateItem.
+// Каждый запрос транзакции выполняется с ctx и собственным таймаутом: отмена ctx (shutdown консьюмера,
+// таймаут запроса) прерывает зависший запрос, а транзакция откатывается
 func (r *DBRepository) Save(ctx context.Context, order *model.Order) error {
+	if err := order.ValidateItems(); err != nil {
+		return err
+	}
 	// Прокидываем контекст
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -44,53 +106,132 @@ func (r *DBRepository) Save(ctx context.Context, order *model.Order) error {
 		}
 	}()
 
-	if err := r.saveOrder(tx, order); err != nil {
+	// Серверный предел на случай, если клиентская отмена не дойдет до Postgres (разрыв сети)
+	if err := r.exec(ctx, tx, "setStatementTimeout",
+		fmt.Sprintf(`SET LOCAL statement_timeout = %d`, r.statementTimeout.Milliseconds())); err != nil {
+		return err
+	}
+	// PK партиционированной orders - (order_uid, date_created), поэтому единственность order_uid
+	// держится на этой блокировке: два Save одного заказа не вставят две строки в разные месяцы
+	if err := r.exec(ctx, tx, "lockOrder", `SELECT pg_advisory_xact_lock(hashtext($1))`, order.OrderUID); err != nil {
 		return err
 	}
-	if err := r.saveDelivery(tx, order); err != nil {
+	prev, err := r.lockPrevious(ctx, tx, order.OrderUID)
+	if err != nil {
 		return err
 	}
-	if err := r.savePayment(tx, order); err != nil {
+	if prev != nil && prev.DeletedAt != nil {
+		return fmt.Errorf("%w: %s", ErrOrderDeleted, order.OrderUID)
+	}
+	if prev != nil && !prev.DateCreated.Equal(order.DateCreated) {
+		if err := r.moveOrder(ctx, tx, order.OrderUID, prev.DateCreated, order.DateCreated); err != nil {
+			return err
+		}
+	}
+	if err := r.saveOrder(ctx, tx, order); err != nil {
 		return err
 	}
-	if err := r.saveItems(tx, order); err != nil {
+	if err := r.saveDelivery(ctx, tx, order); err != nil {
 		return err
 	}
+	if err := r.savePayment(ctx, tx, order); err != nil {
+		return err
+	}
+	if err := r.saveItems(ctx, tx, order); err != nil {
+		return err
+	}
+	changed, err := r.appendHistory(ctx, tx, prev, order)
+	if err != nil {
+		return err
+	}
+	// Событие только на реальное изменение: повторная доставка того же заказа его не дублирует
+	if changed {
+		if err := r.appendOutbox(ctx, tx, EventOrderSaved, order); err != nil {
+			return err
+		}
+	}
 
 	if err := tx.Commit(); err != nil {
 		return err
 	}
+	r.markWritten(order.OrderUID)
 	return nil
 }
 
-// GetByID возвращает заказ по ID
+// GetByID возвращает заказ по ID. Два запроса: orders+deliveries+payments одним join и items.
+// Мягко удаленный заказ не возвращается
 func (r *DBRepository) GetByID(ctx context.Context, id string) (*model.Order, error) {
-	var order model.Order
-
-	if err := r.loadOrder(ctx, &order, id); err != nil {
+	orders, err := r.loadOrders(ctx, r.reader(id), `WHERE o.order_uid = $1 AND o.deleted_at IS NULL`, id)
+	if err != nil {
 		return nil, err
 	}
-	if err := r.loadDelivery(ctx, &order); err != nil {
-		return nil, err
+	if len(orders) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrOrderNotFound, id)
 	}
-	if err := r.loadPayment(ctx, &order); err != nil {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-	if err := r.loadItems(ctx, &order); err != nil {
-		return nil, err
+
+	return orders[0], nil
+}
+
+// GetByIDs возвращает найденные заказы из ids одним набором запросов.
+// Отсутствующие id пропускаются, порядок результата не определен
+func (r *DBRepository) GetByIDs(ctx context.Context, ids []string) ([]*model.Order, error) {
+	if len(ids) == 0 {
+		return []*model.Order{}, nil
 	}
-	if err := ctx.Err(); err != nil {
-		return nil, err
+	orders, err := r.loadOrders(ctx, r.reader(ids...), `WHERE o.order_uid = ANY($1) AND o.deleted_at IS NULL`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("get orders by ids: %w", err)
 	}
+	return orders, nil
+}
 
-	return &order, nil
+// ListPage возвращает до limit заказов строго после курсора after в порядке
+// date_created DESC, order_uid DESC (сначала новые). Keyset-пагинация: страница читается
+// по индексу orders_date_created_idx без OFFSET. Для полного обхода - IterateOrders/IterateChunks
+func (r *DBRepository) ListPage(ctx context.Context, after Cursor, limit int) ([]*model.Order, error) {
+	if limit <= 0 {
+		return []*model.Order{}, nil
+	}
+	var (
+		orders []*model.Order
+		err    error
+	)
+	if after.IsZero() {
+		orders, err = r.loadOrders(ctx, r.reader(), `WHERE o.deleted_at IS NULL
+			ORDER BY o.date_created DESC, o.order_uid DESC LIMIT $1`, limit)
+	} else {
+		orders, err = r.loadOrders(ctx, r.reader(), `WHERE (o.date_created, o.order_uid) < ($1, $2) AND o.deleted_at IS NULL
+			ORDER BY o.date_created DESC, o.order_uid DESC LIMIT $3`, after.DateCreated, after.OrderUID, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list orders page: %w", err)
+	}
+	return orders, nil
+}
+
+// Watermark возвращает часы БД минус watermarkMargin. Время берется из БД,
+// поэтому сравнение с ним не зависит от расхождения часов между инстансами.
+// max(updated_at) не подходит: updated_at = now() - время начала транзакции, и транзакция,
+// начатая до снятия отметки и закоммиченная после, получила бы updated_at меньше отметки.
+// С запасом ChangedSince может вернуть уже учтенные изменения, но не пропустит новые
+func (r *DBRepository) Watermark(ctx context.Context) (time.Time, error) {
+	var wm time.Time
+	err := r.db.QueryRowContext(ctx,
+		`SELECT clock_timestamp() - make_interval(secs => $1)`, r.watermarkMargin.Seconds()).Scan(&wm)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("watermark: %w", err)
+	}
+	return wm, nil
 }
 
-// GetAll создает массив []*model.Order по данным из Postgres. TODO: поставить ограничение
-func (r *DBRepository) GetAll(ctx context.Context) ([]*model.Order, error) {
-	rows, err := r.db.QueryContext(ctx, `SELECT order_uid FROM orders`)
+// ChangedSince возвращает order_uid заказов, измененных строго после since
+func (r *DBRepository) ChangedSince(ctx context.Context, since time.Time) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT order_uid FROM orders WHERE updated_at > $1`, since)
 	if err != nil {
-		return nil, fmt.Errorf("get all orders: %w", err)
+		return nil, fmt.Errorf("changed since: %w", err)
 	}
 	defer func() {
 		err := rows.Close()
@@ -99,40 +240,267 @@ func (r *DBRepository) GetAll(ctx context.Context) ([]*model.Order, error) {
 		}
 	}()
 
-	orders := make([]*model.Order, 0, 256) // предвыделение
-
+	ids := make([]string, 0, 16)
 	for rows.Next() {
 		var id string
 		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
 
-		// Дальше тоже пробрасываем контекст
-		order, err := r.GetByID(ctx, id)
-		if err != nil {
-			return nil, err
+// Delete мягко удаляет заказ: ставит deleted_at и пишет запись delete в историю.
+// Строки остаются в БД до retention. Уже удаленный или несуществующий заказ - ErrOrderNotFound
+func (r *DBRepository) Delete(ctx context.Context, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Printf("Rollback error:%s", err.Error())
 		}
+	}()
+
+	if err := r.exec(ctx, tx, "setStatementTimeout",
+		fmt.Sprintf(`SET LOCAL statement_timeout = %d`, r.statementTimeout.Milliseconds())); err != nil {
+		return err
+	}
 
-		orders = append(orders, order)
+	stmtCtx, cancel := context.WithTimeout(ctx, r.statementTimeout)
+	defer cancel()
+	var deletedAt time.Time
+	err = tx.QueryRowContext(stmtCtx, `
+		UPDATE orders SET deleted_at = now(), updated_at = now()
+		WHERE order_uid = $1 AND deleted_at IS NULL
+		RETURNING deleted_at
+	`, id).Scan(&deletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s", ErrOrderNotFound, id)
+	}
+	if err != nil {
+		return fmt.Errorf("softDelete: %w", err)
+	}
+
+	source, err := json.Marshal(model.ChangeSourceFrom(ctx))
+	if err != nil {
+		return fmt.Errorf("softDelete: %w", err)
+	}
+	changes, err := json.Marshal([]model.FieldChange{{Field: "deleted_at", New: deletedAt}})
+	if err != nil {
+		return fmt.Errorf("softDelete: %w", err)
+	}
+	if err := r.exec(ctx, tx, "appendHistory",
+		`INSERT INTO order_history (order_uid, op, source, changes) VALUES ($1, $2, $3, $4)`,
+		id, model.HistoryDelete, source, changes); err != nil {
+		return err
 	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.markWritten(id)
+	return nil
+}
+
+//
+// ---------------- PRIVATE (set-based load) ----------------
+//
+
+// reader подключение для чтения заказов ids: реплика, если они настроены и ни один из ids
+// не записан только что, иначе primary. Без ids - любая здоровая реплика (списки, обход)
+func (r *DBRepository) reader(ids ...string) queryer {
+	if r.replicas == nil {
+		return r.db
+	}
+	if len(ids) == 0 {
+		return r.replicas.Reader()
+	}
+	return r.replicas.ReaderFor(ids...)
+}
+
+// markWritten запись заказа видна на primary сразу, на репликах - с отставанием
+func (r *DBRepository) markWritten(id string) {
+	r.MarkWritten(id)
+}
+
+// MarkWritten следующие MaxLag чтения заказов ids идут на primary. Без реплик ничего не делает
+func (r *DBRepository) MarkWritten(ids ...string) {
+	if r.replicas != nil {
+		r.replicas.MarkWritten(ids...)
+	}
+}
+
+// queryer *sql.DB или *sql.Tx: чтение заказа внутри транзакции записи идет тем же кодом
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// selectOrdersSQL заказ вместе с delivery и payment одной строкой.
+// Заказ без delivery/payment считается неполным и не возвращается
+const selectOrdersSQL = `
+	SELECT o.order_uid, o.track_number, o.entry, o.locale, o.internal_signature,
+	       o.customer_id, o.delivery_service, o.shardkey, o.sm_id, o.date_created, o.oof_shard, o.deleted_at,
+	       d.delivery_id, d.name, d.phone, d.zip, d.city, d.address, d.region, d.email,
+	       d.name_enc, d.phone_enc, d.address_enc, d.email_enc, d.pii_key, d.pii_key_id,
+	       p.payment_id, p.transaction, p.request_id, p.currency, p.provider,
+	       p.amount, p.payment_dt, p.bank, p.delivery_cost, p.goods_total, p.custom_fee
+	FROM orders o
+	JOIN deliveries d ON d.order_uid = o.order_uid
+	JOIN payments p ON p.order_uid = o.order_uid
+`
+
+// selectItemsSQL позиции заказов $1. date_created позиций совпадает с заказом ($2):
+// условие по нему отсекает лишние месячные партиции
+const selectItemsSQL = `
+	SELECT item_id, order_uid, chrt_id, track_number, price, rid, name,
+	       sale, size, total_price, nm_id, brand, status
+	FROM items WHERE order_uid = ANY($1) AND date_created = ANY($2)
+	ORDER BY order_uid, item_id
+`
+
+// selectArchivedOrdersSQL, selectArchivedItemsSQL те же запросы к архиву retention.
+// У позиций, заархивированных до партиционирования (миграция 000008), date_created пустой
+var (
+	selectArchivedOrdersSQL = strings.NewReplacer("FROM orders o", "FROM orders_archive o",
+		"JOIN deliveries d", "JOIN deliveries_archive d", "JOIN payments p", "JOIN payments_archive p").
+		Replace(selectOrdersSQL)
+	selectArchivedItemsSQL = strings.Replace(selectItemsSQL, "FROM items WHERE order_uid = ANY($1) AND date_created = ANY($2)",
+		"FROM items_archive WHERE order_uid = ANY($1) AND (date_created = ANY($2) OR date_created IS NULL)", 1)
+)
+
+// loadOrders грузит заказы одним join-запросом (orders+deliveries+payments) с хвостом tail
+// (WHERE/ORDER BY/LIMIT) и items одним запросом по всем order_uid.
+// Порядок заказов в результате совпадает с порядком строк запроса
+func (r *DBRepository) loadOrders(ctx context.Context, q queryer, tail string, args ...any) ([]*model.Order, error) {
+	return r.loadOrdersFrom(ctx, q, selectOrdersSQL, selectItemsSQL, tail, args...)
+}
+
+// loadArchivedOrders то же, что loadOrders, для заказов в архиве retention
+func (r *DBRepository) loadArchivedOrders(ctx context.Context, q queryer, tail string, args ...any) ([]*model.Order, error) {
+	return r.loadOrdersFrom(ctx, q, selectArchivedOrdersSQL, selectArchivedItemsSQL, tail, args...)
+}
 
+func (r *DBRepository) loadOrdersFrom(ctx context.Context, q queryer, ordersSQL, itemsSQL, tail string,
+	args ...any) ([]*model.Order, error) {
+	rows, err := q.QueryContext(ctx, ordersSQL+tail, args...)
+	if err != nil {
+		return nil, fmt.Errorf("loadOrders: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("rows close error:%s", err)
+		}
+	}()
+
+	orders := make([]*model.Order, 0, 64)
+	for rows.Next() {
+		var (
+			o model.Order
+			s sealedDelivery
+		)
+		if err := rows.Scan(&o.OrderUID, &o.TrackNumber, &o.Entry, &o.Locale,
+			&o.InternalSignature, &o.CustomerID, &o.DeliveryService,
+			&o.Shardkey, &o.SmID, &o.DateCreated, &o.OofShard, &o.DeletedAt,
+			&o.Delivery.DeliveryID, &s.name, &s.phone, &o.Delivery.Zip,
+			&o.Delivery.City, &s.address, &o.Delivery.Region, &s.email,
+			&s.nameEnc, &s.phoneEnc, &s.addressEnc, &s.emailEnc, &s.key, &s.keyID,
+			&o.Payment.PaymentID, &o.Payment.Transaction, &o.Payment.RequestID,
+			&o.Payment.Currency, &o.Payment.Provider, &o.Payment.Amount, &o.Payment.PaymentDt,
+			&o.Payment.Bank, &o.Payment.DeliveryCost, &o.Payment.GoodsTotal, &o.Payment.CustomFee); err != nil {
+			return nil, fmt.Errorf("scanOrder: %w", err)
+		}
+		if err := r.openDelivery(o.OrderUID, s, &o.Delivery); err != nil {
+			return nil, fmt.Errorf("loadOrders: %w", err)
+		}
+		o.Delivery.OrderUID = o.OrderUID
+		o.Payment.OrderUID = o.OrderUID
+		orders = append(orders, &o)
+	}
 	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("loadOrders: %w", err)
+	}
+
+	if err := r.loadItemsFor(ctx, q, itemsSQL, orders); err != nil {
 		return nil, err
 	}
 	return orders, nil
 }
 
+// loadItemsFor грузит items сразу для всех заказов одним запросом itemsSQL с = ANY($1)
+func (r *DBRepository) loadItemsFor(ctx context.Context, q queryer, itemsSQL string, orders []*model.Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	byID := make(map[string]*model.Order, len(orders))
+	ids := make([]string, 0, len(orders))
+	dates := make([]time.Time, 0, len(orders))
+	for _, o := range orders {
+		byID[o.OrderUID] = o
+		ids = append(ids, o.OrderUID)
+		dates = append(dates, o.DateCreated)
+	}
+
+	rows, err := q.QueryContext(ctx, itemsSQL, pq.Array(ids), pq.Array(dates))
+	if err != nil {
+		return fmt.Errorf("loadItems: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("rows close error:%s", err)
+		}
+	}()
+
+	for rows.Next() {
+		var it model.Item
+		if err := rows.Scan(&it.ItemID, &it.OrderUID, &it.ChrtID, &it.TrackNumber, &it.Price,
+			&it.Rid, &it.Name, &it.Sale, &it.Size, &it.TotalPrice, &it.NmID, &it.Brand, &it.Status); err != nil {
+			return fmt.Errorf("scanItem: %w", err)
+		}
+		if o, ok := byID[it.OrderUID]; ok {
+			o.Items = append(o.Items, it)
+		}
+	}
+	return rows.Err()
+}
+
+//
+// ---------------- PRIVATE (write) ----------------
+//
+
+// exec выполняет запрос транзакции с таймаутом statementTimeout.
+// Если запрос прерван по контексту, в цепочку ошибок добавляется причина (Canceled/DeadlineExceeded)
+func (r *DBRepository) exec(ctx context.Context, tx *sql.Tx, name, query string, args ...any) error {
+	stmtCtx, cancel := context.WithTimeout(ctx, r.statementTimeout)
+	defer cancel()
+
+	if _, err := tx.ExecContext(stmtCtx, query, args...); err != nil {
+		if ctxErr := stmtCtx.Err(); ctxErr != nil {
+			return fmt.Errorf("%s: %w: %w", name, ctxErr, err)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
 //
 // ---------------- PRIVATE (orders) ----------------
 //
 
-func (r *DBRepository) saveOrder(tx *sql.Tx, o *model.Order) error {
-	_, err := tx.Exec(`
+func (r *DBRepository) saveOrder(ctx context.Context, tx *sql.Tx, o *model.Order) error {
+	return r.exec(ctx, tx, "saveOrder", `
 		INSERT INTO orders (
 			order_uid, track_number, entry, locale, internal_signature,
 			customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard
 		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
-		ON CONFLICT (order_uid) DO UPDATE SET
+		ON CONFLICT (order_uid, date_created) DO UPDATE SET
 			track_number = EXCLUDED.track_number,
 			entry = EXCLUDED.entry,
 			locale = EXCLUDED.locale,
@@ -141,67 +509,54 @@ func (r *DBRepository) saveOrder(tx *sql.Tx, o *model.Order) error {
 			delivery_service = EXCLUDED.delivery_service,
 			shardkey = EXCLUDED.shardkey,
 			sm_id = EXCLUDED.sm_id,
-			date_created = EXCLUDED.date_created,
-			oof_shard = EXCLUDED.oof_shard
+			oof_shard = EXCLUDED.oof_shard,
+			updated_at = now()
 	`, o.OrderUID, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature,
 		o.CustomerID, o.DeliveryService, o.Shardkey, o.SmID, o.DateCreated, o.OofShard)
-
-	if err != nil {
-		return fmt.Errorf("saveOrder: %w", err)
-	}
-	return nil
-}
-
-func (r *DBRepository) loadOrder(ctx context.Context, o *model.Order, id string) error {
-	return r.db.QueryRowContext(ctx, `
-		SELECT order_uid, track_number, entry, locale, internal_signature,
-		       customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard
-		FROM orders WHERE order_uid = $1
-	`, id).Scan(&o.OrderUID, &o.TrackNumber, &o.Entry, &o.Locale,
-		&o.InternalSignature, &o.CustomerID, &o.DeliveryService,
-		&o.Shardkey, &o.SmID, &o.DateCreated, &o.OofShard)
 }
 
 //
 // ---------------- PRIVATE (delivery) ----------------
 //
 
-func (r *DBRepository) saveDelivery(tx *sql.Tx, o *model.Order) error {
-	_, err := tx.Exec(`
-		INSERT INTO deliveries (order_uid, name, phone, zip, city, address, region, email)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+// saveDelivery пишет доставку. Имя, телефон, адрес и email при настроенных ключах PII шифруются
+// (sealDelivery), и все колонки перезаписываются: после выключения шифрования строка снова открытая
+func (r *DBRepository) saveDelivery(ctx context.Context, tx *sql.Tx, o *model.Order) error {
+	s, err := r.sealDelivery(o.OrderUID, o.Delivery)
+	if err != nil {
+		return fmt.Errorf("saveDelivery: %w", err)
+	}
+	return r.exec(ctx, tx, "saveDelivery", `
+		INSERT INTO deliveries (order_uid, zip, city, region,
+			name, phone, address, email, name_enc, phone_enc, address_enc, email_enc,
+			pii_key, pii_key_id, name_bidx, phone_bidx, email_bidx)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17)
 		ON CONFLICT ON CONSTRAINT deliveries_order_uid_uniq DO UPDATE SET
-			name = EXCLUDED.name,
-			phone = EXCLUDED.phone,
 			zip = EXCLUDED.zip,
 			city = EXCLUDED.city,
-			address = EXCLUDED.address,
 			region = EXCLUDED.region,
-			email = EXCLUDED.email
-	`, o.OrderUID, o.Delivery.Name, o.Delivery.Phone, o.Delivery.Zip,
-		o.Delivery.City, o.Delivery.Address, o.Delivery.Region, o.Delivery.Email)
-
-	if err != nil {
-		return fmt.Errorf("saveDelivery: %w", err)
-	}
-	return nil
-}
-
-func (r *DBRepository) loadDelivery(ctx context.Context, o *model.Order) error {
-	return r.db.QueryRowContext(ctx, `
-		SELECT delivery_id, order_uid, name, phone, zip, city, address, region, email
-		FROM deliveries WHERE order_uid = $1
-	`, o.OrderUID).Scan(&o.Delivery.DeliveryID, &o.Delivery.OrderUID, &o.Delivery.Name,
-		&o.Delivery.Phone, &o.Delivery.Zip, &o.Delivery.City, &o.Delivery.Address,
-		&o.Delivery.Region, &o.Delivery.Email)
+			name = EXCLUDED.name,
+			phone = EXCLUDED.phone,
+			address = EXCLUDED.address,
+			email = EXCLUDED.email,
+			name_enc = EXCLUDED.name_enc,
+			phone_enc = EXCLUDED.phone_enc,
+			address_enc = EXCLUDED.address_enc,
+			email_enc = EXCLUDED.email_enc,
+			pii_key = EXCLUDED.pii_key,
+			pii_key_id = EXCLUDED.pii_key_id,
+			name_bidx = EXCLUDED.name_bidx,
+			phone_bidx = EXCLUDED.phone_bidx,
+			email_bidx = EXCLUDED.email_bidx
+	`, append([]any{o.OrderUID, o.Delivery.Zip, o.Delivery.City, o.Delivery.Region}, s.args()...)...)
 }
 
 //
 // ---------------- PRIVATE (payment) ----------------
 //
 
-func (r *DBRepository) savePayment(tx *sql.Tx, o *model.Order) error {
-	_, err := tx.Exec(`
+func (r *DBRepository) savePayment(ctx context.Context, tx *sql.Tx, o *model.Order) error {
+	return r.exec(ctx, tx, "savePayment", `
 		INSERT INTO payments (order_uid, transaction, request_id, currency, provider,
 			amount, payment_dt, bank, delivery_cost, goods_total, custom_fee)
 		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
@@ -219,71 +574,84 @@ func (r *DBRepository) savePayment(tx *sql.Tx, o *model.Order) error {
 	`, o.OrderUID, o.Payment.Transaction, o.Payment.RequestID, o.Payment.Currency,
 		o.Payment.Provider, o.Payment.Amount, o.Payment.PaymentDt, o.Payment.Bank,
 		o.Payment.DeliveryCost, o.Payment.GoodsTotal, o.Payment.CustomFee)
-
-	if err != nil {
-		return fmt.Errorf("savePayment: %w", err)
-	}
-	return nil
-}
-
-func (r *DBRepository) loadPayment(ctx context.Context, o *model.Order) error {
-	return r.db.QueryRowContext(ctx, `
-		SELECT payment_id, order_uid, transaction, request_id, currency, provider,
-		       amount, payment_dt, bank, delivery_cost, goods_total, custom_fee
-		FROM payments WHERE order_uid = $1
-	`, o.OrderUID).Scan(&o.Payment.PaymentID, &o.Payment.OrderUID, &o.Payment.Transaction,
-		&o.Payment.RequestID, &o.Payment.Currency, &o.Payment.Provider,
-		&o.Payment.Amount, &o.Payment.PaymentDt, &o.Payment.Bank,
-		&o.Payment.DeliveryCost, &o.Payment.GoodsTotal, &o.Payment.CustomFee)
 }
 
 //
 // ---------------- PRIVATE (items) ----------------
 //
 
-func (r *DBRepository) saveItems(tx *sql.Tx, o *model.Order) error {
-	_, err := tx.Exec(`DELETE FROM items WHERE order_uid = $1`, o.OrderUID)
-	if err != nil {
-		return fmt.Errorf("deleteItems: %w", err)
+// itemColumnsCount число параметров на одну строку items в multi-row INSERT
+const itemColumnsCount = 13
+
+// itemsBatchSize строк в одном INSERT: держит число параметров далеко от лимита Postgres (65535)
+const itemsBatchSize = 1000
+
+// saveItems синхронизирует items заказа по ключу (order_uid, chrt_id) без пересоздания строк:
+// удаляются только позиции, которых больше нет в заказе, остальные вставляются multi-row upsert'ом,
+// который обновляет строку, только если она действительно изменилась.
+// Так item_id сохраняются, а неизмененные позиции не порождают мертвых строк и NOTIFY
+func (r *DBRepository) saveItems(ctx context.Context, tx *sql.Tx, o *model.Order) error {
+	items := o.Items
+
+	chrtIDs := make([]int64, 0, len(items))
+	for _, it := range items {
+		chrtIDs = append(chrtIDs, it.ChrtID)
+	}
+	if err := r.exec(ctx, tx, "deleteItems",
+		`DELETE FROM items WHERE order_uid = $1 AND date_created = $2 AND NOT (chrt_id = ANY($3))`,
+		o.OrderUID, o.DateCreated, pq.Array(chrtIDs)); err != nil {
+		return err
 	}
 
-	for _, it := range o.Items {
-		_, err = tx.Exec(`
-			INSERT INTO items (order_uid, chrt_id, track_number, price, rid, name,
-				sale, size, total_price, nm_id, brand, status)
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
-		`, o.OrderUID, it.ChrtID, it.TrackNumber, it.Price, it.Rid, it.Name,
-			it.Sale, it.Size, it.TotalPrice, it.NmID, it.Brand, it.Status)
-		if err != nil {
-			return fmt.Errorf("insertItem: %w", err)
+	for start := 0; start < len(items); start += itemsBatchSize {
+		batch := items[start:min(start+itemsBatchSize, len(items))]
+		query, args := upsertItemsQuery(o.OrderUID, o.DateCreated, batch)
+		if err := r.exec(ctx, tx, "upsertItems", query, args...); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (r *DBRepository) loadItems(ctx context.Context, o *model.Order) error {
-	rows, err := r.db.QueryContext(ctx, `
-		SELECT item_id, order_uid, chrt_id, track_number, price, rid, name,
-		       sale, size, total_price, nm_id, brand, status
-		FROM items WHERE order_uid = $1
-	`, o.OrderUID)
-	if err != nil {
-		return fmt.Errorf("loadItems: %w", err)
-	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			log.Printf("rows close error:%s", err)
-		}
-	}()
+// upsertItemsQuery собирает INSERT ... VALUES (...),(...) ON CONFLICT для батча items
+func upsertItemsQuery(orderUID string, dateCreated time.Time, items []model.Item) (string, []any) {
+	var sb strings.Builder
+	args := make([]any, 0, len(items)*itemColumnsCount)
 
-	for rows.Next() {
-		var it model.Item
-		if err := rows.Scan(&it.ItemID, &it.OrderUID, &it.ChrtID, &it.TrackNumber, &it.Price,
-			&it.Rid, &it.Name, &it.Sale, &it.Size, &it.TotalPrice, &it.NmID, &it.Brand, &it.Status); err != nil {
-			return fmt.Errorf("scanItem: %w", err)
+	sb.WriteString(`INSERT INTO items (order_uid, chrt_id, track_number, price, rid, name,
+		sale, size, total_price, nm_id, brand, status, date_created) VALUES `)
+	for i, it := range items {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(")
+		for c := 0; c < itemColumnsCount; c++ {
+			if c > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString("$")
+			sb.WriteString(strconv.Itoa(i*itemColumnsCount + c + 1))
 		}
-		o.Items = append(o.Items, it)
+		sb.WriteString(")")
+		args = append(args, orderUID, it.ChrtID, it.TrackNumber, it.Price, it.Rid, it.Name,
+			it.Sale, it.Size, it.TotalPrice, it.NmID, it.Brand, it.Status, dateCreated)
 	}
-	return nil
+	sb.WriteString(`
+		ON CONFLICT (order_uid, chrt_id, date_created) DO UPDATE SET
+			track_number = EXCLUDED.track_number,
+			price = EXCLUDED.price,
+			rid = EXCLUDED.rid,
+			name = EXCLUDED.name,
+			sale = EXCLUDED.sale,
+			size = EXCLUDED.size,
+			total_price = EXCLUDED.total_price,
+			nm_id = EXCLUDED.nm_id,
+			brand = EXCLUDED.brand,
+			status = EXCLUDED.status
+		WHERE (items.track_number, items.price, items.rid, items.name, items.sale, items.size,
+			items.total_price, items.nm_id, items.brand, items.status)
+			IS DISTINCT FROM
+			(EXCLUDED.track_number, EXCLUDED.price, EXCLUDED.rid, EXCLUDED.name, EXCLUDED.sale, EXCLUDED.size,
+			EXCLUDED.total_price, EXCLUDED.nm_id, EXCLUDED.brand, EXCLUDED.status)`)
+	return sb.String(), args
 }
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/lib/pq"
+)
+
+// RetentionStats сколько данных попадает под retention. Отчет dry-run
+type RetentionStats struct {
+	Orders int64 `json:"orders"`
+	// Deleted из них мягко удаленных
+	Deleted int64 `json:"deleted"`
+	Items   int64 `json:"items"`
+	// Oldest, Newest диапазон date_created. Нулевые, если заказов нет
+	Oldest time.Time `json:"oldest"`
+	Newest time.Time `json:"newest"`
+}
+
+// IRetentionRepository операции retention-задачи. Реализуется не всеми хранилищами
+type IRetentionRepository interface {
+	// ExpiredStats статистика заказов с date_created < before
+	ExpiredStats(ctx context.Context, before time.Time) (RetentionStats, error)
+	// ExpiredOrders до limit самых старых заказов с date_created < before, включая мягко удаленные
+	ExpiredOrders(ctx context.Context, before time.Time, limit int) ([]*model.Order, error)
+	// ArchiveOrders переносит заказы в *_archive таблицы и удаляет их из основных одной транзакцией
+	ArchiveOrders(ctx context.Context, ids []string) (int, error)
+	// PurgeOrders удаляет заказы из основных таблиц одной транзакцией
+	PurgeOrders(ctx context.Context, ids []string) (int, error)
+}
+
+// ExpiredStats статистика заказов с date_created < before одним запросом
+func (r *DBRepository) ExpiredStats(ctx context.Context, before time.Time) (RetentionStats, error) {
+	var (
+		st             RetentionStats
+		oldest, newest sql.NullTime
+	)
+	err := r.db.QueryRowContext(ctx, `
+		SELECT count(*), count(*) FILTER (WHERE o.deleted_at IS NOT NULL),
+		       (SELECT count(*) FROM items i WHERE i.date_created < $1),
+		       min(o.date_created), max(o.date_created)
+		FROM orders o WHERE o.date_created < $1
+	`, before).Scan(&st.Orders, &st.Deleted, &st.Items, &oldest, &newest)
+	if err != nil {
+		return RetentionStats{}, fmt.Errorf("expired stats: %w", err)
+	}
+	st.Oldest, st.Newest = oldest.Time, newest.Time
+	return st, nil
+}
+
+// ExpiredOrders до limit самых старых заказов с date_created < before, включая мягко удаленные.
+// Обработанные заказы удаляются, поэтому следующий вызов с теми же аргументами вернет следующую порцию
+func (r *DBRepository) ExpiredOrders(ctx context.Context, before time.Time, limit int) ([]*model.Order, error) {
+	if limit <= 0 {
+		return []*model.Order{}, nil
+	}
+	orders, err := r.loadOrders(ctx, r.db, `WHERE o.date_created < $1
+		ORDER BY o.date_created, o.order_uid LIMIT $2`, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("expired orders: %w", err)
+	}
+	return orders, nil
+}
+
+// archiveSQL копирует строки заказов $1 в архив. Списки колонок явные:
+// новая колонка в основной таблице должна добавляться и в архивную
+var archiveSQL = []struct{ name, query string }{
+	{"archiveOrders", `
+		INSERT INTO orders_archive (order_uid, track_number, entry, locale, internal_signature,
+			customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, updated_at, deleted_at)
+		SELECT order_uid, track_number, entry, locale, internal_signature,
+			customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard, updated_at, deleted_at
+		FROM orders WHERE order_uid = ANY($1)
+	`},
+	{"archiveDeliveries", `
+		INSERT INTO deliveries_archive (delivery_id, order_uid, name, phone, zip, city, address, region, email,
+			name_enc, phone_enc, address_enc, email_enc, pii_key, pii_key_id, name_bidx, phone_bidx, email_bidx)
+		SELECT delivery_id, order_uid, name, phone, zip, city, address, region, email,
+			name_enc, phone_enc, address_enc, email_enc, pii_key, pii_key_id, name_bidx, phone_bidx, email_bidx
+		FROM deliveries WHERE order_uid = ANY($1)
+	`},
+	{"archivePayments", `
+		INSERT INTO payments_archive (payment_id, order_uid, transaction, request_id, currency, provider,
+			amount, payment_dt, bank, delivery_cost, goods_total, custom_fee)
+		SELECT payment_id, order_uid, transaction, request_id, currency, provider,
+			amount, payment_dt, bank, delivery_cost, goods_total, custom_fee
+		FROM payments WHERE order_uid = ANY($1)
+	`},
+	{"archiveItems", `
+		INSERT INTO items_archive (item_id, order_uid, chrt_id, track_number, price, rid, name,
+			sale, size, total_price, nm_id, brand, status, date_created)
+		SELECT item_id, order_uid, chrt_id, track_number, price, rid, name,
+			sale, size, total_price, nm_id, brand, status, date_created
+		FROM items WHERE order_uid = ANY($1)
+	`},
+}
+
+// ArchiveOrders копирует заказы в *_archive и удаляет их из основных таблиц одной транзакцией.
+// Возвращает число удаленных заказов
+func (r *DBRepository) ArchiveOrders(ctx context.Context, ids []string) (int, error) {
+	return r.removeOrders(ctx, ids, true)
+}
+
+// PurgeOrders удаляет заказы из основных таблиц одной транзакцией. Возвращает число удаленных заказов
+func (r *DBRepository) PurgeOrders(ctx context.Context, ids []string) (int, error) {
+	return r.removeOrders(ctx, ids, false)
+}
+
+// removeOrders удаляет заказы ids вместе с зависимыми строками, при archive - сначала копирует их в архив.
+// История заказов (order_history) не удаляется: это журнал аудита. Исходные сообщения удаляются без архива
+func (r *DBRepository) removeOrders(ctx context.Context, ids []string, archive bool) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Printf("Rollback error:%s", err.Error())
+		}
+	}()
+
+	if err := r.exec(ctx, tx, "setStatementTimeout",
+		fmt.Sprintf(`SET LOCAL statement_timeout = %d`, r.statementTimeout.Milliseconds())); err != nil {
+		return 0, err
+	}
+	arg := pq.Array(ids)
+	if archive {
+		for _, st := range archiveSQL {
+			if err := r.exec(ctx, tx, st.name, st.query, arg); err != nil {
+				return 0, err
+			}
+		}
+	}
+	for _, table := range []string{"items", "deliveries", "payments", "order_raw_messages"} {
+		if err := r.exec(ctx, tx, "purge "+table,
+			`DELETE FROM `+table+` WHERE order_uid = ANY($1)`, arg); err != nil {
+			return 0, err
+		}
+	}
+
+	stmtCtx, cancel := context.WithTimeout(ctx, r.statementTimeout)
+	defer cancel()
+	res, err := tx.ExecContext(stmtCtx, `DELETE FROM orders WHERE order_uid = ANY($1)`, arg)
+	if err != nil {
+		return 0, fmt.Errorf("purge orders: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("purge orders: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gogazub/myapp/internal/model"
+)
+
+// ISearchRepository поиск заказов по данным доставки и позиций. Реализуется не всеми хранилищами,
+// сервис проверяет его через type assertion
+type ISearchRepository interface {
+	Search(ctx context.Context, q model.SearchQuery) (model.SearchPage, error)
+}
+
+// searchSQL ищет заказы полнотекстово и по триграммам. Условия WHERE повторяют выражения индексов
+// миграции 000012, иначе планировщик их не использует. $1 - запрос, $2 - ILIKE-шаблон подстроки,
+// $5-$7 - blind index запроса для зашифрованных имени, телефона и email (NULL без ключей PII).
+// Оценка поля: word_similarity (подстроки и опечатки) плюс ts_rank (совпадение целых слов),
+// точное совпадение по blind index - 2. Для таких совпадений value NULL, а enc - шифротекст поля
+const searchSQL = `
+	WITH hits AS (
+		SELECT d.order_uid, f.field, f.value, NULL::bytea AS enc, NULL::bytea AS pii_key, NULL::varchar AS pii_key_id,
+		       false AS exact
+		FROM deliveries d
+		CROSS JOIN LATERAL (VALUES ('delivery.name', d.name), ('delivery.phone', d.phone),
+		                           ('delivery.email', d.email), ('delivery.city', d.city)) AS f(field, value)
+		WHERE (to_tsvector('simple', coalesce(d.name, '') || ' ' || coalesce(d.email, '') || ' ' || coalesce(d.city, ''))
+		           @@ plainto_tsquery('simple', $1)
+		       OR d.name ILIKE $2 OR d.phone ILIKE $2 OR d.email ILIKE $2 OR d.city ILIKE $2
+		       OR $1 <% d.name OR $1 <% d.email OR $1 <% d.city)
+		  AND (f.value ILIKE $2 OR $1 <% f.value OR to_tsvector('simple', f.value) @@ plainto_tsquery('simple', $1))
+		UNION
+		SELECT d.order_uid, f.field, NULL, f.enc, d.pii_key, d.pii_key_id, true
+		FROM deliveries d
+		CROSS JOIN LATERAL (VALUES ('delivery.name', d.name_bidx = $5, d.name_enc),
+		                           ('delivery.phone', d.phone_bidx = $6, d.phone_enc),
+		                           ('delivery.email', d.email_bidx = $7, d.email_enc)) AS f(field, hit, enc)
+		WHERE (d.name_bidx = $5 OR d.phone_bidx = $6 OR d.email_bidx = $7) AND f.hit
+		UNION
+		SELECT i.order_uid, f.field, f.value, NULL, NULL, NULL, false
+		FROM items i
+		CROSS JOIN LATERAL (VALUES ('item.name', i.name), ('item.brand', i.brand)) AS f(field, value)
+		WHERE (to_tsvector('simple', coalesce(i.name, '') || ' ' || coalesce(i.brand, '')) @@ plainto_tsquery('simple', $1)
+		       OR i.name ILIKE $2 OR i.brand ILIKE $2
+		       OR $1 <% i.name OR $1 <% i.brand)
+		  AND (f.value ILIKE $2 OR $1 <% f.value OR to_tsvector('simple', f.value) @@ plainto_tsquery('simple', $1))
+	),
+	scored AS (
+		SELECT order_uid, field, value, enc, pii_key, pii_key_id,
+		       CASE WHEN exact THEN 2
+		            ELSE word_similarity($1, value) + ts_rank(to_tsvector('simple', value), plainto_tsquery('simple', $1))
+		       END AS score
+		FROM hits
+	),
+	ranked AS (
+		SELECT s.order_uid, o.date_created, max(s.score) AS rank
+		FROM scored s
+		JOIN orders o ON o.order_uid = s.order_uid AND o.deleted_at IS NULL
+		GROUP BY s.order_uid, o.date_created
+		ORDER BY rank DESC, o.date_created DESC, s.order_uid DESC
+		LIMIT $3 OFFSET $4
+	)
+	SELECT r.order_uid, r.date_created, r.rank, s.field, s.value, s.score, s.enc, s.pii_key, s.pii_key_id
+	FROM ranked r
+	JOIN scored s ON s.order_uid = r.order_uid
+	ORDER BY r.rank DESC, r.date_created DESC, r.order_uid DESC, s.score DESC, s.field, s.value
+`
+
+// Search ищет заказы по имени, телефону, email и городу доставки, названию и бренду позиций.
+// Заказы упорядочены по лучшей оценке совпавшего поля, для каждого возвращаются совпавшие поля с подсветкой.
+// Limit <= 0 - DefaultSearchLimit, выдача ограничена MaxSearchWindow. Удаленные заказы не ищутся
+func (r *DBRepository) Search(ctx context.Context, q model.SearchQuery) (model.SearchPage, error) {
+	text, limit, ok := searchWindow(q)
+	if !ok {
+		return model.SearchPage{Hits: []model.SearchHit{}}, nil
+	}
+
+	var nameIdx, phoneIdx, emailIdx []byte
+	if r.pii != nil {
+		nameIdx, phoneIdx, emailIdx = r.pii.BlindIndex("name", text), r.pii.BlindIndex("phone", text),
+			r.pii.BlindIndex("email", text)
+	}
+	// Одна лишняя строка показывает, есть ли следующая страница
+	rows, err := r.reader().QueryContext(ctx, searchSQL, text, "%"+escapeLike(text)+"%", limit+1, q.Offset,
+		nullBytes(nameIdx), nullBytes(phoneIdx), nullBytes(emailIdx))
+	if err != nil {
+		return model.SearchPage{}, fmt.Errorf("search orders: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("rows close error:%s", err)
+		}
+	}()
+
+	hits := make([]model.SearchHit, 0, limit+1)
+	for rows.Next() {
+		var (
+			hit      model.SearchHit
+			m        model.SearchMatch
+			value    sql.NullString
+			enc, key []byte
+			keyID    sql.NullString
+		)
+		if err := rows.Scan(&hit.OrderUID, &hit.DateCreated, &hit.Rank, &m.Field, &value, &m.Score,
+			&enc, &key, &keyID); err != nil {
+			return model.SearchPage{}, fmt.Errorf("scanSearch: %w", err)
+		}
+		if value.Valid {
+			m.Value, m.Highlight = value.String, model.Highlight(value.String, text)
+		} else {
+			// Точное совпадение по blind index: значение зашифровано и совпало целиком
+			field := strings.TrimPrefix(m.Field, "delivery.")
+			if m.Value, err = r.openField(hit.OrderUID, field, keyID.String, key, enc); err != nil {
+				return model.SearchPage{}, fmt.Errorf("search orders: %w", err)
+			}
+			m.Highlight = "<mark>" + html.EscapeString(m.Value) + "</mark>"
+		}
+		if n := len(hits); n > 0 && hits[n-1].OrderUID == hit.OrderUID {
+			hits[n-1].Matches = append(hits[n-1].Matches, m)
+			continue
+		}
+		hit.Matches = []model.SearchMatch{m}
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return model.SearchPage{}, fmt.Errorf("search orders: %w", err)
+	}
+	return searchPage(hits, q.Offset, limit), nil
+}
+
+// searchWindow нормализует запрос: обрезанный текст и limit с учетом MaxSearchWindow.
+// false - искать нечего: запрос короче MinSearchLen или offset за пределами выдачи
+func searchWindow(q model.SearchQuery) (string, int, bool) {
+	text := strings.TrimSpace(q.Text)
+	if utf8.RuneCountInString(text) < model.MinSearchLen || q.Offset < 0 || q.Offset >= model.MaxSearchWindow {
+		return "", 0, false
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = model.DefaultSearchLimit
+	}
+	return text, min(limit, model.MaxSearchWindow-q.Offset), true
+}
+
+// searchPage страница из limit+1 найденных заказов: лишний заказ означает, что есть следующая страница
+func searchPage(hits []model.SearchHit, offset, limit int) model.SearchPage {
+	page := model.SearchPage{Hits: hits}
+	if len(hits) > limit {
+		page.Hits = hits[:limit]
+		if offset+limit < model.MaxSearchWindow {
+			page.NextOffset = offset + limit
+		}
+	}
+	return page
+}
+
+// escapeLike экранирует спецсимволы LIKE, чтобы запрос искался как подстрока
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
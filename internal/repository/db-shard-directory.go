@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// IShardDirectory каталог order_uid -> имя шарда
+type IShardDirectory interface {
+	// Lookup шарды заказов ids. Заказов, которых нет в каталоге, нет и в результате
+	Lookup(ctx context.Context, ids []string) (map[string]string, error)
+	// Assign записывает шард заказа, если записи еще нет. Возвращает шард из каталога
+	Assign(ctx context.Context, id, shard string) (string, error)
+	// Remove удаляет записи заказов ids
+	Remove(ctx context.Context, ids []string) error
+}
+
+// DBShardDirectory каталог в таблице order_shards (миграция 000009)
+type DBShardDirectory struct {
+	db *sql.DB
+}
+
+// NewShardDirectory конструктор каталога поверх подключения к БД, где лежит order_shards
+func NewShardDirectory(db *sql.DB) *DBShardDirectory {
+	return &DBShardDirectory{db: db}
+}
+
+// Lookup шарды заказов ids одним запросом
+func (d *DBShardDirectory) Lookup(ctx context.Context, ids []string) (map[string]string, error) {
+	out := make(map[string]string, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+	rows, err := d.db.QueryContext(ctx,
+		`SELECT order_uid, shard FROM order_shards WHERE order_uid = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("shard lookup: %w", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("rows close error:%s", err.Error())
+		}
+	}()
+	for rows.Next() {
+		var id, shard string
+		if err := rows.Scan(&id, &shard); err != nil {
+			return nil, fmt.Errorf("shard lookup: %w", err)
+		}
+		out[id] = shard
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("shard lookup: %w", err)
+	}
+	return out, nil
+}
+
+// Assign вставляет запись или возвращает существующую. Пустой DO UPDATE нужен,
+// чтобы RETURNING вернул строку и при конфликте
+func (d *DBShardDirectory) Assign(ctx context.Context, id, shard string) (string, error) {
+	var got string
+	err := d.db.QueryRowContext(ctx, `
+		INSERT INTO order_shards (order_uid, shard) VALUES ($1, $2)
+		ON CONFLICT (order_uid) DO UPDATE SET order_uid = EXCLUDED.order_uid
+		RETURNING shard
+	`, id, shard).Scan(&got)
+	if err != nil {
+		return "", fmt.Errorf("shard assign: %w", err)
+	}
+	return got, nil
+}
+
+// Remove удаляет записи заказов ids
+func (d *DBShardDirectory) Remove(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if _, err := d.db.ExecContext(ctx,
+		`DELETE FROM order_shards WHERE order_uid = ANY($1)`, pq.Array(ids)); err != nil {
+		return fmt.Errorf("shard remove: %w", err)
+	}
+	return nil
+}
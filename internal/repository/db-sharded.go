@@ -0,0 +1,596 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gogazub/myapp/internal/model"
+)
+
+// ErrShardChanged заказ уже записан на другой шард. Shardkey сохраненного заказа менять нельзя
+var ErrShardChanged = errors.New("order shard changed")
+
+// Shard именованный шард
+type Shard struct {
+	Name string
+	Repo IDBRepository
+}
+
+// ShardedConfig настройки шардированного репозитория
+type ShardedConfig struct {
+	// Shards шарды. Порядок важен: Shardkey вне KeyMap распределяются хешем по индексу шарда
+	Shards []Shard
+	// KeyMap Shardkey -> имя шарда
+	KeyMap map[string]string
+	// Directory каталог order_uid -> шард. nil - поиск по order_uid опрашивает все шарды
+	Directory IShardDirectory
+}
+
+// ShardedRepository IDBRepository поверх N шардов. Запись идет на шард по Shardkey заказа,
+// поиск по order_uid - через каталог, а если заказа в каталоге нет, на все шарды параллельно.
+// Постраничный обход сливает страницы шардов в общем порядке
+type ShardedRepository struct {
+	shards []Shard
+	byName map[string]IDBRepository
+	keyMap map[string]string
+	dir    IShardDirectory
+}
+
+// NewShardedRepository конструктор. Проверяет, что шарды есть, имена уникальны и KeyMap ссылается на них
+func NewShardedRepository(cfg ShardedConfig) (*ShardedRepository, error) {
+	if len(cfg.Shards) == 0 {
+		return nil, fmt.Errorf("sharded repository: no shards")
+	}
+	byName := make(map[string]IDBRepository, len(cfg.Shards))
+	for _, s := range cfg.Shards {
+		if s.Name == "" || s.Repo == nil {
+			return nil, fmt.Errorf("sharded repository: shard must have name and repo")
+		}
+		if _, ok := byName[s.Name]; ok {
+			return nil, fmt.Errorf("sharded repository: duplicate shard %q", s.Name)
+		}
+		byName[s.Name] = s.Repo
+	}
+	for key, name := range cfg.KeyMap {
+		if _, ok := byName[name]; !ok {
+			return nil, fmt.Errorf("sharded repository: shardkey %q maps to unknown shard %q", key, name)
+		}
+	}
+	return &ShardedRepository{
+		shards: slices.Clone(cfg.Shards),
+		byName: byName,
+		keyMap: cfg.KeyMap,
+		dir:    cfg.Directory,
+	}, nil
+}
+
+// ShardFor имя шарда для Shardkey: из KeyMap, иначе по fnv-хешу ключа
+func (r *ShardedRepository) ShardFor(shardkey string) string {
+	if name, ok := r.keyMap[shardkey]; ok {
+		return name
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(shardkey))
+	return r.shards[h.Sum32()%uint32(len(r.shards))].Name
+}
+
+// Shards шарды в порядке конфигурации
+func (r *ShardedRepository) Shards() []Shard {
+	return slices.Clone(r.shards)
+}
+
+// Save сохраняет заказ на шард его Shardkey. С каталогом заказ сначала регистрируется в нем:
+// если заказ уже записан на другой шард - ErrShardChanged
+func (r *ShardedRepository) Save(ctx context.Context, order *model.Order) error {
+	name := r.ShardFor(order.Shardkey)
+	if r.dir != nil {
+		got, err := r.dir.Assign(ctx, order.OrderUID, name)
+		if err != nil {
+			return fmt.Errorf("shard directory assign: %w", err)
+		}
+		if got != name {
+			return fmt.Errorf("%w: %s is on %s, shardkey %q routes to %s",
+				ErrShardChanged, order.OrderUID, got, order.Shardkey, name)
+		}
+	}
+	return r.byName[name].Save(ctx, order)
+}
+
+// GetByID ищет заказ на шарде из каталога, без записи в каталоге - на всех шардах
+func (r *ShardedRepository) GetByID(ctx context.Context, id string) (*model.Order, error) {
+	shards, err := r.locate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]*model.Order, len(shards))
+	errs := scatter(ctx, shards, func(ctx context.Context, i int, s Shard) error {
+		o, err := s.Repo.GetByID(ctx, id)
+		found[i] = o
+		return err
+	})
+	for _, o := range found {
+		if o != nil {
+			return o, nil
+		}
+	}
+	return nil, shardsError(errs, id)
+}
+
+// GetByIDs группирует ids по шардам каталога; id без записи в каталоге ищутся на всех шардах.
+// Отсутствующие id пропускаются, порядок результата не определен
+func (r *ShardedRepository) GetByIDs(ctx context.Context, ids []string) ([]*model.Order, error) {
+	if len(ids) == 0 {
+		return []*model.Order{}, nil
+	}
+	known := map[string]string{}
+	if r.dir != nil {
+		var err error
+		if known, err = r.dir.Lookup(ctx, ids); err != nil {
+			return nil, fmt.Errorf("shard directory lookup: %w", err)
+		}
+	}
+	perShard := make([][]string, len(r.shards))
+	for _, id := range ids {
+		name, ok := known[id]
+		for i, s := range r.shards {
+			if !ok || s.Name == name {
+				perShard[i] = append(perShard[i], id)
+			}
+		}
+	}
+
+	found := make([][]*model.Order, len(r.shards))
+	errs := scatter(ctx, r.shards, func(ctx context.Context, i int, s Shard) error {
+		if len(perShard[i]) == 0 {
+			return nil
+		}
+		orders, err := s.Repo.GetByIDs(ctx, perShard[i])
+		found[i] = orders
+		return err
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("get orders by ids: %w", err)
+	}
+
+	seen := make(map[string]bool, len(ids))
+	out := make([]*model.Order, 0, len(ids))
+	for _, orders := range found {
+		for _, o := range orders {
+			if !seen[o.OrderUID] {
+				seen[o.OrderUID] = true
+				out = append(out, o)
+			}
+		}
+	}
+	return out, nil
+}
+
+// Delete мягко удаляет заказ на его шарде. Без записи в каталоге удаление пробуется на всех шардах
+func (r *ShardedRepository) Delete(ctx context.Context, id string) error {
+	shards, err := r.locate(ctx, id)
+	if err != nil {
+		return err
+	}
+	errs := scatter(ctx, shards, func(ctx context.Context, _ int, s Shard) error {
+		return s.Repo.Delete(ctx, id)
+	})
+	if slices.Contains(errs, nil) {
+		return nil
+	}
+	return shardsError(errs, id)
+}
+
+// ListPage берет страницу после after с каждого шарда и сливает их в общем порядке
+// date_created DESC, order_uid DESC. Курсор общий для всех шардов, поэтому keyset-пагинация сохраняется
+func (r *ShardedRepository) ListPage(ctx context.Context, after Cursor, limit int) ([]*model.Order, error) {
+	if limit <= 0 {
+		return []*model.Order{}, nil
+	}
+	pages := make([][]*model.Order, len(r.shards))
+	errs := scatter(ctx, r.shards, func(ctx context.Context, i int, s Shard) error {
+		page, err := s.Repo.ListPage(ctx, after, limit)
+		pages[i] = page
+		return err
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("list orders page: %w", err)
+	}
+
+	merged := slices.Concat(pages...)
+	slices.SortFunc(merged, func(a, b *model.Order) int {
+		if c := b.DateCreated.Compare(a.DateCreated); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.OrderUID, a.OrderUID)
+	})
+	if len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged, nil
+}
+
+// Watermark минимальный watermark шардов: изменения после него не пропустит ни один шард.
+// Расхождение часов шардов должно быть меньше запаса их Watermark
+func (r *ShardedRepository) Watermark(ctx context.Context) (time.Time, error) {
+	marks := make([]time.Time, len(r.shards))
+	errs := scatter(ctx, r.shards, func(ctx context.Context, i int, s Shard) error {
+		wm, err := s.Repo.Watermark(ctx)
+		marks[i] = wm
+		return err
+	})
+	if err := errors.Join(errs...); err != nil {
+		return time.Time{}, fmt.Errorf("watermark: %w", err)
+	}
+	wm := marks[0]
+	for _, m := range marks[1:] {
+		if m.Before(wm) {
+			wm = m
+		}
+	}
+	return wm, nil
+}
+
+// ChangedSince объединение ChangedSince всех шардов
+func (r *ShardedRepository) ChangedSince(ctx context.Context, since time.Time) ([]string, error) {
+	changed := make([][]string, len(r.shards))
+	errs := scatter(ctx, r.shards, func(ctx context.Context, i int, s Shard) error {
+		ids, err := s.Repo.ChangedSince(ctx, since)
+		changed[i] = ids
+		return err
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("changed since: %w", err)
+	}
+	return slices.Concat(changed...), nil
+}
+
+// History журнал изменений заказа с его шарда. Шарды должны реализовывать IHistoryRepository
+func (r *ShardedRepository) History(ctx context.Context, id string, limit int) ([]model.HistoryEntry, error) {
+	shards, err := r.locate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	found := make([][]model.HistoryEntry, len(shards))
+	errs := scatter(ctx, shards, func(ctx context.Context, i int, s Shard) error {
+		h, ok := s.Repo.(IHistoryRepository)
+		if !ok {
+			return fmt.Errorf("shard %s: history not supported", s.Name)
+		}
+		entries, err := h.History(ctx, id, limit)
+		found[i] = entries
+		return err
+	})
+	for i, entries := range found {
+		if errs[i] == nil {
+			return entries, nil
+		}
+	}
+	return nil, shardsError(errs, id)
+}
+
+// SaveRawMessage сохраняет исходное сообщение на шард заказа: из каталога, а для нового заказа
+// или без каталога - на шард Shardkey из тела сообщения, куда затем пойдет Save
+func (r *ShardedRepository) SaveRawMessage(ctx context.Context, msg model.RawMessage) error {
+	name := r.ShardFor(msg.Shardkey)
+	if r.dir != nil && msg.OrderUID != "" {
+		known, err := r.dir.Lookup(ctx, []string{msg.OrderUID})
+		if err != nil {
+			return fmt.Errorf("shard directory lookup: %w", err)
+		}
+		if got, ok := known[msg.OrderUID]; ok {
+			name = got
+		}
+	}
+	raw, ok := r.byName[name].(IRawMessageRepository)
+	if !ok {
+		return fmt.Errorf("shard %s: raw messages not supported", name)
+	}
+	return raw.SaveRawMessage(ctx, msg)
+}
+
+// RawMessages исходные сообщения заказа с его шарда. Шарды должны реализовывать IRawMessageRepository
+func (r *ShardedRepository) RawMessages(ctx context.Context, id string, limit int) ([]model.RawMessage, error) {
+	shards, err := r.locate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	found := make([][]model.RawMessage, len(shards))
+	errs := scatter(ctx, shards, func(ctx context.Context, i int, s Shard) error {
+		raw, ok := s.Repo.(IRawMessageRepository)
+		if !ok {
+			return fmt.Errorf("shard %s: raw messages not supported", s.Name)
+		}
+		msgs, err := raw.RawMessages(ctx, id, limit)
+		found[i] = msgs
+		return err
+	})
+	for i, msgs := range found {
+		if errs[i] == nil {
+			return msgs, nil
+		}
+	}
+	return nil, shardsError(errs, id)
+}
+
+// Search ищет на всех шардах первые Offset+Limit заказов и сливает их по оценке, затем отрезает страницу.
+// Шарды должны реализовывать ISearchRepository
+func (r *ShardedRepository) Search(ctx context.Context, q model.SearchQuery) (model.SearchPage, error) {
+	text, limit, ok := searchWindow(q)
+	if !ok {
+		return model.SearchPage{Hits: []model.SearchHit{}}, nil
+	}
+	pages := make([][]model.SearchHit, len(r.shards))
+	errs := scatter(ctx, r.shards, func(ctx context.Context, i int, s Shard) error {
+		sr, ok := s.Repo.(ISearchRepository)
+		if !ok {
+			return errors.New("search not supported")
+		}
+		page, err := sr.Search(ctx, model.SearchQuery{Text: text, Limit: q.Offset + limit + 1})
+		pages[i] = page.Hits
+		return err
+	})
+	if err := errors.Join(errs...); err != nil {
+		return model.SearchPage{}, fmt.Errorf("search orders: %w", err)
+	}
+
+	merged := slices.Concat(pages...)
+	slices.SortFunc(merged, model.CompareSearchHits)
+	if len(merged) <= q.Offset {
+		return model.SearchPage{Hits: []model.SearchHit{}}, nil
+	}
+	return searchPage(merged[q.Offset:], q.Offset, limit), nil
+}
+
+// ExportCustomer выгрузки всех шардов одним набором: заказы покупателя распределены по Shardkey.
+// Каждый шард пишет свою запись аудита. Шарды должны реализовывать IPrivacyRepository
+func (r *ShardedRepository) ExportCustomer(ctx context.Context, customerID string) (model.CustomerExport, error) {
+	parts := make([]model.CustomerExport, len(r.shards))
+	errs := scatter(ctx, r.shards, func(ctx context.Context, i int, s Shard) error {
+		p, ok := s.Repo.(IPrivacyRepository)
+		if !ok {
+			return errors.New("privacy requests not supported")
+		}
+		var err error
+		parts[i], err = p.ExportCustomer(ctx, customerID)
+		return err
+	})
+	if err := errors.Join(errs...); err != nil {
+		return model.CustomerExport{}, fmt.Errorf("export customer: %w", err)
+	}
+
+	out := model.CustomerExport{CustomerID: customerID, ExportedAt: time.Now().UTC(), Orders: []model.ExportedOrder{},
+		History: []model.HistoryEntry{}, RawMessages: []model.RawMessage{}}
+	for _, p := range parts {
+		out.Orders = append(out.Orders, p.Orders...)
+		out.History = append(out.History, p.History...)
+		out.RawMessages = append(out.RawMessages, p.RawMessages...)
+	}
+	slices.SortStableFunc(out.Orders, func(a, b model.ExportedOrder) int {
+		if c := a.DateCreated.Compare(b.DateCreated); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.OrderUID, b.OrderUID)
+	})
+	slices.SortStableFunc(out.History, func(a, b model.HistoryEntry) int {
+		return a.ChangedAt.Compare(b.ChangedAt)
+	})
+	slices.SortStableFunc(out.RawMessages, func(a, b model.RawMessage) int {
+		return a.ReceivedAt.Compare(b.ReceivedAt)
+	})
+	return out, nil
+}
+
+// EraseCustomer анонимизирует заказы покупателя на всех шардах. Транзакции шардов независимы:
+// при ошибке части шардов запрос нужно повторить, уже анонимизированное повтор не ломает
+func (r *ShardedRepository) EraseCustomer(ctx context.Context, customerID string) (model.CustomerErasure, error) {
+	erased := make([][]string, len(r.shards))
+	errs := scatter(ctx, r.shards, func(ctx context.Context, i int, s Shard) error {
+		p, ok := s.Repo.(IPrivacyRepository)
+		if !ok {
+			return errors.New("privacy requests not supported")
+		}
+		res, err := p.EraseCustomer(ctx, customerID)
+		erased[i] = res.Orders
+		return err
+	})
+	out := model.CustomerErasure{CustomerID: customerID, ErasedAt: time.Now().UTC(),
+		Orders: slices.Sorted(slices.Values(slices.Concat(erased...)))}
+	if out.Orders == nil {
+		out.Orders = []string{}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return out, fmt.Errorf("erase customer: %w", err)
+	}
+	return out, nil
+}
+
+// locate шарды, на которых может быть заказ id: один из каталога или все
+func (r *ShardedRepository) locate(ctx context.Context, id string) ([]Shard, error) {
+	if r.dir == nil {
+		return r.shards, nil
+	}
+	known, err := r.dir.Lookup(ctx, []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("shard directory lookup: %w", err)
+	}
+	name, ok := known[id]
+	if !ok {
+		return r.shards, nil
+	}
+	repo, ok := r.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("shard directory: order %s is on unknown shard %q", id, name)
+	}
+	return []Shard{{Name: name, Repo: repo}}, nil
+}
+
+// scatter выполняет fn на шардах параллельно. Ошибки возвращаются по индексам шардов
+func scatter(ctx context.Context, shards []Shard, fn func(ctx context.Context, i int, s Shard) error) []error {
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, s := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(ctx, i, s); err != nil {
+				errs[i] = fmt.Errorf("shard %s: %w", s.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// shardsError объединяет ошибки шардов, кроме ErrOrderNotFound. Если других ошибок нет - ErrOrderNotFound
+func shardsError(errs []error, id string) error {
+	var other []error
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, ErrOrderNotFound) {
+			other = append(other, err)
+		}
+	}
+	if len(other) > 0 {
+		return errors.Join(other...)
+	}
+	return fmt.Errorf("%w: %s", ErrOrderNotFound, id)
+}
+
+// ExpiredStats сумма статистики retention по шардам. Шарды должны реализовывать IRetentionRepository
+func (r *ShardedRepository) ExpiredStats(ctx context.Context, before time.Time) (RetentionStats, error) {
+	stats := make([]RetentionStats, len(r.shards))
+	errs := scatter(ctx, r.shards, func(ctx context.Context, i int, s Shard) error {
+		rr, err := retentionOf(s)
+		if err != nil {
+			return err
+		}
+		stats[i], err = rr.ExpiredStats(ctx, before)
+		return err
+	})
+	if err := errors.Join(errs...); err != nil {
+		return RetentionStats{}, fmt.Errorf("expired stats: %w", err)
+	}
+	var total RetentionStats
+	for _, st := range stats {
+		if st.Orders == 0 {
+			continue
+		}
+		if total.Orders == 0 || st.Oldest.Before(total.Oldest) {
+			total.Oldest = st.Oldest
+		}
+		if st.Newest.After(total.Newest) {
+			total.Newest = st.Newest
+		}
+		total.Orders += st.Orders
+		total.Deleted += st.Deleted
+		total.Items += st.Items
+	}
+	return total, nil
+}
+
+// ExpiredOrders до limit самых старых заказов всех шардов с date_created < before
+func (r *ShardedRepository) ExpiredOrders(ctx context.Context, before time.Time, limit int) ([]*model.Order, error) {
+	if limit <= 0 {
+		return []*model.Order{}, nil
+	}
+	found := make([][]*model.Order, len(r.shards))
+	errs := scatter(ctx, r.shards, func(ctx context.Context, i int, s Shard) error {
+		rr, err := retentionOf(s)
+		if err != nil {
+			return err
+		}
+		found[i], err = rr.ExpiredOrders(ctx, before, limit)
+		return err
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("expired orders: %w", err)
+	}
+	merged := slices.Concat(found...)
+	slices.SortFunc(merged, func(a, b *model.Order) int {
+		if c := a.DateCreated.Compare(b.DateCreated); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.OrderUID, b.OrderUID)
+	})
+	if len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged, nil
+}
+
+// PurgeRejectedRaw удаляет отклоненные исходные сообщения на всех шардах.
+// Шарды должны реализовывать IRawRetentionRepository
+func (r *ShardedRepository) PurgeRejectedRaw(ctx context.Context, before time.Time) (int64, error) {
+	removed := make([]int64, len(r.shards))
+	errs := scatter(ctx, r.shards, func(ctx context.Context, i int, s Shard) error {
+		rr, ok := s.Repo.(IRawRetentionRepository)
+		if !ok {
+			return fmt.Errorf("raw retention not supported")
+		}
+		var err error
+		removed[i], err = rr.PurgeRejectedRaw(ctx, before)
+		return err
+	})
+	var total int64
+	for _, n := range removed {
+		total += n
+	}
+	if err := errors.Join(errs...); err != nil {
+		return total, fmt.Errorf("purge rejected raw: %w", err)
+	}
+	return total, nil
+}
+
+// ArchiveOrders архивирует заказы на всех шардах: каждый шард обрабатывает те ids, что у него есть
+func (r *ShardedRepository) ArchiveOrders(ctx context.Context, ids []string) (int, error) {
+	return r.removeOrders(ctx, ids, IRetentionRepository.ArchiveOrders)
+}
+
+// PurgeOrders удаляет заказы на всех шардах
+func (r *ShardedRepository) PurgeOrders(ctx context.Context, ids []string) (int, error) {
+	return r.removeOrders(ctx, ids, IRetentionRepository.PurgeOrders)
+}
+
+// removeOrders транзакции шардов независимы: при ошибке части шардов удаленное на остальных
+// не возвращается, следующий запуск retention доделает оставшееся. Записи каталога удаляются после шардов
+func (r *ShardedRepository) removeOrders(ctx context.Context, ids []string,
+	remove func(IRetentionRepository, context.Context, []string) (int, error)) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	removed := make([]int, len(r.shards))
+	errs := scatter(ctx, r.shards, func(ctx context.Context, i int, s Shard) error {
+		rr, err := retentionOf(s)
+		if err != nil {
+			return err
+		}
+		removed[i], err = remove(rr, ctx, ids)
+		return err
+	})
+	n := 0
+	for _, k := range removed {
+		n += k
+	}
+	if err := errors.Join(errs...); err != nil {
+		return n, err
+	}
+	if r.dir != nil {
+		if err := r.dir.Remove(ctx, ids); err != nil {
+			return n, fmt.Errorf("shard directory remove: %w", err)
+		}
+	}
+	return n, nil
+}
+
+func retentionOf(s Shard) (IRetentionRepository, error) {
+	rr, ok := s.Repo.(IRetentionRepository)
+	if !ok {
+		return nil, fmt.Errorf("shard %s: retention not supported", s.Name)
+	}
+	return rr, nil
+}
@@ -0,0 +1,333 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gogazub/myapp/internal/model"
+)
+
+// MemoryConfig настройки MemoryRepository
+type MemoryConfig struct {
+	// Path JSON-файл, в котором заказы хранятся между запусками. Пустой - только память процесса
+	Path string
+}
+
+// MemoryRepository IDBRepository в памяти процесса для локальной разработки без Postgres.
+// Семантика совпадает с DBRepository: Save - upsert, позиции синхронизируются по chrt_id (повтор - ErrDuplicateItem),
+// удаление мягкое, списки в порядке date_created DESC, order_uid DESC, отсутствующий заказ - ErrOrderNotFound.
+// История, поиск, outbox и другие необязательные возможности не поддерживаются
+type MemoryRepository struct {
+	mu     sync.RWMutex
+	path   string
+	orders map[string]*memoryRecord
+	// nextID последний выданный id строки: delivery_id, payment_id, item_id
+	nextID int
+	// lastUpdate последний выданный updated_at. Время строго растет, как в ChangedSince у Postgres
+	lastUpdate time.Time
+}
+
+// memoryRecord хранимый заказ. order - собственная копия, наружу отдаются только Clone()
+type memoryRecord struct {
+	order     *model.Order
+	updatedAt time.Time
+}
+
+// memoryFile формат файла MemoryConfig.Path: заказы в формате API плюс служебные поля.
+// Файл можно подготовить вручную как набор тестовых данных
+type memoryFile struct {
+	Orders []memoryFileOrder `json:"orders"`
+}
+
+type memoryFileOrder struct {
+	*model.Order
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// NewMemoryRepository конструктор. Если cfg.Path задан и файл существует, заказы загружаются из него
+func NewMemoryRepository(cfg MemoryConfig) (*MemoryRepository, error) {
+	r := &MemoryRepository{path: cfg.Path, orders: make(map[string]*memoryRecord)}
+	if cfg.Path == "" {
+		return r, nil
+	}
+	b, err := os.ReadFile(cfg.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("memory repository: %w", err)
+	}
+	var f memoryFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("memory repository: %s: %w", cfg.Path, err)
+	}
+	for _, fo := range f.Orders {
+		if fo.Order == nil || fo.OrderUID == "" {
+			return nil, fmt.Errorf("memory repository: %s: order without order_uid", cfg.Path)
+		}
+		if err := fo.ValidateItems(); err != nil {
+			return nil, fmt.Errorf("memory repository: %s: order %s: %w", cfg.Path, fo.OrderUID, err)
+		}
+		o := r.assignIDs(nil, fo.Order)
+		o.DeletedAt = fo.DeletedAt
+		if fo.UpdatedAt.IsZero() {
+			fo.UpdatedAt = r.now()
+		}
+		if fo.UpdatedAt.After(r.lastUpdate) {
+			r.lastUpdate = fo.UpdatedAt
+		}
+		r.orders[o.OrderUID] = &memoryRecord{order: o, updatedAt: fo.UpdatedAt}
+	}
+	return r, nil
+}
+
+// Save создает или заменяет заказ. Мягко удаленный заказ не восстанавливается: ErrOrderDeleted,
+// позиции с повторяющимся chrt_id - model.ErrDuplicateItem
+func (r *MemoryRepository) Save(ctx context.Context, order *model.Order) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := order.ValidateItems(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev := r.orders[order.OrderUID]
+	if prev != nil && prev.order.DeletedAt != nil {
+		return fmt.Errorf("%w: %s", ErrOrderDeleted, order.OrderUID)
+	}
+	var prevOrder *model.Order
+	if prev != nil {
+		prevOrder = prev.order
+	}
+	r.orders[order.OrderUID] = &memoryRecord{order: r.assignIDs(prevOrder, order), updatedAt: r.now()}
+	return r.persist(order.OrderUID, prev)
+}
+
+// GetByID возвращает копию заказа. Отсутствующий или мягко удаленный - ErrOrderNotFound
+func (r *MemoryRepository) GetByID(ctx context.Context, id string) (*model.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rec, ok := r.orders[id]
+	if !ok || rec.order.DeletedAt != nil {
+		return nil, fmt.Errorf("%w: %s", ErrOrderNotFound, id)
+	}
+	return rec.order.Clone(), nil
+}
+
+// GetByIDs возвращает найденные заказы из ids. Отсутствующие и удаленные пропускаются
+func (r *MemoryRepository) GetByIDs(ctx context.Context, ids []string) ([]*model.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]*model.Order, 0, len(ids))
+	for _, id := range slices.Compact(slices.Sorted(slices.Values(ids))) {
+		if rec, ok := r.orders[id]; ok && rec.order.DeletedAt == nil {
+			out = append(out, rec.order.Clone())
+		}
+	}
+	return out, nil
+}
+
+// Delete мягко удаляет заказ. Уже удаленный или несуществующий - ErrOrderNotFound
+func (r *MemoryRepository) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev, ok := r.orders[id]
+	if !ok || prev.order.DeletedAt != nil {
+		return fmt.Errorf("%w: %s", ErrOrderNotFound, id)
+	}
+	now := r.now()
+	o := prev.order.Clone()
+	o.DeletedAt = &now
+	r.orders[id] = &memoryRecord{order: o, updatedAt: now}
+	return r.persist(id, prev)
+}
+
+// ListPage до limit неудаленных заказов строго после курсора after в порядке date_created DESC, order_uid DESC
+func (r *MemoryRepository) ListPage(ctx context.Context, after Cursor, limit int) ([]*model.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		return []*model.Order{}, nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	page := make([]*model.Order, 0, min(limit, len(r.orders)))
+	for _, rec := range r.orders {
+		if rec.order.DeletedAt != nil {
+			continue
+		}
+		if !after.IsZero() && compareNewestFirst(CursorOf(rec.order), after) <= 0 {
+			continue
+		}
+		page = append(page, rec.order)
+	}
+	slices.SortFunc(page, func(a, b *model.Order) int {
+		return compareNewestFirst(CursorOf(a), CursorOf(b))
+	})
+	page = page[:min(limit, len(page))]
+	for i, o := range page {
+		page[i] = o.Clone()
+	}
+	return page, nil
+}
+
+// Watermark максимальный updated_at: записи идут под мьютексом, поэтому время изменения растет в порядке коммитов.
+// Без заказов - начало эпохи
+func (r *MemoryRepository) Watermark(ctx context.Context) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	wm := time.Unix(0, 0).UTC()
+	for _, rec := range r.orders {
+		if rec.updatedAt.After(wm) {
+			wm = rec.updatedAt
+		}
+	}
+	return wm, nil
+}
+
+// ChangedSince order_uid заказов, измененных строго после since, включая удаленные
+func (r *MemoryRepository) ChangedSince(ctx context.Context, since time.Time) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]string, 0, 16)
+	for id, rec := range r.orders {
+		if rec.updatedAt.After(since) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// assignIDs копия order с id строк, как их выдал бы Postgres: delivery_id и payment_id сохраняются,
+// позиции сопоставляются по chrt_id, существующие сохраняют item_id, новые получают следующие.
+// Позиции упорядочены по item_id, как в loadItemsFor
+func (r *MemoryRepository) assignIDs(prev, order *model.Order) *model.Order {
+	o := order.Clone()
+	o.DeletedAt = nil
+	o.Delivery.OrderUID, o.Payment.OrderUID = o.OrderUID, o.OrderUID
+
+	prevItems := make(map[int64]int)
+	if prev != nil {
+		o.Delivery.DeliveryID, o.Payment.PaymentID = prev.Delivery.DeliveryID, prev.Payment.PaymentID
+		for _, it := range prev.Items {
+			prevItems[it.ChrtID] = it.ItemID
+		}
+	} else {
+		o.Delivery.DeliveryID, o.Payment.PaymentID = r.newID(), r.newID()
+	}
+	for i := range o.Items {
+		it := &o.Items[i]
+		it.OrderUID = o.OrderUID
+		if id, ok := prevItems[it.ChrtID]; ok {
+			it.ItemID = id
+		} else {
+			it.ItemID = r.newID()
+		}
+	}
+	slices.SortFunc(o.Items, func(a, b model.Item) int { return cmp.Compare(a.ItemID, b.ItemID) })
+	return o
+}
+
+func (r *MemoryRepository) newID() int {
+	r.nextID++
+	return r.nextID
+}
+
+// now время изменения с точностью Postgres (микросекунды), строго больше предыдущего
+func (r *MemoryRepository) now() time.Time {
+	t := time.Now().UTC().Truncate(time.Microsecond)
+	if !t.After(r.lastUpdate) {
+		t = r.lastUpdate.Add(time.Microsecond)
+	}
+	r.lastUpdate = t
+	return t
+}
+
+// persist записывает все заказы в файл. При ошибке изменение заказа id откатывается к prev,
+// чтобы память не расходилась с файлом
+func (r *MemoryRepository) persist(id string, prev *memoryRecord) error {
+	if r.path == "" {
+		return nil
+	}
+	if err := r.writeFile(); err != nil {
+		if prev == nil {
+			delete(r.orders, id)
+		} else {
+			r.orders[id] = prev
+		}
+		return fmt.Errorf("memory repository persist: %w", err)
+	}
+	return nil
+}
+
+// writeFile пишет файл целиком через временный файл и rename: оборванная запись не портит прежний
+func (r *MemoryRepository) writeFile() error {
+	f := memoryFile{Orders: make([]memoryFileOrder, 0, len(r.orders))}
+	for _, rec := range r.orders {
+		f.Orders = append(f.Orders, memoryFileOrder{Order: rec.order, DeletedAt: rec.order.DeletedAt, UpdatedAt: rec.updatedAt})
+	}
+	slices.SortFunc(f.Orders, func(a, b memoryFileOrder) int {
+		return -compareNewestFirst(CursorOf(a.Order), CursorOf(b.Order))
+	})
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	enc := json.NewEncoder(tmp)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// compareNewestFirst порядок списков: date_created DESC, order_uid DESC. Отрицательное - a раньше в списке
+func compareNewestFirst(a, b Cursor) int {
+	if c := b.DateCreated.Compare(a.DateCreated); c != 0 {
+		return c
+	}
+	return cmp.Compare(b.OrderUID, a.OrderUID)
+}
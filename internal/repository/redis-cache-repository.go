@@ -0,0 +1,431 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// Значения по умолчанию для RedisCacheConfig
+const (
+	defaultRedisKeyPrefix    = "order:"
+	defaultRedisInvalidateCh = "orders:invalidate"
+	defaultRedisWarmupSize   = maxCacheSize
+	defaultL1TTL             = 30 * time.Second
+
+	// clearAllMarker сообщение в канале инвалидации "сбросить весь L1"
+	clearAllMarker = "*"
+)
+
+// RedisCacheConfig настройки кеша поверх Redis-совместимого сервера
+type RedisCacheConfig struct {
+	Addr     string
+	Password string
+	DB       int
+
+	// KeyPrefix префикс ключей заказов. По умолчанию "order:"
+	KeyPrefix string
+	// TTL время жизни ключа в Redis. 0 - без TTL
+	TTL time.Duration
+	// WarmupSize сколько свежих заказов заливать в Redis при прогреве
+	WarmupSize int
+
+	// L1Size размер локального in-memory кеша перед Redis. 0 - L1 выключен
+	L1Size int
+	// L1TTL время жизни записи в L1. Ограничивает устаревание, если сообщение об инвалидации потерялось
+	L1TTL time.Duration
+	// InvalidationChannel канал pub/sub, через который инстансы сбрасывают друг другу L1
+	InvalidationChannel string
+}
+
+// RedisCacheRepository реализация ICacheRepository поверх Redis, общая для всех реплик.
+// Опциональный L1 (CacheRepository) снимает с Redis горячие чтения; чтобы L1 других реплик
+// не устаревал, каждое изменение публикуется в канал инвалидации (см. RunInvalidation)
+type RedisCacheRepository struct {
+	client *redis.Client
+	cfg    RedisCacheConfig
+	l1     *CacheRepository
+
+	// instanceID позволяет игнорировать собственные сообщения об инвалидации
+	instanceID string
+
+	hits          atomic.Uint64
+	l1Hits        atomic.Uint64
+	misses        atomic.Uint64
+	invalidations atomic.Uint64
+	loads         atomic.Uint64
+	lastLoad      atomic.Pointer[loadInfo]
+
+	warmup *warmupTracker
+}
+
+type loadInfo struct {
+	at       time.Time
+	duration time.Duration
+	orders   int
+}
+
+// NewRedisCacheRepository конструктор. Подключение к Redis ленивое: ошибки проявятся при первом запросе,
+// для проверки при старте используйте Ping
+func NewRedisCacheRepository(cfg RedisCacheConfig) *RedisCacheRepository {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = defaultRedisKeyPrefix
+	}
+	if cfg.InvalidationChannel == "" {
+		cfg.InvalidationChannel = defaultRedisInvalidateCh
+	}
+	if cfg.WarmupSize <= 0 {
+		cfg.WarmupSize = defaultRedisWarmupSize
+	}
+	if cfg.L1TTL <= 0 {
+		cfg.L1TTL = defaultL1TTL
+	}
+
+	r := &RedisCacheRepository{
+		client:     client,
+		cfg:        cfg,
+		instanceID: newInstanceID(),
+		warmup:     newWarmupTracker(),
+	}
+	if cfg.L1Size > 0 {
+		r.l1 = NewCacheRepositoryWithConfig(CacheConfig{MaxSize: cfg.L1Size, TTL: cfg.L1TTL})
+	}
+	return r
+}
+
+// Ping проверяет доступность Redis
+func (r *RedisCacheRepository) Ping(ctx context.Context) error {
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("redis ping error:%w", err)
+	}
+	return nil
+}
+
+// Close закрывает подключение к Redis
+func (r *RedisCacheRepository) Close() error {
+	return r.client.Close()
+}
+
+// LoadFromDB заливает в Redis самые свежие заказы из БД: каждая страница IterateChunks - один pipeline
+func (r *RedisCacheRepository) LoadFromDB(ctx context.Context, psqlRepo IDBRepository) error {
+	start := time.Now()
+	if err := r.warmup.begin(start); err != nil {
+		return err
+	}
+	r.warmup.setTarget(r.cfg.WarmupSize)
+
+	loaded, seen := 0, 0
+	for chunk, err := range IterateChunks(ctx, psqlRepo, min(DefaultChunkSize, r.cfg.WarmupSize)) {
+		if err != nil {
+			err = fmt.Errorf("load from db error:%w", err)
+			r.warmup.finish(loaded, err)
+			return err
+		}
+		if rest := r.cfg.WarmupSize - seen; len(chunk) > rest {
+			chunk = chunk[:rest]
+		}
+		seen += len(chunk)
+
+		pipe := r.client.Pipeline()
+		for _, order := range chunk {
+			data, err := json.Marshal(order)
+			if err != nil {
+				log.Printf("marshal order error:%v\norder:%v", err, model.GetOrderLog(order))
+				continue
+			}
+			pipe.Set(ctx, r.key(order.OrderUID), data, r.cfg.TTL)
+		}
+		cmds, err := pipe.Exec(ctx)
+		if err != nil {
+			err = fmt.Errorf("load from db error:%w", err)
+			r.warmup.finish(loaded, err)
+			return err
+		}
+		loaded += len(cmds)
+		r.warmup.setLoaded(loaded)
+		if seen >= r.cfg.WarmupSize {
+			break
+		}
+	}
+
+	r.loads.Add(1)
+	r.lastLoad.Store(&loadInfo{at: start, duration: time.Since(start), orders: loaded})
+	r.warmup.finish(loaded, nil)
+	return nil
+}
+
+// Save записывает заказ в Redis и L1, остальным репликам отправляет инвалидацию
+func (r *RedisCacheRepository) Save(ctx context.Context, order *model.Order) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("save error:%w", err)
+	}
+	data, err := json.Marshal(order)
+	if err != nil {
+		return fmt.Errorf("save error:%w", err)
+	}
+	if err := r.client.Set(ctx, r.key(order.OrderUID), data, r.cfg.TTL).Err(); err != nil {
+		return fmt.Errorf("save error:%w", err)
+	}
+	if r.l1 != nil {
+		if err := r.l1.Save(ctx, order); err != nil {
+			return err
+		}
+	}
+	r.publishInvalidation(ctx, order.OrderUID)
+	return nil
+}
+
+// GetByID ищет заказ сначала в L1, затем в Redis. Найденное в Redis кладется в L1
+func (r *RedisCacheRepository) GetByID(ctx context.Context, id string) (*model.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("getByID error:%w", err)
+	}
+	if r.l1 != nil {
+		if order, err := r.l1.GetByID(ctx, id); err == nil {
+			r.l1Hits.Add(1)
+			r.hits.Add(1)
+			return order, nil
+		}
+	}
+
+	data, err := r.client.Get(ctx, r.key(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		r.misses.Add(1)
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getByID error:%w", err)
+	}
+
+	var order model.Order
+	if err := json.Unmarshal(data, &order); err != nil {
+		return nil, fmt.Errorf("getByID error:%w", err)
+	}
+	r.hits.Add(1)
+	if r.l1 != nil {
+		if err := r.l1.Save(ctx, &order); err != nil {
+			log.Printf("l1 save error:%v", err)
+		}
+	}
+	return &order, nil
+}
+
+// Contains есть ли заказ в L1 или в Redis
+func (r *RedisCacheRepository) Contains(ctx context.Context, id string) (bool, error) {
+	if r.l1 != nil {
+		if ok, err := r.l1.Contains(ctx, id); err != nil || ok {
+			return ok, err
+		}
+	}
+	n, err := r.client.Exists(ctx, r.key(id)).Result()
+	if err != nil {
+		return false, fmt.Errorf("contains error:%w", err)
+	}
+	return n > 0, nil
+}
+
+// Delete удаляет заказ из Redis и из L1 всех реплик
+func (r *RedisCacheRepository) Delete(ctx context.Context, id string) error {
+	if err := r.Evict(ctx, id); err != nil {
+		return err
+	}
+	r.publishInvalidation(ctx, id)
+	return nil
+}
+
+// Evict удаляет заказ из Redis и L1 этой реплики без рассылки инвалидации
+func (r *RedisCacheRepository) Evict(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("delete error:%w", err)
+	}
+	n, err := r.client.Del(ctx, r.key(id)).Result()
+	if err != nil {
+		return fmt.Errorf("delete error:%w", err)
+	}
+	r.invalidations.Add(uint64(n))
+	if r.l1 != nil {
+		if err := r.l1.Delete(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clear удаляет все ключи заказов (по префиксу) и сбрасывает L1 всех реплик
+func (r *RedisCacheRepository) Clear(ctx context.Context) error {
+	iter := r.client.Scan(ctx, 0, r.cfg.KeyPrefix+"*", 500).Iterator()
+	batch := make([]string, 0, 500)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := r.client.Del(ctx, batch...).Result()
+		if err != nil {
+			return fmt.Errorf("clear error:%w", err)
+		}
+		r.invalidations.Add(uint64(n))
+		batch = batch[:0]
+		return nil
+	}
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("clear error:%w", err)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if r.l1 != nil {
+		if err := r.l1.Clear(ctx); err != nil {
+			return err
+		}
+	}
+	r.publishInvalidation(ctx, clearAllMarker)
+	return nil
+}
+
+// ClearLocal сбрасывает L1 этой реплики. Общий Redis и L1 других реплик не трогаются
+func (r *RedisCacheRepository) ClearLocal(ctx context.Context) error {
+	if r.l1 == nil {
+		return nil
+	}
+	return r.l1.Clear(ctx)
+}
+
+// Stats статистика этой реплики. Size/Capacity/Evictions относятся к L1:
+// размер общего Redis смотрите средствами самого Redis
+func (r *RedisCacheRepository) Stats() CacheStats {
+	st := CacheStats{
+		Backend:       "redis",
+		Hits:          r.hits.Load(),
+		L1Hits:        r.l1Hits.Load(),
+		Misses:        r.misses.Load(),
+		Invalidations: r.invalidations.Load(),
+		TTLSeconds:    r.cfg.TTL.Seconds(),
+		Loads:         r.loads.Load(),
+	}
+	if r.l1 != nil {
+		l1 := r.l1.Stats()
+		st.Size = l1.Size
+		st.Capacity = l1.Capacity
+		st.Evictions = l1.Evictions
+		st.Expired = l1.Expired
+	}
+	if li := r.lastLoad.Load(); li != nil {
+		st.LastLoadAt = li.at
+		st.LastLoadMs = float64(li.duration) / float64(time.Millisecond)
+		st.LastLoadOrders = li.orders
+	}
+	if total := st.Hits + st.Misses; total > 0 {
+		st.HitRatio = float64(st.Hits) / float64(total)
+	}
+	return st
+}
+
+// WarmupStatus текущий прогресс прогрева
+func (r *RedisCacheRepository) WarmupStatus() WarmupStatus {
+	return r.warmup.get()
+}
+
+// RunInvalidation слушает канал инвалидации и сбрасывает записи L1, измененные другими репликами.
+// При (пере)подписке L1 очищается целиком: сообщения, пришедшие во время разрыва, потеряны.
+// Блокируется до отмены ctx. Без L1 сразу возвращает nil
+func (r *RedisCacheRepository) RunInvalidation(ctx context.Context) error {
+	if r.l1 == nil {
+		return nil
+	}
+	sub := r.client.Subscribe(ctx, r.cfg.InvalidationChannel)
+	defer func() {
+		if err := sub.Close(); err != nil {
+			log.Printf("redis subscription close error:%v", err)
+		}
+	}()
+
+	for {
+		msg, err := sub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// go-redis сам переподключается; ждем, чтобы не крутить цикл при лежащем Redis
+			log.Printf("redis invalidation receive error:%v", err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind == "subscribe" {
+				if err := r.l1.Clear(ctx); err != nil {
+					log.Printf("l1 clear error:%v", err)
+				}
+			}
+		case *redis.Message:
+			r.applyInvalidation(ctx, m.Payload)
+		}
+	}
+}
+
+// applyInvalidation обрабатывает сообщение вида "<instanceID>|<order_uid или *>"
+func (r *RedisCacheRepository) applyInvalidation(ctx context.Context, payload string) {
+	from, id, ok := strings.Cut(payload, "|")
+	if !ok || from == r.instanceID {
+		return
+	}
+	var err error
+	if id == clearAllMarker {
+		err = r.l1.Clear(ctx)
+	} else {
+		err = r.l1.Delete(ctx, id)
+	}
+	if err != nil {
+		log.Printf("l1 invalidation error:%v", err)
+	}
+}
+
+// publishInvalidation рассылает инвалидацию. Публикуем даже без своего L1: у других реплик он может быть.
+// Ошибка только логируется: запись в Redis уже прошла, а L1 других реплик протухнет по L1TTL
+func (r *RedisCacheRepository) publishInvalidation(ctx context.Context, id string) {
+	if err := r.client.Publish(ctx, r.cfg.InvalidationChannel, r.instanceID+"|"+id).Err(); err != nil {
+		log.Printf("redis publish invalidation error:%v", err)
+	}
+}
+
+func (r *RedisCacheRepository) key(id string) string {
+	return r.cfg.KeyPrefix + id
+}
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,412 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gogazub/myapp/internal/model"
+	// Драйвер "sqlite" без cgo: бинарник собирается и для edge-устройств
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteRepository IDBRepository поверх встроенной SQLite (схема migrations/sqlite).
+// Семантика совпадает с DBRepository: upsert заказа, синхронизация позиций по chrt_id, мягкое удаление,
+// keyset-списки. Время хранится целыми микросекундами UTC, суммы - строками.
+// История, поиск, outbox, партиции и шифрование не поддерживаются
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository конструктор. db - подключение из OpenSQLite с примененными миграциями
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
+	return &SQLiteRepository{db: db}
+}
+
+// OpenSQLite открывает файл SQLite path (":memory:" - БД в памяти процесса) с внешними ключами,
+// WAL и ожиданием блокировки. Соединение одно: SQLite все равно пишет по одной транзакции,
+// а БД в памяти видна только своему соединению
+func OpenSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+
+		"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite error: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("open sqlite error: %w", err)
+	}
+	return db, nil
+}
+
+// Save сохраняет заказ одной транзакцией. Мягко удаленный заказ не восстанавливается: ErrOrderDeleted,
+// позиции с повторяющимся chrt_id - model.ErrDuplicateItem
+func (r *SQLiteRepository) Save(ctx context.Context, order *model.Order) error {
+	if err := order.ValidateItems(); err != nil {
+		return err
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Printf("Rollback error:%s", err.Error())
+		}
+	}()
+
+	var deletedAt sql.NullInt64
+	err = tx.QueryRowContext(ctx, `SELECT deleted_at FROM orders WHERE order_uid = ?`, order.OrderUID).Scan(&deletedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("lockPrevious: %w", err)
+	}
+	if deletedAt.Valid {
+		return fmt.Errorf("%w: %s", ErrOrderDeleted, order.OrderUID)
+	}
+	now, err := nextUpdatedAt(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO orders (order_uid, track_number, entry, locale, internal_signature, customer_id,
+			delivery_service, shardkey, sm_id, date_created, oof_shard, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (order_uid) DO UPDATE SET
+			track_number = excluded.track_number,
+			entry = excluded.entry,
+			locale = excluded.locale,
+			internal_signature = excluded.internal_signature,
+			customer_id = excluded.customer_id,
+			delivery_service = excluded.delivery_service,
+			shardkey = excluded.shardkey,
+			sm_id = excluded.sm_id,
+			date_created = excluded.date_created,
+			oof_shard = excluded.oof_shard,
+			updated_at = excluded.updated_at`,
+		order.OrderUID, order.TrackNumber, order.Entry, order.Locale, order.InternalSignature, order.CustomerID,
+		order.DeliveryService, order.Shardkey, order.SmID, order.DateCreated.UnixMicro(), order.OofShard, now,
+	); err != nil {
+		return fmt.Errorf("saveOrder: %w", err)
+	}
+
+	d := order.Delivery
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO deliveries (order_uid, name, phone, zip, city, address, region, email)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (order_uid) DO UPDATE SET
+			name = excluded.name,
+			phone = excluded.phone,
+			zip = excluded.zip,
+			city = excluded.city,
+			address = excluded.address,
+			region = excluded.region,
+			email = excluded.email`,
+		order.OrderUID, d.Name, d.Phone, d.Zip, d.City, d.Address, d.Region, d.Email,
+	); err != nil {
+		return fmt.Errorf("saveDelivery: %w", err)
+	}
+
+	p := order.Payment
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO payments (order_uid, "transaction", request_id, currency, provider, amount,
+			payment_dt, bank, delivery_cost, goods_total, custom_fee)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (order_uid) DO UPDATE SET
+			"transaction" = excluded."transaction",
+			request_id = excluded.request_id,
+			currency = excluded.currency,
+			provider = excluded.provider,
+			amount = excluded.amount,
+			payment_dt = excluded.payment_dt,
+			bank = excluded.bank,
+			delivery_cost = excluded.delivery_cost,
+			goods_total = excluded.goods_total,
+			custom_fee = excluded.custom_fee`,
+		order.OrderUID, p.Transaction, p.RequestID, p.Currency, p.Provider, p.Amount,
+		p.PaymentDt, p.Bank, p.DeliveryCost, p.GoodsTotal, p.CustomFee,
+	); err != nil {
+		return fmt.Errorf("savePayment: %w", err)
+	}
+
+	if err := r.saveItems(ctx, tx, order.OrderUID, order.Items); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetByID возвращает заказ по ID. Мягко удаленный заказ не возвращается
+func (r *SQLiteRepository) GetByID(ctx context.Context, id string) (*model.Order, error) {
+	orders, err := r.loadOrders(ctx, `WHERE o.order_uid = ? AND o.deleted_at IS NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrOrderNotFound, id)
+	}
+	return orders[0], nil
+}
+
+// GetByIDs возвращает найденные заказы из ids. Отсутствующие id пропускаются, порядок не определен
+func (r *SQLiteRepository) GetByIDs(ctx context.Context, ids []string) ([]*model.Order, error) {
+	if len(ids) == 0 {
+		return []*model.Order{}, nil
+	}
+	args := make([]any, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	orders, err := r.loadOrders(ctx, `WHERE o.order_uid IN (`+placeholders(len(ids))+`) AND o.deleted_at IS NULL`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get orders by ids: %w", err)
+	}
+	return orders, nil
+}
+
+// ListPage до limit заказов строго после курсора after в порядке date_created DESC, order_uid DESC
+func (r *SQLiteRepository) ListPage(ctx context.Context, after Cursor, limit int) ([]*model.Order, error) {
+	if limit <= 0 {
+		return []*model.Order{}, nil
+	}
+	var (
+		orders []*model.Order
+		err    error
+	)
+	if after.IsZero() {
+		orders, err = r.loadOrders(ctx, `WHERE o.deleted_at IS NULL
+			ORDER BY o.date_created DESC, o.order_uid DESC LIMIT ?`, limit)
+	} else {
+		orders, err = r.loadOrders(ctx, `WHERE (o.date_created, o.order_uid) < (?, ?) AND o.deleted_at IS NULL
+			ORDER BY o.date_created DESC, o.order_uid DESC LIMIT ?`, after.DateCreated.UnixMicro(), after.OrderUID, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list orders page: %w", err)
+	}
+	return orders, nil
+}
+
+// Watermark максимальный orders.updated_at. Запись в SQLite одна за раз, а nextUpdatedAt строго растет,
+// поэтому updated_at идут в порядке коммитов. Без заказов - начало эпохи
+func (r *SQLiteRepository) Watermark(ctx context.Context) (time.Time, error) {
+	var wm int64
+	if err := r.db.QueryRowContext(ctx, `SELECT COALESCE(max(updated_at), 0) FROM orders`).Scan(&wm); err != nil {
+		return time.Time{}, fmt.Errorf("watermark: %w", err)
+	}
+	return time.UnixMicro(wm).UTC(), nil
+}
+
+// ChangedSince order_uid заказов, измененных строго после since, включая удаленные
+func (r *SQLiteRepository) ChangedSince(ctx context.Context, since time.Time) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT order_uid FROM orders WHERE updated_at > ?`, since.UnixMicro())
+	if err != nil {
+		return nil, fmt.Errorf("changed since: %w", err)
+	}
+	defer closeRows(rows)
+
+	ids := make([]string, 0, 16)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Delete мягко удаляет заказ. Уже удаленный или несуществующий - ErrOrderNotFound
+func (r *SQLiteRepository) Delete(ctx context.Context, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Printf("Rollback error:%s", err.Error())
+		}
+	}()
+
+	now, err := nextUpdatedAt(ctx, tx)
+	if err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE orders SET deleted_at = ?, updated_at = ?
+		WHERE order_uid = ? AND deleted_at IS NULL`, now, now, id)
+	if err != nil {
+		return fmt.Errorf("softDelete: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("softDelete: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrOrderNotFound, id)
+	}
+	return tx.Commit()
+}
+
+//
+// ---------------- PRIVATE ----------------
+//
+
+// selectSQLiteOrdersSQL заказ вместе с delivery и payment одной строкой, как selectOrdersSQL
+const selectSQLiteOrdersSQL = `
+	SELECT o.order_uid, o.track_number, o.entry, o.locale, o.internal_signature,
+	       o.customer_id, o.delivery_service, o.shardkey, o.sm_id, o.date_created, o.oof_shard, o.deleted_at,
+	       d.delivery_id, d.name, d.phone, d.zip, d.city, d.address, d.region, d.email,
+	       p.payment_id, p."transaction", p.request_id, p.currency, p.provider,
+	       p.amount, p.payment_dt, p.bank, p.delivery_cost, p.goods_total, p.custom_fee
+	FROM orders o
+	JOIN deliveries d ON d.order_uid = o.order_uid
+	JOIN payments p ON p.order_uid = o.order_uid
+`
+
+// loadOrders заказы запросом selectSQLiteOrdersSQL с хвостом tail и их items одним запросом.
+// Порядок заказов совпадает с порядком строк запроса
+func (r *SQLiteRepository) loadOrders(ctx context.Context, tail string, args ...any) ([]*model.Order, error) {
+	rows, err := r.db.QueryContext(ctx, selectSQLiteOrdersSQL+tail, args...)
+	if err != nil {
+		return nil, fmt.Errorf("loadOrders: %w", err)
+	}
+	defer closeRows(rows)
+
+	orders := make([]*model.Order, 0, 64)
+	for rows.Next() {
+		var (
+			o           model.Order
+			dateCreated int64
+			deletedAt   sql.NullInt64
+		)
+		if err := rows.Scan(&o.OrderUID, &o.TrackNumber, &o.Entry, &o.Locale,
+			&o.InternalSignature, &o.CustomerID, &o.DeliveryService,
+			&o.Shardkey, &o.SmID, &dateCreated, &o.OofShard, &deletedAt,
+			&o.Delivery.DeliveryID, &o.Delivery.Name, &o.Delivery.Phone, &o.Delivery.Zip,
+			&o.Delivery.City, &o.Delivery.Address, &o.Delivery.Region, &o.Delivery.Email,
+			&o.Payment.PaymentID, &o.Payment.Transaction, &o.Payment.RequestID,
+			&o.Payment.Currency, &o.Payment.Provider, &o.Payment.Amount, &o.Payment.PaymentDt,
+			&o.Payment.Bank, &o.Payment.DeliveryCost, &o.Payment.GoodsTotal, &o.Payment.CustomFee); err != nil {
+			return nil, fmt.Errorf("scanOrder: %w", err)
+		}
+		o.DateCreated = time.UnixMicro(dateCreated).UTC()
+		if deletedAt.Valid {
+			t := time.UnixMicro(deletedAt.Int64).UTC()
+			o.DeletedAt = &t
+		}
+		o.Delivery.OrderUID = o.OrderUID
+		o.Payment.OrderUID = o.OrderUID
+		orders = append(orders, &o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("loadOrders: %w", err)
+	}
+	closeRows(rows)
+
+	if err := r.loadItemsFor(ctx, orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// loadItemsFor грузит items для всех заказов одним запросом в порядке item_id
+func (r *SQLiteRepository) loadItemsFor(ctx context.Context, orders []*model.Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	byID := make(map[string]*model.Order, len(orders))
+	args := make([]any, 0, len(orders))
+	for _, o := range orders {
+		byID[o.OrderUID] = o
+		args = append(args, o.OrderUID)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT item_id, order_uid, chrt_id, track_number, price, rid, name,
+		       sale, size, total_price, nm_id, brand, status
+		FROM items WHERE order_uid IN (`+placeholders(len(args))+`)
+		ORDER BY order_uid, item_id`, args...)
+	if err != nil {
+		return fmt.Errorf("loadItems: %w", err)
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var it model.Item
+		if err := rows.Scan(&it.ItemID, &it.OrderUID, &it.ChrtID, &it.TrackNumber, &it.Price,
+			&it.Rid, &it.Name, &it.Sale, &it.Size, &it.TotalPrice, &it.NmID, &it.Brand, &it.Status); err != nil {
+			return fmt.Errorf("scanItem: %w", err)
+		}
+		if o, ok := byID[it.OrderUID]; ok {
+			o.Items = append(o.Items, it)
+		}
+	}
+	return rows.Err()
+}
+
+// saveItems удаляет позиции, которых больше нет в заказе, и upsert'ит остальные по (order_uid, chrt_id):
+// item_id сохранившихся позиций не меняется
+func (r *SQLiteRepository) saveItems(ctx context.Context, tx *sql.Tx, orderUID string, items []model.Item) error {
+	args := make([]any, 0, len(items)+1)
+	args = append(args, orderUID)
+	for _, it := range items {
+		args = append(args, it.ChrtID)
+	}
+	stale := `DELETE FROM items WHERE order_uid = ?`
+	if len(items) > 0 {
+		stale += ` AND chrt_id NOT IN (` + placeholders(len(items)) + `)`
+	}
+	if _, err := tx.ExecContext(ctx, stale, args...); err != nil {
+		return fmt.Errorf("deleteStaleItems: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO items (order_uid, chrt_id, track_number, price, rid, name,
+			sale, size, total_price, nm_id, brand, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (order_uid, chrt_id) DO UPDATE SET
+			track_number = excluded.track_number,
+			price = excluded.price,
+			rid = excluded.rid,
+			name = excluded.name,
+			sale = excluded.sale,
+			size = excluded.size,
+			total_price = excluded.total_price,
+			nm_id = excluded.nm_id,
+			brand = excluded.brand,
+			status = excluded.status`)
+	if err != nil {
+		return fmt.Errorf("saveItems: %w", err)
+	}
+	defer func() { _ = stmt.Close() }()
+	for _, it := range items {
+		if _, err := stmt.ExecContext(ctx, orderUID, it.ChrtID, it.TrackNumber, it.Price, it.Rid, it.Name,
+			it.Sale, it.Size, it.TotalPrice, it.NmID, it.Brand, it.Status); err != nil {
+			return fmt.Errorf("saveItems: %w", err)
+		}
+	}
+	return nil
+}
+
+// nextUpdatedAt updated_at для изменения в транзакции tx: текущее время в микросекундах, но строго
+// больше уже записанных. Иначе два изменения в одну микросекунду были бы неразличимы для ChangedSince
+func nextUpdatedAt(ctx context.Context, tx *sql.Tx) (int64, error) {
+	var last int64
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(max(updated_at), 0) FROM orders`).Scan(&last); err != nil {
+		return 0, fmt.Errorf("updatedAt: %w", err)
+	}
+	return max(time.Now().UnixMicro(), last+1), nil
+}
+
+// placeholders "?, ?, ?" для n аргументов
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
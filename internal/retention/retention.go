@@ -0,0 +1,184 @@
+// Package retention удаляет из БД заказы старше заданного возраста. Перед удалением заказы
+// переносятся в архивные таблицы или выгружаются в сжатые NDJSON-файлы
+package retention
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gogazub/myapp/internal/model"
+	repo "github.com/gogazub/myapp/internal/repository"
+)
+
+// Mode куда деваются заказы перед удалением
+type Mode string
+
+// Режимы retention
+const (
+	// ModeArchive перенос в orders_archive, deliveries_archive, payments_archive, items_archive
+	ModeArchive Mode = "archive"
+	// ModeExport выгрузка в ExportDir файлами *.ndjson.gz, по файлу на батч
+	ModeExport Mode = "export"
+)
+
+// DefaultBatchSize заказов за одну транзакцию удаления
+const DefaultBatchSize = 500
+
+// ErrNoProgress батч заказов не удалился: повторять его бессмысленно
+var ErrNoProgress = errors.New("retention made no progress")
+
+// Config параметры запуска
+type Config struct {
+	// MaxAge заказы с date_created старше now-MaxAge удаляются
+	MaxAge time.Duration
+	Mode   Mode
+	// ExportDir каталог для файлов ModeExport
+	ExportDir string
+	// BatchSize по умолчанию DefaultBatchSize
+	BatchSize int
+	// DryRun только посчитать, что будет удалено
+	DryRun bool
+}
+
+// Report итог запуска
+type Report struct {
+	Cutoff time.Time `json:"cutoff"`
+	Mode   Mode      `json:"mode"`
+	DryRun bool      `json:"dry_run"`
+	// Expired что попадало под retention на момент запуска
+	Expired repo.RetentionStats `json:"expired"`
+	// Removed сколько заказов удалено из основных таблиц
+	Removed int `json:"removed"`
+	// RejectedRaw сколько удалено отклоненных исходных сообщений старше Cutoff
+	RejectedRaw int64 `json:"rejected_raw_removed"`
+	// Files выгруженные файлы ModeExport
+	Files []string `json:"files,omitempty"`
+}
+
+// exportRecord строка NDJSON: заказ в формате API плюс время мягкого удаления
+type exportRecord struct {
+	*model.Order
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Run удаляет заказы старше cfg.MaxAge батчами по cfg.BatchSize. Каждый батч сначала
+// архивируется или выгружается, и только потом удаляется. Прерывание между батчами безопасно:
+// следующий запуск продолжит с оставшихся заказов
+func Run(ctx context.Context, r repo.IRetentionRepository, cfg Config) (Report, error) {
+	if cfg.MaxAge <= 0 {
+		return Report{}, fmt.Errorf("retention error: max age must be positive, got %s", cfg.MaxAge)
+	}
+	if cfg.Mode != ModeArchive && cfg.Mode != ModeExport {
+		return Report{}, fmt.Errorf("retention error: unknown mode %q", cfg.Mode)
+	}
+	if cfg.Mode == ModeExport && cfg.ExportDir == "" {
+		return Report{}, fmt.Errorf("retention error: export dir is required for mode %s", ModeExport)
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+
+	now := time.Now().UTC()
+	rep := Report{Cutoff: now.Add(-cfg.MaxAge), Mode: cfg.Mode, DryRun: cfg.DryRun}
+	stats, err := r.ExpiredStats(ctx, rep.Cutoff)
+	if err != nil {
+		return rep, fmt.Errorf("retention error:%w", err)
+	}
+	rep.Expired = stats
+	if cfg.DryRun {
+		return rep, nil
+	}
+	// Отклоненные исходные сообщения не принадлежат заказам и удаляются отдельно, без архива
+	if rr, ok := r.(repo.IRawRetentionRepository); ok {
+		if rep.RejectedRaw, err = rr.PurgeRejectedRaw(ctx, rep.Cutoff); err != nil {
+			return rep, fmt.Errorf("retention error:%w", err)
+		}
+	}
+	if stats.Orders == 0 {
+		return rep, nil
+	}
+	if cfg.Mode == ModeExport {
+		if err := os.MkdirAll(cfg.ExportDir, 0o755); err != nil {
+			return rep, fmt.Errorf("retention error:%w", err)
+		}
+	}
+
+	for batch := 1; ; batch++ {
+		if err := ctx.Err(); err != nil {
+			return rep, err
+		}
+		orders, err := r.ExpiredOrders(ctx, rep.Cutoff, cfg.BatchSize)
+		if err != nil {
+			return rep, fmt.Errorf("retention error:%w", err)
+		}
+		if len(orders) == 0 {
+			return rep, nil
+		}
+		ids := make([]string, 0, len(orders))
+		for _, o := range orders {
+			ids = append(ids, o.OrderUID)
+		}
+
+		var removed int
+		if cfg.Mode == ModeExport {
+			name := fmt.Sprintf("orders-%s-%04d.ndjson.gz", now.Format("20060102T150405Z"), batch)
+			path := filepath.Join(cfg.ExportDir, name)
+			if err := writeExport(path, orders); err != nil {
+				return rep, fmt.Errorf("retention error:%w", err)
+			}
+			rep.Files = append(rep.Files, path)
+			removed, err = r.PurgeOrders(ctx, ids)
+		} else {
+			removed, err = r.ArchiveOrders(ctx, ids)
+		}
+		if err != nil {
+			return rep, fmt.Errorf("retention error:%w", err)
+		}
+		rep.Removed += removed
+		log.Printf("retention batch %d: %d orders removed", batch, removed)
+		if removed == 0 {
+			return rep, ErrNoProgress
+		}
+	}
+}
+
+// writeExport пишет заказы в path через временный файл. На диске файл появляется
+// только целиком и после fsync, поэтому удалять заказы после writeExport безопасно
+func writeExport(path string, orders []*model.Order) (err error) {
+	tmp := path + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	gz := gzip.NewWriter(f)
+	enc := json.NewEncoder(gz)
+	for _, o := range orders {
+		if err := enc.Encode(exportRecord{Order: o, DeletedAt: o.DeletedAt}); err != nil {
+			return err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -3,16 +3,36 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 
 	"github.com/gogazub/myapp/internal/model"
 	repo "github.com/gogazub/myapp/internal/repository"
 )
 
+// ErrNotSupported выбранное хранилище не поддерживает операцию
+var ErrNotSupported = errors.New("operation not supported by repository")
+
 // IService интерфейс сервиса
 type IService interface {
 	SaveOrder(ctx context.Context, order *model.Order) error
 	GetOrderByID(ctx context.Context, id string) (*model.Order, error)
+	GetOrderHistory(ctx context.Context, id string, limit int) ([]model.HistoryEntry, error)
+	GetOrderRawMessages(ctx context.Context, id string, limit int) ([]model.RawMessage, error)
+	SaveRawMessage(ctx context.Context, msg model.RawMessage) error
+	SearchOrders(ctx context.Context, q model.SearchQuery) (model.SearchPage, error)
+	DeleteOrder(ctx context.Context, id string) error
+	ExportCustomerData(ctx context.Context, customerID string) (model.CustomerExport, error)
+	EraseCustomerData(ctx context.Context, customerID string) (model.CustomerErasure, error)
+
+	CacheStats() repo.CacheStats
+	InvalidateCache(ctx context.Context, id string) error
+	RefreshCache(ctx context.Context, id string) error
+	ClearCache(ctx context.Context) error
+	WarmupCache(ctx context.Context) error
+	WarmupStatus() repo.WarmupStatus
+	ResyncCache(ctx context.Context) error
 }
 
 // Service реализация сервиса.
@@ -57,3 +77,153 @@ func (s *Service) GetOrderByID(ctx context.Context, id string) (*model.Order, er
 	}
 	return order, err
 }
+
+// GetOrderHistory журнал изменений заказа от старых к новым. Кеш не используется
+func (s *Service) GetOrderHistory(ctx context.Context, id string, limit int) ([]model.HistoryEntry, error) {
+	h, ok := s.psqlRepo.(repo.IHistoryRepository)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return h.History(ctx, id, limit)
+}
+
+// GetOrderRawMessages исходные сообщения Kafka заказа, сначала новые. Кеш не используется
+func (s *Service) GetOrderRawMessages(ctx context.Context, id string, limit int) ([]model.RawMessage, error) {
+	raw, ok := s.psqlRepo.(repo.IRawMessageRepository)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return raw.RawMessages(ctx, id, limit)
+}
+
+// SaveRawMessage сохраняет исходное сообщение Kafka до разбора заказа. Кеш не используется
+func (s *Service) SaveRawMessage(ctx context.Context, msg model.RawMessage) error {
+	raw, ok := s.psqlRepo.(repo.IRawMessageRepository)
+	if !ok {
+		return ErrNotSupported
+	}
+	return raw.SaveRawMessage(ctx, msg)
+}
+
+// SearchOrders поиск заказов по данным доставки и позиций. Кеш не используется
+func (s *Service) SearchOrders(ctx context.Context, q model.SearchQuery) (model.SearchPage, error) {
+	sr, ok := s.psqlRepo.(repo.ISearchRepository)
+	if !ok {
+		return model.SearchPage{}, ErrNotSupported
+	}
+	return sr.Search(ctx, q)
+}
+
+// DeleteOrder мягко удаляет заказ в БД и убирает его из кеша.
+// Остальные инстансы узнают об удалении через NOTIFY order_changed
+func (s *Service) DeleteOrder(ctx context.Context, id string) error {
+	if err := s.psqlRepo.Delete(ctx, id); err != nil {
+		return err
+	}
+	return s.cacheRepo.Delete(ctx, id)
+}
+
+// ExportCustomerData все заказы покупателя с историей и исходными сообщениями. Кеш не используется,
+// выгрузка попадает в журнал аудита
+func (s *Service) ExportCustomerData(ctx context.Context, customerID string) (model.CustomerExport, error) {
+	p, ok := s.psqlRepo.(repo.IPrivacyRepository)
+	if !ok {
+		return model.CustomerExport{}, ErrNotSupported
+	}
+	return p.ExportCustomer(ctx, customerID)
+}
+
+// EraseCustomerData анонимизирует персональные данные покупателя в БД и убирает его заказы из кеша.
+// Остальные инстансы узнают об изменении через NOTIFY order_changed
+func (s *Service) EraseCustomerData(ctx context.Context, customerID string) (model.CustomerErasure, error) {
+	p, ok := s.psqlRepo.(repo.IPrivacyRepository)
+	if !ok {
+		return model.CustomerErasure{}, ErrNotSupported
+	}
+	res, err := p.EraseCustomer(ctx, customerID)
+	// При частичной ошибке шардов часть заказов уже анонимизирована: их тоже убираем из кеша
+	for _, id := range res.Orders {
+		if cacheErr := s.cacheRepo.Delete(ctx, id); cacheErr != nil {
+			err = errors.Join(err, fmt.Errorf("cache delete %s: %w", id, cacheErr))
+		}
+	}
+	return res, err
+}
+
+// CacheStats текущая статистика кеша
+func (s *Service) CacheStats() repo.CacheStats {
+	return s.cacheRepo.Stats()
+}
+
+// InvalidateCache удаляет один заказ из кеша. Следующее чтение пойдет в БД
+func (s *Service) InvalidateCache(ctx context.Context, id string) error {
+	// Следующий GetOrderByID заполнит кеш заново: он должен прочитать заказ с primary
+	s.markWritten(id)
+	return s.cacheRepo.Delete(ctx, id)
+}
+
+// RefreshCache обновляет заказ в кеше после его изменения в БД (NOTIFY). Вызывается на каждом инстансе.
+// Заказ, которого в кеше нет, не трогается: иначе каждая запись стоила бы чтения из БД всем инстансам
+// и вытесняла бы из LRU горячие заказы. Закешированный заказ перечитывается из БД, а не удаляется:
+// уведомление приходит и о записи самого инстанса, и удаление сбрасывало бы только что положенный
+// SaveOrder заказ. Заказ, которого в БД нет (или он удален), убирается из кеша.
+// Общий кеш (Redis) каждый инстанс только очищает от заказа, без рассылки инвалидации:
+// перечитывать его N раз незачем, а о самом изменении остальные узнают из своего NOTIFY
+func (s *Service) RefreshCache(ctx context.Context, id string) error {
+	// Заказ мог записать другой процесс: реплика его еще не видит, а устаревшая копия
+	// осталась бы в кеше до следующего изменения
+	s.markWritten(id)
+	if shared, ok := s.cacheRepo.(repo.ISharedCacheRepository); ok {
+		return shared.Evict(ctx, id)
+	}
+	cached, err := s.cacheRepo.Contains(ctx, id)
+	if err != nil || !cached {
+		return err
+	}
+	order, err := s.psqlRepo.GetByID(ctx, id)
+	if err != nil && !errors.Is(err, repo.ErrOrderNotFound) {
+		// Что сейчас в БД, неизвестно: промах лучше устаревшего заказа
+		return errors.Join(fmt.Errorf("refresh %s: %w", id, err), s.cacheRepo.Delete(ctx, id))
+	}
+	if order == nil {
+		return s.cacheRepo.Delete(ctx, id)
+	}
+	return s.cacheRepo.Save(ctx, order)
+}
+
+// ClearCache полностью очищает кеш
+func (s *Service) ClearCache(ctx context.Context) error {
+	return s.cacheRepo.Clear(ctx)
+}
+
+// WarmupCache заново заполняет кеш из БД без рестарта сервиса.
+// Возвращает repo.ErrWarmupInProgress, если прогрев уже идет
+func (s *Service) WarmupCache(ctx context.Context) error {
+	return s.cacheRepo.LoadFromDB(ctx, s.psqlRepo)
+}
+
+// WarmupStatus прогресс прогрева кеша
+func (s *Service) WarmupStatus() repo.WarmupStatus {
+	return s.cacheRepo.WarmupStatus()
+}
+
+// ResyncCache сбрасывает кеш этого инстанса и прогревает его заново. Нужен, когда часть уведомлений
+// об изменениях могла потеряться (например, после разрыва LISTEN-соединения).
+// Общий кеш (Redis) не очищается: после сбоя Postgres переподключаются все реплики, и каждая стирала бы
+// его заново. Его записи обновляют listener'ы остальных реплик, сбрасывается только L1
+func (s *Service) ResyncCache(ctx context.Context) error {
+	if shared, ok := s.cacheRepo.(repo.ISharedCacheRepository); ok {
+		return shared.ClearLocal(ctx)
+	}
+	if err := s.cacheRepo.Clear(ctx); err != nil {
+		return err
+	}
+	return s.cacheRepo.LoadFromDB(ctx, s.psqlRepo)
+}
+
+// markWritten направляет ближайшие чтения заказа на primary, если хранилище читает с реплик
+func (s *Service) markWritten(id string) {
+	if wt, ok := s.psqlRepo.(repo.IWriteTracker); ok {
+		wt.MarkWritten(id)
+	}
+}
@@ -0,0 +1,3 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS orders_updated_at_idx ON orders (updated_at);
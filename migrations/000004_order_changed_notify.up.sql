@@ -0,0 +1,35 @@
+-- Любое изменение заказа или его зависимых сущностей рассылает NOTIFY order_changed с order_uid.
+-- Одинаковые уведомления внутри одной транзакции Postgres схлопывает, поэтому Save дает одно сообщение.
+CREATE OR REPLACE FUNCTION notify_order_changed() RETURNS trigger AS $$
+DECLARE
+    uid UUID;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        uid := OLD.order_uid;
+    ELSE
+        uid := NEW.order_uid;
+    END IF;
+    PERFORM pg_notify('order_changed', uid::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS orders_notify_changed ON orders;
+CREATE TRIGGER orders_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON orders
+    FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+
+DROP TRIGGER IF EXISTS deliveries_notify_changed ON deliveries;
+CREATE TRIGGER deliveries_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON deliveries
+    FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+
+DROP TRIGGER IF EXISTS payments_notify_changed ON payments;
+CREATE TRIGGER payments_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON payments
+    FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+
+DROP TRIGGER IF EXISTS items_notify_changed ON items;
+CREATE TRIGGER items_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON items
+    FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS orders_date_created_idx;
+CREATE INDEX IF NOT EXISTS orders_date_created_idx ON orders (date_created DESC, order_uid);
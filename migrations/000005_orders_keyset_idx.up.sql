@@ -0,0 +1,3 @@
+-- Keyset-пагинация идет по (date_created DESC, order_uid DESC): индекс должен совпадать по направлению
+DROP INDEX IF EXISTS orders_date_created_idx;
+CREATE INDEX IF NOT EXISTS orders_date_created_idx ON orders (date_created DESC, order_uid DESC);
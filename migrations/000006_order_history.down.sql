@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS order_history;
+DROP FUNCTION IF EXISTS order_history_append_only();
@@ -0,0 +1,29 @@
+-- Журнал изменений заказа. Строки только добавляются: UPDATE/DELETE запрещены триггером,
+-- кроме сессий, явно включивших обслуживание (SET orders.history_maintenance = 'on')
+CREATE TABLE IF NOT EXISTS order_history (
+    history_id BIGSERIAL PRIMARY KEY,
+    order_uid UUID NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    op VARCHAR(16) NOT NULL,
+    source JSONB NOT NULL,
+    changes JSONB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_history_order_uid_idx ON order_history (order_uid, history_id);
+
+CREATE OR REPLACE FUNCTION order_history_append_only() RETURNS trigger AS $$
+BEGIN
+    IF current_setting('orders.history_maintenance', true) = 'on' THEN
+        IF TG_OP = 'DELETE' THEN
+            RETURN OLD;
+        END IF;
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'order_history is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS order_history_append_only ON order_history;
+CREATE TRIGGER order_history_append_only
+    BEFORE UPDATE OR DELETE ON order_history
+    FOR EACH ROW EXECUTE FUNCTION order_history_append_only();
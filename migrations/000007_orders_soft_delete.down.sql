@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS items_archive;
+DROP TABLE IF EXISTS payments_archive;
+DROP TABLE IF EXISTS deliveries_archive;
+DROP TABLE IF EXISTS orders_archive;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS deleted_at;
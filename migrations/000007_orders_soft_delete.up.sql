@@ -0,0 +1,17 @@
+-- Мягкое удаление: заказ с deleted_at не отдается чтениями, но остается в БД до retention
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- Архив для retention в режиме archive. Колонки повторяют основные таблицы без ограничений:
+-- один и тот же заказ может попасть в архив повторно, если был пересоздан после очистки
+CREATE TABLE IF NOT EXISTS orders_archive (LIKE orders);
+ALTER TABLE orders_archive ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS orders_archive_order_uid_idx ON orders_archive (order_uid);
+
+CREATE TABLE IF NOT EXISTS deliveries_archive (LIKE deliveries);
+CREATE INDEX IF NOT EXISTS deliveries_archive_order_uid_idx ON deliveries_archive (order_uid);
+
+CREATE TABLE IF NOT EXISTS payments_archive (LIKE payments);
+CREATE INDEX IF NOT EXISTS payments_archive_order_uid_idx ON payments_archive (order_uid);
+
+CREATE TABLE IF NOT EXISTS items_archive (LIKE items);
+CREATE INDEX IF NOT EXISTS items_archive_order_uid_idx ON items_archive (order_uid);
@@ -0,0 +1,79 @@
+-- Обратно в обычные таблицы. Если один order_uid оказался в нескольких партициях,
+-- остается самая свежая по updated_at версия
+ALTER TABLE orders RENAME TO orders_partitioned;
+ALTER TABLE orders_partitioned RENAME CONSTRAINT orders_pkey TO orders_partitioned_pkey;
+ALTER INDEX IF EXISTS orders_date_created_idx RENAME TO orders_partitioned_date_created_idx;
+ALTER INDEX IF EXISTS orders_updated_at_idx RENAME TO orders_partitioned_updated_at_idx;
+
+ALTER TABLE items RENAME TO items_partitioned;
+ALTER TABLE items_partitioned RENAME CONSTRAINT items_pkey TO items_partitioned_pkey;
+ALTER TABLE items_partitioned RENAME CONSTRAINT items_order_uid_chrt_id_uniq TO items_partitioned_order_uid_chrt_id_uniq;
+ALTER SEQUENCE items_item_id_seq OWNED BY NONE;
+
+CREATE TABLE orders (
+    order_uid UUID PRIMARY KEY,
+    track_number VARCHAR(64),
+    entry VARCHAR(64),
+    locale VARCHAR(8),
+    internal_signature TEXT,
+    customer_id VARCHAR(64),
+    delivery_service VARCHAR(255),
+    shardkey VARCHAR(64),
+    sm_id INT,
+    date_created TIMESTAMPTZ,
+    oof_shard VARCHAR(64),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMPTZ
+);
+
+CREATE TABLE items (
+    item_id INT PRIMARY KEY DEFAULT nextval('items_item_id_seq'),
+    order_uid UUID REFERENCES orders(order_uid),
+    chrt_id BIGINT,
+    track_number VARCHAR(64),
+    price NUMERIC(12,2),
+    rid VARCHAR(64),
+    name VARCHAR(255),
+    sale INT,
+    size VARCHAR(32),
+    total_price NUMERIC(12,2),
+    nm_id BIGINT,
+    brand VARCHAR(128),
+    status INT
+);
+ALTER SEQUENCE items_item_id_seq OWNED BY items.item_id;
+ALTER TABLE items ADD CONSTRAINT items_order_uid_chrt_id_uniq UNIQUE (order_uid, chrt_id);
+
+INSERT INTO orders
+SELECT DISTINCT ON (order_uid) order_uid, track_number, entry, locale, internal_signature, customer_id,
+    delivery_service, shardkey, sm_id, date_created, oof_shard, updated_at, deleted_at
+FROM orders_partitioned
+ORDER BY order_uid, updated_at DESC;
+
+INSERT INTO items
+SELECT i.item_id, i.order_uid, i.chrt_id, i.track_number, i.price, i.rid, i.name,
+    i.sale, i.size, i.total_price, i.nm_id, i.brand, i.status
+FROM items_partitioned i
+JOIN orders o ON o.order_uid = i.order_uid AND o.date_created = i.date_created;
+
+DROP TABLE items_partitioned;
+DROP TABLE orders_partitioned;
+DROP FUNCTION IF EXISTS ensure_order_partitions(TIMESTAMPTZ, TIMESTAMPTZ);
+
+-- Позиции и реквизиты заказов, от которых не осталось строки orders, удаляются ради внешних ключей
+DELETE FROM deliveries d WHERE NOT EXISTS (SELECT 1 FROM orders o WHERE o.order_uid = d.order_uid);
+DELETE FROM payments p WHERE NOT EXISTS (SELECT 1 FROM orders o WHERE o.order_uid = p.order_uid);
+ALTER TABLE deliveries ADD CONSTRAINT deliveries_order_uid_fkey FOREIGN KEY (order_uid) REFERENCES orders(order_uid);
+ALTER TABLE payments ADD CONSTRAINT payments_order_uid_fkey FOREIGN KEY (order_uid) REFERENCES orders(order_uid);
+
+CREATE INDEX orders_date_created_idx ON orders (date_created DESC, order_uid DESC);
+CREATE INDEX orders_updated_at_idx ON orders (updated_at);
+
+CREATE TRIGGER orders_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON orders
+    FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+CREATE TRIGGER items_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON items
+    FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+
+ALTER TABLE items_archive DROP COLUMN IF EXISTS date_created;
@@ -0,0 +1,130 @@
+-- Помесячное партиционирование orders и items по date_created (UTC).
+-- Уникальность на партиционированной таблице возможна только вместе с ключом партиционирования,
+-- поэтому PK orders становится (order_uid, date_created), а внешние ключи на orders(order_uid) снимаются.
+-- Единственность order_uid обеспечивает Save: запись заказа идет под pg_advisory_xact_lock по order_uid
+ALTER TABLE deliveries DROP CONSTRAINT IF EXISTS deliveries_order_uid_fkey;
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_order_uid_fkey;
+ALTER TABLE items DROP CONSTRAINT IF EXISTS items_order_uid_fkey;
+
+-- Старые таблицы переименовываются вместе с индексами, чтобы освободить имена
+ALTER TABLE orders RENAME TO orders_unpartitioned;
+ALTER TABLE orders_unpartitioned RENAME CONSTRAINT orders_pkey TO orders_unpartitioned_pkey;
+ALTER INDEX IF EXISTS orders_date_created_idx RENAME TO orders_unpartitioned_date_created_idx;
+ALTER INDEX IF EXISTS orders_updated_at_idx RENAME TO orders_unpartitioned_updated_at_idx;
+
+ALTER TABLE items RENAME TO items_unpartitioned;
+ALTER TABLE items_unpartitioned RENAME CONSTRAINT items_pkey TO items_unpartitioned_pkey;
+ALTER TABLE items_unpartitioned RENAME CONSTRAINT items_order_uid_chrt_id_uniq TO items_unpartitioned_order_uid_chrt_id_uniq;
+-- item_id продолжает ту же последовательность
+ALTER SEQUENCE items_item_id_seq OWNED BY NONE;
+
+CREATE TABLE orders (
+    order_uid UUID NOT NULL,
+    track_number VARCHAR(64),
+    entry VARCHAR(64),
+    locale VARCHAR(8),
+    internal_signature TEXT,
+    customer_id VARCHAR(64),
+    delivery_service VARCHAR(255),
+    shardkey VARCHAR(64),
+    sm_id INT,
+    date_created TIMESTAMPTZ NOT NULL,
+    oof_shard VARCHAR(64),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMPTZ,
+    CONSTRAINT orders_pkey PRIMARY KEY (order_uid, date_created)
+) PARTITION BY RANGE (date_created);
+
+-- date_created позиции всегда равен date_created заказа: позиции лежат в той же месячной партиции
+CREATE TABLE items (
+    item_id INT NOT NULL DEFAULT nextval('items_item_id_seq'),
+    order_uid UUID NOT NULL,
+    chrt_id BIGINT,
+    track_number VARCHAR(64),
+    price NUMERIC(12,2),
+    rid VARCHAR(64),
+    name VARCHAR(255),
+    sale INT,
+    size VARCHAR(32),
+    total_price NUMERIC(12,2),
+    nm_id BIGINT,
+    brand VARCHAR(128),
+    status INT,
+    date_created TIMESTAMPTZ NOT NULL,
+    CONSTRAINT items_pkey PRIMARY KEY (item_id, date_created),
+    CONSTRAINT items_order_uid_chrt_id_uniq UNIQUE (order_uid, chrt_id, date_created)
+) PARTITION BY RANGE (date_created);
+ALTER SEQUENCE items_item_id_seq OWNED BY items.item_id;
+
+-- Строки вне созданных месяцев попадают в default-партиции и переносятся, когда месяц создается
+CREATE TABLE orders_default PARTITION OF orders DEFAULT;
+CREATE TABLE items_default PARTITION OF items DEFAULT;
+
+-- ensure_order_partitions создает месячные партиции orders_pYYYY_MM и items_pYYYY_MM
+-- для всех месяцев от from_ts до to_ts включительно. Строки этих месяцев из default-партиций
+-- переносятся в новую партицию до ATTACH. Возвращает число созданных партиций
+CREATE OR REPLACE FUNCTION ensure_order_partitions(from_ts TIMESTAMPTZ, to_ts TIMESTAMPTZ) RETURNS INTEGER AS $$
+DECLARE
+    m TIMESTAMP := date_trunc('month', from_ts AT TIME ZONE 'UTC');
+    lo TIMESTAMPTZ;
+    hi TIMESTAMPTZ;
+    tbl TEXT;
+    part TEXT;
+    created INTEGER := 0;
+BEGIN
+    -- Реплики сервиса и cron могут вызвать функцию одновременно
+    PERFORM pg_advisory_xact_lock(hashtext('ensure_order_partitions'));
+    WHILE m <= to_ts AT TIME ZONE 'UTC' LOOP
+        lo := m AT TIME ZONE 'UTC';
+        hi := (m + INTERVAL '1 month') AT TIME ZONE 'UTC';
+        FOREACH tbl IN ARRAY ARRAY['orders', 'items'] LOOP
+            part := format('%s_p%s', tbl, to_char(m, 'YYYY_MM'));
+            IF to_regclass(part) IS NULL THEN
+                EXECUTE format('CREATE TABLE %I (LIKE %I INCLUDING DEFAULTS)', part, tbl);
+                EXECUTE format(
+                    'WITH moved AS (DELETE FROM %I WHERE date_created >= %L AND date_created < %L RETURNING *) '
+                    'INSERT INTO %I SELECT * FROM moved', tbl || '_default', lo, hi, part);
+                EXECUTE format('ALTER TABLE %I ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)', tbl, part, lo, hi);
+                created := created + 1;
+            END IF;
+        END LOOP;
+        m := m + INTERVAL '1 month';
+    END LOOP;
+    RETURN created;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Партиции под существующие данные и на три месяца вперед
+SELECT ensure_order_partitions(
+    COALESCE((SELECT min(COALESCE(date_created, updated_at)) FROM orders_unpartitioned), now()),
+    now() + INTERVAL '3 months'
+);
+
+INSERT INTO orders (order_uid, track_number, entry, locale, internal_signature, customer_id,
+    delivery_service, shardkey, sm_id, date_created, oof_shard, updated_at, deleted_at)
+SELECT order_uid, track_number, entry, locale, internal_signature, customer_id,
+    delivery_service, shardkey, sm_id, COALESCE(date_created, updated_at), oof_shard, updated_at, deleted_at
+FROM orders_unpartitioned;
+
+INSERT INTO items (item_id, order_uid, chrt_id, track_number, price, rid, name,
+    sale, size, total_price, nm_id, brand, status, date_created)
+SELECT i.item_id, i.order_uid, i.chrt_id, i.track_number, i.price, i.rid, i.name,
+    i.sale, i.size, i.total_price, i.nm_id, i.brand, i.status, o.date_created
+FROM items_unpartitioned i
+JOIN orders o ON o.order_uid = i.order_uid;
+
+DROP TABLE items_unpartitioned;
+DROP TABLE orders_unpartitioned;
+
+-- Индексы и триггеры создаются на родителе и наследуются всеми партициями, в том числе будущими
+CREATE INDEX orders_date_created_idx ON orders (date_created DESC, order_uid DESC);
+CREATE INDEX orders_updated_at_idx ON orders (updated_at);
+
+CREATE TRIGGER orders_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON orders
+    FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+CREATE TRIGGER items_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON items
+    FOR EACH ROW EXECUTE FUNCTION notify_order_changed();
+
+ALTER TABLE items_archive ADD COLUMN IF NOT EXISTS date_created TIMESTAMPTZ;
@@ -0,0 +1,7 @@
+-- Каталог order_uid -> шард для шардированного хранилища (DB_SHARDS).
+-- Используется только в БД каталога (DB_HOST/DB_NAME), на шардах таблица остается пустой
+CREATE TABLE IF NOT EXISTS order_shards (
+    order_uid UUID PRIMARY KEY,
+    shard VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
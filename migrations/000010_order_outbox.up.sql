@@ -0,0 +1,13 @@
+-- Transactional outbox: событие пишется в транзакции записи заказа, relay публикует его в Kafka.
+-- Опубликованные строки удаляются relay через OUTBOX_RETENTION
+CREATE TABLE IF NOT EXISTS order_outbox (
+    event_id BIGSERIAL PRIMARY KEY,
+    order_uid UUID NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    published_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS order_outbox_pending_idx ON order_outbox (event_id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS order_outbox_published_at_idx ON order_outbox (published_at) WHERE published_at IS NOT NULL;
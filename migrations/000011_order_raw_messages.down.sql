@@ -0,0 +1 @@
+DROP TABLE IF EXISTS order_raw_messages;
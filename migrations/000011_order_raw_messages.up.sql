@@ -0,0 +1,17 @@
+-- Исходные сообщения Kafka, из которых сохранены заказы: тело (JSONB), метаданные и версия схемы.
+-- Одна строка на сообщение; повторная доставка того же offset строку не дублирует
+CREATE TABLE IF NOT EXISTS order_raw_messages (
+    raw_id BIGSERIAL PRIMARY KEY,
+    order_uid UUID NOT NULL,
+    payload JSONB NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    kafka_partition INT NOT NULL,
+    kafka_offset BIGINT NOT NULL,
+    headers JSONB NOT NULL DEFAULT '[]',
+    message_time TIMESTAMPTZ,
+    schema_version INT NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT order_raw_messages_offset_uniq UNIQUE (topic, kafka_partition, kafka_offset)
+);
+
+CREATE INDEX IF NOT EXISTS order_raw_messages_order_uid_idx ON order_raw_messages (order_uid, raw_id);
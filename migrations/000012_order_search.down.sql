@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS items_brand_trgm_idx;
+DROP INDEX IF EXISTS items_name_trgm_idx;
+DROP INDEX IF EXISTS items_search_fts_idx;
+DROP INDEX IF EXISTS deliveries_city_trgm_idx;
+DROP INDEX IF EXISTS deliveries_email_trgm_idx;
+DROP INDEX IF EXISTS deliveries_phone_trgm_idx;
+DROP INDEX IF EXISTS deliveries_name_trgm_idx;
+DROP INDEX IF EXISTS deliveries_search_fts_idx;
+-- Расширение pg_trgm не удаляется: им могут пользоваться другие объекты БД
@@ -0,0 +1,16 @@
+-- Поиск заказов: полнотекстовый (целые слова) и триграммный (подстроки, опечатки).
+-- Выражения индексов должны совпадать с условиями repository.searchSQL
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS deliveries_search_fts_idx ON deliveries
+    USING GIN (to_tsvector('simple', coalesce(name, '') || ' ' || coalesce(email, '') || ' ' || coalesce(city, '')));
+CREATE INDEX IF NOT EXISTS deliveries_name_trgm_idx ON deliveries USING GIN (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS deliveries_phone_trgm_idx ON deliveries USING GIN (phone gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS deliveries_email_trgm_idx ON deliveries USING GIN (email gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS deliveries_city_trgm_idx ON deliveries USING GIN (city gin_trgm_ops);
+
+-- items партиционирована: индексы создаются на каждой партиции, в том числе будущих
+CREATE INDEX IF NOT EXISTS items_search_fts_idx ON items
+    USING GIN (to_tsvector('simple', coalesce(name, '') || ' ' || coalesce(brand, '')));
+CREATE INDEX IF NOT EXISTS items_name_trgm_idx ON items USING GIN (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS items_brand_trgm_idx ON items USING GIN (brand gin_trgm_ops);
@@ -0,0 +1,27 @@
+-- Откат удаляет шифротексты: имя, телефон, адрес и email зашифрованных строк теряются
+DROP INDEX IF EXISTS deliveries_archive_delivery_id_idx;
+DROP INDEX IF EXISTS deliveries_email_bidx_idx;
+DROP INDEX IF EXISTS deliveries_phone_bidx_idx;
+DROP INDEX IF EXISTS deliveries_name_bidx_idx;
+
+ALTER TABLE deliveries_archive
+    DROP COLUMN IF EXISTS name_enc,
+    DROP COLUMN IF EXISTS phone_enc,
+    DROP COLUMN IF EXISTS address_enc,
+    DROP COLUMN IF EXISTS email_enc,
+    DROP COLUMN IF EXISTS pii_key,
+    DROP COLUMN IF EXISTS pii_key_id,
+    DROP COLUMN IF EXISTS name_bidx,
+    DROP COLUMN IF EXISTS phone_bidx,
+    DROP COLUMN IF EXISTS email_bidx;
+
+ALTER TABLE deliveries
+    DROP COLUMN IF EXISTS name_enc,
+    DROP COLUMN IF EXISTS phone_enc,
+    DROP COLUMN IF EXISTS address_enc,
+    DROP COLUMN IF EXISTS email_enc,
+    DROP COLUMN IF EXISTS pii_key,
+    DROP COLUMN IF EXISTS pii_key_id,
+    DROP COLUMN IF EXISTS name_bidx,
+    DROP COLUMN IF EXISTS phone_bidx,
+    DROP COLUMN IF EXISTS email_bidx;
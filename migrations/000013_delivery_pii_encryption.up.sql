@@ -0,0 +1,32 @@
+-- Шифрование персональных данных доставки (envelope encryption). При включенных ключах PII
+-- name, phone, address, email пишутся пустыми, значения лежат в *_enc (AES-GCM ключом данных),
+-- ключ данных - в pii_key, зашифрованный мастер-ключом pii_key_id.
+-- *_bidx - HMAC нормализованного значения для поиска точного совпадения
+ALTER TABLE deliveries
+    ADD COLUMN IF NOT EXISTS name_enc BYTEA,
+    ADD COLUMN IF NOT EXISTS phone_enc BYTEA,
+    ADD COLUMN IF NOT EXISTS address_enc BYTEA,
+    ADD COLUMN IF NOT EXISTS email_enc BYTEA,
+    ADD COLUMN IF NOT EXISTS pii_key BYTEA,
+    ADD COLUMN IF NOT EXISTS pii_key_id VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS name_bidx BYTEA,
+    ADD COLUMN IF NOT EXISTS phone_bidx BYTEA,
+    ADD COLUMN IF NOT EXISTS email_bidx BYTEA;
+
+CREATE INDEX IF NOT EXISTS deliveries_name_bidx_idx ON deliveries (name_bidx) WHERE name_bidx IS NOT NULL;
+CREATE INDEX IF NOT EXISTS deliveries_phone_bidx_idx ON deliveries (phone_bidx) WHERE phone_bidx IS NOT NULL;
+CREATE INDEX IF NOT EXISTS deliveries_email_bidx_idx ON deliveries (email_bidx) WHERE email_bidx IS NOT NULL;
+
+-- Архив хранит доставки в том же виде; rotate-keys обходит его по delivery_id
+ALTER TABLE deliveries_archive
+    ADD COLUMN IF NOT EXISTS name_enc BYTEA,
+    ADD COLUMN IF NOT EXISTS phone_enc BYTEA,
+    ADD COLUMN IF NOT EXISTS address_enc BYTEA,
+    ADD COLUMN IF NOT EXISTS email_enc BYTEA,
+    ADD COLUMN IF NOT EXISTS pii_key BYTEA,
+    ADD COLUMN IF NOT EXISTS pii_key_id VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS name_bidx BYTEA,
+    ADD COLUMN IF NOT EXISTS phone_bidx BYTEA,
+    ADD COLUMN IF NOT EXISTS email_bidx BYTEA;
+
+CREATE INDEX IF NOT EXISTS deliveries_archive_delivery_id_idx ON deliveries_archive (delivery_id);
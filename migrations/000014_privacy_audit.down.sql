@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS orders_archive_customer_id_idx;
+DROP INDEX IF EXISTS orders_customer_id_idx;
+DROP TABLE IF EXISTS privacy_audit;
+DROP FUNCTION IF EXISTS privacy_audit_append_only();
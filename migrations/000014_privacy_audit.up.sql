@@ -0,0 +1,27 @@
+-- Журнал запросов покупателей к их персональным данным (GDPR): выгрузки и удаления.
+-- Строки только добавляются, UPDATE/DELETE запрещены триггером
+CREATE TABLE IF NOT EXISTS privacy_audit (
+    audit_id BIGSERIAL PRIMARY KEY,
+    op VARCHAR(16) NOT NULL,
+    customer_id VARCHAR(64) NOT NULL,
+    source JSONB NOT NULL,
+    order_uids UUID[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS privacy_audit_customer_id_idx ON privacy_audit (customer_id, audit_id);
+
+CREATE OR REPLACE FUNCTION privacy_audit_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'privacy_audit is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS privacy_audit_append_only ON privacy_audit;
+CREATE TRIGGER privacy_audit_append_only
+    BEFORE UPDATE OR DELETE ON privacy_audit
+    FOR EACH ROW EXECUTE FUNCTION privacy_audit_append_only();
+
+-- Заказы покупателя ищутся по customer_id в основных таблицах и в архиве
+CREATE INDEX IF NOT EXISTS orders_customer_id_idx ON orders (customer_id);
+CREATE INDEX IF NOT EXISTS orders_archive_customer_id_idx ON orders_archive (customer_id);
@@ -0,0 +1,3 @@
+-- Relay публикует порцию вне транзакции: перед публикацией строки порции получают аренду claimed_until.
+-- Пока аренда не истекла, другой relay следующую порцию не берет, и порядок событий сохраняется
+ALTER TABLE order_outbox ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMPTZ;
@@ -0,0 +1,6 @@
+-- Отклоненные сообщения в старой схеме не помещаются: у них может не быть order_uid и валидного JSON
+DROP INDEX IF EXISTS order_raw_messages_rejected_idx;
+DELETE FROM order_raw_messages WHERE order_uid IS NULL OR rejection IS NOT NULL;
+ALTER TABLE order_raw_messages DROP COLUMN IF EXISTS rejection;
+ALTER TABLE order_raw_messages ALTER COLUMN payload TYPE JSONB USING convert_from(payload, 'UTF8')::jsonb;
+ALTER TABLE order_raw_messages ALTER COLUMN order_uid SET NOT NULL;
@@ -0,0 +1,9 @@
+-- Исходные сообщения сохраняются до разбора, в том числе отклоненные. Тело хранится байт в байт (BYTEA),
+-- order_uid заполняется, только если его удалось извлечь из тела. rejection - причина отклонения
+ALTER TABLE order_raw_messages ALTER COLUMN order_uid DROP NOT NULL;
+ALTER TABLE order_raw_messages ALTER COLUMN payload TYPE BYTEA USING convert_to(payload::text, 'UTF8');
+ALTER TABLE order_raw_messages ADD COLUMN IF NOT EXISTS rejection TEXT;
+
+-- Для retention: отклоненные сообщения и сообщения без заказа удаляются по received_at
+CREATE INDEX IF NOT EXISTS order_raw_messages_rejected_idx ON order_raw_messages (received_at)
+    WHERE order_uid IS NULL OR rejection IS NOT NULL;
@@ -0,0 +1,11 @@
+-- Зашифрованные строки без ключей не прочитать: исходные сообщения удаляются,
+-- события outbox остаются с замаскированным payload
+DELETE FROM order_raw_messages WHERE pii_key IS NOT NULL;
+ALTER TABLE order_raw_messages
+    DROP COLUMN IF EXISTS pii_key_id,
+    DROP COLUMN IF EXISTS pii_key;
+
+ALTER TABLE order_outbox
+    DROP COLUMN IF EXISTS pii_key_id,
+    DROP COLUMN IF EXISTS pii_key,
+    DROP COLUMN IF EXISTS payload_enc;
@@ -0,0 +1,12 @@
+-- Шифрование персональных данных в событиях outbox и исходных сообщениях (envelope encryption, как у доставки).
+-- order_outbox: при включенных ключах PII payload хранит заказ с "***" вместо персональных полей,
+-- полный payload - в payload_enc. order_raw_messages: payload - шифротекст тела сообщения.
+-- Ключ данных - в pii_key, зашифрованный мастер-ключом pii_key_id; строки с pii_key IS NULL - открытый текст
+ALTER TABLE order_outbox
+    ADD COLUMN IF NOT EXISTS payload_enc BYTEA,
+    ADD COLUMN IF NOT EXISTS pii_key BYTEA,
+    ADD COLUMN IF NOT EXISTS pii_key_id VARCHAR(64);
+
+ALTER TABLE order_raw_messages
+    ADD COLUMN IF NOT EXISTS pii_key BYTEA,
+    ADD COLUMN IF NOT EXISTS pii_key_id VARCHAR(64);
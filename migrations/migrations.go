@@ -0,0 +1,9 @@
+// Package migrations SQL-миграции схемы, встроенные в бинарник. Применяются через internal/migrate
+package migrations
+
+import "embed"
+
+// FS файлы вида 000001_name.up.sql / 000001_name.down.sql
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,64 @@
+-- Аналог migrations/000001_create_tables.up.sql. Типы Postgres в SQLite:
+--   UUID, VARCHAR -> TEXT;
+--   SERIAL -> INTEGER PRIMARY KEY AUTOINCREMENT: id не переиспользуются, как у последовательности;
+--   NUMERIC(12,2) -> TEXT: NUMERIC-аффинити SQLite превращает сумму в REAL, а model.Money пишется строкой без потерь;
+--   TIMESTAMPTZ -> INTEGER, микросекунды с начала эпохи UTC: точность Postgres и верный порядок сравнения
+CREATE TABLE orders (
+    order_uid TEXT PRIMARY KEY,
+    track_number TEXT,
+    entry TEXT,
+    locale TEXT,
+    internal_signature TEXT,
+    customer_id TEXT,
+    delivery_service TEXT,
+    shardkey TEXT,
+    sm_id INTEGER,
+    date_created INTEGER,
+    oof_shard TEXT
+);
+
+CREATE TABLE deliveries (
+    delivery_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_uid TEXT REFERENCES orders(order_uid),
+    name TEXT,
+    phone TEXT,
+    zip TEXT,
+    city TEXT,
+    address TEXT,
+    region TEXT,
+    email TEXT,
+    CONSTRAINT deliveries_order_uid_uniq UNIQUE (order_uid)
+);
+
+CREATE TABLE payments (
+    payment_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_uid TEXT REFERENCES orders(order_uid),
+    "transaction" TEXT,
+    request_id TEXT,
+    currency TEXT,
+    provider TEXT,
+    amount TEXT,
+    payment_dt INTEGER,
+    bank TEXT,
+    delivery_cost TEXT,
+    goods_total INTEGER,
+    custom_fee TEXT,
+    CONSTRAINT payments_order_uid_uniq UNIQUE (order_uid)
+);
+
+CREATE TABLE items (
+    item_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_uid TEXT REFERENCES orders(order_uid),
+    chrt_id INTEGER,
+    track_number TEXT,
+    price TEXT,
+    rid TEXT,
+    name TEXT,
+    sale INTEGER,
+    size TEXT,
+    total_price TEXT,
+    nm_id INTEGER,
+    brand TEXT,
+    status INTEGER,
+    CONSTRAINT items_order_uid_chrt_id_uniq UNIQUE (order_uid, chrt_id)
+);
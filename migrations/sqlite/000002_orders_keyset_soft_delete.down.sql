@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS orders_updated_at_idx;
+DROP INDEX IF EXISTS orders_date_created_idx;
+
+ALTER TABLE orders DROP COLUMN deleted_at;
+ALTER TABLE orders DROP COLUMN updated_at;
@@ -0,0 +1,7 @@
+-- Аналог Postgres-миграций 000002, 000003, 000005 и 000007 (без архивных таблиц):
+-- keyset-индекс списков, updated_at для синхронизации кеша и мягкое удаление
+ALTER TABLE orders ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN deleted_at INTEGER;
+
+CREATE INDEX IF NOT EXISTS orders_date_created_idx ON orders (date_created DESC, order_uid DESC);
+CREATE INDEX IF NOT EXISTS orders_updated_at_idx ON orders (updated_at);
@@ -0,0 +1,10 @@
+// Package sqlite SQL-миграции схемы для встроенной SQLite (DB_BACKEND=sqlite).
+// Схема повторяет Postgres-миграции в части, нужной SQLiteRepository: заказы, доставка, оплата, позиции
+package sqlite
+
+import "embed"
+
+// FS файлы вида 000001_name.up.sql / 000001_name.down.sql
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,128 @@
+package ordermodel
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Example валидный заказ с одной позицией и фиксированными данными. Основа тестовых заказов:
+// тесты меняют в копии только то поле, которое проверяют
+func Example(uid string) *Order {
+	return &Order{
+		OrderUID:    uid,
+		CustomerID:  "cust-001",
+		DateCreated: time.Now().UTC(),
+		OofShard:    "1",
+		Delivery: Delivery{
+			Name:    "Alice",
+			Phone:   "+1234567890",
+			Zip:     "10001",
+			City:    "NY",
+			Address: "5th Avenue, 1",
+			Region:  "NY",
+			Email:   "alice@example.com",
+		},
+		Payment: Payment{
+			Transaction:  "trx-001",
+			Currency:     "USD",
+			Provider:     "visa",
+			Amount:       NewMoney(149, 90),
+			PaymentDt:    1712345678,
+			Bank:         "Chase",
+			DeliveryCost: 0,
+			GoodsTotal:   1,
+			CustomFee:    0,
+		},
+		Items: []Item{
+			{
+				ChrtID:      1,                 // required,gte=1
+				TrackNumber: "TRK123",          // required
+				Price:       NewMoney(149, 90), // gte=0
+				Sale:        0,                 // 0..100
+				TotalPrice:  NewMoney(149, 90), // gte=0
+				NmID:        1,                 // gte=0
+				Status:      0,                 // gte=0
+			},
+		},
+	}
+}
+
+// Generate случайный валидный заказ, созданный в момент now: 1-3 позиции, случайные доставка и сбор.
+// Суммы сходятся (Validate проходит), одинаковый r дает одинаковую последовательность заказов
+func Generate(r *rand.Rand, now time.Time) *Order {
+	now = now.UTC()
+	uid := randomUUID(r)
+
+	itemCount := r.IntN(3) + 1
+	items := make([]Item, 0, itemCount)
+	var goods Money
+	for i := 0; i < itemCount; i++ {
+		price := Money(r.IntN(200_000) + 100)
+		sale := r.IntN(50)
+		total := price - price*Money(sale)/100
+		items = append(items, Item{
+			ChrtID:      int64(r.IntN(1_000_000) + 1),
+			TrackNumber: fmt.Sprintf("TRK%06d", r.IntN(1_000_000)),
+			Price:       price,
+			Rid:         fmt.Sprintf("RID%04d", r.IntN(10000)),
+			Name:        fmt.Sprintf("Item-%d", r.IntN(1000)),
+			Sale:        sale,
+			Size:        "M",
+			TotalPrice:  total,
+			NmID:        int64(r.IntN(1_000_000)),
+			Brand:       "BrandX",
+			Status:      202,
+		})
+		goods = goods.Add(total)
+	}
+	deliveryCost := Money(r.IntN(50_000))
+	customFee := Money(r.IntN(1_000))
+
+	return &Order{
+		OrderUID:          uid,
+		TrackNumber:       fmt.Sprintf("TRACK-%s", uid[:8]),
+		Entry:             "WEB",
+		Locale:            "en-GB",
+		InternalSignature: "",
+		CustomerID:        fmt.Sprintf("cust-%d", r.IntN(100000)),
+		DeliveryService:   "dhl",
+		Shardkey:          fmt.Sprintf("%d", r.IntN(10)),
+		SmID:              r.IntN(1000),
+		DateCreated:       now,
+		OofShard:          "1",
+		Delivery: Delivery{
+			Name:    "Ivan Ivanov",
+			Phone:   "+79991234567",
+			Zip:     "123456",
+			City:    "Moscow",
+			Address: "Lenina, 1",
+			Region:  "Moscow",
+			Email:   "ivan@example.com",
+		},
+		Payment: Payment{
+			Transaction:  fmt.Sprintf("tx-%s", uid[:8]),
+			RequestID:    "",
+			Currency:     "RUB",
+			Provider:     "bank",
+			Amount:       SumMoney(goods, deliveryCost, customFee),
+			PaymentDt:    now.Unix(),
+			Bank:         "BigBank",
+			DeliveryCost: deliveryCost,
+			GoodsTotal:   int(goods.Cents() / 100),
+			CustomFee:    customFee,
+		},
+		Items: items,
+	}
+}
+
+// randomUUID UUID версии 4 из r: order_uid в Postgres имеет тип uuid
+func randomUUID(r *rand.Rand) string {
+	var b [16]byte
+	for i := range b {
+		b[i] = byte(r.Uint32())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
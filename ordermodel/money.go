@@ -0,0 +1,334 @@
+package ordermodel
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money денежная сумма с фиксированной точкой: целое число сотых долей единицы валюты.
+// Масштаб совпадает с NUMERIC(12,2) в БД, поэтому сумма проходит JSON -> Go -> Postgres без округлений float64.
+// В JSON пишется числом в том же виде, что и прежний float64 (1817, 149.9).
+// Валюты с тремя знаками (KWD, BHD) в сотых не представимы и не поддерживаются
+type Money int64
+
+// moneyScale сотых долей в одной единице валюты, moneyExponent - знаков после запятой
+const (
+	moneyScale    = 100
+	moneyExponent = 2
+)
+
+// ErrMoneyFormat строка не является денежной суммой
+var ErrMoneyFormat = errors.New("invalid money format")
+
+// ErrMoneyPrecision сумма не представима в минимальных единицах валюты (например, копейки у JPY)
+// или в сотых долях Money (149.905)
+var ErrMoneyPrecision = errors.New("money precision exceeds currency minor unit")
+
+// ErrCurrencyNotSupported минимальная единица валюты мельче сотых (KWD, BHD): Money ее не хранит
+var ErrCurrencyNotSupported = errors.New("currency minor unit is finer than hundredths")
+
+// ErrAmountMismatch сумма оплаты не сходится с позициями, доставкой и сбором
+var ErrAmountMismatch = errors.New("payment amount mismatch")
+
+// ErrDuplicateItem в заказе несколько позиций с одним chrt_id
+var ErrDuplicateItem = errors.New("duplicate item chrt_id")
+
+// currencyExponents валюты ISO 4217, у которых число знаков после запятой отличается от 2
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// CurrencyExponent число знаков минимальной единицы валюты (USD - 2, JPY - 0, KWD - 3).
+// Неизвестные валюты считаются двухзнаковыми. Валюты с числом знаков больше 2 Money не поддерживает
+func CurrencyExponent(currency string) int {
+	if e, ok := currencyExponents[strings.ToUpper(currency)]; ok {
+		return e
+	}
+	return 2
+}
+
+// NewMoney сумма из целых единиц и сотых: NewMoney(149, 90) = 149.90
+func NewMoney(units, cents int64) Money {
+	return Money(units*moneyScale + cents)
+}
+
+// MoneyFromFloat округляет float64 до сотых (половина - от нуля). Только для старого кода и генераторов
+func MoneyFromFloat(f float64) Money {
+	return Money(math.Round(f * moneyScale))
+}
+
+// maxMoneyExponent предел положительного порядка в экспоненциальной записи: больше - заведомо вне
+// диапазона Money, а строка сдвига росла бы без ограничений
+const maxMoneyExponent = 100
+
+// floatNoiseDigits сколько значащих цифр должно быть в числе, чтобы знаки после сотых считались
+// хвостом двоичного округления float64: shortest round-trip выводит такой хвост на 16-17 цифре
+const floatNoiseDigits = 15
+
+// shiftDecimal переписывает mantissa*10^exp без экспоненты: ("1.5", "-3") -> "0.0015"
+func shiftDecimal(mantissa, exp string) (string, error) {
+	e, err := strconv.Atoi(exp)
+	if err != nil || e > maxMoneyExponent {
+		return "", ErrMoneyFormat
+	}
+	sign := ""
+	if mantissa != "" && (mantissa[0] == '-' || mantissa[0] == '+') {
+		sign, mantissa = mantissa[:1], mantissa[1:]
+	}
+	intPart, fracPart, _ := strings.Cut(mantissa, ".")
+	if (intPart == "" && fracPart == "") || !isDigits(intPart) || !isDigits(fracPart) {
+		return "", ErrMoneyFormat
+	}
+	digits := intPart + fracPart
+	point := len(intPart) + e
+	switch {
+	case point < -moneyExponent:
+		// Меньше 0.001: в сотых представим только ноль
+		if strings.Trim(digits, "0") != "" {
+			return "", ErrMoneyPrecision
+		}
+		return sign + "0", nil
+	case point <= 0:
+		return sign + "0." + strings.Repeat("0", -point) + digits, nil
+	case point >= len(digits):
+		return sign + digits + strings.Repeat("0", point-len(digits)), nil
+	default:
+		return sign + digits[:point] + "." + digits[point:], nil
+	}
+}
+
+// floatNoise знаки после сотых extra (без нулей в конце) - хвост float64-арифметики старого producer
+// (31.450000000000003, 10.129999999999999): число длинное, а хвост - серия нулей или девяток и
+// не больше двух последних цифр. up - хвост из девяток, сотые округляются вверх
+func floatNoise(extra string, significant int) (up, ok bool) {
+	if significant < floatNoiseDigits || (extra[0] != '0' && extra[0] != '9') {
+		return false, false
+	}
+	rest := strings.TrimLeft(extra, extra[:1])
+	return extra[0] == '9', len(rest) <= 2
+}
+
+// ParseMoney разбирает десятичную строку ("149.9", "-3", "0.05") без float64.
+// Больше двух знаков после запятой - ErrMoneyPrecision: сумма не округляется молча.
+// Исключение - хвост двоичного округления от producer на float64 (см. floatNoise), он отбрасывается
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("%w: empty", ErrMoneyFormat)
+	}
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		// Экспоненциальная запись встречается у очень больших/малых float в JSON.
+		// Сдвигаем запятую в строке: через float64 вернулся бы дрейф округления
+		shifted, err := shiftDecimal(s[:i], s[i+1:])
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", err, s)
+		}
+		s = shifted
+	}
+
+	neg := false
+	switch s[0] {
+	case '-':
+		neg = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" {
+		return 0, fmt.Errorf("%w: %q", ErrMoneyFormat, s)
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, fmt.Errorf("%w: %q", ErrMoneyFormat, s)
+	}
+
+	var units int64
+	if intPart != "" {
+		v, err := strconv.ParseInt(intPart, 10, 64)
+		if err != nil || v > math.MaxInt64/moneyScale-1 {
+			return 0, fmt.Errorf("%w: %q out of range", ErrMoneyFormat, s)
+		}
+		units = v
+	}
+
+	frac := fracPart + "00"
+	cents := int64(frac[0]-'0')*10 + int64(frac[1]-'0')
+	if extra := strings.TrimRight(frac[moneyExponent:], "0"); extra != "" {
+		up, ok := floatNoise(extra, len(strings.Trim(intPart+fracPart, "0")))
+		if !ok {
+			return 0, fmt.Errorf("%w: %q has more than %d decimal places", ErrMoneyPrecision, s, moneyExponent)
+		}
+		if up {
+			cents++
+		}
+	}
+
+	m := Money(units*moneyScale + cents)
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
+
+// MustMoney как ParseMoney, но паникует на ошибке. Для констант и тестов
+func MustMoney(s string) Money {
+	m, err := ParseMoney(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Cents сумма в сотых долях
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+// Float64 приближенное значение. Для арифметики использовать методы Money
+func (m Money) Float64() float64 {
+	return float64(m) / moneyScale
+}
+
+// Add сумма m + o
+func (m Money) Add(o Money) Money {
+	return m + o
+}
+
+// Sub разность m - o
+func (m Money) Sub(o Money) Money {
+	return m - o
+}
+
+// Mul умножение на количество
+func (m Money) Mul(n int64) Money {
+	return m * Money(n)
+}
+
+// IsNegative сумма меньше нуля
+func (m Money) IsNegative() bool {
+	return m < 0
+}
+
+// SumMoney сумма нескольких значений
+func SumMoney(values ...Money) Money {
+	var s Money
+	for _, v := range values {
+		s += v
+	}
+	return s
+}
+
+// MinorUnits сумма в минимальных единицах валюты: центы для USD, иены для JPY.
+// Если сумма не представима (дробные иены), возвращает ErrMoneyPrecision,
+// для валют с минимальной единицей мельче сотых (KWD) - ErrCurrencyNotSupported
+func (m Money) MinorUnits(currency string) (int64, error) {
+	switch e := CurrencyExponent(currency); {
+	case e > moneyExponent:
+		return 0, fmt.Errorf("%w: %s", ErrCurrencyNotSupported, currency)
+	case e == 0:
+		if m%moneyScale != 0 {
+			return 0, fmt.Errorf("%w: %s %s", ErrMoneyPrecision, m, currency)
+		}
+		return int64(m / moneyScale), nil
+	default:
+		return int64(m), nil
+	}
+}
+
+// String каноничный вид с двумя знаками: "149.90", "-0.05"
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/moneyScale, v%moneyScale)
+}
+
+// Format сумма с числом знаков, принятым для валюты: "1500 JPY", "149.90 USD"
+func (m Money) Format(currency string) string {
+	if CurrencyExponent(currency) == 0 && m%moneyScale == 0 {
+		return strconv.FormatInt(int64(m/moneyScale), 10) + " " + currency
+	}
+	return m.String() + " " + currency
+}
+
+// MarshalJSON число без лишних нулей: 1817, 149.9, 0.05 - так же, как раньше выглядел float64
+func (m Money) MarshalJSON() ([]byte, error) {
+	s := m.String()
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	if s == "" || s == "-" {
+		s = "0"
+	}
+	return []byte(s), nil
+}
+
+// UnmarshalJSON принимает число или строку с числом. null оставляет значение без изменений
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Value пишет сумму в NUMERIC строкой, без float64
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan читает NUMERIC ([]byte/string от pq), а также целые и float значения
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case []byte:
+		p, err := ParseMoney(string(v))
+		if err != nil {
+			return err
+		}
+		*m = p
+		return nil
+	case string:
+		p, err := ParseMoney(v)
+		if err != nil {
+			return err
+		}
+		*m = p
+		return nil
+	case int64:
+		*m = Money(v * moneyScale)
+		return nil
+	case float64:
+		*m = MoneyFromFloat(v)
+		return nil
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrMoneyFormat, src)
+	}
+}
@@ -0,0 +1,198 @@
+// Package ordermodel - схема сообщения заказа, общая для сервиса и producer: модели для маршалинга в json
+// и сохранения в бд, правила валидации, Money, версия схемы и тестовые заказы.
+// Отдельный модуль: producer подключает его без зависимостей сервиса
+package ordermodel
+
+import (
+	"fmt"
+	"time"
+)
+
+// Order модель заказа
+type Order struct {
+	OrderUID          string    `json:"order_uid" db:"order_uid" validate:"required"`
+	TrackNumber       string    `json:"track_number" db:"track_number" validate:"-"`
+	Entry             string    `json:"entry" db:"entry" validate:"-"`
+	Locale            string    `json:"locale" db:"locale" validate:"-"`
+	InternalSignature string    `json:"internal_signature" db:"internal_signature" validate:"-"`
+	CustomerID        string    `json:"customer_id" db:"customer_id" validate:"required"`
+	DeliveryService   string    `json:"delivery_service" db:"delivery_service" validate:"-"`
+	Shardkey          string    `json:"shardkey" db:"shardkey" validate:"-"`
+	SmID              int       `json:"sm_id" db:"sm_id" validate:"gte=0"`
+	DateCreated       time.Time `json:"date_created" db:"date_created" validate:"required"`
+	OofShard          string    `json:"oof_shard" db:"oof_shard" validate:"required"`
+	// DeletedAt время мягкого удаления. Не приходит из Kafka и не отдается в API
+	DeletedAt *time.Time `json:"-" db:"deleted_at" validate:"-"`
+
+	Delivery Delivery `json:"delivery" validate:"required"`
+	Payment  Payment  `json:"payment"  validate:"required"`
+	Items    []Item   `json:"items"    validate:"required,min=1,dive"`
+}
+
+// Delivery модель поля delivery из модели Order`а
+type Delivery struct {
+	DeliveryID int    `json:"-" db:"delivery_id" validate:"-"`
+	OrderUID   string `json:"-" db:"order_uid"    validate:"-"`
+	Name       string `json:"name" db:"name"       validate:"required"`
+	Phone      string `json:"phone" db:"phone"     validate:"required"`
+	Zip        string `json:"zip" db:"zip"         validate:"required"`
+	City       string `json:"city" db:"city"       validate:"required"`
+	Address    string `json:"address" db:"address" validate:"required"`
+	Region     string `json:"region" db:"region"   validate:"required"`
+	Email      string `json:"email" db:"email"     validate:"required,email"`
+}
+
+// Payment модель поля payment из модели Order`а
+type Payment struct {
+	PaymentID    int    `json:"-" db:"payment_id" validate:"-"`
+	OrderUID     string `json:"-" db:"order_uid"   validate:"-"`
+	Transaction  string `json:"transaction" db:"transaction" validate:"required"`
+	RequestID    string `json:"request_id" db:"request_id"   validate:"omitempty"`
+	Currency     string `json:"currency" db:"currency"       validate:"required"`
+	Provider     string `json:"provider" db:"provider"       validate:"required"`
+	Amount       Money  `json:"amount" db:"amount"           validate:"gte=0"`
+	PaymentDt    int64  `json:"payment_dt" db:"payment_dt"   validate:"gte=0"`
+	Bank         string `json:"bank" db:"bank"               validate:"required"`
+	DeliveryCost Money  `json:"delivery_cost" db:"delivery_cost" validate:"gte=0"`
+	GoodsTotal   int    `json:"goods_total" db:"goods_total"     validate:"gte=0"`
+	CustomFee    Money  `json:"custom_fee" db:"custom_fee"       validate:"gte=0"`
+}
+
+// Item модель массива item из модели Order`а
+type Item struct {
+	ItemID      int    `json:"-" db:"item_id"         validate:"-"`
+	OrderUID    string `json:"-" db:"order_uid"       validate:"-"`
+	ChrtID      int64  `json:"chrt_id" db:"chrt_id"   validate:"required,gte=1"`
+	TrackNumber string `json:"track_number" db:"track_number" validate:"required"`
+	Price       Money  `json:"price" db:"price"       validate:"gte=0"`
+	Rid         string `json:"rid" db:"rid"           validate:"-"`
+	Name        string `json:"name" db:"name"         validate:"-"`
+	Sale        int    `json:"sale" db:"sale"         validate:"gte=0,lte=100"`
+	Size        string `json:"size" db:"size"         validate:"omitempty"`
+	TotalPrice  Money  `json:"total_price" db:"total_price" validate:"gte=0"`
+	NmID        int64  `json:"nm_id" db:"nm_id"       validate:"gte=0"`
+	Brand       string `json:"brand" db:"brand"       validate:"-"`
+	Status      int    `json:"status" db:"status"     validate:"gte=0"`
+}
+
+// Clone возвращает глубокую копию заказа. Items и DeletedAt копируются,
+// так что изменения копии не затрагивают оригинал
+func (o *Order) Clone() *Order {
+	if o == nil {
+		return nil
+	}
+	c := *o
+	if o.Items != nil {
+		c.Items = make([]Item, len(o.Items))
+		copy(c.Items, o.Items)
+	}
+	if o.DeletedAt != nil {
+		t := *o.DeletedAt
+		c.DeletedAt = &t
+	}
+	return &c
+}
+
+// ItemsTotal сумма total_price всех позиций заказа
+func (o *Order) ItemsTotal() Money {
+	var sum Money
+	for _, it := range o.Items {
+		sum = sum.Add(it.TotalPrice)
+	}
+	return sum
+}
+
+// ValidateItems chrt_id позиций не повторяются: по нему хранилище сопоставляет позиции,
+// и дубликаты схлопнулись бы в одну строку, а сумма оплаты проверена по всем
+func (o *Order) ValidateItems() error {
+	seen := make(map[int64]int, len(o.Items))
+	for i, it := range o.Items {
+		if j, ok := seen[it.ChrtID]; ok {
+			return fmt.Errorf("%w: items[%d] and items[%d] chrt_id %d", ErrDuplicateItem, j, i, it.ChrtID)
+		}
+		seen[it.ChrtID] = i
+	}
+	return nil
+}
+
+// ValidateAmounts проверка сумм, которая не выражается тегами validator:
+// все суммы представимы в минимальных единицах валюты оплаты
+func (o *Order) ValidateAmounts() error {
+	cur := o.Payment.Currency
+	check := func(field string, m Money) error {
+		if _, err := m.MinorUnits(cur); err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		return nil
+	}
+	if err := check("payment.amount", o.Payment.Amount); err != nil {
+		return err
+	}
+	if err := check("payment.delivery_cost", o.Payment.DeliveryCost); err != nil {
+		return err
+	}
+	if err := check("payment.custom_fee", o.Payment.CustomFee); err != nil {
+		return err
+	}
+	for i, it := range o.Items {
+		if err := check(fmt.Sprintf("items[%d].price", i), it.Price); err != nil {
+			return err
+		}
+		if err := check(fmt.Sprintf("items[%d].total_price", i), it.TotalPrice); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckAmountSum amount = сумма total_price позиций + delivery_cost + custom_fee.
+// Строгое правило, которого исходный формат не требовал: входит только в ValidateStrict
+func (o *Order) CheckAmountSum() error {
+	want := SumMoney(o.ItemsTotal(), o.Payment.DeliveryCost, o.Payment.CustomFee)
+	if o.Payment.Amount != want {
+		return fmt.Errorf("%w: amount %s, items+delivery+fee %s", ErrAmountMismatch, o.Payment.Amount, want)
+	}
+	return nil
+}
+
+// OrderLog облегченная модель для логирования
+type OrderLog struct {
+	UID         string    `json:"order_uid"`
+	Track       string    `json:"track_number,omitempty"`
+	DateCreated time.Time `json:"date_created"`
+	ItemsCount  int       `json:"items_count"`
+	ItemsTotal  Money     `json:"items_total"`
+	Tx          string    `json:"tx,omitempty"`
+	Amount      Money     `json:"amount,omitempty"`
+	Currency    string    `json:"currency,omitempty"`
+}
+
+// GetOrderLog создает облегченный объект OrderLog для логирования
+func GetOrderLog(o *Order) OrderLog {
+	return OrderLog{
+		UID:         o.OrderUID,
+		Track:       o.TrackNumber,
+		DateCreated: o.DateCreated,
+		ItemsCount:  len(o.Items),
+		ItemsTotal:  o.ItemsTotal(),
+		Tx:          o.Payment.Transaction,
+		Amount:      o.Payment.Amount,
+		Currency:    o.Payment.Currency,
+	}
+}
+
+func (o *OrderLog) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf(
+		"order_uid=%s track=%s tx=%s amount=%s items={count:%d total:%s} date=%s",
+		o.UID,
+		o.Track,
+		o.Tx,
+		o.Amount.Format(o.Currency),
+		o.ItemsCount,
+		o.ItemsTotal,
+		o.DateCreated.UTC().Format(time.RFC3339),
+	)
+}
@@ -0,0 +1,69 @@
+package ordermodel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// SchemaVersion версия схемы сообщения, которую пишет этот пакет. Producer передает ее в заголовке
+// SchemaVersionHeader. Новая версия появляется при несовместимом изменении формата: тогда DecodeVersion
+// переводит сообщения старых версий в текущую, а примеры старых сообщений остаются в тестах совместимости.
+//
+// Версия 1 - исходный формат: суммы числами, как их писал float64 (в том числе с хвостами вроде 12.340000000000002),
+// или строками. Money читает оба варианта без потерь
+const SchemaVersion = 1
+
+// SchemaVersionHeader заголовок сообщения Kafka с версией схемы. Без него сообщение считается версии SchemaVersion
+const SchemaVersionHeader = "schema-version"
+
+// ErrUnsupportedSchemaVersion версия схемы сообщения новее SchemaVersion или некорректна. Разбирать такое
+// сообщение как текущую версию нельзя: новые поля и смысл старых могли измениться
+var ErrUnsupportedSchemaVersion = errors.New("unsupported schema version")
+
+var validate = validator.New()
+
+// DecodeVersion разбирает сообщение версии version из заголовка SchemaVersionHeader.
+// Версии от 1 до SchemaVersion читаются Decode, остальные - ErrUnsupportedSchemaVersion
+func DecodeVersion(data []byte, version int) (*Order, error) {
+	if version < 1 || version > SchemaVersion {
+		return nil, fmt.Errorf("decode order: %w %d, supported 1..%d", ErrUnsupportedSchemaVersion, version, SchemaVersion)
+	}
+	return Decode(data)
+}
+
+// Decode разбирает сообщение заказа. Неизвестные поля - ошибка: это признак расхождения схем
+// продюсера и сервиса, молча терять данные нельзя
+func Decode(data []byte) (*Order, error) {
+	var o Order
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&o); err != nil {
+		return nil, fmt.Errorf("decode order: %w", err)
+	}
+	return &o, nil
+}
+
+// Validate правила валидации заказа: теги validate, уникальность chrt_id (ValidateItems) и точность
+// сумм (ValidateAmounts). Consumer отклоняет заказ, не прошедший Validate
+func Validate(o *Order) error {
+	if err := validate.Struct(o); err != nil {
+		return err
+	}
+	if err := o.ValidateItems(); err != nil {
+		return err
+	}
+	return o.ValidateAmounts()
+}
+
+// ValidateStrict Validate и сходимость суммы оплаты (CheckAmountSum). Producer проверяет так свои заказы,
+// consumer - только с включенной строгой проверкой
+func ValidateStrict(o *Order) error {
+	if err := Validate(o); err != nil {
+		return err
+	}
+	return o.CheckAmountSum()
+}
@@ -3,12 +3,23 @@ module order-service/producer
 go 1.24.7
 
 require (
-	github.com/google/uuid v1.4.0
+	github.com/gogazub/myapp/ordermodel v0.0.0
 	github.com/segmentio/kafka-go v0.4.28
 )
 
 require (
+	github.com/gabriel-vasile/mimetype v1.4.10 // indirect
+	github.com/go-playground/locales v0.14.1 // indirect
+	github.com/go-playground/universal-translator v0.18.1 // indirect
+	github.com/go-playground/validator/v10 v10.28.0 // indirect
 	github.com/golang/snappy v0.0.1 // indirect
 	github.com/klauspost/compress v1.9.8 // indirect
+	github.com/leodido/go-urn v1.4.0 // indirect
 	github.com/pierrec/lz4 v2.6.0+incompatible // indirect
+	golang.org/x/crypto v0.42.0 // indirect
+	golang.org/x/sys v0.36.0 // indirect
+	golang.org/x/text v0.29.0 // indirect
 )
+
+// ordermodel - схема сообщения заказа, общая с сервисом
+replace github.com/gogazub/myapp/ordermodel => ../ordermodel
@@ -5,15 +5,14 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
-	"fmt"
 	"log"
-	"math/rand"
-	"order-service/producer/model"
+	"math/rand/v2"
 	"os"
 	"os/signal"
+	"strconv"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/gogazub/myapp/ordermodel"
 	kafka "github.com/segmentio/kafka-go"
 )
 
@@ -67,7 +66,7 @@ func main() {
 		cancel()
 	}()
 
-	rand.Seed(time.Now().UnixNano())
+	rng := rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 0))
 
 	sent := 0
 	for {
@@ -83,7 +82,12 @@ func main() {
 			return
 		}
 
-		o := generateOrder()
+		o := ordermodel.Generate(rng, time.Now())
+		// Правила consumer в строгом режиме: сообщение, которое он может отклонить, не отправляется
+		if err := ordermodel.ValidateStrict(o); err != nil {
+			log.Printf("generated invalid order %s: %v", o.OrderUID, err)
+			continue
+		}
 		b, err := json.Marshal(o)
 		if err != nil {
 			log.Println("json marshal error:", err)
@@ -93,7 +97,10 @@ func main() {
 		msg := kafka.Message{
 			Key:   []byte(o.OrderUID),
 			Value: b,
-			Time:  time.Now(),
+			Headers: []kafka.Header{
+				{Key: ordermodel.SchemaVersionHeader, Value: []byte(strconv.Itoa(ordermodel.SchemaVersion))},
+			},
+			Time: time.Now(),
 		}
 
 		writeCtx, writeCancel := context.WithTimeout(ctx, 10*time.Second)
@@ -157,68 +164,3 @@ func trimSpace(s string) string {
 	}
 	return s[start:end]
 }
-
-func generateOrder() *model.Order {
-	now := time.Now().UTC()
-	uid := uuid.New().String()
-
-	itemCount := rand.Intn(3) + 1
-	items := make([]model.Item, 0, itemCount)
-	var total float64
-	for i := 0; i < itemCount; i++ {
-		price := float64(rand.Intn(2000)+100) / 100.0
-		count := 1
-		tp := price * float64(count)
-		items = append(items, model.Item{
-			ChrtID:      int64(rand.Intn(1_000_000) + 1),
-			TrackNumber: fmt.Sprintf("TRK%06d", rand.Intn(1_000_000)),
-			Price:       price,
-			Rid:         fmt.Sprintf("RID%04d", rand.Intn(10000)),
-			Name:        fmt.Sprintf("Item-%d", rand.Intn(1000)),
-			Sale:        rand.Intn(50),
-			Size:        "M",
-			TotalPrice:  tp,
-			NmID:        int64(rand.Intn(1_000_000)),
-			Brand:       "BrandX",
-			Status:      1,
-		})
-		total += tp
-	}
-
-	order := &model.Order{
-		OrderUID:          uid,
-		TrackNumber:       fmt.Sprintf("TRACK-%s", uid[:8]),
-		Entry:             "WEB",
-		Locale:            "en-GB",
-		InternalSignature: "",
-		CustomerID:        fmt.Sprintf("cust-%d", rand.Intn(100000)),
-		DeliveryService:   "dhl",
-		Shardkey:          fmt.Sprintf("%d", rand.Intn(100)),
-		SmID:              rand.Intn(1000),
-		DateCreated:       now,
-		OofShard:          "1",
-		Delivery: model.Delivery{
-			Name:    "Ivan Ivanov",
-			Phone:   "+79991234567",
-			Zip:     "123456",
-			City:    "Moscow",
-			Address: "Lenina, 1",
-			Region:  "Moscow",
-			Email:   "ivan@example.com",
-		},
-		Payment: model.Payment{
-			Transaction:  fmt.Sprintf("tx-%s", uid[:8]),
-			RequestID:    "",
-			Currency:     "RUB",
-			Provider:     "bank",
-			Amount:       total,
-			PaymentDt:    now.Unix(),
-			Bank:         "BigBank",
-			DeliveryCost: 0,
-			GoodsTotal:   int(total),
-			CustomFee:    0,
-		},
-		Items: items,
-	}
-	return order
-}
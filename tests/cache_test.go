@@ -3,12 +3,17 @@ package tests
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/gogazub/myapp/internal/model"
 	"github.com/gogazub/myapp/internal/repository"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 /*
@@ -232,3 +237,236 @@ func TestLRU_GetRefreshesRecency(t *testing.T) {
 		t.Fatalf("expected some early keys (k1..k49) to be evicted; got 0")
 	}
 }
+
+func TestCacheStats(t *testing.T) {
+	r := repository.NewCacheRepositoryWithConfig(repository.CacheConfig{MaxSize: 2})
+	ctx := context.Background()
+
+	_ = r.Save(ctx, FakeOrder("a"))
+	_ = r.Save(ctx, FakeOrder("b"))
+	_ = r.Save(ctx, FakeOrder("c")) // вытесняет "a"
+
+	_, _ = r.GetByID(ctx, "b")
+	_, _ = r.GetByID(ctx, "a")
+
+	st := r.Stats()
+	assert.Equal(t, uint64(1), st.Hits)
+	assert.Equal(t, uint64(1), st.Misses)
+	assert.Equal(t, uint64(1), st.Evictions)
+	assert.Equal(t, 2, st.Size)
+	assert.Equal(t, 2, st.Capacity)
+	assert.InDelta(t, 0.5, st.HitRatio, 1e-9)
+}
+
+func TestCacheTTL_Expires(t *testing.T) {
+	r := repository.NewCacheRepositoryWithConfig(repository.CacheConfig{TTL: 10 * time.Millisecond})
+	ctx := context.Background()
+
+	_ = r.Save(ctx, FakeOrder("ttl"))
+	time.Sleep(20 * time.Millisecond)
+
+	o, err := r.GetByID(ctx, "ttl")
+	assert.Nil(t, o)
+	assert.ErrorIs(t, err, repository.ErrCacheMiss)
+
+	st := r.Stats()
+	assert.Equal(t, uint64(1), st.Expired)
+	assert.Equal(t, 0, st.Size)
+}
+
+func TestCacheDeleteAndClear(t *testing.T) {
+	r := repository.NewCacheRepository()
+	ctx := context.Background()
+
+	for _, id := range []string{"a", "b", "c"} {
+		_ = r.Save(ctx, FakeOrder(id))
+	}
+
+	assert.NoError(t, r.Delete(ctx, "a"))
+	assert.NoError(t, r.Delete(ctx, "missing"))
+	_, err := r.GetByID(ctx, "a")
+	assert.ErrorIs(t, err, repository.ErrCacheMiss)
+	assert.Equal(t, 2, r.Size())
+
+	assert.NoError(t, r.Clear(ctx))
+	assert.Equal(t, 0, r.Size())
+	assert.Equal(t, uint64(3), r.Stats().Invalidations)
+}
+
+func TestCacheLoadFromDB_RecentOrders(t *testing.T) {
+	r := repository.NewCacheRepositoryWithConfig(repository.CacheConfig{MaxSize: 3, WarmupSize: 3})
+	db := new(mockDBRepo)
+	ctx := context.Background()
+
+	// ListPage отдает сначала новые заказы
+	recent := []*model.Order{FakeOrder("new"), FakeOrder("mid"), FakeOrder("old")}
+	db.On("ListPage", ctx, repository.Cursor{}, 3).Return(recent, nil).Once()
+
+	assert.Equal(t, repository.WarmupPending, r.WarmupStatus().State)
+	require.NoError(t, r.LoadFromDB(ctx, db))
+
+	st := r.WarmupStatus()
+	assert.Equal(t, repository.WarmupDone, st.State)
+	assert.Equal(t, 3, st.Loaded)
+	assert.Equal(t, 3, st.Target)
+	assert.True(t, st.Finished())
+	assert.Equal(t, 3, r.Size())
+	assert.Equal(t, 3, r.Stats().LastLoadOrders)
+	db.AssertExpectations(t)
+
+	// Самый старый заказ первым кандидатом на вытеснение
+	require.NoError(t, r.Save(ctx, FakeOrder("x")))
+	_, err := r.GetByID(ctx, "old")
+	assert.ErrorIs(t, err, repository.ErrCacheMiss)
+	_, err = r.GetByID(ctx, "new")
+	assert.NoError(t, err)
+}
+
+func TestCacheLoadFromDB_Chunks(t *testing.T) {
+	r := repository.NewCacheRepositoryWithConfig(repository.CacheConfig{MaxSize: 2000, WarmupSize: 1200})
+	db := new(mockDBRepo)
+	ctx := context.Background()
+
+	page := func(prefix string, n int) []*model.Order {
+		out := make([]*model.Order, 0, n)
+		for i := 0; i < n; i++ {
+			out = append(out, FakeOrder(fmt.Sprintf("%s-%04d", prefix, i)))
+		}
+		return out
+	}
+	p1, p2, p3 := page("a", 500), page("b", 500), page("c", 500)
+	db.On("ListPage", ctx, repository.Cursor{}, 500).Return(p1, nil).Once()
+	db.On("ListPage", ctx, repository.CursorOf(p1[499]), 500).Return(p2, nil).Once()
+	db.On("ListPage", ctx, repository.CursorOf(p2[499]), 500).Return(p3, nil).Once()
+
+	require.NoError(t, r.LoadFromDB(ctx, db))
+	assert.Equal(t, 1200, r.Size())
+	assert.Equal(t, 1200, r.WarmupStatus().Loaded)
+	db.AssertExpectations(t)
+}
+
+// Страница прочитана до Delete/Save из другой горутины (NOTIFY, админский сброс): ее копии
+// не должны вернуть удаленный заказ или затереть более свежую версию
+func TestCacheLoadFromDB_SkipsOrdersChangedDuringRead(t *testing.T) {
+	ctx := context.Background()
+	page := []*model.Order{FakeOrder("deleted"), FakeOrder("updated"), FakeOrder("untouched")}
+
+	t.Run("delete and save", func(t *testing.T) {
+		r := repository.NewCacheRepositoryWithConfig(repository.CacheConfig{MaxSize: 3, WarmupSize: 3})
+		db := new(mockDBRepo)
+		fresh := FakeOrder("updated")
+		fresh.TrackNumber = "FRESH"
+		db.On("ListPage", ctx, repository.Cursor{}, 3).Return(page, nil).Once().Run(func(mock.Arguments) {
+			require.NoError(t, r.Delete(ctx, "deleted"))
+			require.NoError(t, r.Save(ctx, fresh))
+		})
+
+		require.NoError(t, r.LoadFromDB(ctx, db))
+		_, err := r.GetByID(ctx, "deleted")
+		require.ErrorIs(t, err, repository.ErrCacheMiss)
+		got, err := r.GetByID(ctx, "updated")
+		require.NoError(t, err)
+		require.Equal(t, "FRESH", got.TrackNumber)
+		_, err = r.GetByID(ctx, "untouched")
+		require.NoError(t, err)
+	})
+	t.Run("clear", func(t *testing.T) {
+		r := repository.NewCacheRepositoryWithConfig(repository.CacheConfig{MaxSize: 3, WarmupSize: 3})
+		db := new(mockDBRepo)
+		db.On("ListPage", ctx, repository.Cursor{}, 3).Return(page, nil).Once().Run(func(mock.Arguments) {
+			require.NoError(t, r.Clear(ctx))
+		})
+
+		require.NoError(t, r.LoadFromDB(ctx, db))
+		require.Zero(t, r.Size())
+	})
+	t.Run("changes after warmup are not tracked", func(t *testing.T) {
+		r := repository.NewCacheRepositoryWithConfig(repository.CacheConfig{MaxSize: 3, WarmupSize: 3})
+		db := new(mockDBRepo)
+		db.On("ListPage", ctx, repository.Cursor{}, 3).Return(page, nil).Twice()
+		require.NoError(t, r.LoadFromDB(ctx, db))
+		require.NoError(t, r.Delete(ctx, "deleted"))
+
+		// Удаление до начала прогрева не мешает загрузить заказ заново
+		require.NoError(t, r.LoadFromDB(ctx, db))
+		require.Equal(t, 3, r.Size())
+	})
+}
+
+func TestCacheLoadFromDB_Error(t *testing.T) {
+	r := repository.NewCacheRepository()
+	db := new(mockDBRepo)
+	ctx := context.Background()
+
+	db.On("ListPage", ctx, repository.Cursor{}, 500).Return(nil, errors.New("db down")).Once()
+
+	require.Error(t, r.LoadFromDB(ctx, db))
+	st := r.WarmupStatus()
+	assert.Equal(t, repository.WarmupFailed, st.State)
+	assert.Contains(t, st.Error, "db down")
+	assert.True(t, st.Finished())
+}
+
+func TestCache_SaveStoresCopy(t *testing.T) {
+	r := repository.NewCacheRepository()
+	ctx := context.Background()
+
+	in := FakeValidOrder("iso")
+	deletedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	in.DeletedAt = new(time.Time)
+	*in.DeletedAt = deletedAt
+	require.NoError(t, r.Save(ctx, in))
+
+	// Вызывающий код продолжает менять свой экземпляр после Save
+	in.TrackNumber = "mutated"
+	in.Items[0].Name = "mutated"
+	in.Items = append(in.Items, model.Item{ChrtID: 2})
+	*in.DeletedAt = deletedAt.Add(time.Hour)
+
+	got, err := r.GetByID(ctx, "iso")
+	require.NoError(t, err)
+	require.Empty(t, got.TrackNumber)
+	require.Empty(t, got.Items[0].Name)
+	require.Len(t, got.Items, 1)
+	require.True(t, got.DeletedAt.Equal(deletedAt))
+
+	// Clone не делит DeletedAt с оригиналом
+	c := got.Clone()
+	*c.DeletedAt = deletedAt.Add(time.Hour)
+	require.True(t, got.DeletedAt.Equal(deletedAt))
+}
+
+// Запускать с -race: читатели меняют полученные заказы одновременно друг с другом.
+// Без копирования в GetByID детектор гонок это ловит, а значения в кеше портятся
+func TestCache_ConcurrentReadersAreIsolated(t *testing.T) {
+	r := repository.NewCacheRepository()
+	ctx := context.Background()
+	require.NoError(t, r.Save(ctx, FakeValidOrder("shared")))
+
+	const readers = 16
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				o, err := r.GetByID(ctx, "shared")
+				if err != nil {
+					t.Errorf("get: %v", err)
+					return
+				}
+				o.TrackNumber = "reader-" + strconvI(i)
+				o.Delivery.City = "somewhere"
+				o.Items[0].Price += 1
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	got, err := r.GetByID(ctx, "shared")
+	require.NoError(t, err)
+	want := FakeValidOrder("shared")
+	require.Empty(t, got.TrackNumber)
+	require.Equal(t, want.Delivery.City, got.Delivery.City)
+	require.Equal(t, want.Items[0].Price, got.Items[0].Price)
+}
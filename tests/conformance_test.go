@@ -0,0 +1,328 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gogazub/myapp/internal/migrate"
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/gogazub/myapp/migrations"
+	sqlitemigrations "github.com/gogazub/myapp/migrations/sqlite"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+// runRepositoryConformance общий набор тестов семантики IDBRepository. Эталон - MemoryRepository,
+// любая другая реализация должна проходить те же проверки. newRepo возвращает пустое хранилище
+func runRepositoryConformance(t *testing.T, newRepo func(t *testing.T) repository.IDBRepository) {
+	ctx := context.Background()
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("save and get", func(t *testing.T) {
+		r := newRepo(t)
+		o := conformanceOrder(1, base)
+		require.NoError(t, r.Save(ctx, o))
+
+		got, err := r.GetByID(ctx, o.OrderUID)
+		require.NoError(t, err)
+		requireSameOrder(t, o, got)
+	})
+
+	t.Run("save is upsert", func(t *testing.T) {
+		r := newRepo(t)
+		o := conformanceOrder(1, base)
+		require.NoError(t, r.Save(ctx, o))
+
+		upd := o.Clone()
+		upd.TrackNumber = "TRK-NEW"
+		upd.Delivery.City = "Boston"
+		upd.Payment.Amount = model.NewMoney(10, 5)
+		require.NoError(t, r.Save(ctx, upd))
+
+		got, err := r.GetByID(ctx, o.OrderUID)
+		require.NoError(t, err)
+		requireSameOrder(t, upd, got)
+	})
+
+	t.Run("items replaced by chrt_id", func(t *testing.T) {
+		r := newRepo(t)
+		o := conformanceOrder(1, base)
+		o.Items = []model.Item{conformanceItem(1, "a"), conformanceItem(2, "b"), conformanceItem(3, "c")}
+		require.NoError(t, r.Save(ctx, o))
+
+		// 1 удалена, 2 изменена, 4 добавлена
+		upd := o.Clone()
+		upd.Items = []model.Item{conformanceItem(2, "b2"), conformanceItem(3, "c"), conformanceItem(4, "d")}
+		require.NoError(t, r.Save(ctx, upd))
+
+		got, err := r.GetByID(ctx, o.OrderUID)
+		require.NoError(t, err)
+		names := make(map[int64]string)
+		for _, it := range got.Items {
+			names[it.ChrtID] = it.Name
+		}
+		require.Equal(t, map[int64]string{2: "b2", 3: "c", 4: "d"}, names)
+		require.Len(t, got.Items, 3)
+	})
+
+	t.Run("duplicate chrt_id rejected", func(t *testing.T) {
+		r := newRepo(t)
+		o := conformanceOrder(1, base)
+		o.Items = []model.Item{conformanceItem(1, "a")}
+		require.NoError(t, r.Save(ctx, o))
+
+		// Позиции не схлопываются молча: заказ не сохраняется, прежняя версия остается
+		dup := o.Clone()
+		dup.Items = []model.Item{conformanceItem(1, "a2"), conformanceItem(2, "b"), conformanceItem(1, "a3")}
+		require.ErrorIs(t, r.Save(ctx, dup), model.ErrDuplicateItem)
+
+		got, err := r.GetByID(ctx, o.OrderUID)
+		require.NoError(t, err)
+		requireSameOrder(t, o, got)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		r := newRepo(t)
+		_, err := r.GetByID(ctx, conformanceUID(404))
+		require.ErrorIs(t, err, repository.ErrOrderNotFound)
+		require.ErrorIs(t, r.Delete(ctx, conformanceUID(404)), repository.ErrOrderNotFound)
+	})
+
+	t.Run("soft delete", func(t *testing.T) {
+		r := newRepo(t)
+		o := conformanceOrder(1, base)
+		keep := conformanceOrder(2, base.Add(-time.Hour))
+		require.NoError(t, r.Save(ctx, o))
+		require.NoError(t, r.Save(ctx, keep))
+
+		require.NoError(t, r.Delete(ctx, o.OrderUID))
+
+		_, err := r.GetByID(ctx, o.OrderUID)
+		require.ErrorIs(t, err, repository.ErrOrderNotFound)
+		got, err := r.GetByIDs(ctx, []string{o.OrderUID, keep.OrderUID})
+		require.NoError(t, err)
+		require.Equal(t, []string{keep.OrderUID}, orderUIDs(got))
+		page, err := r.ListPage(ctx, repository.Cursor{}, 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{keep.OrderUID}, orderUIDs(page))
+
+		require.ErrorIs(t, r.Save(ctx, o), repository.ErrOrderDeleted)
+		require.ErrorIs(t, r.Delete(ctx, o.OrderUID), repository.ErrOrderNotFound)
+	})
+
+	t.Run("get by ids", func(t *testing.T) {
+		r := newRepo(t)
+		for i := 1; i <= 3; i++ {
+			require.NoError(t, r.Save(ctx, conformanceOrder(i, base)))
+		}
+
+		got, err := r.GetByIDs(ctx, []string{conformanceUID(3), conformanceUID(404), conformanceUID(1)})
+		require.NoError(t, err)
+		ids := orderUIDs(got)
+		slices.Sort(ids)
+		require.Equal(t, []string{conformanceUID(1), conformanceUID(3)}, ids)
+
+		got, err = r.GetByIDs(ctx, nil)
+		require.NoError(t, err)
+		require.Empty(t, got)
+	})
+
+	t.Run("list page keyset", func(t *testing.T) {
+		r := newRepo(t)
+		// 2 и 3 созданы одновременно: порядок между ними по order_uid DESC
+		dates := map[int]time.Time{1: base.Add(-2 * time.Hour), 2: base, 3: base, 4: base.Add(-time.Hour), 5: base.Add(time.Hour)}
+		for i, d := range dates {
+			require.NoError(t, r.Save(ctx, conformanceOrder(i, d)))
+		}
+		want := []string{conformanceUID(5), conformanceUID(3), conformanceUID(2), conformanceUID(4), conformanceUID(1)}
+
+		var seen []string
+		var after repository.Cursor
+		for {
+			page, err := r.ListPage(ctx, after, 2)
+			require.NoError(t, err)
+			if len(page) == 0 {
+				break
+			}
+			require.LessOrEqual(t, len(page), 2)
+			seen = append(seen, orderUIDs(page)...)
+			after = repository.CursorOf(page[len(page)-1])
+		}
+		require.Equal(t, want, seen)
+
+		page, err := r.ListPage(ctx, repository.Cursor{}, 0)
+		require.NoError(t, err)
+		require.Empty(t, page)
+	})
+
+	t.Run("watermark and changed since", func(t *testing.T) {
+		r := newRepo(t)
+		a, b := conformanceOrder(1, base), conformanceOrder(2, base)
+		require.NoError(t, r.Save(ctx, a))
+		wm, err := r.Watermark(ctx)
+		require.NoError(t, err)
+
+		// Watermark может быть с запасом (Postgres): ChangedSince тогда вернет и уже учтенные изменения,
+		// но изменения после отметки должен вернуть всегда
+		require.NoError(t, r.Save(ctx, b))
+		changed, err := r.ChangedSince(ctx, wm)
+		require.NoError(t, err)
+		require.Contains(t, changed, b.OrderUID)
+
+		// Удаление - тоже изменение: кеш должен узнать о нем
+		wm, err = r.Watermark(ctx)
+		require.NoError(t, err)
+		require.NoError(t, r.Delete(ctx, a.OrderUID))
+		changed, err = r.ChangedSince(ctx, wm)
+		require.NoError(t, err)
+		require.Contains(t, changed, a.OrderUID)
+
+		next, err := r.Watermark(ctx)
+		require.NoError(t, err)
+		require.False(t, next.Before(wm))
+	})
+}
+
+func TestMemoryRepository_Conformance(t *testing.T) {
+	t.Run("in memory", func(t *testing.T) {
+		runRepositoryConformance(t, func(t *testing.T) repository.IDBRepository {
+			r, err := repository.NewMemoryRepository(repository.MemoryConfig{})
+			require.NoError(t, err)
+			return r
+		})
+	})
+	t.Run("file", func(t *testing.T) {
+		runRepositoryConformance(t, func(t *testing.T) repository.IDBRepository {
+			r, err := repository.NewMemoryRepository(repository.MemoryConfig{Path: filepath.Join(t.TempDir(), "orders.json")})
+			require.NoError(t, err)
+			return r
+		})
+	})
+}
+
+func TestSQLiteRepository_Conformance(t *testing.T) {
+	runRepositoryConformance(t, func(t *testing.T) repository.IDBRepository {
+		db, err := repository.OpenSQLite(filepath.Join(t.TempDir(), "orders.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = db.Close() })
+		m, err := migrate.NewWithDialect(db, sqlitemigrations.FS, migrate.SQLite)
+		require.NoError(t, err)
+		_, err = m.Up(context.Background(), 0)
+		require.NoError(t, err)
+		return repository.NewSQLiteRepository(db)
+	})
+}
+
+// TestDBRepository_Conformance тот же набор против настоящего Postgres. Выполняется только с
+// TEST_POSTGRES_DSN: база очищается перед каждым подтестом
+func TestDBRepository_Conformance(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	m, err := migrate.New(db, migrations.FS)
+	require.NoError(t, err)
+	_, err = m.Up(context.Background(), 0)
+	require.NoError(t, err)
+
+	runRepositoryConformance(t, func(t *testing.T) repository.IDBRepository {
+		_, err := db.Exec(`TRUNCATE orders, deliveries, payments, items, order_history, order_outbox, order_raw_messages, order_shards RESTART IDENTITY CASCADE`)
+		require.NoError(t, err)
+		return repository.NewOrderRepository(db)
+	})
+}
+
+func TestMemoryRepository_Persistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "orders.json")
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	r, err := repository.NewMemoryRepository(repository.MemoryConfig{Path: path})
+	require.NoError(t, err)
+	kept, deleted := conformanceOrder(1, base), conformanceOrder(2, base)
+	require.NoError(t, r.Save(ctx, kept))
+	require.NoError(t, r.Save(ctx, deleted))
+	require.NoError(t, r.Delete(ctx, deleted.OrderUID))
+	wm, err := r.Watermark(ctx)
+	require.NoError(t, err)
+
+	reopened, err := repository.NewMemoryRepository(repository.MemoryConfig{Path: path})
+	require.NoError(t, err)
+	got, err := reopened.GetByID(ctx, kept.OrderUID)
+	require.NoError(t, err)
+	requireSameOrder(t, kept, got)
+	require.ErrorIs(t, reopened.Save(ctx, deleted), repository.ErrOrderDeleted)
+	reopenedWM, err := reopened.Watermark(ctx)
+	require.NoError(t, err)
+	require.True(t, reopenedWM.Equal(wm), "watermark %v, want %v", reopenedWM, wm)
+
+	t.Run("bad file", func(t *testing.T) {
+		bad := filepath.Join(t.TempDir(), "orders.json")
+		require.NoError(t, os.WriteFile(bad, []byte(`{"orders": [{"customer_id": "c"}]}`), 0o600))
+		_, err := repository.NewMemoryRepository(repository.MemoryConfig{Path: bad})
+		require.Error(t, err)
+	})
+
+	t.Run("write error keeps previous state", func(t *testing.T) {
+		dir := t.TempDir()
+		r, err := repository.NewMemoryRepository(repository.MemoryConfig{Path: filepath.Join(dir, "missing", "orders.json")})
+		require.NoError(t, err)
+		require.ErrorIs(t, r.Save(ctx, conformanceOrder(1, base)), os.ErrNotExist)
+		_, err = r.GetByID(ctx, conformanceUID(1))
+		require.ErrorIs(t, err, repository.ErrOrderNotFound)
+	})
+}
+
+// conformanceUID order_uid в формате UUID: колонка order_uid в Postgres имеет тип uuid
+func conformanceUID(n int) string {
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", n)
+}
+
+func conformanceOrder(n int, created time.Time) *model.Order {
+	o := FakeValidOrder(conformanceUID(n))
+	o.DateCreated = created
+	return o
+}
+
+func conformanceItem(chrtID int64, name string) model.Item {
+	it := FakeValidOrder("").Items[0]
+	it.ChrtID, it.Name = chrtID, name
+	return it
+}
+
+// requireSameOrder сравнивает заказы без id строк, которые назначает хранилище
+func requireSameOrder(t *testing.T, want, got *model.Order) {
+	t.Helper()
+	require.Equal(t, normalizeOrder(want), normalizeOrder(got))
+}
+
+func normalizeOrder(o *model.Order) *model.Order {
+	c := o.Clone()
+	c.DateCreated = c.DateCreated.UTC().Truncate(time.Microsecond)
+	c.DeletedAt = nil
+	c.Delivery.DeliveryID, c.Delivery.OrderUID = 0, ""
+	c.Payment.PaymentID, c.Payment.OrderUID = 0, ""
+	for i := range c.Items {
+		c.Items[i].ItemID, c.Items[i].OrderUID = 0, ""
+	}
+	return c
+}
+
+func orderUIDs(orders []*model.Order) []string {
+	ids := make([]string, 0, len(orders))
+	for _, o := range orders {
+		ids = append(ids, o.OrderUID)
+	}
+	return ids
+}
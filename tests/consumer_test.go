@@ -1,14 +1,21 @@
 package tests
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"log"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gogazub/myapp/internal/consumer"
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/service"
+	"github.com/gogazub/myapp/ordermodel"
 	"github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -53,7 +60,7 @@ func TestConsumer(t *testing.T) {
 	// Нарушение правил валидации. Возвращает ValidationErrors. SaveOrder не вызывается
 	t.Run("ProcessMessage/validate error", func(t *testing.T) {
 
-		mockSvc := &MockService{}
+		mockSvc := newRawMockService()
 		c = consumer.NewConsumer(mockSvc, stubReader)
 		fakeOrder := FakeOrder("123")
 		fakeOrder.SmID = -1
@@ -71,7 +78,7 @@ func TestConsumer(t *testing.T) {
 
 	// Json с лишними полями. возвращает ошибку, SaveOrder не вызывается
 	t.Run("ProcessMessage/unknown fields", func(t *testing.T) {
-		mockSvc := &MockService{}
+		mockSvc := newRawMockService()
 		c = consumer.NewConsumer(mockSvc, stubReader)
 
 		fakeOrder := FakeValidOrder("123")
@@ -92,9 +99,50 @@ func TestConsumer(t *testing.T) {
 		mockSvc.AssertNotCalled(t, "SaveOrder")
 	})
 
+	// Сумма оплаты не сходится с позициями: по умолчанию заказ сохраняется, как и до Money
+	t.Run("ProcessMessage/amount mismatch accepted", func(t *testing.T) {
+		mockSvc := newRawMockService()
+		c = consumer.NewConsumer(mockSvc, stubReader)
+
+		order := FakeValidOrder("1")
+		order.Payment.Amount = order.Payment.Amount.Add(model.NewMoney(0, 1))
+		mockSvc.On("SaveOrder", mock.Anything, mock.AnythingOfType("*ordermodel.Order")).Return(nil).Once()
+
+		require.NoError(t, c.ProcessMessageTest(context.Background(), kafka.Message{Value: mustJSON(t, order)}))
+		mockSvc.AssertExpectations(t)
+	})
+
+	// То же в строгом режиме. Возвращает ErrAmountMismatch. SaveOrder не вызывается
+	t.Run("ProcessMessage/amount mismatch strict", func(t *testing.T) {
+		mockSvc := newRawMockService()
+		c = consumer.NewConsumerWithConfig(mockSvc, stubReader, consumer.Config{StrictAmounts: true})
+
+		order := FakeValidOrder("1")
+		order.Payment.Amount = order.Payment.Amount.Add(model.NewMoney(0, 1))
+
+		err := c.ProcessMessageTest(context.Background(), kafka.Message{Value: mustJSON(t, order)})
+		require.ErrorIs(t, err, model.ErrAmountMismatch)
+		mockSvc.AssertNotCalled(t, "SaveOrder")
+	})
+
+	// Две позиции с одним chrt_id. Возвращает ErrDuplicateItem. SaveOrder не вызывается:
+	// хранилище схлопнуло бы их в одну, а сумма оплаты проверена по обеим
+	t.Run("ProcessMessage/duplicate chrt_id", func(t *testing.T) {
+		mockSvc := newRawMockService()
+		c = consumer.NewConsumer(mockSvc, stubReader)
+
+		order := FakeValidOrder("1")
+		order.Items = append(order.Items, order.Items[0])
+		order.Payment.Amount = model.SumMoney(order.ItemsTotal(), order.Payment.DeliveryCost, order.Payment.CustomFee)
+
+		err := c.ProcessMessageTest(context.Background(), kafka.Message{Value: mustJSON(t, order)})
+		require.ErrorIs(t, err, model.ErrDuplicateItem)
+		mockSvc.AssertNotCalled(t, "SaveOrder")
+	})
+
 	// Пустой json. Возвращает ошибку. SaveOrder не вызывается
 	t.Run("ProcessMessage/empty json", func(t *testing.T) {
-		mockSvc := &MockService{}
+		mockSvc := newRawMockService()
 		c = consumer.NewConsumer(mockSvc, stubReader)
 
 		var orderMap map[string]interface{}
@@ -111,7 +159,7 @@ func TestConsumer(t *testing.T) {
 
 	// Даем корректный json. Возвращает nil. SaveOrder вызывается один раз
 	t.Run("ProcessMessage/correct json", func(t *testing.T) {
-		mockSvc := &MockService{}
+		mockSvc := newRawMockService()
 		c = consumer.NewConsumer(mockSvc, stubReader)
 
 		order := FakeValidOrder("1")
@@ -120,7 +168,7 @@ func TestConsumer(t *testing.T) {
 
 		// ожидаем один успешный вызов сохранения
 		mockSvc.
-			On("SaveOrder", mock.Anything, mock.AnythingOfType("*model.Order")).
+			On("SaveOrder", mock.Anything, mock.AnythingOfType("*ordermodel.Order")).
 			Return(nil).
 			Once()
 
@@ -133,9 +181,135 @@ func TestConsumer(t *testing.T) {
 		mockSvc.AssertNumberOfCalls(t, "SaveOrder", 1)
 	})
 
+	// Источник изменения (topic/partition/offset) уходит в SaveOrder через ctx для истории заказа
+	t.Run("ProcessMessage/change source", func(t *testing.T) {
+		mockSvc := newRawMockService()
+		c = consumer.NewConsumer(mockSvc, stubReader)
+
+		want := model.ChangeSource{Kind: model.SourceKafka, Topic: "orders", Partition: 2, Offset: 42}
+		mockSvc.
+			On("SaveOrder", mock.MatchedBy(func(ctx context.Context) bool {
+				return model.ChangeSourceFrom(ctx) == want
+			}), mock.AnythingOfType("*ordermodel.Order")).
+			Return(nil).
+			Once()
+
+		msg := kafka.Message{Topic: "orders", Partition: 2, Offset: 42, Value: mustJSON(t, FakeValidOrder("1"))}
+		require.NoError(t, c.ProcessMessageTest(context.Background(), msg))
+		mockSvc.AssertExpectations(t)
+	})
+
+	// Исходное сообщение с метаданными, версией схемы из заголовка и order_uid из тела сохраняется до SaveOrder
+	t.Run("ProcessMessage/raw message", func(t *testing.T) {
+		mockSvc := &MockService{}
+		c = consumer.NewConsumer(mockSvc, stubReader)
+
+		ts := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+		order := FakeValidOrder("1")
+		msg := kafka.Message{
+			Topic: "orders", Partition: 1, Offset: 7, Time: ts,
+			Headers: []kafka.Header{{Key: "schema-version", Value: []byte("1")}, {Key: "producer", Value: []byte("p1")}},
+			Value:   mustJSON(t, order),
+		}
+		want := model.RawMessage{
+			OrderUID: order.OrderUID, Shardkey: order.Shardkey,
+			Payload: msg.Value, Topic: "orders", Partition: 1, Offset: 7, Timestamp: ts, SchemaVersion: 1,
+			Headers: []model.RawHeader{{Key: "schema-version", Value: "1"}, {Key: "producer", Value: "p1"}},
+		}
+		saveRaw := mockSvc.On("SaveRawMessage", mock.Anything, want).Return(nil).Once()
+		mockSvc.On("SaveOrder", mock.Anything, mock.AnythingOfType("*ordermodel.Order")).
+			Return(nil).Once().NotBefore(saveRaw)
+
+		require.NoError(t, c.ProcessMessageTest(context.Background(), msg))
+		mockSvc.AssertExpectations(t)
+	})
+
+	// Невалидное тело сохраняется байт в байт, затем к нему дописывается причина отклонения
+	t.Run("ProcessMessage/rejected raw message", func(t *testing.T) {
+		mockSvc := &MockService{}
+		c = consumer.NewConsumer(mockSvc, stubReader)
+
+		msg := kafka.Message{Topic: "orders", Offset: 9, Value: []byte(`{"order_uid":"uid-9", "broken`)}
+		mockSvc.On("SaveRawMessage", mock.Anything, mock.MatchedBy(func(m model.RawMessage) bool {
+			return m.Rejection == "" && m.OrderUID == "" && bytes.Equal(m.Payload, msg.Value)
+		})).Return(nil).Once()
+		mockSvc.On("SaveRawMessage", mock.Anything, mock.MatchedBy(func(m model.RawMessage) bool {
+			return strings.Contains(m.Rejection, "processing message error") && bytes.Equal(m.Payload, msg.Value)
+		})).Return(nil).Once()
+
+		require.Error(t, c.ProcessMessageTest(context.Background(), msg))
+		mockSvc.AssertExpectations(t)
+		mockSvc.AssertNotCalled(t, "SaveOrder")
+	})
+
+	// Отклоненный по валидации заказ: order_uid из тела сохраняется вместе с причиной
+	t.Run("ProcessMessage/rejected by validation", func(t *testing.T) {
+		mockSvc := &MockService{}
+		c = consumer.NewConsumer(mockSvc, stubReader)
+
+		order := FakeValidOrder("1")
+		order.SmID = -1
+		mockSvc.On("SaveRawMessage", mock.Anything, mock.MatchedBy(func(m model.RawMessage) bool {
+			return m.OrderUID == order.OrderUID && m.Rejection == ""
+		})).Return(nil).Once()
+		mockSvc.On("SaveRawMessage", mock.Anything, mock.MatchedBy(func(m model.RawMessage) bool {
+			return m.OrderUID == order.OrderUID && strings.Contains(m.Rejection, "SmID")
+		})).Return(nil).Once()
+
+		require.Error(t, c.ProcessMessageTest(context.Background(), kafka.Message{Value: mustJSON(t, order)}))
+		mockSvc.AssertExpectations(t)
+		mockSvc.AssertNotCalled(t, "SaveOrder")
+	})
+
+	// Версия схемы новее поддерживаемой или нечисловая: сообщение отклоняется до разбора, SaveOrder не вызывается
+	for _, version := range []string{strconv.Itoa(ordermodel.SchemaVersion + 1), "v2"} {
+		t.Run("ProcessMessage/unsupported schema version "+version, func(t *testing.T) {
+			mockSvc := &MockService{}
+			c = consumer.NewConsumer(mockSvc, stubReader)
+
+			msg := kafka.Message{
+				Headers: []kafka.Header{{Key: ordermodel.SchemaVersionHeader, Value: []byte(version)}},
+				Value:   mustJSON(t, FakeValidOrder("1")),
+			}
+			mockSvc.On("SaveRawMessage", mock.Anything, mock.MatchedBy(func(m model.RawMessage) bool {
+				return m.Rejection == ""
+			})).Return(nil).Once()
+			mockSvc.On("SaveRawMessage", mock.Anything, mock.MatchedBy(func(m model.RawMessage) bool {
+				return strings.Contains(m.Rejection, "unsupported schema version")
+			})).Return(nil).Once()
+
+			err := c.ProcessMessageTest(context.Background(), msg)
+			require.ErrorIs(t, err, model.ErrUnsupportedSchemaVersion)
+			mockSvc.AssertExpectations(t)
+			mockSvc.AssertNotCalled(t, "SaveOrder")
+		})
+	}
+
+	// Исходное сообщение не сохранилось. Заказ не сохраняется, возвращается ошибка
+	t.Run("ProcessMessage/SaveRawMessage error", func(t *testing.T) {
+		mockSvc := &MockService{}
+		c = consumer.NewConsumer(mockSvc, stubReader)
+		mockSvc.On("SaveRawMessage", mock.Anything, mock.Anything).Return(errors.New("db error")).Once()
+
+		err := c.ProcessMessageTest(context.Background(), kafka.Message{Value: mustJSON(t, FakeValidOrder("1"))})
+		require.ErrorContains(t, err, "db error")
+		mockSvc.AssertNotCalled(t, "SaveOrder")
+	})
+
+	// Хранилище без исходных сообщений: заказ сохраняется как раньше
+	t.Run("ProcessMessage/raw messages not supported", func(t *testing.T) {
+		mockSvc := &MockService{}
+		c = consumer.NewConsumer(mockSvc, stubReader)
+		mockSvc.On("SaveRawMessage", mock.Anything, mock.Anything).Return(service.ErrNotSupported).Once()
+		mockSvc.On("SaveOrder", mock.Anything, mock.AnythingOfType("*ordermodel.Order")).Return(nil).Once()
+
+		require.NoError(t, c.ProcessMessageTest(context.Background(), kafka.Message{Value: mustJSON(t, FakeValidOrder("1"))}))
+		mockSvc.AssertExpectations(t)
+	})
+
 	// SaveOrder возвращает ошибку. Возвращает ошибку.
 	t.Run("ProcessMessage/SaveOrder return error", func(t *testing.T) {
-		mockSvc := &MockService{}
+		mockSvc := newRawMockService()
 		c := consumer.NewConsumer(mockSvc, stubReader)
 
 		order := FakeValidOrder("1")
@@ -143,7 +317,7 @@ func TestConsumer(t *testing.T) {
 		require.NoError(t, err)
 
 		mockSvc.
-			On("SaveOrder", mock.Anything, mock.AnythingOfType("*model.Order")).
+			On("SaveOrder", mock.Anything, mock.AnythingOfType("*ordermodel.Order")).
 			Return(errors.New("db error")).
 			Once()
 
@@ -158,7 +332,7 @@ func TestConsumer(t *testing.T) {
 
 	// SaveOrder возвращает nil. Возвращает nil
 	t.Run("ProcessMessage/SaveOrder return nil", func(t *testing.T) {
-		mockSvc := &MockService{}
+		mockSvc := newRawMockService()
 		c := consumer.NewConsumer(mockSvc, stubReader)
 
 		order := FakeValidOrder("2")
@@ -166,7 +340,7 @@ func TestConsumer(t *testing.T) {
 		require.NoError(t, err)
 
 		mockSvc.
-			On("SaveOrder", mock.Anything, mock.AnythingOfType("*model.Order")).
+			On("SaveOrder", mock.Anything, mock.AnythingOfType("*ordermodel.Order")).
 			Return(nil).
 			Once()
 
@@ -179,3 +353,10 @@ func TestConsumer(t *testing.T) {
 	})
 
 }
+
+// newRawMockService MockService, который принимает любые исходные сообщения
+func newRawMockService() *MockService {
+	m := &MockService{}
+	m.On("SaveRawMessage", mock.Anything, mock.Anything).Return(nil).Maybe()
+	return m
+}
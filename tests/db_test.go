@@ -3,13 +3,16 @@ package tests
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gogazub/myapp/internal/model"
 	"github.com/gogazub/myapp/internal/repository"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/require"
 )
 
@@ -26,6 +29,46 @@ func newDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
 
 func q(sql string) string { return regexp.QuoteMeta(sql) }
 
+// expectBeginTx начало транзакции записи вместе с серверным statement_timeout
+func expectBeginTx(mock sqlmock.Sqlmock, timeout time.Duration) {
+	mock.ExpectBegin()
+	mock.ExpectExec(q(`SET LOCAL statement_timeout = ` + strconvI(int(timeout.Milliseconds())))).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// expectLockPrevious advisory lock на order_uid и чтение текущей версии заказа под FOR UPDATE.
+// prev == nil - заказа еще нет
+func expectLockPrevious(mock sqlmock.Sqlmock, uid string, prev *model.Order) {
+	mock.ExpectExec(q(`SELECT pg_advisory_xact_lock(hashtext($1))`)).
+		WithArgs(uid).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows(orderJoinColumns)
+	if prev != nil {
+		addOrderJoinRow(rows, prev)
+	}
+	mock.ExpectQuery(`WHERE o.order_uid = \$1 FOR UPDATE OF o`).
+		WithArgs(uid).
+		WillReturnRows(rows)
+	if prev != nil {
+		mock.ExpectQuery(`FROM items`).
+			WillReturnRows(addItemRows(sqlmock.NewRows(itemColumns), prev))
+	}
+}
+
+// expectHistory запись в order_history с операцией op
+func expectHistory(mock sqlmock.Sqlmock, uid, op string) {
+	mock.ExpectExec(q(`INSERT INTO order_history (order_uid, op, source, changes) VALUES ($1, $2, $3, $4)`)).
+		WithArgs(uid, op, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// expectOutbox событие order.saved в order_outbox в транзакции записи
+func expectOutbox(mock sqlmock.Sqlmock, uid string) {
+	mock.ExpectExec(`INSERT INTO order_outbox \(order_uid, event_type, payload, payload_enc, pii_key, pii_key_id\)`).
+		WithArgs(uid, repository.EventOrderSaved, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
 // ---------- Save ----------
 func TestDBRepository_Save(t *testing.T) {
 	db, mock := newDB(t)
@@ -33,14 +76,15 @@ func TestDBRepository_Save(t *testing.T) {
 	o := FakeValidOrder("uid-1")
 
 	t.Run("success: upsert всех сущностей в одной транзакции -> commit", func(t *testing.T) {
-		mock.ExpectBegin()
+		expectBeginTx(mock, 5*time.Second)
+		expectLockPrevious(mock, o.OrderUID, nil)
 
 		mock.ExpectExec(q(`
 			INSERT INTO orders (
 				order_uid, track_number, entry, locale, internal_signature,
 				customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard
 			) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
-			ON CONFLICT (order_uid) DO UPDATE SET
+			ON CONFLICT (order_uid, date_created) DO UPDATE SET
 				track_number = EXCLUDED.track_number,
 				entry = EXCLUDED.entry,
 				locale = EXCLUDED.locale,
@@ -49,27 +93,28 @@ func TestDBRepository_Save(t *testing.T) {
 				delivery_service = EXCLUDED.delivery_service,
 				shardkey = EXCLUDED.shardkey,
 				sm_id = EXCLUDED.sm_id,
-				date_created = EXCLUDED.date_created,
-				oof_shard = EXCLUDED.oof_shard
+				oof_shard = EXCLUDED.oof_shard,
+				updated_at = now()
 		`)).
 			WithArgs(o.OrderUID, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature,
 				o.CustomerID, o.DeliveryService, o.Shardkey, o.SmID, o.DateCreated, o.OofShard).
 			WillReturnResult(sqlmock.NewResult(0, 1))
 
+		// Без ключей PII персональные поля пишутся открытым текстом, шифротексты - NULL
 		mock.ExpectExec(q(`
-			INSERT INTO deliveries (order_uid, name, phone, zip, city, address, region, email)
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+			INSERT INTO deliveries (order_uid, zip, city, region,
+				name, phone, address, email, name_enc, phone_enc, address_enc, email_enc,
+				pii_key, pii_key_id, name_bidx, phone_bidx, email_bidx)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17)
 			ON CONFLICT ON CONSTRAINT deliveries_order_uid_uniq DO UPDATE SET
-				name = EXCLUDED.name,
-				phone = EXCLUDED.phone,
 				zip = EXCLUDED.zip,
 				city = EXCLUDED.city,
-				address = EXCLUDED.address,
 				region = EXCLUDED.region,
-				email = EXCLUDED.email
+				name = EXCLUDED.name,
 		`)).
-			WithArgs(o.OrderUID, o.Delivery.Name, o.Delivery.Phone, o.Delivery.Zip,
-				o.Delivery.City, o.Delivery.Address, o.Delivery.Region, o.Delivery.Email).
+			WithArgs(o.OrderUID, o.Delivery.Zip, o.Delivery.City, o.Delivery.Region,
+				o.Delivery.Name, o.Delivery.Phone, o.Delivery.Address, o.Delivery.Email,
+				nil, nil, nil, nil, nil, nil, nil, nil, nil).
 			WillReturnResult(sqlmock.NewResult(0, 1))
 
 		mock.ExpectExec(q(`
@@ -95,23 +140,9 @@ func TestDBRepository_Save(t *testing.T) {
 			).
 			WillReturnResult(sqlmock.NewResult(0, 1))
 
-		mock.ExpectExec(q(`DELETE FROM items WHERE order_uid = $1`)).
-			WithArgs(o.OrderUID).
-			WillReturnResult(sqlmock.NewResult(0, 2))
-
-		insertItems := q(`
-			INSERT INTO items (order_uid, chrt_id, track_number, price, rid, name,
-				sale, size, total_price, nm_id, brand, status)
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
-		`)
-		for _, it := range o.Items {
-			mock.ExpectExec(insertItems).
-				WithArgs(
-					o.OrderUID, it.ChrtID, it.TrackNumber, it.Price, it.Rid, it.Name,
-					it.Sale, it.Size, it.TotalPrice, it.NmID, it.Brand, it.Status,
-				).
-				WillReturnResult(sqlmock.NewResult(0, 1))
-		}
+		expectSaveItems(mock, o, o.Items)
+		expectHistory(mock, o.OrderUID, model.HistoryCreate)
+		expectOutbox(mock, o.OrderUID)
 
 		mock.ExpectCommit()
 
@@ -120,7 +151,8 @@ func TestDBRepository_Save(t *testing.T) {
 	})
 
 	t.Run("error: orders upsert падает -> rollback и ошибка наружу", func(t *testing.T) {
-		mock.ExpectBegin()
+		expectBeginTx(mock, 5*time.Second)
+		expectLockPrevious(mock, o.OrderUID, nil)
 		mock.ExpectExec("INSERT INTO orders").
 			WillReturnError(errors.New("db fail"))
 		mock.ExpectRollback()
@@ -131,116 +163,585 @@ func TestDBRepository_Save(t *testing.T) {
 	})
 }
 
-// ---------------- GetByID ----------------
+// expectSaveItems удаление исчезнувших позиций и один multi-row upsert на все items
+func expectSaveItems(mock sqlmock.Sqlmock, o *model.Order, items []model.Item) {
+	chrtIDs := make([]int64, 0, len(items))
+	args := make([]driver.Value, 0, len(items)*13)
+	for _, it := range items {
+		chrtIDs = append(chrtIDs, it.ChrtID)
+		args = append(args, o.OrderUID, it.ChrtID, it.TrackNumber, it.Price, it.Rid, it.Name,
+			it.Sale, it.Size, it.TotalPrice, it.NmID, it.Brand, it.Status, o.DateCreated)
+	}
+	mock.ExpectExec(q(`DELETE FROM items WHERE order_uid = $1 AND date_created = $2 AND NOT (chrt_id = ANY($3))`)).
+		WithArgs(o.OrderUID, o.DateCreated, pq.Array(chrtIDs)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if len(items) == 0 {
+		return
+	}
+	mock.ExpectExec(`INSERT INTO items .* VALUES \(\$1,\$2,.*\$` + strconvI(len(items)*13) + `\)\s+ON CONFLICT \(order_uid, chrt_id, date_created\) DO UPDATE SET.*IS DISTINCT FROM`).
+		WithArgs(args...).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(items))))
+}
 
-func expectGetByID(mock sqlmock.Sqlmock, o *model.Order) {
-	mock.ExpectQuery(q(`
-		SELECT order_uid, track_number, entry, locale, internal_signature,
-		       customer_id, delivery_service, shardkey, sm_id, date_created, oof_shard
-		FROM orders WHERE order_uid = $1
-	`)).
-		WithArgs(o.OrderUID).
-		WillReturnRows(sqlmock.NewRows([]string{
-			"order_uid", "track_number", "entry", "locale", "internal_signature",
-			"customer_id", "delivery_service", "shardkey", "sm_id", "date_created", "oof_shard",
-		}).AddRow(
-			o.OrderUID, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature,
-			o.CustomerID, o.DeliveryService, o.Shardkey, o.SmID, o.DateCreated, o.OofShard,
-		))
-
-	mock.ExpectQuery(q(`
-		SELECT delivery_id, order_uid, name, phone, zip, city, address, region, email
-		FROM deliveries WHERE order_uid = $1
-	`)).
-		WithArgs(o.OrderUID).
-		WillReturnRows(sqlmock.NewRows([]string{
-			"delivery_id", "order_uid", "name", "phone", "zip", "city", "address", "region", "email",
-		}).AddRow(1, o.OrderUID, o.Delivery.Name, o.Delivery.Phone, o.Delivery.Zip, o.Delivery.City, o.Delivery.Address, o.Delivery.Region, o.Delivery.Email))
-
-	mock.ExpectQuery(q(`
-		SELECT payment_id, order_uid, transaction, request_id, currency, provider,
-		       amount, payment_dt, bank, delivery_cost, goods_total, custom_fee
-		FROM payments WHERE order_uid = $1
-	`)).
-		WithArgs(o.OrderUID).
-		WillReturnRows(sqlmock.NewRows([]string{
-			"payment_id", "order_uid", "transaction", "request_id", "currency", "provider",
-			"amount", "payment_dt", "bank", "delivery_cost", "goods_total", "custom_fee",
-		}).AddRow(1, o.OrderUID, o.Payment.Transaction, o.Payment.RequestID, o.Payment.Currency, o.Payment.Provider, o.Payment.Amount, o.Payment.PaymentDt, o.Payment.Bank, o.Payment.DeliveryCost, o.Payment.GoodsTotal, o.Payment.CustomFee))
-
-	items := sqlmock.NewRows([]string{
-		"item_id", "order_uid", "chrt_id", "track_number", "price", "rid", "name",
-		"sale", "size", "total_price", "nm_id", "brand", "status",
+func TestDBRepository_SaveItems(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+
+	expectUpserts := func(uid string) {
+		expectBeginTx(mock, 5*time.Second)
+		expectLockPrevious(mock, uid, nil)
+		mock.ExpectExec("INSERT INTO orders").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO deliveries").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO payments").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	t.Run("дубли chrt_id: ErrDuplicateItem, транзакция не начинается", func(t *testing.T) {
+		o := FakeValidOrder("uid-dup")
+		first := o.Items[0]
+		second := first
+		second.Price = first.Price + 10
+		o.Items = []model.Item{first, second}
+
+		require.ErrorIs(t, repo.Save(context.Background(), o), model.ErrDuplicateItem)
+		require.NoError(t, mock.ExpectationsWereMet())
 	})
-	for i, it := range o.Items {
-		items.AddRow(i+1, o.OrderUID, it.ChrtID, it.TrackNumber, it.Price, it.Rid, it.Name, it.Sale, it.Size, it.TotalPrice, it.NmID, it.Brand, it.Status)
+
+	t.Run("пустой список: удаляются все позиции, INSERT нет", func(t *testing.T) {
+		o := FakeValidOrder("uid-empty")
+		o.Items = nil
+
+		expectUpserts(o.OrderUID)
+		expectSaveItems(mock, o, nil)
+		expectHistory(mock, o.OrderUID, model.HistoryCreate)
+		expectOutbox(mock, o.OrderUID)
+		mock.ExpectCommit()
+
+		require.NoError(t, repo.Save(context.Background(), o))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("большой заказ режется на батчи по 1000 строк", func(t *testing.T) {
+		o := FakeValidOrder("uid-big")
+		base := o.Items[0]
+		o.Items = make([]model.Item, 0, 1500)
+		for i := 0; i < 1500; i++ {
+			it := base
+			it.ChrtID = int64(i + 1)
+			o.Items = append(o.Items, it)
+		}
+
+		expectUpserts(o.OrderUID)
+		mock.ExpectExec("DELETE FROM items").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO items .*\$13000\)\s+ON CONFLICT`).
+			WillReturnResult(sqlmock.NewResult(0, 1000))
+		mock.ExpectExec(`INSERT INTO items .*\$6500\)\s+ON CONFLICT`).
+			WillReturnResult(sqlmock.NewResult(0, 500))
+		expectHistory(mock, o.OrderUID, model.HistoryCreate)
+		expectOutbox(mock, o.OrderUID)
+		mock.ExpectCommit()
+
+		require.NoError(t, repo.Save(context.Background(), o))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// ---------- Soft delete ----------
+
+func TestDBRepository_Delete(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+	deletedAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("success: deleted_at и запись delete в истории", func(t *testing.T) {
+		expectBeginTx(mock, 5*time.Second)
+		mock.ExpectQuery(`UPDATE orders SET deleted_at = now\(\), updated_at = now\(\)\s+WHERE order_uid = \$1 AND deleted_at IS NULL\s+RETURNING deleted_at`).
+			WithArgs("uid-1").
+			WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}).AddRow(deletedAt))
+		expectHistory(mock, "uid-1", model.HistoryDelete)
+		mock.ExpectCommit()
+
+		require.NoError(t, repo.Delete(context.Background(), "uid-1"))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("нет заказа или уже удален -> ErrOrderNotFound", func(t *testing.T) {
+		expectBeginTx(mock, 5*time.Second)
+		mock.ExpectQuery("UPDATE orders SET deleted_at").
+			WithArgs("uid-1").
+			WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}))
+		mock.ExpectRollback()
+
+		require.ErrorIs(t, repo.Delete(context.Background(), "uid-1"), repository.ErrOrderNotFound)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// Повторная доставка сообщения об удаленном заказе его не восстанавливает
+func TestDBRepository_Save_DeletedOrder(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+	o := FakeValidOrder("uid-deleted")
+	prev := o.Clone()
+	deletedAt := time.Now()
+	prev.DeletedAt = &deletedAt
+
+	expectBeginTx(mock, 5*time.Second)
+	expectLockPrevious(mock, o.OrderUID, prev)
+	mock.ExpectRollback()
+
+	require.ErrorIs(t, repo.Save(context.Background(), o), repository.ErrOrderDeleted)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Изменился date_created: заказ и позиции переносятся в партицию нового месяца до upsert
+func TestDBRepository_Save_MovesPartition(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+	o := FakeValidOrder("uid-moved")
+	prev := o.Clone()
+	prev.DateCreated = o.DateCreated.AddDate(0, -2, 0)
+
+	expectBeginTx(mock, 5*time.Second)
+	expectLockPrevious(mock, o.OrderUID, prev)
+	mock.ExpectExec(q(`UPDATE orders SET date_created = $2 WHERE order_uid = $1 AND date_created = $3`)).
+		WithArgs(o.OrderUID, o.DateCreated, prev.DateCreated).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(`UPDATE items SET date_created = $2 WHERE order_uid = $1 AND date_created = $3`)).
+		WithArgs(o.OrderUID, o.DateCreated, prev.DateCreated).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(o.Items))))
+	mock.ExpectExec("INSERT INTO orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO deliveries").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO payments").WillReturnResult(sqlmock.NewResult(0, 1))
+	expectSaveItems(mock, o, o.Items)
+	expectHistory(mock, o.OrderUID, model.HistoryUpdate)
+	expectOutbox(mock, o.OrderUID)
+	mock.ExpectCommit()
+
+	require.NoError(t, repo.Save(context.Background(), o))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBRepository_EnsurePartitions(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+
+	mock.ExpectQuery(q(`SELECT ensure_order_partitions(now(), now() + make_interval(months => $1))`)).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"created"}).AddRow(2))
+
+	n, err := repo.EnsurePartitions(context.Background(), 3)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Зависший запрос внутри транзакции прерывается отменой ctx (shutdown консьюмера),
+// не дожидаясь ни statement_timeout, ни самого запроса
+func TestDBRepository_Save_HungStatementAbortedOnShutdown(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepositoryWithConfig(db, repository.DBConfig{StatementTimeout: time.Minute})
+	o := FakeValidOrder("uid-hung")
+
+	expectBeginTx(mock, time.Minute)
+	expectLockPrevious(mock, o.OrderUID, nil)
+	mock.ExpectExec("INSERT INTO orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO deliveries").
+		WillDelayFor(time.Hour).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- repo.Save(ctx, o) }()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, context.Canceled)
+		require.Contains(t, err.Error(), "saveDelivery")
+	case <-time.After(2 * time.Second):
+		t.Fatal("Save не прервался после отмены контекста")
 	}
-	mock.ExpectQuery(q(`
-		SELECT item_id, order_uid, chrt_id, track_number, price, rid, name,
-		       sale, size, total_price, nm_id, brand, status
-		FROM items WHERE order_uid = $1
-	`)).
+	// database/sql откатывает транзакцию отмененного ctx в своей горутине, возможно уже после возврата Save
+	require.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, time.Second, 5*time.Millisecond)
+}
+
+// Без отмены ctx зависший запрос ограничен StatementTimeout
+func TestDBRepository_Save_StatementTimeout(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepositoryWithConfig(db, repository.DBConfig{StatementTimeout: 50 * time.Millisecond})
+	o := FakeValidOrder("uid-slow")
+
+	expectBeginTx(mock, 50*time.Millisecond)
+	expectLockPrevious(mock, o.OrderUID, nil)
+	mock.ExpectExec("INSERT INTO orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO deliveries").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO payments").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM items").
+		WillDelayFor(time.Hour).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	start := time.Now()
+	err := repo.Save(context.Background(), o)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Contains(t, err.Error(), "deleteItems")
+	require.Less(t, time.Since(start), 2*time.Second)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// ---------------- GetByID ----------------
+
+func expectGetByID(mock sqlmock.Sqlmock, o *model.Order) {
+	mock.ExpectQuery(`FROM orders o\s+JOIN deliveries d ON d.order_uid = o.order_uid\s+JOIN payments p ON p.order_uid = o.order_uid\s+WHERE o.order_uid = \$1 AND o.deleted_at IS NULL`).
 		WithArgs(o.OrderUID).
-		WillReturnRows(items)
+		WillReturnRows(addOrderJoinRow(sqlmock.NewRows(orderJoinColumns), o))
+
+	mock.ExpectQuery(q(`FROM items WHERE order_uid = ANY($1) AND date_created = ANY($2)`)).
+		WillReturnRows(addItemRows(sqlmock.NewRows(itemColumns), o))
 }
 
 func TestDBRepository_GetByID(t *testing.T) {
 	db, mock := newDB(t)
 	repo := repository.NewOrderRepository(db)
 
-	t.Run("success: грузим order + delivery + payment + items", func(t *testing.T) {
+	t.Run("success: order+delivery+payment одним join, items вторым запросом", func(t *testing.T) {
 		o := FakeValidOrder("uid-2")
 		expectGetByID(mock, o)
 
 		got, err := repo.GetByID(context.Background(), o.OrderUID)
 		require.NoError(t, err)
 		require.Equal(t, o.OrderUID, got.OrderUID)
+		require.Equal(t, o.Delivery.Email, got.Delivery.Email)
+		require.Equal(t, o.Payment.Amount, got.Payment.Amount)
 		require.Len(t, got.Items, len(o.Items))
 		require.NoError(t, mock.ExpectationsWereMet())
 	})
 
-	t.Run("not found: orders возвращает sql.ErrNoRows", func(t *testing.T) {
-		mock.ExpectQuery("FROM orders WHERE order_uid = \\$1").
+	t.Run("not found: пустой результат -> ErrOrderNotFound", func(t *testing.T) {
+		mock.ExpectQuery(`WHERE o.order_uid = \$1`).
 			WithArgs("missing").
-			WillReturnError(sql.ErrNoRows)
+			WillReturnRows(sqlmock.NewRows(orderJoinColumns))
 
 		got, err := repo.GetByID(context.Background(), "missing")
+		require.ErrorIs(t, err, repository.ErrOrderNotFound)
+		require.Nil(t, got)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("error: запрос падает", func(t *testing.T) {
+		mock.ExpectQuery(`WHERE o.order_uid = \$1`).
+			WithArgs("uid-err").
+			WillReturnError(errors.New("db fail"))
+
+		got, err := repo.GetByID(context.Background(), "uid-err")
 		require.Error(t, err)
+		require.NotErrorIs(t, err, repository.ErrOrderNotFound)
 		require.Nil(t, got)
 		require.NoError(t, mock.ExpectationsWereMet())
 	})
 }
 
-// ---------------- GetAll ----------------
+// ---------------- GetByIDs ----------------
 
-func TestDBRepository_GetAll(t *testing.T) {
+func TestDBRepository_GetByIDs(t *testing.T) {
 	db, mock := newDB(t)
 	repo := repository.NewOrderRepository(db)
 
-	t.Run("success: читаем список id и для каждого вызываем GetByID", func(t *testing.T) {
-		ids := sqlmock.NewRows([]string{"order_uid"}).
-			AddRow("uid-1").
-			AddRow("uid-2")
-		mock.ExpectQuery(q(`SELECT order_uid FROM orders`)).WillReturnRows(ids)
-
+	t.Run("success: два запроса на весь батч", func(t *testing.T) {
 		o1 := FakeValidOrder("uid-1")
 		o2 := FakeValidOrder("uid-2")
-		expectGetByID(mock, o1)
-		expectGetByID(mock, o2)
 
-		list, err := repo.GetAll(context.Background())
+		mock.ExpectQuery(`WHERE o.order_uid = ANY\(\$1\) AND o.deleted_at IS NULL`).
+			WillReturnRows(addOrderJoinRow(addOrderJoinRow(sqlmock.NewRows(orderJoinColumns), o1), o2))
+		mock.ExpectQuery(q(`FROM items WHERE order_uid = ANY($1) AND date_created = ANY($2)`)).
+			WillReturnRows(addItemRows(addItemRows(sqlmock.NewRows(itemColumns), o1), o2))
+
+		list, err := repo.GetByIDs(context.Background(), []string{"uid-1", "uid-2", "missing"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"uid-1", "uid-2"}, idsFromOrders(list))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("пустой список: без запросов", func(t *testing.T) {
+		list, err := repo.GetByIDs(context.Background(), nil)
+		require.NoError(t, err)
+		require.Empty(t, list)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// ---------------- ListPage / Iterate ----------------
+
+var orderJoinColumns = []string{
+	"order_uid", "track_number", "entry", "locale", "internal_signature",
+	"customer_id", "delivery_service", "shardkey", "sm_id", "date_created", "oof_shard", "deleted_at",
+	"delivery_id", "name", "phone", "zip", "city", "address", "region", "email",
+	"name_enc", "phone_enc", "address_enc", "email_enc", "pii_key", "pii_key_id",
+	"payment_id", "transaction", "request_id", "currency", "provider",
+	"amount", "payment_dt", "bank", "delivery_cost", "goods_total", "custom_fee",
+}
+
+var itemColumns = []string{
+	"item_id", "order_uid", "chrt_id", "track_number", "price", "rid", "name",
+	"sale", "size", "total_price", "nm_id", "brand", "status",
+}
+
+// addOrderJoinRow добавляет строку orders+deliveries+payments для o
+func addOrderJoinRow(rows *sqlmock.Rows, o *model.Order) *sqlmock.Rows {
+	return rows.AddRow(
+		o.OrderUID, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature,
+		o.CustomerID, o.DeliveryService, o.Shardkey, o.SmID, o.DateCreated, o.OofShard, o.DeletedAt,
+		1, o.Delivery.Name, o.Delivery.Phone, o.Delivery.Zip, o.Delivery.City,
+		o.Delivery.Address, o.Delivery.Region, o.Delivery.Email,
+		nil, nil, nil, nil, nil, nil,
+		1, o.Payment.Transaction, o.Payment.RequestID, o.Payment.Currency, o.Payment.Provider,
+		o.Payment.Amount, o.Payment.PaymentDt, o.Payment.Bank, o.Payment.DeliveryCost,
+		o.Payment.GoodsTotal, o.Payment.CustomFee,
+	)
+}
+
+// addItemRows добавляет строки items для o
+func addItemRows(rows *sqlmock.Rows, o *model.Order) *sqlmock.Rows {
+	for i, it := range o.Items {
+		rows.AddRow(i+1, o.OrderUID, it.ChrtID, it.TrackNumber, it.Price, it.Rid, it.Name,
+			it.Sale, it.Size, it.TotalPrice, it.NmID, it.Brand, it.Status)
+	}
+	return rows
+}
+
+func TestDBRepository_ListPage(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+
+	t.Run("первая страница: без курсора, два запроса на весь набор", func(t *testing.T) {
+		o1 := FakeValidOrder("uid-new")
+		o2 := FakeValidOrder("uid-old")
+
+		mock.ExpectQuery(`FROM orders o\s+JOIN deliveries d ON d.order_uid = o.order_uid\s+JOIN payments p ON p.order_uid = o.order_uid\s+WHERE o.deleted_at IS NULL\s+ORDER BY o.date_created DESC, o.order_uid DESC LIMIT \$1`).
+			WithArgs(2).
+			WillReturnRows(addOrderJoinRow(addOrderJoinRow(sqlmock.NewRows(orderJoinColumns), o1), o2))
+
+		items := addItemRows(addItemRows(sqlmock.NewRows(itemColumns), o1), o2)
+		mock.ExpectQuery(q(`FROM items WHERE order_uid = ANY($1) AND date_created = ANY($2)`)).
+			WillReturnRows(items)
+
+		list, err := repo.ListPage(context.Background(), repository.Cursor{}, 2)
 		require.NoError(t, err)
 		require.Len(t, list, 2)
+		require.Equal(t, "uid-new", list[0].OrderUID)
+		require.Equal(t, "uid-old", list[1].OrderUID)
+		require.Len(t, list[0].Items, len(o1.Items))
+		require.Equal(t, o1.Delivery.Email, list[0].Delivery.Email)
+		require.Equal(t, o2.Payment.Transaction, list[1].Payment.Transaction)
 		require.NoError(t, mock.ExpectationsWereMet())
 	})
 
-	t.Run("error: SELECT order_uid падает", func(t *testing.T) {
-		mock.ExpectQuery(`SELECT order_uid FROM orders`).
-			WillReturnError(errors.New("db fail"))
+	t.Run("следующая страница: keyset по (date_created, order_uid)", func(t *testing.T) {
+		after := repository.Cursor{DateCreated: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), OrderUID: "uid-old"}
+		mock.ExpectQuery(`WHERE \(o.date_created, o.order_uid\) < \(\$1, \$2\) AND o.deleted_at IS NULL\s+ORDER BY o.date_created DESC, o.order_uid DESC LIMIT \$3`).
+			WithArgs(after.DateCreated, after.OrderUID, 2).
+			WillReturnRows(sqlmock.NewRows(orderJoinColumns))
 
-		list, err := repo.GetAll(context.Background())
-		require.Error(t, err)
-		require.Nil(t, list)
+		list, err := repo.ListPage(context.Background(), after, 2)
+		require.NoError(t, err)
+		require.Empty(t, list)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("limit <= 0: без запросов", func(t *testing.T) {
+		list, err := repo.ListPage(context.Background(), repository.Cursor{}, 0)
+		require.NoError(t, err)
+		require.Empty(t, list)
 		require.NoError(t, mock.ExpectationsWereMet())
 	})
 }
+
+func TestIterateOrders(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+	ctx := context.Background()
+
+	o1, o2, o3 := FakeValidOrder("uid-3"), FakeValidOrder("uid-2"), FakeValidOrder("uid-1")
+
+	// Полная страница -> запрашивается следующая с курсором последнего заказа
+	mock.ExpectQuery(`ORDER BY o.date_created DESC, o.order_uid DESC LIMIT \$1`).
+		WithArgs(2).
+		WillReturnRows(addOrderJoinRow(addOrderJoinRow(sqlmock.NewRows(orderJoinColumns), o1), o2))
+	mock.ExpectQuery(`FROM items`).
+		WillReturnRows(addItemRows(addItemRows(sqlmock.NewRows(itemColumns), o1), o2))
+	mock.ExpectQuery(`WHERE \(o.date_created, o.order_uid\) < \(\$1, \$2\)`).
+		WithArgs(o2.DateCreated, o2.OrderUID, 2).
+		WillReturnRows(addOrderJoinRow(sqlmock.NewRows(orderJoinColumns), o3))
+	mock.ExpectQuery(`FROM items`).
+		WillReturnRows(addItemRows(sqlmock.NewRows(itemColumns), o3))
+	// Неполная страница - конец таблицы, третьего запроса нет
+
+	var ids []string
+	for o, err := range repository.IterateOrders(ctx, repo, 2) {
+		require.NoError(t, err)
+		ids = append(ids, o.OrderUID)
+	}
+	require.Equal(t, []string{"uid-3", "uid-2", "uid-1"}, ids)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIterateChunks_StopsOnCancelBetweenChunks(t *testing.T) {
+	db := new(mockDBRepo)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	page := []*model.Order{FakeOrder("a"), FakeOrder("b")}
+	db.On("ListPage", ctx, repository.Cursor{}, 2).Return(page, nil).Once()
+
+	chunks := 0
+	var lastErr error
+	for chunk, err := range repository.IterateChunks(ctx, db, 2) {
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		chunks++
+		require.Len(t, chunk, 2)
+		// Отмена во время обработки страницы: следующая страница не запрашивается
+		cancel()
+	}
+	require.Equal(t, 1, chunks)
+	require.ErrorIs(t, lastErr, context.Canceled)
+	db.AssertExpectations(t)
+}
+
+func TestIterateChunks_Break(t *testing.T) {
+	db := new(mockDBRepo)
+	ctx := context.Background()
+
+	db.On("ListPage", ctx, repository.Cursor{}, 1).Return([]*model.Order{FakeOrder("a")}, nil).Once()
+
+	for range repository.IterateChunks(ctx, db, 1) {
+		break
+	}
+	db.AssertExpectations(t)
+}
+
+// ---------------- Benchmarks ----------------
+
+// benchOrders n валидных заказов по три item в каждом
+func benchOrders(n int) []*model.Order {
+	orders := make([]*model.Order, 0, n)
+	for i := 0; i < n; i++ {
+		o := FakeValidOrder("uid-" + strconvI(i))
+		o.Items = append(o.Items, o.Items[0], o.Items[0])
+		for j := range o.Items {
+			o.Items[j].ChrtID = int64(j + 1)
+		}
+		orders = append(orders, o)
+	}
+	return orders
+}
+
+// Каждая итерация - ровно два ожидаемых запроса к sqlmock, независимо от числа заказов
+func BenchmarkIterateOrders(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run("orders="+strconvI(n), func(b *testing.B) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+			require.NoError(b, err)
+			defer func() { _ = db.Close() }()
+			repo := repository.NewOrderRepository(db)
+			orders := benchOrders(n)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				orderRows := sqlmock.NewRows(orderJoinColumns)
+				itemRows := sqlmock.NewRows(itemColumns)
+				for _, o := range orders {
+					addOrderJoinRow(orderRows, o)
+					addItemRows(itemRows, o)
+				}
+				mock.ExpectQuery(`FROM orders o`).WillReturnRows(orderRows)
+				mock.ExpectQuery(`FROM items`).WillReturnRows(itemRows)
+				b.StartTimer()
+
+				// n < chunk: одна страница, два запроса
+				count := 0
+				for _, err := range repository.IterateOrders(context.Background(), repo, 2*n) {
+					if err != nil {
+						b.Fatal(err)
+					}
+					count++
+				}
+				if count != n {
+					b.Fatalf("IterateOrders: count=%d", count)
+				}
+			}
+			b.StopTimer()
+			require.NoError(b, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func BenchmarkDBRepository_GetByIDs(b *testing.B) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	require.NoError(b, err)
+	defer func() { _ = db.Close() }()
+	repo := repository.NewOrderRepository(db)
+	orders := benchOrders(100)
+	ids := make([]string, 0, len(orders))
+	for _, o := range orders {
+		ids = append(ids, o.OrderUID)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		orderRows := sqlmock.NewRows(orderJoinColumns)
+		itemRows := sqlmock.NewRows(itemColumns)
+		for _, o := range orders {
+			addOrderJoinRow(orderRows, o)
+			addItemRows(itemRows, o)
+		}
+		mock.ExpectQuery(`WHERE o.order_uid = ANY`).WillReturnRows(orderRows)
+		mock.ExpectQuery(`FROM items`).WillReturnRows(itemRows)
+		b.StartTimer()
+
+		if _, err := repo.GetByIDs(context.Background(), ids); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	require.NoError(b, mock.ExpectationsWereMet())
+}
+
+// Watermark отстает от часов БД на запас: транзакция, начатая до отметки и закоммиченная после,
+// получает updated_at = now() меньше max(updated_at) и иначе была бы пропущена ChangedSince
+func TestDBRepository_Watermark_ClockMinusMargin(t *testing.T) {
+	db, mock := newDB(t)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name string
+		cfg  repository.DBConfig
+		secs float64
+	}{
+		{"default", repository.DBConfig{}, 100},
+		{"from statement timeout", repository.DBConfig{StatementTimeout: time.Second}, 20},
+		{"explicit", repository.DBConfig{WatermarkMargin: 3 * time.Minute}, 180},
+		{"not below statement timeout", repository.DBConfig{StatementTimeout: time.Minute, WatermarkMargin: time.Second}, 60},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := repository.NewOrderRepositoryWithConfig(db, tc.cfg)
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT clock_timestamp() - make_interval(secs => $1)`)).
+				WithArgs(tc.secs).
+				WillReturnRows(sqlmock.NewRows([]string{"wm"}).AddRow(now))
+
+			wm, err := repo.Watermark(context.Background())
+			require.NoError(t, err)
+			require.True(t, wm.Equal(now))
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
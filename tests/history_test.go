@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffOrders(t *testing.T) {
+	t.Run("create: все заполненные поля от нулевых значений", func(t *testing.T) {
+		o := FakeValidOrder("uid-1")
+		changes := model.DiffOrders(nil, o)
+
+		fields := make(map[string]model.FieldChange, len(changes))
+		for _, c := range changes {
+			fields[c.Field] = c
+		}
+		require.Equal(t, "uid-1", fields["order_uid"].New)
+		require.Equal(t, o.Payment.Amount, fields["payment.amount"].New)
+		require.Equal(t, "Alice", fields["delivery.name"].New)
+		require.Contains(t, fields, "items[chrt_id=1]")
+		require.NotContains(t, fields, "track_number")
+	})
+
+	t.Run("без изменений: пустой diff", func(t *testing.T) {
+		o := FakeValidOrder("uid-1")
+		require.Empty(t, model.DiffOrders(o, o.Clone()))
+	})
+
+	t.Run("время сравнивается без учета зоны", func(t *testing.T) {
+		o := FakeValidOrder("uid-1")
+		n := o.Clone()
+		n.DateCreated = o.DateCreated.In(time.FixedZone("MSK", 3*3600))
+		require.Empty(t, model.DiffOrders(o, n))
+	})
+
+	t.Run("update: поля заказа, оплаты и позиции", func(t *testing.T) {
+		o := FakeValidOrder("uid-1")
+		n := o.Clone()
+		n.TrackNumber = "WB2"
+		n.Payment.Bank = "Citi"
+		n.Items[0].Status = 202
+		n.Items = append(n.Items, model.Item{ChrtID: 2, TrackNumber: "WB2"})
+
+		require.Equal(t, []model.FieldChange{
+			{Field: "track_number", Old: "", New: "WB2"},
+			{Field: "payment.bank", Old: "Chase", New: "Citi"},
+			{Field: "items[chrt_id=1].status", Old: 0, New: 202},
+			{Field: "items[chrt_id=2]", New: n.Items[1]},
+		}, model.DiffOrders(o, n))
+	})
+
+	t.Run("удаленная позиция", func(t *testing.T) {
+		o := FakeValidOrder("uid-1")
+		n := o.Clone()
+		n.Items = nil
+
+		require.Equal(t, []model.FieldChange{
+			{Field: "items[chrt_id=1]", Old: o.Items[0]},
+		}, model.DiffOrders(o, n))
+	})
+}
+
+func TestChangeSourceFrom(t *testing.T) {
+	require.Equal(t, model.SourceSystem, model.ChangeSourceFrom(context.Background()).Kind)
+
+	src := model.ChangeSource{Kind: model.SourceAPI, User: "admin"}
+	ctx := model.WithChangeSource(context.Background(), src)
+	require.Equal(t, src, model.ChangeSourceFrom(ctx))
+}
+
+// expectSaveAll upsert'ы всех сущностей заказа без проверки аргументов
+func expectSaveAll(mock sqlmock.Sqlmock, o *model.Order) {
+	mock.ExpectExec("INSERT INTO orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO deliveries").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO payments").WillReturnResult(sqlmock.NewResult(0, 1))
+	expectSaveItems(mock, o, o.Items)
+}
+
+// jsonArg проверяет JSON-аргумент запроса через fn
+type jsonArg struct{ fn func(raw []byte) bool }
+
+func (a jsonArg) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	return ok && a.fn(b)
+}
+
+func TestDBRepository_Save_History(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+
+	t.Run("повторное сохранение без изменений: записи в истории нет", func(t *testing.T) {
+		o := FakeValidOrder("uid-same")
+
+		expectBeginTx(mock, 5*time.Second)
+		expectLockPrevious(mock, o.OrderUID, o)
+		expectSaveAll(mock, o)
+		mock.ExpectCommit()
+
+		require.NoError(t, repo.Save(context.Background(), o))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("update из Kafka: diff и источник в order_history", func(t *testing.T) {
+		prev := FakeValidOrder("uid-upd")
+		o := prev.Clone()
+		o.Payment.Bank = "Citi"
+
+		src := model.ChangeSource{Kind: model.SourceKafka, Topic: "orders", Partition: 1, Offset: 100}
+		expectBeginTx(mock, 5*time.Second)
+		expectLockPrevious(mock, o.OrderUID, prev)
+		expectSaveAll(mock, o)
+		mock.ExpectExec("INSERT INTO order_history").
+			WithArgs(o.OrderUID, model.HistoryUpdate,
+				jsonArg{func(raw []byte) bool {
+					var got model.ChangeSource
+					return json.Unmarshal(raw, &got) == nil && got == src
+				}},
+				jsonArg{func(raw []byte) bool {
+					var got []model.FieldChange
+					return json.Unmarshal(raw, &got) == nil && len(got) == 1 &&
+						got[0].Field == "payment.bank" && got[0].Old == "Chase" && got[0].New == "Citi"
+				}}).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectOutbox(mock, o.OrderUID)
+		mock.ExpectCommit()
+
+		ctx := model.WithChangeSource(context.Background(), src)
+		require.NoError(t, repo.Save(ctx, o))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDBRepository_History(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+	columns := []string{"history_id", "order_uid", "changed_at", "op", "source", "changes"}
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("записи от старых к новым", func(t *testing.T) {
+		mock.ExpectQuery(`FROM order_history WHERE order_uid = \$1\s+ORDER BY history_id LIMIT \$2`).
+			WithArgs("uid-1", 10).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, "uid-1", at, model.HistoryCreate, []byte(`{"kind":"kafka","topic":"orders","offset":5}`),
+					[]byte(`[{"field":"order_uid","old":"","new":"uid-1"}]`)).
+				AddRow(2, "uid-1", at.Add(time.Hour), model.HistoryUpdate, []byte(`{"kind":"api","user":"admin"}`),
+					[]byte(`[{"field":"payment.bank","old":"Chase","new":"Citi"}]`)))
+
+		got, err := repo.History(context.Background(), "uid-1", 10)
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		require.Equal(t, model.ChangeSource{Kind: model.SourceKafka, Topic: "orders", Offset: 5}, got[0].Source)
+		require.Equal(t, model.HistoryUpdate, got[1].Op)
+		require.Equal(t, "admin", got[1].Source.User)
+		require.Equal(t, []model.FieldChange{{Field: "payment.bank", Old: "Chase", New: "Citi"}}, got[1].Changes)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("limit вне диапазона ограничивается сверху", func(t *testing.T) {
+		mock.ExpectQuery("FROM order_history").
+			WithArgs("uid-1", 1000).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, "uid-1", at, model.HistoryCreate, []byte(`{"kind":"system"}`), []byte(`[]`)))
+
+		_, err := repo.History(context.Background(), "uid-1", 0)
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("нет записей -> ErrOrderNotFound", func(t *testing.T) {
+		mock.ExpectQuery("FROM order_history").
+			WithArgs("missing", 1000).
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		_, err := repo.History(context.Background(), "missing", 5000)
+		require.ErrorIs(t, err, repository.ErrOrderNotFound)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
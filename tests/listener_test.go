@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gogazub/myapp/internal/listener"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNotifier подменяет *pq.Listener: уведомления пишутся в ch вручную
+type fakeNotifier struct {
+	ch       chan *pq.Notification
+	listened []string
+}
+
+func newFakeNotifier() *fakeNotifier {
+	return &fakeNotifier{ch: make(chan *pq.Notification)}
+}
+
+func (f *fakeNotifier) Listen(channel string) error {
+	f.listened = append(f.listened, channel)
+	return nil
+}
+func (f *fakeNotifier) NotificationChannel() <-chan *pq.Notification { return f.ch }
+func (f *fakeNotifier) Ping() error                                  { return nil }
+func (f *fakeNotifier) Close() error                                 { return nil }
+
+// startListener запускает Listener в горутине и возвращает канал с результатом Start
+func startListener(t *testing.T, l *listener.Listener) (context.CancelFunc, <-chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.Start(ctx) }()
+	t.Cleanup(cancel)
+	return cancel, done
+}
+
+func TestListener_RefreshesChangedOrder(t *testing.T) {
+	n := newFakeNotifier()
+	ms := &MockService{}
+	l := listener.NewListener(ms, n)
+
+	refreshed := make(chan string, 1)
+	ms.On("RefreshCache", mock.Anything, "uid-1").
+		Run(func(args mock.Arguments) { refreshed <- args.String(1) }).
+		Return(nil).
+		Once()
+
+	cancel, done := startListener(t, l)
+	n.ch <- &pq.Notification{Channel: listener.Channel, Extra: "uid-1"}
+
+	select {
+	case id := <-refreshed:
+		require.Equal(t, "uid-1", id)
+	case <-time.After(time.Second):
+		t.Fatal("RefreshCache was not called")
+	}
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+	require.Equal(t, []string{listener.Channel}, n.listened)
+	// Уведомление не должно выкидывать заказ из кеша
+	ms.AssertNotCalled(t, "InvalidateCache", mock.Anything, mock.Anything)
+	ms.AssertExpectations(t)
+}
+
+func TestListener_ResyncAfterReconnect(t *testing.T) {
+	n := newFakeNotifier()
+	ms := &MockService{}
+	l := listener.NewListener(ms, n)
+
+	resynced := make(chan struct{}, 1)
+	ms.On("ResyncCache", mock.Anything).
+		Run(func(mock.Arguments) { resynced <- struct{}{} }).
+		Return(errors.New("db down")). // ошибка ресинка не останавливает listener
+		Once()
+	ms.On("RefreshCache", mock.Anything, "uid-2").Return(nil).Once()
+
+	cancel, done := startListener(t, l)
+	// nil от pq означает переподключение
+	n.ch <- nil
+
+	select {
+	case <-resynced:
+	case <-time.After(time.Second):
+		t.Fatal("ResyncCache was not called")
+	}
+
+	// listener продолжает работать после неудачного ресинка
+	n.ch <- &pq.Notification{Channel: listener.Channel, Extra: "uid-2"}
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+	ms.AssertExpectations(t)
+}
+
+// Пересборка кеша идет вне цикла уведомлений и повторяется, пока занят прогрев
+func TestListener_ResyncOffLoopRetriesWhileWarmup(t *testing.T) {
+	n := newFakeNotifier()
+	ms := &MockService{}
+	l := listener.NewListenerWithConfig(ms, n, listener.Config{ResyncRetry: 10 * time.Millisecond})
+
+	started, release := make(chan struct{}), make(chan struct{})
+	ms.On("ResyncCache", mock.Anything).Return(repository.ErrWarmupInProgress).Once()
+	ms.On("ResyncCache", mock.Anything).
+		Run(func(mock.Arguments) {
+			close(started)
+			<-release
+		}).
+		Return(nil).
+		Once()
+	// reconnect во время пересборки: еще одна пересборка после текущей
+	again := make(chan struct{})
+	ms.On("ResyncCache", mock.Anything).Run(func(mock.Arguments) { close(again) }).Return(nil).Once()
+	refreshed := make(chan struct{})
+	ms.On("RefreshCache", mock.Anything, "uid-3").
+		Run(func(mock.Arguments) { close(refreshed) }).
+		Return(nil).
+		Once()
+
+	cancel, done := startListener(t, l)
+	n.ch <- nil
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("ResyncCache was not retried")
+	}
+	// Пока кеш пересобирается, уведомления обрабатываются; повторный reconnect не ждет пересборку
+	n.ch <- nil
+	n.ch <- &pq.Notification{Channel: listener.Channel, Extra: "uid-3"}
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("notification blocked by resync")
+	}
+
+	close(release)
+	select {
+	case <-again:
+	case <-time.After(time.Second):
+		t.Fatal("reconnect during resync was lost")
+	}
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+	ms.AssertExpectations(t)
+}
@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gogazub/myapp/internal/migrate"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/gogazub/myapp/migrations"
+	sqlitemigrations "github.com/gogazub/myapp/migrations/sqlite"
+	"github.com/stretchr/testify/require"
+)
+
+func testMigrationsFS() fstest.MapFS {
+	return fstest.MapFS{
+		"000001_init.up.sql":     {Data: []byte(`CREATE TABLE a (id INT)`)},
+		"000001_init.down.sql":   {Data: []byte(`DROP TABLE a`)},
+		"000002_second.up.sql":   {Data: []byte(`CREATE TABLE b (id INT)`)},
+		"000002_second.down.sql": {Data: []byte(`DROP TABLE b`)},
+		"README.md":              {Data: []byte(`ignored`)},
+	}
+}
+
+// expectLocked lock, создание schema_migrations и чтение текущей версии
+func expectLocked(mock sqlmock.Sqlmock, version int64, dirty bool) {
+	mock.ExpectExec(q(`SELECT pg_advisory_lock($1)`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(`CREATE TABLE IF NOT EXISTS schema_migrations`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version", "dirty"})
+	if version > 0 {
+		rows.AddRow(version, dirty)
+	}
+	mock.ExpectQuery(q(`SELECT version, dirty FROM schema_migrations`)).WillReturnRows(rows)
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(q(`SELECT pg_advisory_unlock($1)`)).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// expectApply dirty-отметка, транзакция с телом миграции и итоговой версией
+func expectApply(mock sqlmock.Sqlmock, mark int64, body string, to int64) {
+	mock.ExpectExec(q(`DELETE FROM schema_migrations`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(`INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`)).
+		WithArgs(mark, true).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(q(body)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(`DELETE FROM schema_migrations`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	if to > 0 {
+		mock.ExpectExec(q(`INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`)).
+			WithArgs(to, false).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+}
+
+func newMigrator(t *testing.T) (*migrate.Migrator, sqlmock.Sqlmock) {
+	db, mock := newDB(t)
+	m, err := migrate.New(db, testMigrationsFS())
+	require.NoError(t, err)
+	return m, mock
+}
+
+func TestMigrate_Load(t *testing.T) {
+	list, err := migrate.Load(testMigrationsFS())
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, uint(1), list[0].Version)
+	require.Equal(t, "init", list[0].Name)
+	require.Equal(t, "DROP TABLE b", list[1].Down)
+
+	t.Run("нет down", func(t *testing.T) {
+		fsys := testMigrationsFS()
+		delete(fsys, "000002_second.down.sql")
+		_, err := migrate.Load(fsys)
+		require.ErrorContains(t, err, "both up and down")
+	})
+
+	t.Run("плохое имя", func(t *testing.T) {
+		fsys := testMigrationsFS()
+		fsys["2_x.sql"] = &fstest.MapFile{Data: []byte(`x`)}
+		_, err := migrate.Load(fsys)
+		require.ErrorContains(t, err, "bad file name")
+	})
+}
+
+// Встроенные в бинарник миграции корректны: все версии парные и идут подряд
+func TestMigrate_EmbeddedMigrations(t *testing.T) {
+	list, err := migrate.Load(migrations.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, list)
+	for i, mig := range list {
+		require.Equal(t, uint(i+1), mig.Version)
+	}
+}
+
+// Миграции SQLite применяются и откатываются на настоящей встроенной БД, без advisory lock
+func TestMigrate_SQLite(t *testing.T) {
+	ctx := context.Background()
+	db, err := repository.OpenSQLite(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	m, err := migrate.NewWithDialect(db, sqlitemigrations.FS, migrate.SQLite)
+	require.NoError(t, err)
+	for i, mig := range m.Migrations() {
+		require.Equal(t, uint(i+1), mig.Version)
+	}
+
+	n, err := m.Up(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, len(m.Migrations()), n)
+	st, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint(len(m.Migrations())), st.Version)
+	require.Empty(t, st.Pending)
+
+	n, err = m.Down(ctx, len(m.Migrations()))
+	require.NoError(t, err)
+	require.Equal(t, len(m.Migrations()), n)
+	var tables int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'orders'`).Scan(&tables))
+	require.Zero(t, tables)
+
+	_, err = m.Up(ctx, 0)
+	require.NoError(t, err)
+}
+
+func TestMigrate_Up(t *testing.T) {
+	m, mock := newMigrator(t)
+
+	expectLocked(mock, 0, false)
+	expectApply(mock, 1, `CREATE TABLE a (id INT)`, 1)
+	expectApply(mock, 2, `CREATE TABLE b (id INT)`, 2)
+	expectUnlock(mock)
+
+	n, err := m.Up(context.Background(), 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrate_UpStepAndNoChange(t *testing.T) {
+	m, mock := newMigrator(t)
+
+	expectLocked(mock, 1, false)
+	expectApply(mock, 2, `CREATE TABLE b (id INT)`, 2)
+	expectUnlock(mock)
+	n, err := m.Up(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	expectLocked(mock, 2, false)
+	expectUnlock(mock)
+	n, err = m.Up(context.Background(), 0)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrate_UpFailureLeavesDirty(t *testing.T) {
+	m, mock := newMigrator(t)
+
+	expectLocked(mock, 1, false)
+	mock.ExpectExec(q(`DELETE FROM schema_migrations`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(`INSERT INTO schema_migrations`)).WithArgs(int64(2), true).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(q(`CREATE TABLE b`)).WillReturnError(errors.New("syntax error"))
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	_, err := m.Up(context.Background(), 0)
+	require.ErrorContains(t, err, "000002_second")
+	require.ErrorContains(t, err, "syntax error")
+
+	// Следующий запуск отказывается работать с dirty схемой
+	expectLocked(mock, 2, true)
+	expectUnlock(mock)
+	_, err = m.Up(context.Background(), 0)
+	require.ErrorIs(t, err, migrate.ErrDirty)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrate_Down(t *testing.T) {
+	m, mock := newMigrator(t)
+
+	expectLocked(mock, 2, false)
+	expectApply(mock, 2, `DROP TABLE b`, 1)
+	expectApply(mock, 1, `DROP TABLE a`, 0)
+	expectUnlock(mock)
+
+	n, err := m.Down(context.Background(), 5)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrate_StatusAndForce(t *testing.T) {
+	m, mock := newMigrator(t)
+
+	expectLocked(mock, 1, true)
+	expectUnlock(mock)
+	st, err := m.Status(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, uint(1), st.Version)
+	require.True(t, st.Dirty)
+	require.Len(t, st.Pending, 1)
+	require.Equal(t, uint(2), st.Pending[0].Version)
+
+	mock.ExpectExec(q(`SELECT pg_advisory_lock($1)`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(`CREATE TABLE IF NOT EXISTS schema_migrations`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(`DELETE FROM schema_migrations`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(`INSERT INTO schema_migrations`)).WithArgs(int64(1), false).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectUnlock(mock)
+	require.NoError(t, m.Force(context.Background(), 1))
+
+	require.ErrorIs(t, m.Force(context.Background(), 42), migrate.ErrUnknownVersion)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrate_UnknownVersionInDB(t *testing.T) {
+	m, mock := newMigrator(t)
+
+	expectLocked(mock, 99, false)
+	expectUnlock(mock)
+	_, err := m.Up(context.Background(), 0)
+	require.ErrorIs(t, err, migrate.ErrUnknownVersion)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		in   string
+		want model.Money
+	}{
+		{"0", 0},
+		{"1817", model.NewMoney(1817, 0)},
+		{"149.9", model.NewMoney(149, 90)},
+		{"149.90", model.NewMoney(149, 90)},
+		{".05", model.NewMoney(0, 5)},
+		{"-3.5", -model.NewMoney(3, 50)},
+		// Нули после сотых значения не меняют
+		{"149.900", model.NewMoney(149, 90)},
+		// Хвост float-арифметики продюсера отбрасывается
+		{"31.450000000000003", model.NewMoney(31, 45)},
+		{"10.129999999999999", model.NewMoney(10, 13)},
+		{"-0.30000000000000004", -model.NewMoney(0, 30)},
+		{"1e3", model.NewMoney(1000, 0)},
+		// Экспонента сдвигает запятую в строке, без float64
+		{"1.2345e+2", model.NewMoney(123, 45)},
+		{"1500E-3", model.NewMoney(1, 50)},
+		{"-1.5e-1", -model.NewMoney(0, 15)},
+		{"3.1450000000000003e1", model.NewMoney(31, 45)},
+		{"0e-1000", 0},
+	}
+	for _, tc := range cases {
+		got, err := model.ParseMoney(tc.in)
+		require.NoError(t, err, tc.in)
+		require.Equal(t, tc.want, got, tc.in)
+	}
+
+	for _, bad := range []string{"", "-", ".", "1.2.3", "abc", "1,5", "99999999999999999999",
+		"1e", "e5", "1e2.5", "1e1000", "9e20"} {
+		_, err := model.ParseMoney(bad)
+		require.ErrorIs(t, err, model.ErrMoneyFormat, bad)
+	}
+
+	// Третий знак - не округление, а потеря суммы: 149.905 не стало молча 149.91
+	for _, lossy := range []string{"149.905", "1.004", "-0.125", "0.001", "1.0050000000000001",
+		"1.005e0", "-9.9e-3", "9.99e-4", "5e-1000"} {
+		_, err := model.ParseMoney(lossy)
+		require.ErrorIs(t, err, model.ErrMoneyPrecision, lossy)
+	}
+}
+
+func TestMoney_JSONBackwardCompatible(t *testing.T) {
+	// Вывод совпадает с тем, что давал float64
+	for _, f := range []float64{0, 1817, 149.9, 0.05, 12.34, -3.5} {
+		old, err := json.Marshal(f)
+		require.NoError(t, err)
+		got, err := json.Marshal(model.MoneyFromFloat(f))
+		require.NoError(t, err)
+		require.Equal(t, string(old), string(got))
+	}
+
+	var p model.Payment
+	require.NoError(t, json.Unmarshal([]byte(`{"amount":1817,"delivery_cost":"15.5","custom_fee":null}`), &p))
+	require.Equal(t, model.NewMoney(1817, 0), p.Amount)
+	require.Equal(t, model.NewMoney(15, 50), p.DeliveryCost)
+	require.Equal(t, model.Money(0), p.CustomFee)
+
+	require.Error(t, json.Unmarshal([]byte(`{"amount":"x"}`), &p))
+}
+
+func TestMoney_SQL(t *testing.T) {
+	v, err := model.NewMoney(149, 90).Value()
+	require.NoError(t, err)
+	require.Equal(t, "149.90", v)
+
+	var m model.Money
+	require.NoError(t, m.Scan([]byte("453.00")))
+	require.Equal(t, model.NewMoney(453, 0), m)
+	require.NoError(t, m.Scan("0.10"))
+	require.Equal(t, model.NewMoney(0, 10), m)
+	require.NoError(t, m.Scan(int64(7)))
+	require.Equal(t, model.NewMoney(7, 0), m)
+	require.NoError(t, m.Scan(nil))
+	require.Equal(t, model.Money(0), m)
+	require.Error(t, m.Scan(true))
+}
+
+func TestMoney_MinorUnits(t *testing.T) {
+	m := model.NewMoney(1500, 0)
+
+	minor, err := m.MinorUnits("USD")
+	require.NoError(t, err)
+	require.Equal(t, int64(150000), minor)
+
+	minor, err = m.MinorUnits("jpy")
+	require.NoError(t, err)
+	require.Equal(t, int64(1500), minor)
+
+	// Филсы KWD - тысячные: в сотых Money их не выразить
+	_, err = m.MinorUnits("KWD")
+	require.ErrorIs(t, err, model.ErrCurrencyNotSupported)
+
+	_, err = model.NewMoney(1, 50).MinorUnits("JPY")
+	require.ErrorIs(t, err, model.ErrMoneyPrecision)
+
+	require.Equal(t, "1500 JPY", m.Format("JPY"))
+	require.Equal(t, "1500.00 USD", m.Format("USD"))
+	require.Equal(t, "-0.05", (-model.NewMoney(0, 5)).String())
+}
+
+func TestOrder_ItemsTotalIsExact(t *testing.T) {
+	o := FakeValidOrder("sum")
+	o.Items = nil
+	for i := 0; i < 10; i++ {
+		o.Items = append(o.Items, model.Item{TotalPrice: model.MustMoney("0.10")})
+	}
+	// float64 дал бы 0.9999999999999999
+	require.Equal(t, model.NewMoney(1, 0), o.ItemsTotal())
+
+	log := model.GetOrderLog(o)
+	require.Equal(t, model.NewMoney(1, 0), log.ItemsTotal)
+	require.Contains(t, log.String(), "total:1.00")
+}
+
+func TestOrder_ValidateAmounts(t *testing.T) {
+	o := validOrderFixture()
+	require.NoError(t, o.ValidateAmounts())
+
+	// Сходимость суммы - отдельная строгая проверка, ValidateAmounts ее не требует
+	t.Run("amount не сходится", func(t *testing.T) {
+		o := validOrderFixture()
+		o.Payment.DeliveryCost = o.Payment.DeliveryCost.Sub(model.NewMoney(0, 1))
+		require.NoError(t, o.ValidateAmounts())
+		require.ErrorIs(t, o.CheckAmountSum(), model.ErrAmountMismatch)
+	})
+
+	t.Run("дробные единицы в валюте без копеек", func(t *testing.T) {
+		o := validOrderFixture()
+		o.Payment.Currency = "JPY"
+		o.Items[0].Price = model.MustMoney("453.5")
+		require.ErrorIs(t, o.ValidateAmounts(), model.ErrMoneyPrecision)
+	})
+
+	t.Run("валюта с тремя знаками", func(t *testing.T) {
+		o := validOrderFixture()
+		o.Payment.Currency = "bhd"
+		require.ErrorIs(t, o.ValidateAmounts(), model.ErrCurrencyNotSupported)
+	})
+}
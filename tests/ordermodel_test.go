@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogazub/myapp/ordermodel"
+	"github.com/stretchr/testify/require"
+)
+
+// Сообщения старых версий схемы из testdata/ordermodel/v<N> должны разбираться и проходить валидацию
+// текущей версией пакета. Новый файл добавляется на каждую особенность формата, которую уже видели в Kafka
+func TestOrderModel_CompatibleWithOldVersions(t *testing.T) {
+	tests := []struct {
+		file       string
+		uid        string
+		amount     ordermodel.Money
+		itemsTotal ordermodel.Money
+		created    time.Time
+	}{
+		// Пример заказа из исходного задания
+		{"v1/wb-sample.json", "b563feb7b2b84b6test", ordermodel.NewMoney(1817, 0), ordermodel.NewMoney(317, 0),
+			time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC)},
+		// Старый producer на float64: сумма оплаты с хвостом округления
+		{"v1/float-producer.json", "3f0c9a52-8d1e-4b7a-9c61-2e5d7f4a1b90", ordermodel.NewMoney(10, 13), ordermodel.NewMoney(10, 13),
+			time.Date(2025, 9, 14, 10, 21, 7, 483912000, time.UTC)},
+		// Суммы строками, время со смещением
+		{"v1/string-amounts.json", "7d3e2c10-4b5a-4f6e-8a90-1c2b3d4e5f60", ordermodel.NewMoney(159, 95), ordermodel.NewMoney(149, 90),
+			time.Date(2024, 3, 1, 5, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			payload, err := os.ReadFile(filepath.Join("testdata", "ordermodel", tt.file))
+			require.NoError(t, err)
+
+			version, err := strconv.Atoi(strings.TrimPrefix(filepath.Dir(tt.file), "v"))
+			require.NoError(t, err)
+			o, err := ordermodel.DecodeVersion(payload, version)
+			require.NoError(t, err)
+			require.NoError(t, ordermodel.Validate(o))
+			require.Equal(t, tt.uid, o.OrderUID)
+			require.Equal(t, tt.amount, o.Payment.Amount)
+			require.Equal(t, tt.itemsTotal, o.ItemsTotal())
+			require.True(t, o.DateCreated.Equal(tt.created), "date_created %v", o.DateCreated)
+
+			// Текущая версия пишет заказ так, что он читается обратно без изменений
+			b, err := json.Marshal(o)
+			require.NoError(t, err)
+			again, err := ordermodel.Decode(b)
+			require.NoError(t, err)
+			require.Equal(t, o, again)
+		})
+	}
+}
+
+// Сообщение версии новее SchemaVersion не читается как текущая, даже если его тело совпадает с ней
+func TestOrderModel_FutureSchemaVersionRejected(t *testing.T) {
+	payload, err := os.ReadFile(filepath.Join("testdata", "ordermodel", "v1", "wb-sample.json"))
+	require.NoError(t, err)
+
+	for _, version := range []int{ordermodel.SchemaVersion + 1, 0, -1} {
+		_, err := ordermodel.DecodeVersion(payload, version)
+		require.ErrorIs(t, err, ordermodel.ErrUnsupportedSchemaVersion, "version %d", version)
+	}
+	_, err = ordermodel.DecodeVersion(payload, ordermodel.SchemaVersion)
+	require.NoError(t, err)
+}
+
+func TestOrderModel_Decode(t *testing.T) {
+	_, err := ordermodel.Decode([]byte(`{"order_uid": "1", "unknown": true}`))
+	require.ErrorContains(t, err, `unknown field "unknown"`)
+
+	_, err = ordermodel.Decode([]byte(`{"payment": {"amount": "1,5"}}`))
+	require.ErrorIs(t, err, ordermodel.ErrMoneyFormat)
+}
+
+// Producer отправляет только заказы Generate: каждый из них должен проходить проверки consumer, включая строгие
+func TestOrderModel_GenerateIsValid(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for seed := uint64(0); seed < 500; seed++ {
+		o := ordermodel.Generate(rand.New(rand.NewPCG(seed, 0)), now)
+		require.NoError(t, ordermodel.ValidateStrict(o), "seed %d", seed)
+		require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, o.OrderUID)
+
+		b, err := json.Marshal(o)
+		require.NoError(t, err)
+		decoded, err := ordermodel.Decode(b)
+		require.NoError(t, err)
+		require.Equal(t, o, decoded, "seed %d", seed)
+	}
+
+	a := ordermodel.Generate(rand.New(rand.NewPCG(7, 0)), now)
+	b := ordermodel.Generate(rand.New(rand.NewPCG(7, 0)), now)
+	require.Equal(t, a, b)
+}
+
+func TestOrderModel_ExampleIsValid(t *testing.T) {
+	require.NoError(t, ordermodel.Validate(ordermodel.Example("uid-1")))
+}
@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gogazub/myapp/internal/outbox"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/lib/pq"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePublisher запоминает отправленные сообщения. err - ошибка следующей отправки,
+// onWrite вызывается перед отправкой
+type fakePublisher struct {
+	msgs    []kafka.Message
+	err     error
+	onWrite func(ctx context.Context)
+}
+
+func (p *fakePublisher) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if p.onWrite != nil {
+		p.onWrite(ctx)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	p.msgs = append(p.msgs, msgs...)
+	return nil
+}
+
+var outboxColumns = []string{"event_id", "order_uid", "event_type", "payload", "payload_enc", "pii_key", "pii_key_id",
+	"created_at"}
+
+func expectRelayLock(mock sqlmock.Sqlmock, locked bool) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(`SELECT pg_try_advisory_xact_lock(hashtext('order_outbox_relay'))`)).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(locked))
+}
+
+// expectRelayBusy проверка, что голова очереди не в аренде у relay другого инстанса
+func expectRelayBusy(mock sqlmock.Sqlmock, busy bool) {
+	mock.ExpectQuery(`SELECT COALESCE\(\(SELECT claimed_until > now\(\) FROM order_outbox`).
+		WillReturnRows(sqlmock.NewRows([]string{"busy"}).AddRow(busy))
+}
+
+// expectClaim аренда порции ids на lease и коммит короткой транзакции
+func expectClaim(mock sqlmock.Sqlmock, ids []int64, lease time.Duration) {
+	mock.ExpectExec(q(`UPDATE order_outbox SET claimed_until = now() + make_interval(secs => $2) WHERE event_id = ANY($1)`)).
+		WithArgs(pq.Array(ids), lease.Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(ids))))
+	mock.ExpectCommit()
+}
+
+func TestRelay_PublishPending(t *testing.T) {
+	db, mock := newDB(t)
+	r := repository.NewOrderRepository(db)
+	pub := &fakePublisher{}
+	relay := outbox.NewRelay(r, pub, outbox.Config{BatchSize: 10})
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	expectRelayLock(mock, true)
+	expectRelayBusy(mock, false)
+	mock.ExpectQuery(`FROM order_outbox WHERE published_at IS NULL\s+ORDER BY event_id LIMIT \$1`).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows(outboxColumns).
+			AddRow(int64(7), "uid-1", repository.EventOrderSaved, []byte(`{"order_uid":"uid-1"}`), nil, nil, nil, created).
+			AddRow(int64(8), "uid-1", repository.EventOrderSaved, []byte(`{"order_uid":"uid-1","x":1}`), nil, nil, nil, created))
+	expectClaim(mock, []int64{7, 8}, outbox.DefaultLease)
+	// Публикация идет после коммита: транзакция и блокировки строк брокера не ждут
+	pub.onWrite = func(ctx context.Context) {
+		require.NoError(t, mock.ExpectationsWereMet())
+		deadline, ok := ctx.Deadline()
+		require.True(t, ok)
+		require.WithinDuration(t, time.Now().Add(outbox.DefaultLease), deadline, time.Second)
+
+		mock.ExpectExec(q(`UPDATE order_outbox SET published_at = now(), claimed_until = NULL WHERE event_id = ANY($1)`)).
+			WithArgs(pq.Array([]int64{7, 8})).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+	}
+
+	n, err := relay.PublishPending(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	require.Len(t, pub.msgs, 2)
+	for i, id := range []string{"7", "8"} {
+		m := pub.msgs[i]
+		require.Equal(t, "uid-1", string(m.Key))
+		require.Equal(t, []kafka.Header{
+			{Key: "event-type", Value: []byte(repository.EventOrderSaved)},
+			{Key: "event-id", Value: []byte(id)},
+		}, m.Headers)
+	}
+	require.JSONEq(t, `{"order_uid":"uid-1"}`, string(pub.msgs[0].Value))
+}
+
+// Ошибка брокера снимает аренду: порция уйдет заново в следующем цикле, не дожидаясь ее истечения
+func TestRelay_PublishErrorReleasesClaim(t *testing.T) {
+	db, mock := newDB(t)
+	pub := &fakePublisher{err: errors.New("broker down")}
+	relay := outbox.NewRelay(repository.NewOrderRepository(db), pub, outbox.Config{BatchSize: 10, Lease: 5 * time.Second})
+
+	expectRelayLock(mock, true)
+	expectRelayBusy(mock, false)
+	mock.ExpectQuery(`FROM order_outbox`).
+		WillReturnRows(sqlmock.NewRows(outboxColumns).
+			AddRow(int64(1), "uid-1", repository.EventOrderSaved, []byte(`{}`), nil, nil, nil, time.Now()))
+	expectClaim(mock, []int64{1}, 5*time.Second)
+	mock.ExpectExec(q(`UPDATE order_outbox SET claimed_until = NULL WHERE event_id = ANY($1) AND published_at IS NULL`)).
+		WithArgs(pq.Array([]int64{1})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := relay.PublishPending(context.Background())
+	require.ErrorContains(t, err, "broker down")
+	require.Zero(t, n)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Relay другого инстанса держит блокировку: порция не читается
+func TestRelay_LockedByOtherInstance(t *testing.T) {
+	db, mock := newDB(t)
+	pub := &fakePublisher{}
+	relay := outbox.NewRelay(repository.NewOrderRepository(db), pub, outbox.Config{})
+
+	expectRelayLock(mock, false)
+	mock.ExpectRollback()
+
+	n, err := relay.PublishPending(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, n)
+	require.Empty(t, pub.msgs)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Порция предыдущего relay еще публикуется (аренда не истекла): следующая не берется, иначе
+// события одного заказа могли бы обогнать друг друга
+func TestRelay_PreviousBatchStillClaimed(t *testing.T) {
+	db, mock := newDB(t)
+	pub := &fakePublisher{}
+	relay := outbox.NewRelay(repository.NewOrderRepository(db), pub, outbox.Config{})
+
+	expectRelayLock(mock, true)
+	expectRelayBusy(mock, true)
+	mock.ExpectRollback()
+
+	n, err := relay.PublishPending(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, n)
+	require.Empty(t, pub.msgs)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBRepository_PurgeOutbox(t *testing.T) {
+	db, mock := newDB(t)
+	r := repository.NewOrderRepository(db)
+	before := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec(q(`DELETE FROM order_outbox WHERE published_at IS NOT NULL AND published_at < $1`)).
+		WithArgs(before).
+		WillReturnResult(sqlmock.NewResult(0, 42))
+
+	n, err := r.PurgeOutbox(context.Background(), before)
+	require.NoError(t, err)
+	require.EqualValues(t, 42, n)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,461 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/pii"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(b byte) []byte { return bytes.Repeat([]byte{b}, pii.KeySize) }
+
+// newTestCipher связка из ключей ids (первый активный) и blind index ключа 0xbb.
+// Секрет ключа зависит только от id, поэтому разные связки читают данные друг друга
+func newTestCipher(t *testing.T, ids ...string) *pii.Cipher {
+	t.Helper()
+	keys := make([]pii.Key, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, pii.Key{ID: id, Secret: testKey(id[len(id)-1])})
+	}
+	c, err := pii.NewCipher(keys, testKey(0xbb))
+	require.NoError(t, err)
+	return c
+}
+
+// captureArgs запоминает аргументы запроса: sqlmock передает их в Match по порядку
+type captureArgs struct{ values *[]driver.Value }
+
+func (c captureArgs) Match(v driver.Value) bool {
+	*c.values = append(*c.values, v)
+	return true
+}
+
+func capture(n int) (*[]driver.Value, []driver.Value) {
+	values := &[]driver.Value{}
+	args := make([]driver.Value, n)
+	for i := range args {
+		args[i] = captureArgs{values}
+	}
+	return values, args
+}
+
+func TestPII_EncryptDecrypt(t *testing.T) {
+	c := newTestCipher(t, "k2", "k1")
+	require.Equal(t, "k2", c.ActiveKeyID())
+
+	dek, wrapped, err := c.NewDataKey("uid-1/delivery.key")
+	require.NoError(t, err)
+	ct, err := pii.Encrypt(dek, "Alice", "uid-1/delivery.name")
+	require.NoError(t, err)
+	require.NotContains(t, string(ct), "Alice")
+
+	got, err := c.UnwrapDataKey("k2", wrapped, "uid-1/delivery.key")
+	require.NoError(t, err)
+	plain, err := pii.Decrypt(got, ct, "uid-1/delivery.name")
+	require.NoError(t, err)
+	require.Equal(t, "Alice", plain)
+
+	// Шифротекст другого поля или заказа не расшифровывается
+	_, err = pii.Decrypt(got, ct, "uid-2/delivery.name")
+	require.Error(t, err)
+	_, err = c.UnwrapDataKey("k2", wrapped, "uid-2/delivery.key")
+	require.Error(t, err)
+	// Ключ данных зашифрован k2, а не k1
+	_, err = c.UnwrapDataKey("k1", wrapped, "uid-1/delivery.key")
+	require.Error(t, err)
+	_, err = c.UnwrapDataKey("k0", wrapped, "uid-1/delivery.key")
+	require.ErrorIs(t, err, pii.ErrUnknownKey)
+
+	empty, err := pii.Encrypt(dek, "", "uid-1/delivery.email")
+	require.NoError(t, err)
+	require.Nil(t, empty)
+}
+
+func TestPII_BlindIndex(t *testing.T) {
+	c := newTestCipher(t, "k1")
+
+	require.Equal(t, c.BlindIndex("phone", "+7 (999) 123-45-67"), c.BlindIndex("phone", "79991234567"))
+	require.Equal(t, c.BlindIndex("email", "Alice@Example.com "), c.BlindIndex("email", "alice@example.com"))
+	require.Equal(t, c.BlindIndex("name", "Test  Testov"), c.BlindIndex("name", "test testov"))
+	require.NotEqual(t, c.BlindIndex("name", "alice"), c.BlindIndex("email", "alice"))
+	require.Nil(t, c.BlindIndex("phone", "no digits"))
+
+	// Другой ключ blind index - другие хеши
+	other, err := pii.NewCipher([]pii.Key{{ID: "k1", Secret: testKey(1)}}, testKey(0xcc))
+	require.NoError(t, err)
+	require.NotEqual(t, c.BlindIndex("name", "alice"), other.BlindIndex("name", "alice"))
+}
+
+func TestPII_ParseKeys(t *testing.T) {
+	k1, k2 := base64.StdEncoding.EncodeToString(testKey(1)), base64.StdEncoding.EncodeToString(testKey(2))
+
+	keys, err := pii.ParseKeys("k2=" + k2 + ",\nk1=" + k1 + "\n")
+	require.NoError(t, err)
+	require.Equal(t, []pii.Key{{ID: "k2", Secret: testKey(2)}, {ID: "k1", Secret: testKey(1)}}, keys)
+
+	for _, bad := range []string{"k1", "=" + k1, "k1=not-base64", "k1=" + base64.StdEncoding.EncodeToString([]byte("short"))} {
+		_, err := pii.ParseKeys(bad)
+		require.Error(t, err, bad)
+	}
+
+	_, err = pii.NewCipher([]pii.Key{{ID: "k1", Secret: testKey(1)}, {ID: "k1", Secret: testKey(2)}}, testKey(0xbb))
+	require.ErrorContains(t, err, "duplicate")
+	_, err = pii.NewCipher([]pii.Key{{ID: "k1", Secret: testKey(1)}}, nil)
+	require.ErrorContains(t, err, "blind index key")
+}
+
+// addSealedOrderJoinRow строка orders+deliveries+payments, в которой доставка записана
+// с аргументами saveDelivery args
+func addSealedOrderJoinRow(rows *sqlmock.Rows, o *model.Order, args []driver.Value) *sqlmock.Rows {
+	return rows.AddRow(
+		o.OrderUID, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature,
+		o.CustomerID, o.DeliveryService, o.Shardkey, o.SmID, o.DateCreated, o.OofShard, o.DeletedAt,
+		1, args[4], args[5], args[1], args[2], args[6], args[3], args[7],
+		args[8], args[9], args[10], args[11], args[12], args[13],
+		1, o.Payment.Transaction, o.Payment.RequestID, o.Payment.Currency, o.Payment.Provider,
+		o.Payment.Amount, o.Payment.PaymentDt, o.Payment.Bank, o.Payment.DeliveryCost,
+		o.Payment.GoodsTotal, o.Payment.CustomFee,
+	)
+}
+
+func TestDBRepository_Save_EncryptsDelivery(t *testing.T) {
+	db, mock := newDB(t)
+	c := newTestCipher(t, "k1")
+	repo := repository.NewOrderRepositoryWithConfig(db, repository.DBConfig{PII: c})
+	o := FakeValidOrder("uid-pii")
+
+	saved, args := capture(17)
+	expectBeginTx(mock, 5*time.Second)
+	expectLockPrevious(mock, o.OrderUID, nil)
+	mock.ExpectExec("INSERT INTO orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO deliveries").WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO payments").WillReturnResult(sqlmock.NewResult(0, 1))
+	expectSaveItems(mock, o, o.Items)
+	// Персональные поля в истории маскируются
+	mock.ExpectExec("INSERT INTO order_history").
+		WithArgs(o.OrderUID, model.HistoryCreate, sqlmock.AnyArg(), jsonArg{func(raw []byte) bool {
+			var changes []model.FieldChange
+			if json.Unmarshal(raw, &changes) != nil {
+				return false
+			}
+			masked := 0
+			for _, ch := range changes {
+				switch ch.Field {
+				case "delivery.name", "delivery.phone", "delivery.address", "delivery.email":
+					if ch.New != "***" {
+						return false
+					}
+					masked++
+				case "delivery.city":
+					if ch.New != "NY" {
+						return false
+					}
+				}
+			}
+			return masked == 4
+		}}).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	outboxArgs, args := capture(6)
+	mock.ExpectExec("INSERT INTO order_outbox").WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	require.NoError(t, repo.Save(context.Background(), o))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// В payload события данные доставки замаскированы, полный заказ - в payload_enc
+	ob := *outboxArgs
+	require.NotContains(t, string(ob[2].([]byte)), o.Delivery.Name)
+	require.Contains(t, string(ob[2].([]byte)), `"name":"***"`)
+	require.Equal(t, "k1", ob[5])
+	dek, err := c.UnwrapDataKey("k1", ob[4].([]byte), o.OrderUID+"/outbox."+repository.EventOrderSaved+".key")
+	require.NoError(t, err)
+	full, err := pii.Decrypt(dek, ob[3].([]byte), o.OrderUID+"/outbox."+repository.EventOrderSaved)
+	require.NoError(t, err)
+	require.JSONEq(t, string(mustJSON(t, o)), full)
+
+	t.Run("relay публикует расшифрованный payload", func(t *testing.T) {
+		expectRelayLock(mock, true)
+		expectRelayBusy(mock, false)
+		mock.ExpectQuery(`FROM order_outbox WHERE published_at IS NULL`).
+			WillReturnRows(sqlmock.NewRows(outboxColumns).
+				AddRow(int64(1), o.OrderUID, repository.EventOrderSaved, ob[2], ob[3], ob[4], ob[5], time.Now()))
+		expectClaim(mock, []int64{1}, time.Second)
+		mock.ExpectExec(`UPDATE order_outbox SET published_at = now\(\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		var published []byte
+		n, err := repo.RelayOutbox(context.Background(), 10, time.Second,
+			func(_ context.Context, events []repository.OutboxEvent) error {
+				published = events[0].Payload
+				return nil
+			})
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+		require.Equal(t, full, string(published))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	got := *saved
+	require.Len(t, got, 17)
+	require.Equal(t, []driver.Value{o.OrderUID, "10001", "NY", "NY", "", "", "", ""}, got[:8])
+	for i, v := range got[8:13] {
+		b, ok := v.([]byte)
+		require.True(t, ok && len(b) > 0, "column %d", 9+i)
+		require.False(t, strings.Contains(string(b), "Alice"))
+	}
+	require.Equal(t, "k1", got[13])
+	require.Equal(t, c.BlindIndex("name", "alice"), got[14])
+	require.Equal(t, c.BlindIndex("phone", "1234567890"), got[15])
+	require.Equal(t, c.BlindIndex("email", "ALICE@example.com"), got[16])
+
+	t.Run("чтение расшифровывает доставку", func(t *testing.T) {
+		mock.ExpectQuery("FROM orders o").
+			WillReturnRows(addSealedOrderJoinRow(sqlmock.NewRows(orderJoinColumns), o, got))
+		mock.ExpectQuery("FROM items").WillReturnRows(addItemRows(sqlmock.NewRows(itemColumns), o))
+
+		loaded, err := repo.GetByID(context.Background(), o.OrderUID)
+		require.NoError(t, err)
+		require.Equal(t, o.Delivery.Name, loaded.Delivery.Name)
+		require.Equal(t, o.Delivery.Phone, loaded.Delivery.Phone)
+		require.Equal(t, o.Delivery.Address, loaded.Delivery.Address)
+		require.Equal(t, o.Delivery.Email, loaded.Delivery.Email)
+		require.Equal(t, o.Delivery.City, loaded.Delivery.City)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("без ключей зашифрованный заказ не читается", func(t *testing.T) {
+		plain := repository.NewOrderRepository(db)
+		mock.ExpectQuery("FROM orders o").
+			WillReturnRows(addSealedOrderJoinRow(sqlmock.NewRows(orderJoinColumns), o, got))
+
+		_, err := plain.GetByID(context.Background(), o.OrderUID)
+		require.ErrorContains(t, err, "PII keys are not configured")
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("без старого мастер-ключа - ErrUnknownKey", func(t *testing.T) {
+		rotated := repository.NewOrderRepositoryWithConfig(db, repository.DBConfig{PII: newTestCipher(t, "k2")})
+		mock.ExpectQuery("FROM orders o").
+			WillReturnRows(addSealedOrderJoinRow(sqlmock.NewRows(orderJoinColumns), o, got))
+
+		_, err := rotated.GetByID(context.Background(), o.OrderUID)
+		require.ErrorIs(t, err, pii.ErrUnknownKey)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+var rotateColumns = []string{"delivery_id", "order_uid", "name", "phone", "address", "email",
+	"name_enc", "phone_enc", "address_enc", "email_enc", "pii_key", "pii_key_id"}
+
+func TestDBRepository_RotatePII(t *testing.T) {
+	db, mock := newDB(t)
+	old := repository.NewOrderRepositoryWithConfig(db, repository.DBConfig{PII: newTestCipher(t, "k1")})
+	c := newTestCipher(t, "k2", "k1")
+	repo := repository.NewOrderRepositoryWithConfig(db, repository.DBConfig{PII: c})
+	o := FakeValidOrder("uid-old")
+
+	// Строка, зашифрованная ключом k1
+	sealed, args := capture(17)
+	expectBeginTx(mock, 5*time.Second)
+	expectLockPrevious(mock, o.OrderUID, nil)
+	mock.ExpectExec("INSERT INTO orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO deliveries").WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO payments").WillReturnResult(sqlmock.NewResult(0, 1))
+	expectSaveItems(mock, o, o.Items)
+	expectHistory(mock, o.OrderUID, model.HistoryCreate)
+	expectOutbox(mock, o.OrderUID)
+	mock.ExpectCommit()
+	require.NoError(t, old.Save(context.Background(), o))
+	s := *sealed
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM deliveries\s+WHERE delivery_id > \$1 AND pii_key_id IS DISTINCT FROM \$2\s+ORDER BY delivery_id LIMIT \$3\s+FOR UPDATE`).
+		WithArgs(10, "k2", 100).
+		WillReturnRows(sqlmock.NewRows(rotateColumns).
+			AddRow(11, o.OrderUID, s[4], s[5], s[6], s[7], s[8], s[9], s[10], s[11], s[12], s[13]).
+			AddRow(15, "uid-plain", "Bob", "+7 999", "Main st", "bob@example.com", nil, nil, nil, nil, nil, nil))
+	updated := make([]*[]driver.Value, 2)
+	for i, id := range []int{11, 15} {
+		var rest []driver.Value
+		updated[i], rest = capture(13)
+		mock.ExpectExec(q(`UPDATE deliveries SET`)).
+			WithArgs(append([]driver.Value{id}, rest...)...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	last, n, err := repo.RotatePII(context.Background(), "deliveries", 10, 100)
+	require.NoError(t, err)
+	require.Equal(t, 15, last)
+	require.Equal(t, 2, n)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	for i, want := range []model.Delivery{o.Delivery, {Name: "Bob", Phone: "+7 999", Address: "Main st", Email: "bob@example.com"}} {
+		u := *updated[i]
+		require.Equal(t, []driver.Value{"", "", "", ""}, u[:4])
+		require.Equal(t, "k2", u[9])
+		require.Equal(t, c.BlindIndex("email", want.Email), u[12])
+
+		uid := []string{o.OrderUID, "uid-plain"}[i]
+		dek, err := c.UnwrapDataKey("k2", u[8].([]byte), uid+"/delivery.key")
+		require.NoError(t, err)
+		name, err := pii.Decrypt(dek, u[4].([]byte), uid+"/delivery.name")
+		require.NoError(t, err)
+		require.Equal(t, want.Name, name)
+	}
+
+	_, _, err = repo.RotatePII(context.Background(), "payments", 0, 100)
+	require.ErrorContains(t, err, "unknown table")
+}
+
+// С ключами PII тело исходного сообщения хранится зашифрованным и читается байт в байт
+func TestDBRepository_RawMessage_Encrypted(t *testing.T) {
+	db, mock := newDB(t)
+	c := newTestCipher(t, "k1")
+	repo := repository.NewOrderRepositoryWithConfig(db, repository.DBConfig{PII: c})
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg := model.RawMessage{
+		OrderUID: "7f6c2a4e-3b1d-4c5a-9e8f-0a1b2c3d4e5f",
+		Payload:  []byte(`{"delivery": {"name": "Alice",  "phone": "+1 234"}}`),
+		Topic:    "orders", Partition: 1, Offset: 5,
+		SchemaVersion: 1,
+	}
+
+	saved, args := capture(11)
+	mock.ExpectExec("INSERT INTO order_raw_messages").WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+	require.NoError(t, repo.SaveRawMessage(context.Background(), msg))
+	require.NoError(t, mock.ExpectationsWereMet())
+	got := *saved
+	require.NotContains(t, string(got[1].([]byte)), "Alice")
+	require.Equal(t, "k1", got[10])
+
+	mock.ExpectQuery("FROM order_raw_messages").
+		WillReturnRows(sqlmock.NewRows(rawColumns).
+			AddRow(int64(1), msg.OrderUID, got[1], "orders", 1, int64(5), []byte(`[]`), nil, 1, at, nil, got[9], got[10]))
+	loaded, err := repo.RawMessages(context.Background(), msg.OrderUID, 10)
+	require.NoError(t, err)
+	require.Equal(t, msg.Payload, loaded[0].Payload)
+
+	// Шифротекст, перенесенный в строку другого сообщения, не расшифровывается
+	mock.ExpectQuery("FROM order_raw_messages").
+		WillReturnRows(sqlmock.NewRows(rawColumns).
+			AddRow(int64(2), msg.OrderUID, got[1], "orders", 1, int64(6), []byte(`[]`), nil, 1, at, nil, got[9], got[10]))
+	_, err = repo.RawMessages(context.Background(), msg.OrderUID, 10)
+	require.Error(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// rotate-keys шифрует события outbox и исходные сообщения, записанные открытым текстом,
+// и маскирует персональные поля в старой истории
+func TestDBRepository_RotatePII_OutboxRawHistory(t *testing.T) {
+	db, mock := newDB(t)
+	c := newTestCipher(t, "k2", "k1")
+	repo := repository.NewOrderRepositoryWithConfig(db, repository.DBConfig{PII: c})
+	o := FakeValidOrder("uid-old")
+	payload := mustJSON(t, o)
+
+	t.Run("order_outbox", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM order_outbox\s+WHERE event_id > \$1 AND pii_key_id IS DISTINCT FROM \$2\s+ORDER BY event_id LIMIT \$3\s+FOR UPDATE`).
+			WithArgs(0, "k2", 100).
+			WillReturnRows(sqlmock.NewRows([]string{"event_id", "order_uid", "event_type", "payload", "payload_enc", "pii_key", "pii_key_id"}).
+				AddRow(4, o.OrderUID, repository.EventOrderSaved, payload, nil, nil, nil))
+		updated, rest := capture(4)
+		mock.ExpectExec(q(`UPDATE order_outbox SET payload = $2, payload_enc = $3, pii_key = $4, pii_key_id = $5`)).
+			WithArgs(append([]driver.Value{4}, rest...)...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		last, n, err := repo.RotatePII(context.Background(), "order_outbox", 0, 100)
+		require.NoError(t, err)
+		require.Equal(t, 4, last)
+		require.Equal(t, 1, n)
+		require.NoError(t, mock.ExpectationsWereMet())
+
+		u := *updated
+		require.NotContains(t, string(u[0].([]byte)), o.Delivery.Name)
+		require.Equal(t, "k2", u[3])
+		aad := o.OrderUID + "/outbox." + repository.EventOrderSaved
+		dek, err := c.UnwrapDataKey("k2", u[2].([]byte), aad+".key")
+		require.NoError(t, err)
+		full, err := pii.Decrypt(dek, u[1].([]byte), aad)
+		require.NoError(t, err)
+		require.Equal(t, string(payload), full)
+	})
+
+	t.Run("order_raw_messages", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM order_raw_messages\s+WHERE raw_id > \$1 AND pii_key_id IS DISTINCT FROM \$2\s+ORDER BY raw_id LIMIT \$3\s+FOR UPDATE`).
+			WithArgs(0, "k2", 100).
+			WillReturnRows(sqlmock.NewRows([]string{"raw_id", "topic", "kafka_partition", "kafka_offset", "payload", "pii_key", "pii_key_id"}).
+				AddRow(7, "orders", 0, int64(3), payload, nil, nil))
+		updated, rest := capture(3)
+		mock.ExpectExec(q(`UPDATE order_raw_messages SET payload = $2, pii_key = $3, pii_key_id = $4 WHERE raw_id = $1`)).
+			WithArgs(append([]driver.Value{7}, rest...)...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		last, n, err := repo.RotatePII(context.Background(), "order_raw_messages", 0, 100)
+		require.NoError(t, err)
+		require.Equal(t, 7, last)
+		require.Equal(t, 1, n)
+		require.NoError(t, mock.ExpectationsWereMet())
+
+		u := *updated
+		dek, err := c.UnwrapDataKey("k2", u[1].([]byte), "raw/orders/0/3.key")
+		require.NoError(t, err)
+		plain, err := pii.Decrypt(dek, u[0].([]byte), "raw/orders/0/3")
+		require.NoError(t, err)
+		require.Equal(t, string(payload), plain)
+	})
+
+	t.Run("order_history", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(q(`SET LOCAL orders.history_maintenance = 'on'`)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`UPDATE order_history SET changes = .+WHERE history_id > \$1 .+RETURNING history_id`).
+			WithArgs(5, `{"delivery.name","delivery.phone","delivery.address","delivery.email"}`, 100).
+			WillReturnRows(sqlmock.NewRows([]string{"history_id"}).AddRow(9).AddRow(6))
+		mock.ExpectCommit()
+
+		last, n, err := repo.RotatePII(context.Background(), "order_history", 5, 100)
+		require.NoError(t, err)
+		require.Equal(t, 9, last)
+		require.Equal(t, 2, n)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// Зашифрованное имя находится по blind index точного совпадения
+func TestDBRepository_Search_BlindIndex(t *testing.T) {
+	db, mock := newDB(t)
+	c := newTestCipher(t, "k1")
+	repo := repository.NewOrderRepositoryWithConfig(db, repository.DBConfig{PII: c})
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	dek, wrapped, err := c.NewDataKey("uid-1/delivery.key")
+	require.NoError(t, err)
+	enc, err := pii.Encrypt(dek, "Test Testov", "uid-1/delivery.name")
+	require.NoError(t, err)
+
+	mock.ExpectQuery("FROM deliveries d").
+		WithArgs("test testov", "%test testov%", model.DefaultSearchLimit+1, 0,
+			c.BlindIndex("name", "Test Testov"), nil, c.BlindIndex("email", "test testov")).
+		WillReturnRows(sqlmock.NewRows(searchColumns).
+			AddRow("uid-1", at, 2.0, "delivery.name", nil, 2.0, enc, wrapped, "k1"))
+
+	page, err := repo.Search(context.Background(), model.SearchQuery{Text: "test testov"})
+	require.NoError(t, err)
+	require.Equal(t, []model.SearchMatch{{
+		Field: "delivery.name", Value: "Test Testov", Highlight: "<mark>Test Testov</mark>", Score: 2,
+	}}, page.Hits[0].Matches)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
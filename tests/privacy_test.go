@@ -0,0 +1,254 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/gogazub/myapp/internal/service"
+)
+
+var historyColumns = []string{"history_id", "order_uid", "changed_at", "op", "source", "changes"}
+
+// sourceArg source запроса в журнале аудита
+func sourceArg(want model.ChangeSource) jsonArg {
+	return jsonArg{func(raw []byte) bool {
+		var got model.ChangeSource
+		return json.Unmarshal(raw, &got) == nil && got == want
+	}}
+}
+
+func TestDBRepository_ExportCustomer(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+	ctx := model.WithChangeSource(context.Background(), model.ChangeSource{Kind: model.SourceAPI, User: "admin"})
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	live := FakeValidOrder("uid-2")
+	archived := FakeValidOrder("uid-1")
+	archived.DateCreated = at
+	archived.DeletedAt = &at
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM orders o\s+JOIN deliveries d .*WHERE o.customer_id = \$1 ORDER BY o.date_created, o.order_uid`).
+		WithArgs("cust-001").
+		WillReturnRows(addOrderJoinRow(sqlmock.NewRows(orderJoinColumns), live))
+	mock.ExpectQuery(q(`FROM items WHERE order_uid = ANY($1) AND date_created = ANY($2)`)).
+		WillReturnRows(addItemRows(sqlmock.NewRows(itemColumns), live))
+	mock.ExpectQuery(`FROM orders_archive o\s+JOIN deliveries_archive d .*JOIN payments_archive p .*WHERE o.customer_id = \$1`).
+		WithArgs("cust-001").
+		WillReturnRows(addOrderJoinRow(sqlmock.NewRows(orderJoinColumns), archived))
+	mock.ExpectQuery(q(`FROM items_archive WHERE order_uid = ANY($1) AND (date_created = ANY($2) OR date_created IS NULL)`)).
+		WillReturnRows(addItemRows(sqlmock.NewRows(itemColumns), archived))
+	mock.ExpectQuery(`FROM order_history WHERE order_uid = ANY\(\$1\)\s+ORDER BY history_id`).
+		WithArgs(`{"uid-1","uid-2"}`).
+		WillReturnRows(sqlmock.NewRows(historyColumns).
+			AddRow(1, "uid-1", at, model.HistoryCreate, []byte(`{"kind":"system"}`), []byte(`[]`)))
+	mock.ExpectQuery(`FROM order_raw_messages WHERE order_uid = ANY\(\$1\)\s+ORDER BY raw_id`).
+		WithArgs(`{"uid-1","uid-2"}`).
+		WillReturnRows(sqlmock.NewRows(rawColumns).
+			AddRow(int64(1), "uid-2", []byte(`{"v":1}`), "orders", 0, int64(3), []byte(`[]`), nil, 1, at, nil, nil, nil))
+	mock.ExpectExec(q(`INSERT INTO privacy_audit (op, customer_id, source, order_uids) VALUES ($1, $2, $3, $4)`)).
+		WithArgs(model.PrivacyExport, "cust-001", sourceArg(model.ChangeSource{Kind: model.SourceAPI, User: "admin"}),
+			`{"uid-1","uid-2"}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	got, err := repo.ExportCustomer(ctx, "cust-001")
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	require.Equal(t, "cust-001", got.CustomerID)
+	require.Len(t, got.Orders, 2)
+	require.Equal(t, "uid-2", got.Orders[0].OrderUID)
+	require.False(t, got.Orders[0].Archived)
+	require.Equal(t, live.Delivery.Email, got.Orders[0].Delivery.Email)
+	require.Len(t, got.Orders[0].Items, len(live.Items))
+	require.True(t, got.Orders[1].Archived)
+	require.Equal(t, &at, got.Orders[1].DeletedAt)
+	require.Len(t, got.History, 1)
+	require.Len(t, got.RawMessages, 1)
+
+	// deleted_at и archived видны в выгрузке, хотя в API заказа их нет
+	b, err := json.Marshal(got.Orders[1])
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"deleted_at":"2025-01-02T03:04:05Z"`)
+	require.Contains(t, string(b), `"archived":true`)
+	require.Contains(t, string(b), `"order_uid":"uid-1"`)
+}
+
+func TestDBRepository_EraseCustomer(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+	ctx := model.WithChangeSource(context.Background(), model.ChangeSource{Kind: model.SourceCLI, User: "dpo"})
+	src := sourceArg(model.ChangeSource{Kind: model.SourceCLI, User: "dpo"})
+	ids := `{"uid-1","uid-2"}`
+
+	erased := FakeValidOrder("uid-2")
+	erased.Delivery = model.Delivery{}
+	erased.Payment.Transaction, erased.Payment.RequestID = "", ""
+
+	expectBeginTx(mock, 5*time.Second)
+	mock.ExpectExec(q(`SET LOCAL orders.history_maintenance = 'on'`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(q(`SELECT order_uid FROM orders WHERE customer_id = $1 ORDER BY order_uid FOR UPDATE`)).
+		WithArgs("cust-001").
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid"}).AddRow("uid-2"))
+	mock.ExpectQuery(q(`SELECT order_uid FROM orders_archive WHERE customer_id = $1`)).
+		WithArgs("cust-001").
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid"}).AddRow("uid-1"))
+	for _, query := range []string{
+		`UPDATE deliveries SET\s+name = '', phone = '', zip = '', city = '', address = '', region = '', email = '',\s+name_enc = NULL`,
+		`UPDATE deliveries_archive SET`,
+		q(`UPDATE payments SET transaction = '', request_id = ''`),
+		q(`UPDATE payments_archive SET transaction = '', request_id = ''`),
+		q(`UPDATE orders SET updated_at = now()`),
+		q(`DELETE FROM order_raw_messages WHERE order_uid = ANY($1)`),
+		q(`DELETE FROM order_outbox WHERE order_uid = ANY($1)`),
+	} {
+		mock.ExpectExec(query).WithArgs(ids).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(`UPDATE order_history SET changes = `).
+		WithArgs(ids, `{"delivery.name","delivery.phone","delivery.address","delivery.email","delivery.zip",`+
+			`"delivery.city","delivery.region","payment.transaction","payment.request_id"}`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(q(`SELECT unnest($1::uuid[]), $2, $3, $4`)).
+		WithArgs(ids, model.HistoryErase, src, jsonArg{func(raw []byte) bool {
+			var changes []model.FieldChange
+			return json.Unmarshal(raw, &changes) == nil && len(changes) == 9 &&
+				changes[0] == model.FieldChange{Field: "delivery.name", Old: "***", New: ""}
+		}}).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	// Событие order.erased только для заказа в основных таблицах, с уже затертыми данными
+	mock.ExpectQuery(`FROM orders o.*WHERE o.order_uid = ANY\(\$1\)`).
+		WithArgs(`{"uid-2"}`).
+		WillReturnRows(addOrderJoinRow(sqlmock.NewRows(orderJoinColumns), erased))
+	mock.ExpectQuery("FROM items").WillReturnRows(addItemRows(sqlmock.NewRows(itemColumns), erased))
+	mock.ExpectExec(`INSERT INTO order_outbox \(order_uid, event_type, payload, payload_enc, pii_key, pii_key_id\)`).
+		WithArgs("uid-2", repository.EventOrderErased, jsonArg{func(raw []byte) bool {
+			var o model.Order
+			return json.Unmarshal(raw, &o) == nil && o.Delivery.Name == "" && o.Payment.Amount == erased.Payment.Amount
+		}}, nil, nil, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(q(`INSERT INTO privacy_audit`)).
+		WithArgs(model.PrivacyErase, "cust-001", src, ids).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	got, err := repo.EraseCustomer(ctx, "cust-001")
+	require.NoError(t, err)
+	require.Equal(t, []string{"uid-1", "uid-2"}, got.Orders)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	t.Run("заказов нет - только запись аудита", func(t *testing.T) {
+		expectBeginTx(mock, 5*time.Second)
+		mock.ExpectExec(q(`SET LOCAL orders.history_maintenance = 'on'`)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM orders WHERE").WillReturnRows(sqlmock.NewRows([]string{"order_uid"}))
+		mock.ExpectQuery("FROM orders_archive WHERE").WillReturnRows(sqlmock.NewRows([]string{"order_uid"}))
+		mock.ExpectExec(q(`INSERT INTO privacy_audit`)).
+			WithArgs(model.PrivacyErase, "cust-404", src, `{}`).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		got, err := repo.EraseCustomer(ctx, "cust-404")
+		require.NoError(t, err)
+		require.Equal(t, []string{}, got.Orders)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// privacyShard шард с заранее заданными заказами покупателя
+type privacyShard struct {
+	*fakeShard
+	orders []string
+}
+
+func (s *privacyShard) ExportCustomer(_ context.Context, id string) (model.CustomerExport, error) {
+	out := model.CustomerExport{CustomerID: id}
+	for _, uid := range s.orders {
+		o := FakeValidOrder(uid)
+		out.Orders = append(out.Orders, model.ExportedOrder{Order: o})
+	}
+	return out, nil
+}
+
+func (s *privacyShard) EraseCustomer(_ context.Context, id string) (model.CustomerErasure, error) {
+	return model.CustomerErasure{CustomerID: id, Orders: s.orders}, nil
+}
+
+func TestShardedRepository_Privacy(t *testing.T) {
+	s0 := &privacyShard{fakeShard: newFakeShard(), orders: []string{"c"}}
+	s1 := &privacyShard{fakeShard: newFakeShard(), orders: []string{"a", "b"}}
+	r, err := repository.NewShardedRepository(repository.ShardedConfig{
+		Shards: []repository.Shard{{Name: "s0", Repo: s0}, {Name: "s1", Repo: s1}},
+	})
+	require.NoError(t, err)
+
+	export, err := r.ExportCustomer(context.Background(), "cust-001")
+	require.NoError(t, err)
+	require.Len(t, export.Orders, 3)
+	require.NotNil(t, export.History)
+
+	erased, err := r.EraseCustomer(context.Background(), "cust-001")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c"}, erased.Orders)
+
+	// Шард без IPrivacyRepository - ошибка, но анонимизированное на остальных возвращается
+	r, err = repository.NewShardedRepository(repository.ShardedConfig{
+		Shards: []repository.Shard{{Name: "s0", Repo: s0}, {Name: "s1", Repo: newFakeShard()}},
+	})
+	require.NoError(t, err)
+	erased, err = r.EraseCustomer(context.Background(), "cust-001")
+	require.ErrorContains(t, err, "shard s1: privacy requests not supported")
+	require.Equal(t, []string{"c"}, erased.Orders)
+}
+
+// privacyDBRepo хранилище с IPrivacyRepository
+type privacyDBRepo struct{ mockDBRepo }
+
+func (m *privacyDBRepo) ExportCustomer(ctx context.Context, id string) (model.CustomerExport, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(model.CustomerExport), args.Error(1)
+}
+
+func (m *privacyDBRepo) EraseCustomer(ctx context.Context, id string) (model.CustomerErasure, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(model.CustomerErasure), args.Error(1)
+}
+
+// Анонимизированные заказы убираются из кеша
+func TestService_EraseCustomerData_evictsCache(t *testing.T) {
+	db := new(privacyDBRepo)
+	cache := new(mockCacheRepo)
+	s := service.NewService(db, cache)
+	ctx := context.Background()
+
+	db.On("EraseCustomer", ctx, "cust-001").
+		Return(model.CustomerErasure{CustomerID: "cust-001", Orders: []string{"uid-1", "uid-2"}}, nil).Once()
+	cache.On("Delete", ctx, "uid-1").Return(nil).Once()
+	cache.On("Delete", ctx, "uid-2").Return(nil).Once()
+
+	res, err := s.EraseCustomerData(ctx, "cust-001")
+	require.NoError(t, err)
+	require.Equal(t, []string{"uid-1", "uid-2"}, res.Orders)
+	db.AssertExpectations(t)
+	cache.AssertExpectations(t)
+}
+
+// Хранилище без IPrivacyRepository -> ErrNotSupported, кеш не трогается
+func TestService_CustomerData_notSupported(t *testing.T) {
+	cache := new(mockCacheRepo)
+	s := service.NewService(new(mockDBRepo), cache)
+
+	_, err := s.ExportCustomerData(context.Background(), "cust-001")
+	require.ErrorIs(t, err, service.ErrNotSupported)
+	_, err = s.EraseCustomerData(context.Background(), "cust-001")
+	require.ErrorIs(t, err, service.ErrNotSupported)
+	cache.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/stretchr/testify/require"
+)
+
+var rawColumns = []string{"raw_id", "order_uid", "payload", "topic", "kafka_partition", "kafka_offset",
+	"headers", "message_time", "schema_version", "received_at", "rejection", "pii_key", "pii_key_id"}
+
+// Исходное сообщение сохраняется отдельным запросом, тело - байт в байт
+func TestDBRepository_SaveRawMessage(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	const uid = "7f6c2a4e-3b1d-4c5a-9e8f-0a1b2c3d4e5f"
+	const upsert = `INSERT INTO order_raw_messages .+ON CONFLICT \(topic, kafka_partition, kafka_offset\) ` +
+		`DO UPDATE SET rejection = EXCLUDED.rejection\s+WHERE EXCLUDED.rejection IS NOT NULL`
+
+	t.Run("принятое сообщение", func(t *testing.T) {
+		msg := model.RawMessage{
+			OrderUID: uid,
+			Payload:  []byte(`{"order_uid": "` + uid + `",  "b": 1.50}`),
+			Topic:    "orders", Partition: 2, Offset: 42,
+			Timestamp:     at,
+			SchemaVersion: model.CurrentSchemaVersion,
+		}
+		mock.ExpectExec(upsert).
+			WithArgs(uid, msg.Payload, "orders", 2, int64(42), []byte(`[]`), at, 1, nil, nil, nil).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		require.NoError(t, repo.SaveRawMessage(context.Background(), msg))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// order_uid не UUID в колонку не пишется, причина отклонения сохраняется
+	t.Run("отклоненное сообщение", func(t *testing.T) {
+		msg := model.RawMessage{
+			OrderUID: "not-a-uuid",
+			Payload:  []byte{0xff, '{'},
+			Topic:    "orders", Offset: 43,
+			SchemaVersion: model.CurrentSchemaVersion,
+			Rejection:     "bad json",
+		}
+		mock.ExpectExec(upsert).
+			WithArgs(nil, msg.Payload, "orders", 0, int64(43), []byte(`[]`), nil, 1, "bad json", nil, nil).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		require.NoError(t, repo.SaveRawMessage(context.Background(), msg))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// Отклоненные сообщения и сообщения без заказа удаляются по возрасту
+func TestDBRepository_PurgeRejectedRaw(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+	before := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec(`DELETE FROM order_raw_messages\s+WHERE \(order_uid IS NULL OR rejection IS NOT NULL\) AND received_at < \$1`).
+		WithArgs(before).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := repo.PurgeRejectedRaw(context.Background(), before)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), n)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Тело-JSON в API встраивается без пробелов, но с исходными числами, остальное - base64 байт в байт
+func TestRawMessage_JSON(t *testing.T) {
+	for name, payload := range map[string][]byte{
+		"json":     []byte(`{"a": 1.50}`),
+		"not json": []byte{0xff, '{', '"'},
+	} {
+		t.Run(name, func(t *testing.T) {
+			b, err := json.Marshal(model.RawMessage{ID: 1, Payload: payload, Rejection: "r"})
+			require.NoError(t, err)
+
+			var fields map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal(b, &fields))
+			if json.Valid(payload) {
+				require.Equal(t, `{"a":1.50}`, string(fields["payload"]))
+				require.NotContains(t, fields, "payload_encoding")
+			} else {
+				require.JSONEq(t, `"base64"`, string(fields["payload_encoding"]))
+			}
+
+			var got model.RawMessage
+			require.NoError(t, json.Unmarshal(b, &got))
+			if json.Valid(payload) {
+				require.JSONEq(t, string(payload), string(got.Payload))
+			} else {
+				require.Equal(t, payload, got.Payload)
+			}
+			require.Equal(t, "r", got.Rejection)
+		})
+	}
+}
+
+func TestDBRepository_RawMessages(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("сообщения от новых к старым", func(t *testing.T) {
+		mock.ExpectQuery(`FROM order_raw_messages WHERE order_uid = \$1\s+ORDER BY raw_id DESC LIMIT \$2`).
+			WithArgs("uid-1", 10).
+			WillReturnRows(sqlmock.NewRows(rawColumns).
+				AddRow(int64(2), "uid-1", []byte(`{"v":2}`), "orders", 0, int64(8),
+					[]byte(`[{"key":"schema-version","value":"2"}]`), at, 2, at, nil, nil, nil).
+				AddRow(int64(1), "uid-1", []byte(`{"v":1}`), "orders", 0, int64(3), []byte(`[]`), nil, 1, at,
+					"duplicate item", nil, nil))
+
+		got, err := repo.RawMessages(context.Background(), "uid-1", 10)
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		require.Equal(t, []model.RawHeader{{Key: "schema-version", Value: "2"}}, got[0].Headers)
+		require.Equal(t, 2, got[0].SchemaVersion)
+		require.Equal(t, at, got[0].Timestamp)
+		require.JSONEq(t, `{"v":1}`, string(got[1].Payload))
+		require.True(t, got[1].Timestamp.IsZero())
+		require.Empty(t, got[0].Rejection)
+		require.Equal(t, "duplicate item", got[1].Rejection)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("нет сообщений -> ErrOrderNotFound", func(t *testing.T) {
+		mock.ExpectQuery("FROM order_raw_messages").
+			WithArgs("missing", 100).
+			WillReturnRows(sqlmock.NewRows(rawColumns))
+
+		_, err := repo.RawMessages(context.Background(), "missing", 0)
+		require.ErrorIs(t, err, repository.ErrOrderNotFound)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/stretchr/testify/require"
+)
+
+func newRedisCache(t *testing.T, mr *miniredis.Miniredis, cfg repository.RedisCacheConfig) *repository.RedisCacheRepository {
+	t.Helper()
+	cfg.Addr = mr.Addr()
+	r := repository.NewRedisCacheRepository(cfg)
+	t.Cleanup(func() { _ = r.Close() })
+	require.NoError(t, r.Ping(context.Background()))
+	return r
+}
+
+func TestRedisCache_SaveGetDelete(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := newRedisCache(t, mr, repository.RedisCacheConfig{})
+	ctx := context.Background()
+
+	_, err := r.GetByID(ctx, "uid-1")
+	require.ErrorIs(t, err, repository.ErrCacheMiss)
+
+	require.NoError(t, r.Save(ctx, FakeValidOrder("uid-1")))
+	require.True(t, mr.Exists("order:uid-1"))
+
+	got, err := r.GetByID(ctx, "uid-1")
+	require.NoError(t, err)
+	require.Equal(t, "uid-1", got.OrderUID)
+	require.Equal(t, "alice@example.com", got.Delivery.Email)
+
+	require.NoError(t, r.Delete(ctx, "uid-1"))
+	_, err = r.GetByID(ctx, "uid-1")
+	require.ErrorIs(t, err, repository.ErrCacheMiss)
+
+	st := r.Stats()
+	require.Equal(t, "redis", st.Backend)
+	require.Equal(t, uint64(1), st.Hits)
+	require.Equal(t, uint64(2), st.Misses)
+	require.Equal(t, uint64(1), st.Invalidations)
+}
+
+func TestRedisCache_TTL(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := newRedisCache(t, mr, repository.RedisCacheConfig{TTL: time.Minute})
+	ctx := context.Background()
+
+	require.NoError(t, r.Save(ctx, FakeOrder("ttl")))
+	mr.FastForward(2 * time.Minute)
+
+	_, err := r.GetByID(ctx, "ttl")
+	require.ErrorIs(t, err, repository.ErrCacheMiss)
+}
+
+func TestRedisCache_SharedBetweenReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newRedisCache(t, mr, repository.RedisCacheConfig{})
+	b := newRedisCache(t, mr, repository.RedisCacheConfig{})
+	ctx := context.Background()
+
+	require.NoError(t, a.Save(ctx, FakeOrder("shared")))
+	got, err := b.GetByID(ctx, "shared")
+	require.NoError(t, err)
+	require.Equal(t, "shared", got.OrderUID)
+}
+
+func TestRedisCache_L1InvalidatedAcrossReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := repository.RedisCacheConfig{L1Size: 10, L1TTL: time.Hour}
+	a := newRedisCache(t, mr, cfg)
+	b := newRedisCache(t, mr, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = b.RunInvalidation(ctx) }()
+	// Ждем подписку b, иначе сообщение уйдет в пустоту
+	require.Eventually(t, func() bool {
+		return mr.PubSubNumSub("orders:invalidate")["orders:invalidate"] == 1
+	}, time.Second, 5*time.Millisecond)
+
+	first := FakeOrder("uid-1")
+	first.TrackNumber = "v1"
+	require.NoError(t, a.Save(ctx, first))
+
+	// b кладет заказ в свой L1
+	got, err := b.GetByID(ctx, "uid-1")
+	require.NoError(t, err)
+	require.Equal(t, "v1", got.TrackNumber)
+	got, err = b.GetByID(ctx, "uid-1")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), b.Stats().L1Hits)
+
+	// a обновляет заказ - L1 у b должен сброситься
+	second := FakeOrder("uid-1")
+	second.TrackNumber = "v2"
+	require.NoError(t, a.Save(ctx, second))
+
+	require.Eventually(t, func() bool {
+		o, err := b.GetByID(ctx, "uid-1")
+		return err == nil && o.TrackNumber == "v2"
+	}, time.Second, 5*time.Millisecond)
+}
+
+// ClearLocal сбрасывает только L1: общий Redis остается для остальных реплик
+func TestRedisCache_ClearLocalKeepsRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := newRedisCache(t, mr, repository.RedisCacheConfig{L1Size: 10, L1TTL: time.Hour})
+	ctx := context.Background()
+
+	require.NoError(t, r.Save(ctx, FakeOrder("uid-1")))
+	require.NoError(t, r.ClearLocal(ctx))
+	require.True(t, mr.Exists("order:uid-1"))
+
+	_, err := r.GetByID(ctx, "uid-1")
+	require.NoError(t, err)
+	st := r.Stats()
+	require.Equal(t, uint64(0), st.L1Hits)
+	require.Equal(t, uint64(1), st.Hits)
+}
+
+// Evict по NOTIFY делает каждая реплика сама: из Redis заказ убирается, а L1 других реплик
+// инвалидацией не трогается
+func TestRedisCache_EvictDoesNotPublish(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := repository.RedisCacheConfig{L1Size: 10, L1TTL: time.Hour}
+	a := newRedisCache(t, mr, cfg)
+	b := newRedisCache(t, mr, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = b.RunInvalidation(ctx) }()
+	require.Eventually(t, func() bool {
+		return mr.PubSubNumSub("orders:invalidate")["orders:invalidate"] == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// b сохраняет сам: свои сообщения он игнорирует, и заказы остаются в его L1
+	for _, id := range []string{"evicted", "deleted"} {
+		require.NoError(t, b.Save(ctx, FakeOrder(id)))
+	}
+
+	require.NoError(t, a.Evict(ctx, "evicted"))
+	require.False(t, mr.Exists("order:evicted"))
+	// Сообщения канала приходят по порядку: после инвалидации "deleted" сообщения об "evicted" уже не будет
+	require.NoError(t, a.Delete(ctx, "deleted"))
+	require.Eventually(t, func() bool {
+		ok, err := b.Contains(ctx, "deleted")
+		return err == nil && !ok
+	}, time.Second, 5*time.Millisecond)
+
+	ok, err := b.Contains(ctx, "evicted")
+	require.NoError(t, err)
+	require.True(t, ok, "L1 of b must be kept")
+	ok, err = a.Contains(ctx, "evicted")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestRedisCache_ClearAndLoadFromDB(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := newRedisCache(t, mr, repository.RedisCacheConfig{WarmupSize: 2})
+	ctx := context.Background()
+
+	db := new(mockDBRepo)
+	db.On("ListPage", ctx, repository.Cursor{}, 2).Return([]*model.Order{FakeOrder("a"), FakeOrder("b")}, nil).Once()
+
+	require.NoError(t, r.LoadFromDB(ctx, db))
+	require.True(t, mr.Exists("order:a"))
+	require.True(t, mr.Exists("order:b"))
+	st := r.WarmupStatus()
+	require.Equal(t, repository.WarmupDone, st.State)
+	require.Equal(t, 2, st.Loaded)
+
+	// Посторонние ключи Clear не трогает
+	require.NoError(t, mr.Set("other", "x"))
+	require.NoError(t, r.Clear(ctx))
+	require.False(t, mr.Exists("order:a"))
+	require.False(t, mr.Exists("order:b"))
+	require.True(t, mr.Exists("other"))
+	db.AssertExpectations(t)
+}
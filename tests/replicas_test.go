@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/gogazub/myapp/internal/service"
+	"github.com/stretchr/testify/require"
+)
+
+// expectLag ответ проверки здоровья реплики
+func expectLag(mock sqlmock.Sqlmock, lag float64) {
+	mock.ExpectQuery(`pg_last_xact_replay_timestamp`).
+		WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(lag))
+}
+
+func newReplicaSet(t *testing.T) (*repository.ReplicaSet, *sql.DB, []*sql.DB, []sqlmock.Sqlmock) {
+	t.Helper()
+	primary, _ := newDB(t)
+	r1, m1 := newDB(t)
+	r2, m2 := newDB(t)
+	set := repository.NewReplicaSet(primary, []*sql.DB{r1, r2}, []string{"r1", "r2"},
+		repository.ReplicaConfig{MaxLag: time.Second})
+	return set, primary, []*sql.DB{r1, r2}, []sqlmock.Sqlmock{m1, m2}
+}
+
+func TestReplicaSet_RoundRobin(t *testing.T) {
+	set, primary, replicas, mocks := newReplicaSet(t)
+
+	// до первой проверки реплики не используются
+	require.Same(t, primary, set.Reader())
+
+	expectLag(mocks[0], 0)
+	expectLag(mocks[1], 0.2)
+	set.CheckHealth(context.Background())
+
+	got := map[*sql.DB]int{}
+	for range 4 {
+		got[set.Reader()]++
+	}
+	require.Equal(t, map[*sql.DB]int{replicas[0]: 2, replicas[1]: 2}, got)
+	for _, m := range mocks {
+		require.NoError(t, m.ExpectationsWereMet())
+	}
+}
+
+func TestReplicaSet_UnhealthyReplicaSkipped(t *testing.T) {
+	set, primary, replicas, mocks := newReplicaSet(t)
+
+	expectLag(mocks[0], 5) // отстает больше MaxLag
+	mocks[1].ExpectQuery(`pg_last_xact_replay_timestamp`).WillReturnError(errors.New("connection refused"))
+	set.CheckHealth(context.Background())
+	require.Same(t, primary, set.Reader())
+
+	st := set.Status()
+	require.False(t, st[0].Healthy)
+	require.Equal(t, 5*time.Second, st[0].Lag)
+	require.Equal(t, "connection refused", st[1].Error)
+
+	expectLag(mocks[0], 0.5)
+	mocks[1].ExpectQuery(`pg_last_xact_replay_timestamp`).WillReturnError(errors.New("connection refused"))
+	set.CheckHealth(context.Background())
+	require.Same(t, replicas[0], set.Reader())
+	require.Same(t, replicas[0], set.Reader())
+}
+
+func TestReplicaSet_FreshReadAfterWrite(t *testing.T) {
+	set, primary, _, mocks := newReplicaSet(t)
+	expectLag(mocks[0], 0)
+	expectLag(mocks[1], 0)
+	set.CheckHealth(context.Background())
+
+	set.MarkWritten("uid-1")
+	require.Same(t, primary, set.ReaderFor("uid-1"))
+	require.Same(t, primary, set.ReaderFor("uid-2", "uid-1"))
+	require.NotSame(t, primary, set.ReaderFor("uid-2"))
+}
+
+func TestDBRepository_ReadsFromReplica(t *testing.T) {
+	primary, primaryMock := newDB(t)
+	replica, replicaMock := newDB(t)
+	set := repository.NewReplicaSet(primary, []*sql.DB{replica}, []string{"r1"}, repository.ReplicaConfig{})
+	repo := repository.NewOrderRepositoryWithConfig(primary, repository.DBConfig{Replicas: set})
+	o := FakeValidOrder("uid-1")
+
+	expectLag(replicaMock, 0)
+	set.CheckHealth(context.Background())
+
+	expectGetByID(replicaMock, o)
+	_, err := repo.GetByID(context.Background(), o.OrderUID)
+	require.NoError(t, err)
+
+	// после записи заказ читается с primary
+	set.MarkWritten(o.OrderUID)
+	expectGetByID(primaryMock, o)
+	_, err = repo.GetByID(context.Background(), o.OrderUID)
+	require.NoError(t, err)
+
+	require.NoError(t, replicaMock.ExpectationsWereMet())
+	require.NoError(t, primaryMock.ExpectationsWereMet())
+}
+
+// Заказ, измененный другим процессом, перечитывается по NOTIFY и после сброса кеша с primary:
+// реплика может еще отдавать старую версию, и она осталась бы в кеше
+func TestService_CacheRefillReadsFromPrimary(t *testing.T) {
+	ctx := context.Background()
+	primary, primaryMock := newDB(t)
+	replica, replicaMock := newDB(t)
+	set := repository.NewReplicaSet(primary, []*sql.DB{replica}, []string{"r1"}, repository.ReplicaConfig{})
+	db := repository.NewOrderRepositoryWithConfig(primary, repository.DBConfig{Replicas: set})
+	cache := repository.NewCacheRepository()
+	svc := service.NewService(db, cache)
+	o := FakeValidOrder("uid-1")
+
+	expectLag(replicaMock, 0)
+	set.CheckHealth(ctx)
+
+	t.Run("RefreshCache", func(t *testing.T) {
+		old := FakeValidOrder(o.OrderUID)
+		old.TrackNumber = "OLD"
+		require.NoError(t, cache.Save(ctx, old))
+
+		expectGetByID(primaryMock, o)
+		require.NoError(t, svc.RefreshCache(ctx, o.OrderUID))
+		got, err := cache.GetByID(ctx, o.OrderUID)
+		require.NoError(t, err)
+		require.Equal(t, o.TrackNumber, got.TrackNumber)
+	})
+	t.Run("InvalidateCache then GetOrderByID", func(t *testing.T) {
+		o := FakeValidOrder("uid-2")
+		require.NoError(t, svc.InvalidateCache(ctx, o.OrderUID))
+
+		expectGetByID(primaryMock, o)
+		_, err := svc.GetOrderByID(ctx, o.OrderUID)
+		require.NoError(t, err)
+	})
+	t.Run("other orders still read from replica", func(t *testing.T) {
+		o := FakeValidOrder("uid-3")
+		expectGetByID(replicaMock, o)
+		_, err := svc.GetOrderByID(ctx, o.OrderUID)
+		require.NoError(t, err)
+	})
+
+	require.NoError(t, replicaMock.ExpectationsWereMet())
+	require.NoError(t, primaryMock.ExpectationsWereMet())
+}
@@ -0,0 +1,259 @@
+package tests
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/gogazub/myapp/internal/retention"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRetentionRepo хранит заказы в памяти и удаляет их так же, как БД
+type fakeRetentionRepo struct {
+	orders   []*model.Order
+	archived []string
+	purged   []string
+	// stuck удаление ничего не удаляет
+	stuck bool
+	// rejectedRaw отклоненных исходных сообщений, rawBefore - граница последнего PurgeRejectedRaw
+	rejectedRaw int64
+	rawBefore   time.Time
+}
+
+func (f *fakeRetentionRepo) ExpiredStats(_ context.Context, before time.Time) (repository.RetentionStats, error) {
+	var st repository.RetentionStats
+	for _, o := range f.expired(before, len(f.orders)) {
+		st.Orders++
+		st.Items += int64(len(o.Items))
+		if o.DeletedAt != nil {
+			st.Deleted++
+		}
+	}
+	return st, nil
+}
+
+func (f *fakeRetentionRepo) ExpiredOrders(_ context.Context, before time.Time, limit int) ([]*model.Order, error) {
+	return f.expired(before, limit), nil
+}
+
+func (f *fakeRetentionRepo) ArchiveOrders(_ context.Context, ids []string) (int, error) {
+	f.archived = append(f.archived, ids...)
+	return f.remove(ids), nil
+}
+
+func (f *fakeRetentionRepo) PurgeOrders(_ context.Context, ids []string) (int, error) {
+	f.purged = append(f.purged, ids...)
+	return f.remove(ids), nil
+}
+
+func (f *fakeRetentionRepo) PurgeRejectedRaw(_ context.Context, before time.Time) (int64, error) {
+	n := f.rejectedRaw
+	f.rejectedRaw, f.rawBefore = 0, before
+	return n, nil
+}
+
+func (f *fakeRetentionRepo) expired(before time.Time, limit int) []*model.Order {
+	out := make([]*model.Order, 0, limit)
+	for _, o := range f.orders {
+		if len(out) == limit {
+			break
+		}
+		if o.DateCreated.Before(before) {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+func (f *fakeRetentionRepo) remove(ids []string) int {
+	if f.stuck {
+		return 0
+	}
+	drop := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		drop[id] = true
+	}
+	kept := f.orders[:0]
+	for _, o := range f.orders {
+		if !drop[o.OrderUID] {
+			kept = append(kept, o)
+		}
+	}
+	n := len(f.orders) - len(kept)
+	f.orders = kept
+	return n
+}
+
+// retentionOrders 5 старых заказов (один мягко удален) и 1 свежий
+func retentionOrders() []*model.Order {
+	old := time.Now().Add(-48 * time.Hour)
+	orders := make([]*model.Order, 0, 6)
+	for _, id := range []string{"old-1", "old-2", "old-3", "old-4", "old-5"} {
+		o := FakeValidOrder(id)
+		o.DateCreated = old
+		orders = append(orders, o)
+	}
+	orders[1].DeletedAt = &old
+	return append(orders, FakeValidOrder("fresh"))
+}
+
+func TestRetention_DryRun(t *testing.T) {
+	r := &fakeRetentionRepo{orders: retentionOrders(), rejectedRaw: 2}
+
+	rep, err := retention.Run(context.Background(), r, retention.Config{
+		MaxAge: 24 * time.Hour, Mode: retention.ModeArchive, DryRun: true,
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 5, rep.Expired.Orders)
+	require.EqualValues(t, 1, rep.Expired.Deleted)
+	require.EqualValues(t, 5, rep.Expired.Items)
+	require.Zero(t, rep.Removed)
+	require.Empty(t, r.archived)
+	require.Len(t, r.orders, 6)
+	require.Zero(t, rep.RejectedRaw)
+	require.EqualValues(t, 2, r.rejectedRaw)
+}
+
+func TestRetention_Archive(t *testing.T) {
+	r := &fakeRetentionRepo{orders: retentionOrders(), rejectedRaw: 2}
+
+	rep, err := retention.Run(context.Background(), r, retention.Config{
+		MaxAge: 24 * time.Hour, Mode: retention.ModeArchive, BatchSize: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 5, rep.Removed)
+	require.Equal(t, []string{"old-1", "old-2", "old-3", "old-4", "old-5"}, r.archived)
+	require.Len(t, r.orders, 1)
+	require.Equal(t, "fresh", r.orders[0].OrderUID)
+	require.EqualValues(t, 2, rep.RejectedRaw)
+	require.Equal(t, rep.Cutoff, r.rawBefore)
+}
+
+func TestRetention_Export(t *testing.T) {
+	r := &fakeRetentionRepo{orders: retentionOrders()}
+	dir := t.TempDir()
+
+	rep, err := retention.Run(context.Background(), r, retention.Config{
+		MaxAge: 24 * time.Hour, Mode: retention.ModeExport, ExportDir: dir, BatchSize: 3,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 5, rep.Removed)
+	require.Len(t, rep.Files, 2)
+	require.Len(t, r.purged, 5)
+
+	var lines []map[string]any
+	for _, path := range rep.Files {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		gz, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		sc := bufio.NewScanner(gz)
+		for sc.Scan() {
+			var line map[string]any
+			require.NoError(t, json.Unmarshal(sc.Bytes(), &line))
+			lines = append(lines, line)
+		}
+		require.NoError(t, sc.Err())
+		require.NoError(t, f.Close())
+	}
+	require.Len(t, lines, 5)
+	require.Equal(t, "old-1", lines[0]["order_uid"])
+	require.NotContains(t, lines[0], "deleted_at")
+	require.Contains(t, lines[1], "deleted_at")
+
+	// временных файлов не остается
+	parts, err := filepath.Glob(filepath.Join(dir, "*.part"))
+	require.NoError(t, err)
+	require.Empty(t, parts)
+}
+
+func TestRetention_NoProgress(t *testing.T) {
+	r := &fakeRetentionRepo{orders: retentionOrders(), stuck: true}
+
+	_, err := retention.Run(context.Background(), r, retention.Config{
+		MaxAge: 24 * time.Hour, Mode: retention.ModeArchive,
+	})
+	require.ErrorIs(t, err, retention.ErrNoProgress)
+}
+
+func TestRetention_BadConfig(t *testing.T) {
+	r := &fakeRetentionRepo{}
+	cases := map[string]retention.Config{
+		"no max age":    {Mode: retention.ModeArchive},
+		"unknown mode":  {MaxAge: time.Hour, Mode: "drop"},
+		"export no dir": {MaxAge: time.Hour, Mode: retention.ModeExport},
+	}
+	for name, cfg := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := retention.Run(context.Background(), r, cfg)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDBRepository_ArchiveOrders(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+	ids := []string{"uid-1", "uid-2"}
+
+	expectBeginTx(mock, 5*time.Second)
+	for _, table := range []string{"orders", "deliveries", "payments", "items"} {
+		mock.ExpectExec(`INSERT INTO ` + table + `_archive .* FROM ` + table + ` WHERE order_uid = ANY\(\$1\)`).
+			WithArgs(pq.Array(ids)).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+	}
+	for _, table := range []string{"items", "deliveries", "payments", "order_raw_messages", "orders"} {
+		mock.ExpectExec(q(`DELETE FROM ` + table + ` WHERE order_uid = ANY($1)`)).
+			WithArgs(pq.Array(ids)).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+	}
+	mock.ExpectCommit()
+
+	n, err := repo.ArchiveOrders(context.Background(), ids)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBRepository_PurgeOrders_Rollback(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+
+	expectBeginTx(mock, 5*time.Second)
+	mock.ExpectExec("DELETE FROM items").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM deliveries").WillReturnError(context.DeadlineExceeded)
+	mock.ExpectRollback()
+
+	_, err := repo.PurgeOrders(context.Background(), []string{"uid-1"})
+	require.Error(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBRepository_ExpiredStats(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+	before := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	oldest := before.AddDate(-1, 0, 0)
+
+	mock.ExpectQuery(`FROM orders o WHERE o.date_created < \$1`).
+		WithArgs(before).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "deleted", "items", "min", "max"}).
+			AddRow(10, 2, 25, oldest, before.Add(-time.Hour)))
+
+	st, err := repo.ExpiredStats(context.Background(), before)
+	require.NoError(t, err)
+	require.Equal(t, repository.RetentionStats{
+		Orders: 10, Deleted: 2, Items: 25, Oldest: oldest, Newest: before.Add(-time.Hour),
+	}, st)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
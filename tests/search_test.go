@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHighlight(t *testing.T) {
+	cases := []struct {
+		name, value, query, want string
+	}{
+		{"подстрока без учета регистра", "Test Testov", "testov", "Test <mark>Testov</mark>"},
+		{"несколько слов", "Kiryat Mozkin", "kir mozk", "<mark>Kir</mark>yat <mark>Mozk</mark>in"},
+		{"кириллица", "Иван Петров", "петр", "Иван <mark>Петр</mark>ов"},
+		{"HTML экранируется", "<b>Vivienne</b>", "vivi", "&lt;b&gt;<mark>Vivi</mark>enne&lt;/b&gt;"},
+		{"перекрывающиеся вхождения сливаются", "aaaa", "aaa", "<mark>aaaa</mark>"},
+		{"опечатка - без разметки", "Vivienne", "vivianne", "Vivienne"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, model.Highlight(tc.value, tc.query))
+		})
+	}
+}
+
+var searchColumns = []string{"order_uid", "date_created", "rank", "field", "value", "score",
+	"enc", "pii_key", "pii_key_id"}
+
+func TestDBRepository_Search(t *testing.T) {
+	db, mock := newDB(t)
+	repo := repository.NewOrderRepository(db)
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("совпавшие поля группируются по заказам, лишний заказ - следующая страница", func(t *testing.T) {
+		mock.ExpectQuery(`FROM deliveries d.+FROM items i.+LIMIT \$3 OFFSET \$4`).
+			WithArgs("testov", "%testov%", 3, 10, nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows(searchColumns).
+				AddRow("uid-1", at, 1.1, "delivery.name", "Test Testov", 1.1, nil, nil, nil).
+				AddRow("uid-1", at, 1.1, "delivery.email", "testov@gmail.com", 0.8, nil, nil, nil).
+				AddRow("uid-2", at, 0.7, "item.brand", "Testova", 0.7, nil, nil, nil).
+				AddRow("uid-3", at, 0.6, "item.name", "Testovich", 0.6, nil, nil, nil))
+
+		page, err := repo.Search(context.Background(), model.SearchQuery{Text: " testov ", Limit: 2, Offset: 10})
+		require.NoError(t, err)
+		require.Len(t, page.Hits, 2)
+		require.Equal(t, 12, page.NextOffset)
+		require.Equal(t, "uid-1", page.Hits[0].OrderUID)
+		require.Equal(t, []model.SearchMatch{
+			{Field: "delivery.name", Value: "Test Testov", Highlight: "Test <mark>Testov</mark>", Score: 1.1},
+			{Field: "delivery.email", Value: "testov@gmail.com", Highlight: "<mark>testov</mark>@gmail.com", Score: 0.8},
+		}, page.Hits[0].Matches)
+		require.Equal(t, "item.brand", page.Hits[1].Matches[0].Field)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("последняя страница и экранирование LIKE", func(t *testing.T) {
+		mock.ExpectQuery("FROM deliveries d").
+			WithArgs("100%_ok", `%100\%\_ok%`, model.DefaultSearchLimit+1, 0, nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows(searchColumns).
+				AddRow("uid-1", at, 1.0, "item.name", "100%_ok", 1.0, nil, nil, nil))
+
+		page, err := repo.Search(context.Background(), model.SearchQuery{Text: "100%_ok"})
+		require.NoError(t, err)
+		require.Len(t, page.Hits, 1)
+		require.Zero(t, page.NextOffset)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("страница обрезается по MaxSearchWindow", func(t *testing.T) {
+		mock.ExpectQuery("FROM deliveries d").
+			WithArgs("moscow", "%moscow%", 6, model.MaxSearchWindow-5, nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows(searchColumns))
+
+		page, err := repo.Search(context.Background(),
+			model.SearchQuery{Text: "moscow", Limit: 50, Offset: model.MaxSearchWindow - 5})
+		require.NoError(t, err)
+		require.Empty(t, page.Hits)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("короткий запрос и offset за пределами выдачи - без запроса к БД", func(t *testing.T) {
+		for _, q := range []model.SearchQuery{
+			{Text: " ab "},
+			{Text: "moscow", Offset: model.MaxSearchWindow},
+			{Text: "moscow", Offset: -1},
+		} {
+			page, err := repo.Search(context.Background(), q)
+			require.NoError(t, err)
+			require.Empty(t, page.Hits)
+		}
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+// searchShard шард с заранее заданной выдачей поиска
+type searchShard struct {
+	*fakeShard
+	hits []model.SearchHit
+	got  model.SearchQuery
+}
+
+func (s *searchShard) Search(_ context.Context, q model.SearchQuery) (model.SearchPage, error) {
+	s.got = q
+	return model.SearchPage{Hits: s.hits[:min(q.Limit, len(s.hits))]}, nil
+}
+
+func TestShardedRepository_Search(t *testing.T) {
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	hit := func(id string, rank float64) model.SearchHit {
+		return model.SearchHit{OrderUID: id, DateCreated: at, Rank: rank}
+	}
+	s0 := &searchShard{fakeShard: newFakeShard(), hits: []model.SearchHit{hit("a", 0.9), hit("c", 0.7), hit("e", 0.5)}}
+	s1 := &searchShard{fakeShard: newFakeShard(), hits: []model.SearchHit{hit("b", 0.8), hit("d", 0.6)}}
+	r, err := repository.NewShardedRepository(repository.ShardedConfig{
+		Shards: []repository.Shard{{Name: "s0", Repo: s0}, {Name: "s1", Repo: s1}},
+	})
+	require.NoError(t, err)
+
+	page, err := r.Search(context.Background(), model.SearchQuery{Text: "testov", Limit: 2, Offset: 1})
+	require.NoError(t, err)
+	require.Equal(t, model.SearchQuery{Text: "testov", Limit: 4}, s0.got)
+	require.Equal(t, []string{"b", "c"}, []string{page.Hits[0].OrderUID, page.Hits[1].OrderUID})
+	require.Equal(t, 3, page.NextOffset)
+
+	page, err = r.Search(context.Background(), model.SearchQuery{Text: "testov", Limit: 2, Offset: 3})
+	require.NoError(t, err)
+	require.Equal(t, []string{"d", "e"}, []string{page.Hits[0].OrderUID, page.Hits[1].OrderUID})
+	require.Zero(t, page.NextOffset)
+
+	// Шард без ISearchRepository - ошибка
+	r, err = repository.NewShardedRepository(repository.ShardedConfig{
+		Shards: []repository.Shard{{Name: "s0", Repo: s0}, {Name: "s1", Repo: newFakeShard()}},
+	})
+	require.NoError(t, err)
+	_, err = r.Search(context.Background(), model.SearchQuery{Text: "testov"})
+	require.ErrorContains(t, err, "shard s1: search not supported")
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/repository"
 	"github.com/gogazub/myapp/internal/service"
 )
 
@@ -29,8 +31,22 @@ func (m *mockDBRepo) GetByID(ctx context.Context, id string) (*model.Order, erro
 	return o, args.Error(1)
 }
 
-func (m *mockDBRepo) GetAll(ctx context.Context) ([]*model.Order, error) {
-	args := m.Called(ctx)
+func (m *mockDBRepo) GetByIDs(ctx context.Context, ids []string) ([]*model.Order, error) {
+	args := m.Called(ctx, ids)
+	var out []*model.Order
+	if v := args.Get(0); v != nil {
+		out = v.([]*model.Order)
+	}
+	return out, args.Error(1)
+}
+
+func (m *mockDBRepo) Delete(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *mockDBRepo) ListPage(ctx context.Context, after repository.Cursor, limit int) ([]*model.Order, error) {
+	args := m.Called(ctx, after, limit)
 	var out []*model.Order
 	if v := args.Get(0); v != nil {
 		out = v.([]*model.Order)
@@ -38,6 +54,20 @@ func (m *mockDBRepo) GetAll(ctx context.Context) ([]*model.Order, error) {
 	return out, args.Error(1)
 }
 
+func (m *mockDBRepo) Watermark(ctx context.Context) (time.Time, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *mockDBRepo) ChangedSince(ctx context.Context, since time.Time) ([]string, error) {
+	args := m.Called(ctx, since)
+	var out []string
+	if v := args.Get(0); v != nil {
+		out = v.([]string)
+	}
+	return out, args.Error(1)
+}
+
 type mockCacheRepo struct{ mock.Mock }
 
 func (m *mockCacheRepo) Save(ctx context.Context, order *model.Order) error {
@@ -54,6 +84,49 @@ func (m *mockCacheRepo) GetByID(ctx context.Context, id string) (*model.Order, e
 	return o, args.Error(1)
 }
 
+func (m *mockCacheRepo) Contains(ctx context.Context, id string) (bool, error) {
+	args := m.Called(ctx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *mockCacheRepo) LoadFromDB(ctx context.Context, psqlRepo repository.IDBRepository) error {
+	args := m.Called(ctx, psqlRepo)
+	return args.Error(0)
+}
+
+func (m *mockCacheRepo) Delete(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *mockCacheRepo) Clear(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+func (m *mockCacheRepo) Stats() repository.CacheStats {
+	args := m.Called()
+	return args.Get(0).(repository.CacheStats)
+}
+
+func (m *mockCacheRepo) WarmupStatus() repository.WarmupStatus {
+	args := m.Called()
+	return args.Get(0).(repository.WarmupStatus)
+}
+
+// mockSharedCacheRepo общий кеш с L1 (как RedisCacheRepository)
+type mockSharedCacheRepo struct{ mockCacheRepo }
+
+func (m *mockSharedCacheRepo) ClearLocal(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+func (m *mockSharedCacheRepo) Evict(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
 // ---------- SaveOrder ----------
 
 func TestService_SaveOrder_success(t *testing.T) {
@@ -204,3 +277,174 @@ func TestService_GetOrderByID_dbReturnsNilNoError(t *testing.T) {
 	db.AssertExpectations(t)
 	cache.AssertExpectations(t)
 }
+
+// ---------- Cache admin ----------
+
+func TestService_InvalidateCache(t *testing.T) {
+	db := new(mockDBRepo)
+	cache := new(mockCacheRepo)
+	s := service.NewService(db, cache)
+	ctx := context.Background()
+
+	cache.On("Delete", ctx, "uid-7").Return(nil).Once()
+
+	require.NoError(t, s.InvalidateCache(ctx, "uid-7"))
+	cache.AssertExpectations(t)
+}
+
+func TestService_WarmupCache_usesDBRepo(t *testing.T) {
+	db := new(mockDBRepo)
+	cache := new(mockCacheRepo)
+	s := service.NewService(db, cache)
+	ctx := context.Background()
+
+	cache.On("LoadFromDB", ctx, db).Return(nil).Once()
+
+	require.NoError(t, s.WarmupCache(ctx))
+	cache.AssertExpectations(t)
+}
+
+func TestService_ResyncCache_clearsThenWarmsUp(t *testing.T) {
+	db := new(mockDBRepo)
+	cache := new(mockCacheRepo)
+	s := service.NewService(db, cache)
+	ctx := context.Background()
+
+	mock.InOrder(
+		cache.On("Clear", ctx).Return(nil).Once(),
+		cache.On("LoadFromDB", ctx, db).Return(nil).Once(),
+	)
+
+	require.NoError(t, s.ResyncCache(ctx))
+	cache.AssertExpectations(t)
+}
+
+// Общий кеш не очищается и не прогревается: после сбоя Postgres ресинк делают все реплики разом
+func TestService_ResyncCache_sharedCacheClearsOnlyLocal(t *testing.T) {
+	db := new(mockDBRepo)
+	cache := new(mockSharedCacheRepo)
+	s := service.NewService(db, cache)
+	ctx := context.Background()
+
+	cache.On("ClearLocal", ctx).Return(nil).Once()
+
+	require.NoError(t, s.ResyncCache(ctx))
+	cache.AssertNotCalled(t, "Clear", mock.Anything)
+	cache.AssertNotCalled(t, "LoadFromDB", mock.Anything, mock.Anything)
+	cache.AssertExpectations(t)
+}
+
+func TestService_RefreshCache(t *testing.T) {
+	ctx := context.Background()
+	order := FakeValidOrder("uid-1")
+
+	t.Run("not cached - db not read", func(t *testing.T) {
+		db, cache := new(mockDBRepo), new(mockCacheRepo)
+		cache.On("Contains", ctx, "uid-1").Return(false, nil).Once()
+
+		require.NoError(t, service.NewService(db, cache).RefreshCache(ctx, "uid-1"))
+		db.AssertNotCalled(t, "GetByID", mock.Anything, mock.Anything)
+		cache.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+		cache.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+		cache.AssertExpectations(t)
+	})
+	t.Run("found - saved to cache", func(t *testing.T) {
+		db, cache := new(mockDBRepo), new(mockCacheRepo)
+		cache.On("Contains", ctx, "uid-1").Return(true, nil).Once()
+		db.On("GetByID", ctx, "uid-1").Return(order, nil).Once()
+		cache.On("Save", ctx, order).Return(nil).Once()
+
+		require.NoError(t, service.NewService(db, cache).RefreshCache(ctx, "uid-1"))
+		cache.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+		cache.AssertExpectations(t)
+	})
+	t.Run("deleted - removed from cache", func(t *testing.T) {
+		db, cache := new(mockDBRepo), new(mockCacheRepo)
+		cache.On("Contains", ctx, "uid-1").Return(true, nil).Once()
+		db.On("GetByID", ctx, "uid-1").Return(nil, repository.ErrOrderNotFound).Once()
+		cache.On("Delete", ctx, "uid-1").Return(nil).Once()
+
+		require.NoError(t, service.NewService(db, cache).RefreshCache(ctx, "uid-1"))
+		cache.AssertExpectations(t)
+	})
+	t.Run("db error - removed from cache", func(t *testing.T) {
+		db, cache := new(mockDBRepo), new(mockCacheRepo)
+		dbErr := errors.New("db down")
+		cache.On("Contains", ctx, "uid-1").Return(true, nil).Once()
+		db.On("GetByID", ctx, "uid-1").Return(nil, dbErr).Once()
+		cache.On("Delete", ctx, "uid-1").Return(nil).Once()
+
+		require.ErrorIs(t, service.NewService(db, cache).RefreshCache(ctx, "uid-1"), dbErr)
+		cache.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+		cache.AssertExpectations(t)
+	})
+	t.Run("shared cache - evicted without db read", func(t *testing.T) {
+		db, cache := new(mockDBRepo), new(mockSharedCacheRepo)
+		cache.On("Evict", ctx, "uid-1").Return(nil).Once()
+
+		require.NoError(t, service.NewService(db, cache).RefreshCache(ctx, "uid-1"))
+		db.AssertNotCalled(t, "GetByID", mock.Anything, mock.Anything)
+		cache.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+		cache.AssertExpectations(t)
+	})
+}
+
+// ---------- GetOrderHistory ----------
+
+// Хранилище без IHistoryRepository -> ErrNotSupported, кеш не трогается
+func TestService_GetOrderHistory_notSupported(t *testing.T) {
+	db := new(mockDBRepo)
+	cache := new(mockCacheRepo)
+	s := service.NewService(db, cache)
+
+	_, err := s.GetOrderHistory(context.Background(), "uid-1", 10)
+	require.ErrorIs(t, err, service.ErrNotSupported)
+	cache.AssertNotCalled(t, "GetByID", mock.Anything, mock.Anything)
+}
+
+// Хранилище без IRawMessageRepository -> ErrNotSupported
+func TestService_GetOrderRawMessages_notSupported(t *testing.T) {
+	s := service.NewService(new(mockDBRepo), new(mockCacheRepo))
+
+	_, err := s.GetOrderRawMessages(context.Background(), "uid-1", 10)
+	require.ErrorIs(t, err, service.ErrNotSupported)
+}
+
+// Хранилище без ISearchRepository -> ErrNotSupported
+func TestService_SearchOrders_notSupported(t *testing.T) {
+	s := service.NewService(new(mockDBRepo), new(mockCacheRepo))
+
+	_, err := s.SearchOrders(context.Background(), model.SearchQuery{Text: "testov"})
+	require.ErrorIs(t, err, service.ErrNotSupported)
+}
+
+// ---------- DeleteOrder ----------
+
+func TestService_DeleteOrder(t *testing.T) {
+	db := new(mockDBRepo)
+	cache := new(mockCacheRepo)
+	s := service.NewService(db, cache)
+	ctx := context.Background()
+
+	mock.InOrder(
+		db.On("Delete", ctx, "uid-1").Return(nil).Once(),
+		cache.On("Delete", ctx, "uid-1").Return(nil).Once(),
+	)
+
+	require.NoError(t, s.DeleteOrder(ctx, "uid-1"))
+	db.AssertExpectations(t)
+	cache.AssertExpectations(t)
+}
+
+// Ошибка БД: кеш не трогается
+func TestService_DeleteOrder_dbError(t *testing.T) {
+	db := new(mockDBRepo)
+	cache := new(mockCacheRepo)
+	s := service.NewService(db, cache)
+	ctx := context.Background()
+
+	db.On("Delete", ctx, "missing").Return(repository.ErrOrderNotFound).Once()
+
+	require.ErrorIs(t, s.DeleteOrder(ctx, "missing"), repository.ErrOrderNotFound)
+	cache.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
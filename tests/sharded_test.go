@@ -0,0 +1,332 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeShard шард в памяти. calls считает обращения к GetByID/GetByIDs/Delete
+type fakeShard struct {
+	mu     sync.Mutex
+	orders map[string]*model.Order
+	calls  int
+	// err возвращается всеми операциями
+	err error
+}
+
+func newFakeShard(orders ...*model.Order) *fakeShard {
+	s := &fakeShard{orders: map[string]*model.Order{}}
+	for _, o := range orders {
+		s.orders[o.OrderUID] = o
+	}
+	return s
+}
+
+func (s *fakeShard) Save(_ context.Context, o *model.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.orders[o.OrderUID] = o
+	return nil
+}
+
+func (s *fakeShard) GetByID(_ context.Context, id string) (*model.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	o, ok := s.orders[id]
+	if !ok {
+		return nil, repository.ErrOrderNotFound
+	}
+	return o, nil
+}
+
+func (s *fakeShard) GetByIDs(_ context.Context, ids []string) ([]*model.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	out := []*model.Order{}
+	for _, id := range ids {
+		if o, ok := s.orders[id]; ok {
+			out = append(out, o)
+		}
+	}
+	return out, nil
+}
+
+func (s *fakeShard) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if _, ok := s.orders[id]; !ok {
+		return repository.ErrOrderNotFound
+	}
+	delete(s.orders, id)
+	return nil
+}
+
+func (s *fakeShard) ListPage(_ context.Context, after repository.Cursor, limit int) ([]*model.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := make([]*model.Order, 0, len(s.orders))
+	for _, o := range s.orders {
+		if after.IsZero() || o.DateCreated.Before(after.DateCreated) ||
+			(o.DateCreated.Equal(after.DateCreated) && o.OrderUID < after.OrderUID) {
+			all = append(all, o)
+		}
+	}
+	slices.SortFunc(all, func(a, b *model.Order) int {
+		if c := b.DateCreated.Compare(a.DateCreated); c != 0 {
+			return c
+		}
+		if a.OrderUID > b.OrderUID {
+			return -1
+		}
+		return 1
+	})
+	return all[:min(limit, len(all))], nil
+}
+
+func (s *fakeShard) Watermark(_ context.Context) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var wm time.Time
+	for _, o := range s.orders {
+		if o.DateCreated.After(wm) {
+			wm = o.DateCreated
+		}
+	}
+	return wm, nil
+}
+
+func (s *fakeShard) ChangedSince(_ context.Context, since time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id, o := range s.orders {
+		if o.DateCreated.After(since) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// fakeDirectory каталог в памяти
+type fakeDirectory map[string]string
+
+func (d fakeDirectory) Lookup(_ context.Context, ids []string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, id := range ids {
+		if s, ok := d[id]; ok {
+			out[id] = s
+		}
+	}
+	return out, nil
+}
+
+func (d fakeDirectory) Assign(_ context.Context, id, shard string) (string, error) {
+	if s, ok := d[id]; ok {
+		return s, nil
+	}
+	d[id] = shard
+	return shard, nil
+}
+
+func (d fakeDirectory) Remove(_ context.Context, ids []string) error {
+	for _, id := range ids {
+		delete(d, id)
+	}
+	return nil
+}
+
+func shardedOrder(id, shardkey string, created time.Time) *model.Order {
+	o := FakeValidOrder(id)
+	o.Shardkey = shardkey
+	o.DateCreated = created
+	return o
+}
+
+func newSharded(t *testing.T, dir repository.IShardDirectory, s0, s1 *fakeShard) *repository.ShardedRepository {
+	t.Helper()
+	r, err := repository.NewShardedRepository(repository.ShardedConfig{
+		Shards:    []repository.Shard{{Name: "s0", Repo: s0}, {Name: "s1", Repo: s1}},
+		KeyMap:    map[string]string{"1": "s0", "9": "s1"},
+		Directory: dir,
+	})
+	require.NoError(t, err)
+	return r
+}
+
+func TestShardedRepository_SaveRoutesByShardkey(t *testing.T) {
+	s0, s1 := newFakeShard(), newFakeShard()
+	dir := fakeDirectory{}
+	r := newSharded(t, dir, s0, s1)
+	ctx := context.Background()
+
+	require.NoError(t, r.Save(ctx, shardedOrder("a", "1", time.Now())))
+	require.NoError(t, r.Save(ctx, shardedOrder("b", "9", time.Now())))
+
+	require.Contains(t, s0.orders, "a")
+	require.Contains(t, s1.orders, "b")
+	require.Equal(t, fakeDirectory{"a": "s0", "b": "s1"}, dir)
+
+	// ключ вне карты всегда попадает на один и тот же шард
+	require.Equal(t, r.ShardFor("unmapped"), r.ShardFor("unmapped"))
+}
+
+func TestShardedRepository_SaveShardChanged(t *testing.T) {
+	s0, s1 := newFakeShard(), newFakeShard()
+	r := newSharded(t, fakeDirectory{"a": "s0"}, s0, s1)
+
+	err := r.Save(context.Background(), shardedOrder("a", "9", time.Now()))
+	require.ErrorIs(t, err, repository.ErrShardChanged)
+	require.Empty(t, s1.orders)
+}
+
+func TestShardedRepository_GetByID(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("каталог: запрос только к шарду заказа", func(t *testing.T) {
+		s0, s1 := newFakeShard(shardedOrder("a", "1", time.Now())), newFakeShard()
+		r := newSharded(t, fakeDirectory{"a": "s0"}, s0, s1)
+
+		o, err := r.GetByID(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "a", o.OrderUID)
+		require.Equal(t, 1, s0.calls)
+		require.Zero(t, s1.calls)
+	})
+
+	t.Run("без записи в каталоге: scatter-gather", func(t *testing.T) {
+		s0, s1 := newFakeShard(), newFakeShard(shardedOrder("a", "9", time.Now()))
+		r := newSharded(t, fakeDirectory{}, s0, s1)
+
+		o, err := r.GetByID(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "a", o.OrderUID)
+		require.Equal(t, 1, s0.calls)
+		require.Equal(t, 1, s1.calls)
+	})
+
+	t.Run("ошибка шарда не мешает найти заказ на другом", func(t *testing.T) {
+		s0, s1 := newFakeShard(), newFakeShard(shardedOrder("a", "9", time.Now()))
+		s0.err = errors.New("connection refused")
+		r := newSharded(t, nil, s0, s1)
+
+		o, err := r.GetByID(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "a", o.OrderUID)
+
+		_, err = r.GetByID(ctx, "missing")
+		require.Error(t, err)
+		require.NotErrorIs(t, err, repository.ErrOrderNotFound)
+	})
+
+	t.Run("нет ни на одном шарде", func(t *testing.T) {
+		r := newSharded(t, nil, newFakeShard(), newFakeShard())
+		_, err := r.GetByID(ctx, "missing")
+		require.ErrorIs(t, err, repository.ErrOrderNotFound)
+	})
+}
+
+func TestShardedRepository_GetByIDs(t *testing.T) {
+	now := time.Now()
+	s0 := newFakeShard(shardedOrder("a", "1", now), shardedOrder("c", "1", now))
+	s1 := newFakeShard(shardedOrder("b", "9", now))
+	r := newSharded(t, fakeDirectory{"a": "s0", "b": "s1"}, s0, s1)
+
+	orders, err := r.GetByIDs(context.Background(), []string{"a", "b", "c", "missing"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c"}, idsFromOrders(orders))
+}
+
+func TestShardedRepository_ListPageMerges(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s0, s1 := newFakeShard(), newFakeShard()
+	var want []string
+	for i := 0; i < 10; i++ {
+		id := "order-" + strconvI(i)
+		shard := s0
+		if i%3 == 0 {
+			shard = s1
+		}
+		shard.orders[id] = shardedOrder(id, "", base.Add(time.Duration(i)*time.Hour))
+		want = append([]string{id}, want...)
+	}
+	r := newSharded(t, nil, s0, s1)
+
+	var got []string
+	for o, err := range repository.IterateOrders(context.Background(), r, 3) {
+		require.NoError(t, err)
+		got = append(got, o.OrderUID)
+	}
+	require.Equal(t, want, got)
+}
+
+func TestShardedRepository_Delete(t *testing.T) {
+	s0, s1 := newFakeShard(), newFakeShard(shardedOrder("a", "9", time.Now()))
+	r := newSharded(t, nil, s0, s1)
+	ctx := context.Background()
+
+	require.NoError(t, r.Delete(ctx, "a"))
+	require.Empty(t, s1.orders)
+	require.ErrorIs(t, r.Delete(ctx, "a"), repository.ErrOrderNotFound)
+}
+
+func TestNewShardedRepository_BadConfig(t *testing.T) {
+	s := newFakeShard()
+	cases := map[string]repository.ShardedConfig{
+		"no shards": {},
+		"duplicate": {Shards: []repository.Shard{{Name: "s0", Repo: s}, {Name: "s0", Repo: s}}},
+		"unknown shard in map": {
+			Shards: []repository.Shard{{Name: "s0", Repo: s}},
+			KeyMap: map[string]string{"1": "s1"},
+		},
+	}
+	for name, cfg := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := repository.NewShardedRepository(cfg)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestShardDirectory(t *testing.T) {
+	db, mock := newDB(t)
+	dir := repository.NewShardDirectory(db)
+	ctx := context.Background()
+
+	mock.ExpectQuery(`INSERT INTO order_shards \(order_uid, shard\) VALUES \(\$1, \$2\)\s+ON CONFLICT \(order_uid\) DO UPDATE.*RETURNING shard`).
+		WithArgs("uid-1", "s1").
+		WillReturnRows(sqlmock.NewRows([]string{"shard"}).AddRow("s0"))
+	got, err := dir.Assign(ctx, "uid-1", "s1")
+	require.NoError(t, err)
+	require.Equal(t, "s0", got)
+
+	mock.ExpectQuery(q(`SELECT order_uid, shard FROM order_shards WHERE order_uid = ANY($1)`)).
+		WithArgs(pq.Array([]string{"uid-1", "uid-2"})).
+		WillReturnRows(sqlmock.NewRows([]string{"order_uid", "shard"}).AddRow("uid-1", "s0"))
+	known, err := dir.Lookup(ctx, []string{"uid-1", "uid-2"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"uid-1": "s0"}, known)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot_RoundTripKeepsLRUOrder(t *testing.T) {
+	ctx := context.Background()
+	src := repository.NewCacheRepositoryWithConfig(repository.CacheConfig{MaxSize: 3})
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, src.Save(ctx, FakeValidOrder(id)))
+	}
+	// "a" становится самым свежим
+	_, err := src.GetByID(ctx, "a")
+	require.NoError(t, err)
+
+	wm := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	var buf bytes.Buffer
+	require.NoError(t, src.WriteSnapshot(&buf, wm))
+
+	dst := repository.NewCacheRepositoryWithConfig(repository.CacheConfig{MaxSize: 3})
+	gotWM, n, err := dst.ReadSnapshot(&buf)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.True(t, wm.Equal(gotWM))
+
+	got, err := dst.GetByID(ctx, "b")
+	require.NoError(t, err)
+	require.Equal(t, "alice@example.com", got.Delivery.Email)
+
+	// Порядок LRU сохранился: самый старый теперь "c" ("b" только что прочитан), его и вытеснит новый заказ
+	require.NoError(t, dst.Save(ctx, FakeOrder("d")))
+	_, err = dst.GetByID(ctx, "c")
+	require.ErrorIs(t, err, repository.ErrCacheMiss)
+	_, err = dst.GetByID(ctx, "a")
+	require.NoError(t, err)
+}
+
+func TestSnapshot_RejectsUnknownVersion(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, repository.NewCacheRepository().WriteSnapshot(&buf, time.Now()))
+
+	raw := buf.Bytes()
+	raw[len("ORDCACHE")+1]++ // младший байт версии
+
+	_, _, err := repository.NewCacheRepository().ReadSnapshot(bytes.NewReader(raw))
+	require.ErrorIs(t, err, repository.ErrSnapshotVersion)
+}
+
+func TestSnapshotFile_ReconcilesWithDB(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	wm := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	src := repository.NewCacheRepository()
+	for _, id := range []string{"fresh", "stale", "deleted"} {
+		require.NoError(t, src.Save(ctx, FakeValidOrder(id)))
+	}
+	db := new(mockDBRepo)
+	db.On("Watermark", mock.Anything).Return(wm, nil).Once()
+	require.NoError(t, src.SaveSnapshotFile(ctx, path, db))
+
+	// После снапшота "stale" изменили, а "deleted" мягко удалили: строка осталась, поэтому ChangedSince
+	// ее возвращает, а GetByIDs пропускает
+	updated := FakeValidOrder("stale")
+	updated.TrackNumber = "v2"
+	db.On("ChangedSince", ctx, wm).Return([]string{"stale", "deleted", "not-cached"}, nil).Once()
+	db.On("GetByIDs", ctx, []string{"stale", "deleted"}).Return([]*model.Order{updated}, nil).Once()
+
+	dst := repository.NewCacheRepository()
+	n, err := dst.LoadSnapshotFile(ctx, path, db)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	_, err = dst.GetByID(ctx, "fresh")
+	require.NoError(t, err)
+	got, err := dst.GetByID(ctx, "stale")
+	require.NoError(t, err)
+	require.Equal(t, "v2", got.TrackNumber)
+	_, err = dst.GetByID(ctx, "deleted")
+	require.ErrorIs(t, err, repository.ErrCacheMiss)
+
+	st := dst.WarmupStatus()
+	require.Equal(t, repository.WarmupDone, st.State)
+	require.Equal(t, 2, st.Loaded)
+	db.AssertExpectations(t)
+}
+
+// Заказ, сохраненный в кеш (NOTIFY) пока шла сверка, свежее прочитанного батчем и не затирается им
+func TestSnapshotFile_ReconcileKeepsConcurrentSave(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snap")
+	wm := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	src := repository.NewCacheRepository()
+	require.NoError(t, src.Save(ctx, FakeValidOrder("stale")))
+	db := new(mockDBRepo)
+	db.On("Watermark", mock.Anything).Return(wm, nil).Once()
+	require.NoError(t, src.SaveSnapshotFile(ctx, path, db))
+
+	dst := repository.NewCacheRepository()
+	v2, v3 := FakeValidOrder("stale"), FakeValidOrder("stale")
+	v2.TrackNumber, v3.TrackNumber = "v2", "v3"
+	db.On("ChangedSince", ctx, wm).Return([]string{"stale"}, nil).Once()
+	db.On("GetByIDs", ctx, []string{"stale"}).Return([]*model.Order{v2}, nil).Once().Run(func(mock.Arguments) {
+		require.NoError(t, dst.Save(ctx, v3))
+	})
+
+	_, err := dst.LoadSnapshotFile(ctx, path, db)
+	require.NoError(t, err)
+	got, err := dst.GetByID(ctx, "stale")
+	require.NoError(t, err)
+	require.Equal(t, "v3", got.TrackNumber)
+}
+
+func TestSnapshotFile_MissingFile(t *testing.T) {
+	r := repository.NewCacheRepository()
+	_, err := r.LoadSnapshotFile(context.Background(), filepath.Join(t.TempDir(), "nope"), new(mockDBRepo))
+	require.True(t, errors.Is(err, os.ErrNotExist))
+	require.Equal(t, repository.WarmupPending, r.WarmupStatus().State)
+}
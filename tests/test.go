@@ -6,9 +6,10 @@ import (
 	"encoding/json"
 	"sort"
 	"testing"
-	"time"
 
 	"github.com/gogazub/myapp/internal/model"
+	"github.com/gogazub/myapp/internal/repository"
+	"github.com/gogazub/myapp/ordermodel"
 	"github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -35,6 +36,90 @@ func (m *MockService) GetOrderByID(ctx context.Context, id string) (*model.Order
 	return args.Get(0).(*model.Order), args.Error(1)
 }
 
+// GetOrderHistory мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) GetOrderHistory(ctx context.Context, id string, limit int) ([]model.HistoryEntry, error) {
+	args := m.Called(ctx, id, limit)
+	return args.Get(0).([]model.HistoryEntry), args.Error(1)
+}
+
+// GetOrderRawMessages мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) GetOrderRawMessages(ctx context.Context, id string, limit int) ([]model.RawMessage, error) {
+	args := m.Called(ctx, id, limit)
+	return args.Get(0).([]model.RawMessage), args.Error(1)
+}
+
+// SaveRawMessage мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) SaveRawMessage(ctx context.Context, msg model.RawMessage) error {
+	args := m.Called(ctx, msg)
+	return args.Error(0)
+}
+
+// SearchOrders мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) SearchOrders(ctx context.Context, q model.SearchQuery) (model.SearchPage, error) {
+	args := m.Called(ctx, q)
+	return args.Get(0).(model.SearchPage), args.Error(1)
+}
+
+// DeleteOrder мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) DeleteOrder(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// ExportCustomerData мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) ExportCustomerData(ctx context.Context, customerID string) (model.CustomerExport, error) {
+	args := m.Called(ctx, customerID)
+	return args.Get(0).(model.CustomerExport), args.Error(1)
+}
+
+// EraseCustomerData мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) EraseCustomerData(ctx context.Context, customerID string) (model.CustomerErasure, error) {
+	args := m.Called(ctx, customerID)
+	return args.Get(0).(model.CustomerErasure), args.Error(1)
+}
+
+// CacheStats мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) CacheStats() repository.CacheStats {
+	args := m.Called()
+	return args.Get(0).(repository.CacheStats)
+}
+
+// InvalidateCache мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) InvalidateCache(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// RefreshCache мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) RefreshCache(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// ClearCache мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) ClearCache(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+// WarmupCache мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) WarmupCache(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+// WarmupStatus мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) WarmupStatus() repository.WarmupStatus {
+	args := m.Called()
+	return args.Get(0).(repository.WarmupStatus)
+}
+
+// ResyncCache мок реализация. Записывает вызовы в mock.Called
+func (m *MockService) ResyncCache(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
 // --- StubService ---
 
 // StubService stub реализация Service`а. Вызовы методов возвращают установленную ошибку Err
@@ -52,6 +137,76 @@ func (s *StubService) GetOrderByID(_ context.Context, _ string) (*model.Order, e
 	return nil, s.Err
 }
 
+// GetOrderHistory stub реализация. Возвращает установленную ошибку StubService.Err
+func (s *StubService) GetOrderHistory(_ context.Context, _ string, _ int) ([]model.HistoryEntry, error) {
+	return nil, s.Err
+}
+
+// GetOrderRawMessages stub реализация. Возвращает установленную ошибку StubService.Err
+func (s *StubService) GetOrderRawMessages(_ context.Context, _ string, _ int) ([]model.RawMessage, error) {
+	return nil, s.Err
+}
+
+// SaveRawMessage stub реализация. Возвращает установленную ошибку StubService.Err
+func (s *StubService) SaveRawMessage(_ context.Context, _ model.RawMessage) error {
+	return s.Err
+}
+
+// SearchOrders stub реализация. Возвращает установленную ошибку StubService.Err
+func (s *StubService) SearchOrders(_ context.Context, _ model.SearchQuery) (model.SearchPage, error) {
+	return model.SearchPage{}, s.Err
+}
+
+// DeleteOrder stub реализация. Возвращает установленную ошибку StubService.Err
+func (s *StubService) DeleteOrder(_ context.Context, _ string) error {
+	return s.Err
+}
+
+// ExportCustomerData stub реализация. Возвращает установленную ошибку StubService.Err
+func (s *StubService) ExportCustomerData(_ context.Context, _ string) (model.CustomerExport, error) {
+	return model.CustomerExport{}, s.Err
+}
+
+// EraseCustomerData stub реализация. Возвращает установленную ошибку StubService.Err
+func (s *StubService) EraseCustomerData(_ context.Context, _ string) (model.CustomerErasure, error) {
+	return model.CustomerErasure{}, s.Err
+}
+
+// CacheStats stub реализация. Возвращает пустую статистику
+func (s *StubService) CacheStats() repository.CacheStats {
+	return repository.CacheStats{}
+}
+
+// InvalidateCache stub реализация. Возвращает установленную ошибку StubService.Err
+func (s *StubService) InvalidateCache(_ context.Context, _ string) error {
+	return s.Err
+}
+
+// RefreshCache stub реализация. Возвращает установленную ошибку StubService.Err
+func (s *StubService) RefreshCache(_ context.Context, _ string) error {
+	return s.Err
+}
+
+// ClearCache stub реализация. Возвращает установленную ошибку StubService.Err
+func (s *StubService) ClearCache(_ context.Context) error {
+	return s.Err
+}
+
+// WarmupCache stub реализация. Возвращает установленную ошибку StubService.Err
+func (s *StubService) WarmupCache(_ context.Context) error {
+	return s.Err
+}
+
+// WarmupStatus stub реализация. Прогрев всегда завершен
+func (s *StubService) WarmupStatus() repository.WarmupStatus {
+	return repository.WarmupStatus{State: repository.WarmupDone}
+}
+
+// ResyncCache stub реализация. Возвращает установленную ошибку StubService.Err
+func (s *StubService) ResyncCache(_ context.Context) error {
+	return s.Err
+}
+
 // --- StubReader ---
 
 // StubReader реализация Service`а
@@ -77,47 +232,9 @@ func FakeOrder(id string) *model.Order {
 	return &model.Order{OrderUID: id}
 }
 
-// FakeValidOrder создает валидный ордер с заполненными полями и заданным id
+// FakeValidOrder создает валидный ордер с заполненными полями и заданным id: общий пример ordermodel.Example
 func FakeValidOrder(id string) *model.Order {
-	now := time.Now().UTC()
-
-	return &model.Order{
-		OrderUID:    id,
-		CustomerID:  "cust-001",
-		DateCreated: now,
-		OofShard:    "1",
-		Delivery: model.Delivery{
-			Name:    "Alice",
-			Phone:   "+1234567890",
-			Zip:     "10001",
-			City:    "NY",
-			Address: "5th Avenue, 1",
-			Region:  "NY",
-			Email:   "alice@example.com",
-		},
-		Payment: model.Payment{
-			Transaction:  "trx-001",
-			Currency:     "USD",
-			Provider:     "visa",
-			Amount:       149.90,
-			PaymentDt:    1712345678,
-			Bank:         "Chase",
-			DeliveryCost: 0,
-			GoodsTotal:   1,
-			CustomFee:    0,
-		},
-		Items: []model.Item{
-			{
-				ChrtID:      1,        // required,gte=1
-				TrackNumber: "TRK123", // required
-				Price:       149.90,   // gte=0
-				Sale:        0,        // 0..100
-				TotalPrice:  149.90,   // gte=0
-				NmID:        1,        // gte=0
-				Status:      0,        // gte=0
-			},
-		},
-	}
+	return ordermodel.Example(id)
 }
 
 func idsFromOrders(orders []*model.Order) []string {
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -40,10 +41,10 @@ func validOrderFixture() model.Order {
 			RequestID:    "",
 			Currency:     "USD",
 			Provider:     "wbpay",
-			Amount:       1817,
+			Amount:       model.NewMoney(1817, 0),
 			PaymentDt:    1637907727,
 			Bank:         "alpha",
-			DeliveryCost: 1500,
+			DeliveryCost: model.NewMoney(1500, 0),
 			GoodsTotal:   317,
 			CustomFee:    0,
 		},
@@ -51,12 +52,12 @@ func validOrderFixture() model.Order {
 			{
 				ChrtID:      9934930,
 				TrackNumber: "WBILMTESTTRACK",
-				Price:       453,
+				Price:       model.NewMoney(453, 0),
 				Rid:         "ab4219087a764ae0btest",
 				Name:        "Mascaras",
 				Sale:        30,
 				Size:        "0",
-				TotalPrice:  317,
+				TotalPrice:  model.NewMoney(317, 0),
 				NmID:        2389212,
 				Brand:       "Vivienne Sabo",
 				Status:      202,
@@ -311,3 +312,13 @@ func TestItems_Dive_FailsOnBadElement(t *testing.T) {
 	ve := mustBeInvalid(t, err)
 	mustHaveFieldTag(t, ve, "ChrtID", "required")
 }
+
+// TestItems_DuplicateChrtID - позиции сопоставляются по chrt_id, повтор - ошибка, а не тихая потеря позиции
+func TestItems_DuplicateChrtID(t *testing.T) {
+	o := validOrderFixture()
+	o.Items = append(o.Items, o.Items[0])
+	o.Items[1].Name = "second"
+	if err := o.ValidateItems(); !errors.Is(err, model.ErrDuplicateItem) {
+		t.Fatalf("expected ErrDuplicateItem, got %v", err)
+	}
+}